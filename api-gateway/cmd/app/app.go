@@ -3,6 +3,7 @@ package main
 import (
 	"api-gateway/config"
 	"api-gateway/handler"
+	appMiddleware "api-gateway/middleware"
 	"api-gateway/routes"
 	"api-gateway/webResponse"
 	"context"
@@ -11,10 +12,10 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/time/rate"
 	"messaging"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -36,6 +37,9 @@ type Handler struct {
 func (app *App) Initialize() {
 	app.LoadEnv()
 	app.Server = echo.New()
+	app.Server.HTTPErrorHandler = webResponse.HTTPErrorHandler
+	// per-IP limits and lockouts count c.RealIP(), only trusted proxies may set it through headers
+	app.Server.IPExtractor = config.LoadIPExtractor()
 
 	// Recover from panic
 	defer func() {
@@ -44,18 +48,15 @@ func (app *App) Initialize() {
 		}
 	}()
 
-	// Rate Limit
+	// Rate Limit, counted in Redis so every replica shares the limits
 	cfg := config.LoadRateLimitConfig()
-	app.Server.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStoreWithConfig(
-		middleware.RateLimiterMemoryStoreConfig{
-			Rate:      rate.Limit(cfg.RateLimit),
-			Burst:     cfg.RateLimit,
-			ExpiresIn: 1 * time.Minute,
-		},
-	)))
+	app.Server.Use(appMiddleware.RateLimit(cfg,
+		config.LoadRateLimitPolicy("global", config.RateLimitByIP, strconv.Itoa(cfg.RateLimit)+"/1m"),
+		config.LoadRateLimitPolicy("api-key", config.RateLimitByAPIKey, "1000/1m"),
+	))
 
 	// Redis
-	redisClient := config.NewRedisClient()
+	redisClient := config.GetRedisClient()
 	if redisClient == nil {
 		logrus.Fatal("Failed to initialize Redis")
 	}
@@ -63,11 +64,21 @@ func (app *App) Initialize() {
 	// Logger
 	config.SetupLogger()
 
+	// OIDC providers for social login
+	if err := config.InitOIDCProviders(context.Background()); err != nil {
+		logrus.Errorf("Failed to load OIDC providers: %v", err)
+	}
+
+	// WebAuthn relying party for passkeys
+	if err := config.InitWebAuthn(); err != nil {
+		logrus.Warnf("Passkeys disabled: %v", err)
+	}
+
 	// Middleware
 	app.Server.Use(middleware.Recover())
 	app.Server.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowHeaders: []string{"Origin", "Content-Type", "Accept"},
 	}))
 	app.Server.Use(middleware.Gzip())
@@ -95,6 +106,9 @@ func (app *App) Initialize() {
 	}
 
 	routes.UserRoutes(app.Server, cfg, app.RMQ, app.ResponseHandler)
+
+	// purge sessions and avatars of users that user-service anonymized
+	messaging.ConsumeEvent(app.RMQ, "api-gateway-events", []string{"UserDeleted"}, app.Handler.UserHandler.HandleUserDeleted)
 }
 
 // LoadEnv function to load environment variables
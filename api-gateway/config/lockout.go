@@ -0,0 +1,156 @@
+package config
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy controls how failed password logins are slowed down and locked
+type LockoutPolicy struct {
+	// MaxFailures failed logins on an account, from any IP, lock it
+	MaxFailures int
+	// DelayAfter failed logins from one IP on one account start the exponential delay
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// FailureWindow is how long failures are remembered without a successful login
+	FailureWindow time.Duration
+	// LockDuration doubles with every lockout within a day, up to MaxLockDuration
+	LockDuration    time.Duration
+	MaxLockDuration time.Duration
+}
+
+// LoadLockoutPolicy reads LOGIN_MAX_FAILURES and LOGIN_LOCK_MINUTES, the rest uses defaults
+func LoadLockoutPolicy() LockoutPolicy {
+	policy := LockoutPolicy{
+		MaxFailures:     10,
+		DelayAfter:      3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		FailureWindow:   time.Hour,
+		LockDuration:    15 * time.Minute,
+		MaxLockDuration: 24 * time.Hour,
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && n > 0 {
+		policy.MaxFailures = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_LOCK_MINUTES")); err == nil && n > 0 {
+		policy.LockDuration = time.Duration(n) * time.Minute
+	}
+	return policy
+}
+
+// delay is how long an IP waits after its failures on one account, doubling from BaseDelay once
+// DelayAfter is reached, up to MaxDelay
+func (p LockoutPolicy) delay(failures int64) time.Duration {
+	if failures < int64(p.DelayAfter) {
+		return 0
+	}
+	// a long run of failures shifts into a negative or zero duration, capped like any other overflow
+	delay := p.BaseDelay << (failures - int64(p.DelayAfter))
+	if delay <= 0 || delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// lockDuration is how long the lockouts-th lockout of the day lasts, doubling from LockDuration up to MaxLockDuration
+func (p LockoutPolicy) lockDuration(lockouts int64) time.Duration {
+	lockedFor := p.LockDuration << (lockouts - 1)
+	if lockedFor <= 0 || lockedFor > p.MaxLockDuration {
+		return p.MaxLockDuration
+	}
+	return lockedFor
+}
+
+// lockoutKey normalizes the email so differently cased logins share their counters
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginBlocked returns how long the email has to wait before the next password attempt from ip,
+// locked is true when the whole account is locked rather than only this IP delayed
+func LoginBlocked(ctx context.Context, email, ip string) (wait time.Duration, locked bool, err error) {
+	rdb := GetRedisClient()
+	account := lockoutKey(email)
+
+	wait, err = rdb.PTTL(ctx, "login:lock:"+account).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if wait > 0 {
+		return wait, true, nil
+	}
+
+	wait, err = rdb.PTTL(ctx, "login:delay:"+ip+":"+account).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if wait > 0 {
+		return wait, false, nil
+	}
+	return 0, false, nil
+}
+
+// RecordLoginFailure counts a failed password per account and per IP+account, delays the IP exponentially
+// and locks the account once it reaches MaxFailures. lockedFor is non-zero when this failure locked it.
+func RecordLoginFailure(ctx context.Context, policy LockoutPolicy, email, ip string) (lockedFor time.Duration, err error) {
+	rdb := GetRedisClient()
+	account := lockoutKey(email)
+
+	ipFailures, err := IncrementCounter(ctx, "login:fail:"+ip+":"+account, policy.FailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	if delay := policy.delay(ipFailures); delay > 0 {
+		if err := rdb.Set(ctx, "login:delay:"+ip+":"+account, 1, delay).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	failures, err := IncrementCounter(ctx, "login:fail:"+account, policy.FailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	if failures < int64(policy.MaxFailures) {
+		return 0, nil
+	}
+
+	lockouts, err := IncrementCounter(ctx, "login:lockouts:"+account, 24*time.Hour)
+	if err != nil {
+		return 0, err
+	}
+	lockedFor = policy.lockDuration(lockouts)
+
+	// the next lockout starts counting from zero again
+	if err := rdb.Set(ctx, "login:lock:"+account, 1, lockedFor).Err(); err != nil {
+		return 0, err
+	}
+	if err := rdb.Del(ctx, "counter:login:fail:"+account).Err(); err != nil {
+		return 0, err
+	}
+	return lockedFor, nil
+}
+
+// ResetLoginFailures forgets the failures of an account after a successful password login from ip
+func ResetLoginFailures(ctx context.Context, email, ip string) error {
+	account := lockoutKey(email)
+	return GetRedisClient().Del(ctx,
+		"counter:login:fail:"+account,
+		"counter:login:fail:"+ip+":"+account,
+		"login:delay:"+ip+":"+account,
+	).Err()
+}
+
+// UnlockAccount lifts the lockout of an account and forgets its failures
+func UnlockAccount(ctx context.Context, email string) error {
+	account := lockoutKey(email)
+	return GetRedisClient().Del(ctx,
+		"login:lock:"+account,
+		"counter:login:fail:"+account,
+		"counter:login:lockouts:"+account,
+	).Err()
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	policy := LoadLockoutPolicy()
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		// the third failure from one IP starts the delay, each further one doubles it
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{8, 32 * time.Second},
+		{9, time.Minute},
+		{30, time.Minute},
+		// shifts past the width of a duration must not wrap around to no delay
+		{66, time.Minute},
+		{200, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockDuration(t *testing.T) {
+	policy := LoadLockoutPolicy()
+	tests := []struct {
+		lockouts int64
+		want     time.Duration
+	}{
+		{1, 15 * time.Minute},
+		{2, 30 * time.Minute},
+		{3, time.Hour},
+		{6, 8 * time.Hour},
+		{7, 16 * time.Hour},
+		{8, 24 * time.Hour},
+		{70, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := policy.lockDuration(tt.lockouts); got != tt.want {
+			t.Errorf("lockDuration(%d) = %v, want %v", tt.lockouts, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLockoutPolicy(t *testing.T) {
+	tests := []struct {
+		maxFailures, lockMinutes string
+		wantMax                  int
+		wantLock                 time.Duration
+	}{
+		{"", "", 10, 15 * time.Minute},
+		{"5", "30", 5, 30 * time.Minute},
+		{"0", "-1", 10, 15 * time.Minute},
+		{"many", "long", 10, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOGIN_MAX_FAILURES", tt.maxFailures)
+		t.Setenv("LOGIN_LOCK_MINUTES", tt.lockMinutes)
+		policy := LoadLockoutPolicy()
+		if policy.MaxFailures != tt.wantMax || policy.LockDuration != tt.wantLock {
+			t.Errorf("LoadLockoutPolicy with %q, %q = %d failures, %v lock, want %d, %v",
+				tt.maxFailures, tt.lockMinutes, policy.MaxFailures, policy.LockDuration, tt.wantMax, tt.wantLock)
+		}
+	}
+}
@@ -1,15 +1,222 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"os"
+	"strings"
+	"sync"
 )
 
-var GoogleOauthConfig = &oauth2.Config{
-	ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-	ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-	RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-	Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email, https://www.googleapis.com/auth/userinfo.profile"},
-	Endpoint:     google.Endpoint,
+// defaultIssuers are used when a provider is enabled without an explicit issuer
+var defaultIssuers = map[string]string{
+	"google":   "https://accounts.google.com",
+	"apple":    "https://appleid.apple.com",
+	"facebook": "https://www.facebook.com",
+}
+
+// ClaimMapping maps ID token claims to the fields we store on a user
+type ClaimMapping struct {
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
+
+// OIDCProviderConfig holds the settings of a single social / SSO login provider
+type OIDCProviderConfig struct {
+	Name         string       `json:"name"`
+	Issuer       string       `json:"issuer"`
+	ClientID     string       `json:"client_id"`
+	ClientSecret string       `json:"client_secret"`
+	RedirectURL  string       `json:"redirect_url"`
+	Scopes       []string     `json:"scopes"`
+	ResponseMode string       `json:"response_mode"`
+	ClaimMapping ClaimMapping `json:"claim_mapping"`
+}
+
+// OIDCProvider is a discovered provider ready to start and verify logins
+type OIDCProvider struct {
+	Config   OIDCProviderConfig
+	OAuth2   *oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+}
+
+// OIDCIdentity is the normalized identity extracted from a verified ID token
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+var (
+	oidcProviders   = map[string]*OIDCProvider{}
+	oidcProvidersMu sync.RWMutex
+)
+
+// LoadOIDCProviderConfigs reads provider definitions from OIDC_PROVIDERS_FILE (JSON array)
+// or, when no file is given, from OIDC_PROVIDERS and OIDC_<NAME>_* environment variables
+func LoadOIDCProviderConfigs() ([]OIDCProviderConfig, error) {
+	var configs []OIDCProviderConfig
+
+	if path := os.Getenv("OIDC_PROVIDERS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read OIDC providers file: %w", err)
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("parse OIDC providers file: %w", err)
+		}
+	} else {
+		for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+			prefix := "OIDC_" + strings.ToUpper(name) + "_"
+			configs = append(configs, OIDCProviderConfig{
+				Name:         name,
+				Issuer:       os.Getenv(prefix + "ISSUER"),
+				ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+				Scopes:       splitList(os.Getenv(prefix + "SCOPES")),
+				ResponseMode: os.Getenv(prefix + "RESPONSE_MODE"),
+				ClaimMapping: ClaimMapping{
+					Subject:       os.Getenv(prefix + "CLAIM_SUBJECT"),
+					Email:         os.Getenv(prefix + "CLAIM_EMAIL"),
+					EmailVerified: os.Getenv(prefix + "CLAIM_EMAIL_VERIFIED"),
+					Name:          os.Getenv(prefix + "CLAIM_NAME"),
+					Picture:       os.Getenv(prefix + "CLAIM_PICTURE"),
+				},
+			})
+		}
+	}
+
+	for i := range configs {
+		configs[i].Name = strings.ToLower(configs[i].Name)
+		if configs[i].Issuer == "" {
+			configs[i].Issuer = defaultIssuers[configs[i].Name]
+		}
+		if len(configs[i].Scopes) == 0 {
+			configs[i].Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+		}
+		if configs[i].Name == "" || configs[i].Issuer == "" || configs[i].ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q requires a name, issuer and client id", configs[i].Name)
+		}
+	}
+
+	return configs, nil
+}
+
+// InitOIDCProviders runs discovery for every configured provider and registers the ones that succeed
+func InitOIDCProviders(ctx context.Context) error {
+	configs, err := LoadOIDCProviderConfigs()
+	if err != nil {
+		return err
+	}
+
+	providers := make(map[string]*OIDCProvider, len(configs))
+	for _, cfg := range configs {
+		discovered, err := oidc.NewProvider(ctx, cfg.Issuer)
+		if err != nil {
+			logrus.Errorf("OIDC discovery failed for provider %s: %v", cfg.Name, err)
+			continue
+		}
+
+		providers[cfg.Name] = &OIDCProvider{
+			Config: cfg,
+			OAuth2: &oauth2.Config{
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				RedirectURL:  cfg.RedirectURL,
+				Scopes:       cfg.Scopes,
+				Endpoint:     discovered.Endpoint(),
+			},
+			Verifier: discovered.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		}
+		logrus.Infof("OIDC provider registered: %s (%s)", cfg.Name, cfg.Issuer)
+	}
+
+	oidcProvidersMu.Lock()
+	oidcProviders = providers
+	oidcProvidersMu.Unlock()
+	return nil
+}
+
+// GetOIDCProvider returns the registered provider with the given name
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+
+	provider, ok := oidcProviders[strings.ToLower(name)]
+	return provider, ok
+}
+
+// AuthCodeURL builds the authorization URL for the given state and nonce
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) string {
+	opts := []oauth2.AuthCodeOption{oidc.Nonce(nonce)}
+	if p.Config.ResponseMode != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("response_mode", p.Config.ResponseMode))
+	}
+	return p.OAuth2.AuthCodeURL(state, opts...)
+}
+
+// Identity maps the claims of a verified ID token using the provider's claim mapping
+func (p *OIDCProvider) Identity(idToken *oidc.IDToken) (*OIDCIdentity, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	mapping := p.Config.ClaimMapping
+	identity := &OIDCIdentity{
+		Provider:      p.Config.Name,
+		Subject:       claimString(claims, mapping.Subject, "sub"),
+		Email:         strings.ToLower(claimString(claims, mapping.Email, "email")),
+		EmailVerified: claimBool(claims, mapping.EmailVerified, "email_verified"),
+		Name:          claimString(claims, mapping.Name, "name"),
+		Picture:       claimString(claims, mapping.Picture, "picture"),
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("ID token from %s has no subject claim", p.Config.Name)
+	}
+
+	return identity, nil
+}
+
+func claimString(claims map[string]interface{}, key, fallback string) string {
+	if key == "" {
+		key = fallback
+	}
+	value, _ := claims[key].(string)
+	return value
+}
+
+// claimBool accepts both JSON booleans and the "true"/"false" strings some providers (Apple) send
+func claimBool(claims map[string]interface{}, key, fallback string) bool {
+	if key == "" {
+		key = fallback
+	}
+	switch value := claims[key].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
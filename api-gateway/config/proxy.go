@@ -0,0 +1,43 @@
+package config
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strings"
+)
+
+// LoadIPExtractor decides where c.RealIP() comes from. Without TRUSTED_PROXIES the client address is the peer
+// of the connection and forwarding headers are ignored, so clients cannot pick the IP that rate limits and
+// lockouts count. TRUSTED_PROXIES (comma separated IPs or CIDRs, e.g. 10.0.0.0/8) trusts X-Forwarded-For
+// only when it was added by those proxies
+func LoadIPExtractor() echo.IPExtractor {
+	value := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	if value == "" {
+		return echo.ExtractIPDirect()
+	}
+
+	// the default trust of loopback and private networks is replaced by the configured proxies
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipRange, err := net.ParseCIDR(entry)
+		if err != nil {
+			logrus.Errorf("Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
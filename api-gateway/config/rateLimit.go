@@ -1,9 +1,12 @@
 package config
 
 import (
-	"github.com/labstack/echo/v4"
-	"net/http"
-	"sync"
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,14 +16,7 @@ type RateLimitConfig struct {
 	Enable         bool
 }
 
-type NewRateLimiterStruct struct {
-	Config     *RateLimitConfig
-	Requests   map[string]int
-	LastAccess map[string]time.Time
-	mu         sync.Mutex
-}
-
-// LoadRateLimitConfig loads rate limit configuration
+// LoadRateLimitConfig loads rate limit configuration, RATE_LIMIT_ENABLED=false turns limiting off
 func LoadRateLimitConfig() *RateLimitConfig {
 	timeout := 10 * time.Second
 	rateLimit := 100
@@ -28,48 +24,116 @@ func LoadRateLimitConfig() *RateLimitConfig {
 	return &RateLimitConfig{
 		RequestTimeout: timeout,
 		RateLimit:      rateLimit,
-		Enable:         true,
-	}
-}
-func NewRateLimiter(config *RateLimitConfig) *NewRateLimiterStruct {
-	return &NewRateLimiterStruct{
-		Config:     config,
-		Requests:   make(map[string]int),
-		LastAccess: make(map[string]time.Time),
+		Enable:         os.Getenv("RATE_LIMIT_ENABLED") != "false",
 	}
 }
 
-func (l *NewRateLimiterStruct) Allow(ip string) bool {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// Subjects a rate limit policy can count requests by
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByUser   = "user"
+	RateLimitByAPIKey = "api_key"
+)
 
-	now := time.Now()
+// RateLimitPolicy allows Limit requests per Period for each subject, Name keeps the counters of policies apart
+type RateLimitPolicy struct {
+	Name   string
+	By     string
+	Limit  int
+	Period time.Duration
+}
+
+// LoadRateLimitPolicy reads RATE_LIMIT_<NAME> (e.g. RATE_LIMIT_LOGIN=5/1m) and falls back to def
+func LoadRateLimitPolicy(name, by, def string) RateLimitPolicy {
+	policy := RateLimitPolicy{Name: name, By: by}
+	envKey := "RATE_LIMIT_" + strings.ToUpper(strings.NewReplacer("-", "_", "/", "_").Replace(name))
+	if value := os.Getenv(envKey); value != "" {
+		if limit, period, err := parseRate(value); err == nil {
+			policy.Limit, policy.Period = limit, period
+			return policy
+		}
+	}
 
-	if last, found := l.LastAccess[ip]; found && now.Sub(last) > time.Minute {
-		l.Requests[ip] = 0
-		l.LastAccess[ip] = now
+	limit, period, err := parseRate(def)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default rate limit %q for %s: %v", def, name, err))
 	}
+	policy.Limit, policy.Period = limit, period
+	return policy
+}
 
-	l.Requests[ip]++
-	if l.Requests[ip] > l.Config.RateLimit {
-		return false
+// parseRate parses "<limit>/<period>" where period is a Go duration such as 1m or 1h
+func parseRate(value string) (int, time.Duration, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected <limit>/<period>")
 	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("invalid limit %q", parts[0])
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("invalid period %q", parts[1])
+	}
+	return limit, period, nil
+}
 
-	l.LastAccess[ip] = now
-	return true
+// RateLimitResult is the state of a policy for one subject after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is when the subject has its full limit again, RetryAfter when a refused request may retry
+	ResetAfter time.Duration
+	RetryAfter time.Duration
 }
 
-// CheckRateLimit checks if the rate limit is enabled and if the request is allowed
-func CheckRateLimit(c echo.Context) error {
-	rateLimit := LoadRateLimitConfig()
-	limiter := NewRateLimiter(rateLimit)
+// gcraScript implements the generic cell rate algorithm: the key stores the theoretical arrival time (TAT)
+// in milliseconds, a request is allowed while the TAT is less than limit*interval ahead of now.
+// The clock of Redis is used so that all gateway replicas agree.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - interval * limit
+if now < allow_at then
+	return {0, 0, tat - now, allow_at - now}
+end
 
-	ip := c.RealIP()
-	if rateLimit.Enable && !limiter.Allow(ip) {
-		return c.JSON(http.StatusTooManyRequests, echo.Map{
-			"message": "Rate limit exceeded",
-		})
+redis.call('SET', KEYS[1], new_tat, 'PX', new_tat - now)
+return {1, math.floor((now - allow_at) / interval), new_tat - now, 0}
+`)
+
+// AllowRequest counts a request of subject against policy
+func AllowRequest(ctx context.Context, policy RateLimitPolicy, subject string) (RateLimitResult, error) {
+	interval := policy.Period.Milliseconds() / int64(policy.Limit)
+	if interval < 1 {
+		interval = 1
+	}
+
+	key := "ratelimit:" + policy.Name + ":" + policy.By + ":" + subject
+	values, err := gcraScript.Run(ctx, GetRedisClient(), []string{key}, interval, policy.Limit).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit reply %v", values)
 	}
 
-	return nil
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      policy.Limit,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Millisecond,
+		RetryAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
 }
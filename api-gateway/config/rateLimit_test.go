@@ -0,0 +1,121 @@
+package config
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantLimit  int
+		wantPeriod time.Duration
+		wantErr    bool
+	}{
+		{"5/1m", 5, time.Minute, false},
+		{" 100 / 1h ", 100, time.Hour, false},
+		{"10/30s", 10, 30 * time.Second, false},
+		{"5", 0, 0, true},
+		{"0/1m", 0, 0, true},
+		{"-1/1m", 0, 0, true},
+		{"five/1m", 0, 0, true},
+		{"5/minute", 0, 0, true},
+		{"5/0s", 0, 0, true},
+	}
+	for _, tt := range tests {
+		limit, period, err := parseRate(tt.value)
+		if (err != nil) != tt.wantErr || limit != tt.wantLimit || period != tt.wantPeriod {
+			t.Errorf("parseRate(%q) = %d, %v, %v, want %d, %v, error %v", tt.value, limit, period, err, tt.wantLimit, tt.wantPeriod, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadRateLimitPolicy(t *testing.T) {
+	t.Setenv("RATE_LIMIT_OAUTH_LINK_CONFIRM", "3/10m")
+	t.Setenv("RATE_LIMIT_LOGIN", "not a rate")
+
+	tests := []struct {
+		name       string
+		wantLimit  int
+		wantPeriod time.Duration
+	}{
+		{"oauth/link-confirm", 3, 10 * time.Minute},
+		// an invalid setting falls back to the default
+		{"login", 5, time.Minute},
+		{"register", 5, time.Minute},
+	}
+	for _, tt := range tests {
+		policy := LoadRateLimitPolicy(tt.name, RateLimitByIP, "5/1m")
+		if policy.Name != tt.name || policy.By != RateLimitByIP || policy.Limit != tt.wantLimit || policy.Period != tt.wantPeriod {
+			t.Errorf("LoadRateLimitPolicy(%q) = %+v, want %d per %v", tt.name, policy, tt.wantLimit, tt.wantPeriod)
+		}
+	}
+}
+
+// TestAllowRequestGCRA runs the GCRA script against a real Redis, e.g.
+//
+//	docker run -p 6379:6379 redis:7
+//	REDIS_TEST_ADDR=localhost:6379 go test ./config/
+func TestAllowRequestGCRA(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	t.Setenv("REDIS_URI", addr)
+	ctx := context.Background()
+
+	// one request every 20 seconds with a burst of 3, long enough that the test itself takes no time
+	policy := RateLimitPolicy{Name: "test-" + time.Now().Format("150405.000000000"), By: RateLimitByIP, Limit: 3, Period: time.Minute}
+	interval := 20 * time.Second
+	t.Cleanup(func() { GetRedisClient().Del(ctx, "ratelimit:"+policy.Name+":"+policy.By+":gcra") })
+
+	near := func(got, want time.Duration) bool { return got <= want && got > want-2*time.Second }
+	for i := 1; i <= policy.Limit; i++ {
+		result, err := AllowRequest(ctx, policy, "gcra")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each request pushes the theoretical arrival time one interval further
+		if !result.Allowed || result.Remaining != policy.Limit-i || !near(result.ResetAfter, time.Duration(i)*interval) || result.RetryAfter != 0 {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining, reset after %v", i, result, policy.Limit-i, time.Duration(i)*interval)
+		}
+	}
+
+	result, err := AllowRequest(ctx, policy, "gcra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a refused request does not move the arrival time, it may retry once one interval has passed
+	if result.Allowed || result.Remaining != 0 || !near(result.RetryAfter, interval) || !near(result.ResetAfter, 3*interval) {
+		t.Fatalf("request over the limit: got %+v, want refused, retry after %v, reset after %v", result, interval, 3*interval)
+	}
+
+	// the subjects of a policy are counted apart
+	if other, err := AllowRequest(ctx, policy, "gcra-other"); err != nil || !other.Allowed || other.Remaining != policy.Limit-1 {
+		t.Fatalf("request of another subject: got %+v, %v, want allowed", other, err)
+	}
+	GetRedisClient().Del(ctx, "ratelimit:"+policy.Name+":"+policy.By+":gcra-other")
+}
+
+// TestAllowRequestInterval checks the interval of policies with more requests than milliseconds in the period
+func TestAllowRequestInterval(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	t.Setenv("REDIS_URI", addr)
+	ctx := context.Background()
+
+	policy := RateLimitPolicy{Name: "test-" + time.Now().Format("150405.000000000"), By: RateLimitByIP, Limit: 5000, Period: time.Second}
+	defer GetRedisClient().Del(ctx, "ratelimit:"+policy.Name+":"+policy.By+":fast")
+	result, err := AllowRequest(ctx, policy, "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the interval is rounded up to a millisecond rather than down to zero, which would never limit
+	if !result.Allowed || result.ResetAfter != time.Millisecond {
+		t.Fatalf("got %+v, want allowed with a reset after 1ms", result)
+	}
+}
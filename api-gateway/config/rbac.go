@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Permissions of the role definitions in user-service, written as <resource>:<action>
+const (
+	PermissionProfileRead  = "profile:read"
+	PermissionProfileWrite = "profile:write"
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionDealsPublish = "deals:publish"
+	PermissionRolesWrite   = "roles:write"
+)
+
+// RoleFetcher loads every role with its inherited permissions resolved
+type RoleFetcher func(ctx context.Context) (map[string][]string, error)
+
+// RoleCache keeps role permissions in memory and in Redis, so replicas share one copy
+// and user-service is only asked once per ttl
+type RoleCache struct {
+	fetch RoleFetcher
+	ttl   time.Duration
+
+	mu       sync.RWMutex
+	roles    map[string]map[string]bool
+	loadedAt time.Time
+}
+
+func NewRoleCache(ttl time.Duration, fetch RoleFetcher) *RoleCache {
+	return &RoleCache{
+		fetch: fetch,
+		ttl:   ttl,
+	}
+}
+
+// HasPermission reports whether the role grants the permission
+func (c *RoleCache) HasPermission(ctx context.Context, role, permission string) (bool, error) {
+	roles, err := c.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	return roles[role][permission], nil
+}
+
+// Invalidate drops both cache levels so the next check reloads the roles
+func (c *RoleCache) Invalidate(ctx context.Context) error {
+	c.mu.Lock()
+	c.roles = nil
+	c.mu.Unlock()
+	return GetRedisClient().Del(ctx, "rbac:roles").Err()
+}
+
+func (c *RoleCache) load(ctx context.Context) (map[string]map[string]bool, error) {
+	c.mu.RLock()
+	roles, loadedAt := c.roles, c.loadedAt
+	c.mu.RUnlock()
+	if roles != nil && time.Since(loadedAt) < c.ttl {
+		return roles, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.roles != nil && time.Since(c.loadedAt) < c.ttl {
+		return c.roles, nil
+	}
+
+	permissions, err := c.loadShared(ctx)
+	if err != nil {
+		// keep answering with stale roles rather than locking everyone out
+		if c.roles != nil {
+			logrus.Warnf("Failed to reload roles, using cached roles: %v", err)
+			return c.roles, nil
+		}
+		return nil, err
+	}
+
+	c.roles = make(map[string]map[string]bool, len(permissions))
+	for role, granted := range permissions {
+		c.roles[role] = make(map[string]bool, len(granted))
+		for _, permission := range granted {
+			c.roles[role][permission] = true
+		}
+	}
+	c.loadedAt = time.Now()
+	return c.roles, nil
+}
+
+// loadShared reads the roles cached in Redis, fetching and storing them when missing
+func (c *RoleCache) loadShared(ctx context.Context) (map[string][]string, error) {
+	rdb := GetRedisClient()
+	var permissions map[string][]string
+
+	payload, err := rdb.Get(ctx, "rbac:roles").Bytes()
+	if err == nil && json.Unmarshal(payload, &permissions) == nil {
+		return permissions, nil
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		logrus.Warnf("Failed to read cached roles: %v", err)
+	}
+
+	permissions, err = c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if payload, err := json.Marshal(permissions); err == nil {
+		if err := rdb.Set(ctx, "rbac:roles", payload, c.ttl).Err(); err != nil {
+			logrus.Warnf("Failed to cache roles: %v", err)
+		}
+	}
+	return permissions, nil
+}
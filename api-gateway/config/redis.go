@@ -2,15 +2,25 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sync"
 	"time"
 )
 
 var Ctx = context.Background()
 
+var (
+	redisClient *redis.Client
+	redisOnce   sync.Once
+)
+
 // NewRedisClient function to initialize Redis client
 func NewRedisClient() *redis.Client {
 	rdb := redis.NewClient(&redis.Options{
@@ -26,9 +36,17 @@ func NewRedisClient() *redis.Client {
 	return rdb
 }
 
+// GetRedisClient returns the shared Redis client, connecting on first use
+func GetRedisClient() *redis.Client {
+	redisOnce.Do(func() {
+		redisClient = NewRedisClient()
+	})
+	return redisClient
+}
+
 // StoreTokenInRedis function to store token in Redis
 func StoreTokenInRedis(ctx context.Context, token, userID, role string, ttlHours int) error {
-	rdb := NewRedisClient()
+	rdb := GetRedisClient()
 
 	tokenData := map[string]interface{}{
 		"userID": userID,
@@ -40,16 +58,325 @@ func StoreTokenInRedis(ctx context.Context, token, userID, role string, ttlHours
 		return err
 	}
 
-	err = rdb.Set(ctx, token, string(tokenJSON), time.Duration(ttlHours)*time.Hour).Err()
+	ttl := time.Duration(ttlHours) * time.Hour
+	err = rdb.Set(ctx, token, string(tokenJSON), ttl).Err()
+	if err != nil {
+		return err
+	}
+
+	// remember the user's tokens so all of them can be revoked at once
+	sessionsKey := "user_sessions:" + userID
+	pipe := rdb.TxPipeline()
+	pipe.SAdd(ctx, sessionsKey, token)
+	pipe.Expire(ctx, sessionsKey, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RevokeUserSessions deletes every token issued to the user, signing them out everywhere
+func RevokeUserSessions(ctx context.Context, userID string) error {
+	rdb := GetRedisClient()
+	sessionsKey := "user_sessions:" + userID
+
+	tokens, err := rdb.SMembers(ctx, sessionsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	return rdb.Del(ctx, append(tokens, sessionsKey)...).Err()
+}
+
+// RevokeOtherSessions deletes every token of the user except keepToken, signing them out everywhere else
+func RevokeOtherSessions(ctx context.Context, userID, keepToken string) error {
+	rdb := GetRedisClient()
+	sessionsKey := "user_sessions:" + userID
+
+	tokens, err := rdb.SMembers(ctx, sessionsKey).Result()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	var revoked []string
+	for _, token := range tokens {
+		if token != keepToken {
+			revoked = append(revoked, token)
+		}
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, revoked...)
+	pipe.SRem(ctx, sessionsKey, revoked)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Session is a signed-in session of a user without its token, ID is a stable fingerprint of the token
+type Session struct {
+	ID        string     `json:"id"`
+	Role      string     `json:"role,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ListUserSessions returns the sessions of the user that are still stored, expired tokens are skipped
+func ListUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	rdb := GetRedisClient()
+
+	tokens, err := rdb.SMembers(ctx, "user_sessions:"+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, token := range tokens {
+		data, err := rdb.Get(ctx, token).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var stored struct {
+			Role string `json:"role"`
+		}
+		_ = json.Unmarshal([]byte(data), &stored)
+
+		fingerprint := sha256.Sum256([]byte(token))
+		session := Session{ID: hex.EncodeToString(fingerprint[:8]), Role: stored.Role}
+
+		// the token was verified when it was issued, only its dates are read here
+		var claims jwt.RegisteredClaims
+		if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil {
+			if claims.IssuedAt != nil {
+				session.IssuedAt = &claims.IssuedAt.Time
+			}
+			if claims.ExpiresAt != nil {
+				session.ExpiresAt = &claims.ExpiresAt.Time
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
 }
+
 func BlacklistToken(token string, expiration time.Duration) error {
-	rdb := NewRedisClient()
+	rdb := GetRedisClient()
 	ctx := context.Background()
 
 	return rdb.Set(ctx, "blacklist:"+token, "blacklisted", expiration).Err()
 }
+
+// OAuth flow modes stored in the state
+const (
+	OAuthModeLogin = "login"
+	OAuthModeLink  = "link"
+)
+
+// OAuthState is what we remember between redirecting to a provider and its callback
+type OAuthState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Mode     string `json:"mode"`
+	UserID   string `json:"user_id,omitempty"`
+}
+
+// PendingLink is a verified provider identity waiting for the owner of the matching email to prove their password
+type PendingLink struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// StoreOAuthState saves the state parameter of an authorization request
+func StoreOAuthState(ctx context.Context, state string, data OAuthState, ttl time.Duration) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return GetRedisClient().Set(ctx, "oauth:state:"+state, payload, ttl).Err()
+}
+
+// ConsumeOAuthState loads and deletes a state so each callback can only be used once
+func ConsumeOAuthState(ctx context.Context, state string) (*OAuthState, error) {
+	payload, err := GetRedisClient().GetDel(ctx, "oauth:state:"+state).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("unknown or expired OAuth state")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data OAuthState
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// StorePendingLink remembers a provider identity that needs password confirmation before it is linked
+func StorePendingLink(ctx context.Context, token string, data PendingLink, ttl time.Duration) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return GetRedisClient().Set(ctx, "oauth:pending:"+token, payload, ttl).Err()
+}
+
+// ConsumePendingLink loads and deletes a pending identity link, each link token allows one password attempt
+func ConsumePendingLink(ctx context.Context, token string) (*PendingLink, error) {
+	payload, err := GetRedisClient().GetDel(ctx, "oauth:pending:"+token).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("unknown or expired link token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data PendingLink
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// MFAChallenge is a login that passed the password check and waits for a second factor
+type MFAChallenge struct {
+	UserID             string `json:"user_id"`
+	Email              string `json:"email"`
+	Role               string `json:"role"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+	// Link is a provider identity that is linked once the second factor is proven
+	Link *PendingLink `json:"link,omitempty"`
+}
+
+// StoreMFAChallenge saves a challenge under its token
+func StoreMFAChallenge(ctx context.Context, token string, data MFAChallenge, ttl time.Duration) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return GetRedisClient().Set(ctx, "mfa:challenge:"+token, payload, ttl).Err()
+}
+
+// GetMFAChallenge loads a pending challenge
+func GetMFAChallenge(ctx context.Context, token string) (*MFAChallenge, error) {
+	payload, err := GetRedisClient().Get(ctx, "mfa:challenge:"+token).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("unknown or expired MFA challenge")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data MFAChallenge
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// IncrementMFAChallengeAttempts counts verification attempts for a challenge
+func IncrementMFAChallengeAttempts(ctx context.Context, token string, ttl time.Duration) (int64, error) {
+	key := "mfa:attempts:" + token
+	attempts, err := GetRedisClient().Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if attempts == 1 {
+		GetRedisClient().Expire(ctx, key, ttl)
+	}
+	return attempts, nil
+}
+
+// DeleteMFAChallenge removes a challenge once it is finished or exhausted
+func DeleteMFAChallenge(ctx context.Context, token string) error {
+	return GetRedisClient().Del(ctx, "mfa:challenge:"+token, "mfa:attempts:"+token).Err()
+}
+
+// PhoneOTP is a verification code sent to a phone number that waits for confirmation
+type PhoneOTP struct {
+	Phone    string `json:"phone"`
+	CodeHash string `json:"code_hash"`
+}
+
+// StorePhoneOTP saves the pending code of a user, replacing any earlier one
+func StorePhoneOTP(ctx context.Context, userID string, data PhoneOTP, ttl time.Duration) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	rdb := GetRedisClient()
+	if err := rdb.Del(ctx, "phone_otp:attempts:"+userID).Err(); err != nil {
+		return err
+	}
+	return rdb.Set(ctx, "phone_otp:"+userID, payload, ttl).Err()
+}
+
+// GetPhoneOTP loads the pending code of a user
+func GetPhoneOTP(ctx context.Context, userID string) (*PhoneOTP, error) {
+	payload, err := GetRedisClient().Get(ctx, "phone_otp:"+userID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("unknown or expired phone verification code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data PhoneOTP
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// IncrementPhoneOTPAttempts counts verification attempts for the pending code of a user
+func IncrementPhoneOTPAttempts(ctx context.Context, userID string, ttl time.Duration) (int64, error) {
+	key := "phone_otp:attempts:" + userID
+	attempts, err := GetRedisClient().Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if attempts == 1 {
+		GetRedisClient().Expire(ctx, key, ttl)
+	}
+	return attempts, nil
+}
+
+// DeletePhoneOTP removes a code once it is confirmed or exhausted
+func DeletePhoneOTP(ctx context.Context, userID string) error {
+	return GetRedisClient().Del(ctx, "phone_otp:"+userID, "phone_otp:attempts:"+userID).Err()
+}
+
+// AcquireCooldown returns true when the key was free and starts its cooldown, false while a cooldown is running
+func AcquireCooldown(ctx context.Context, key string, cooldown time.Duration) (bool, error) {
+	return GetRedisClient().SetNX(ctx, "cooldown:"+key, 1, cooldown).Result()
+}
+
+// IncrementCounter counts events in a fixed window that starts with the first event
+func IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error) {
+	rdb := GetRedisClient()
+	count, err := rdb.Incr(ctx, "counter:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		rdb.Expire(ctx, "counter:"+key, window)
+	}
+	return count, nil
+}
+
+// MarkEmailVerified remembers a verification that happened after the user's tokens were issued
+func MarkEmailVerified(ctx context.Context, userID string, ttl time.Duration) error {
+	return GetRedisClient().Set(ctx, "email_verified:"+userID, 1, ttl).Err()
+}
+
+// IsEmailVerified reports whether the user verified the email since signing in
+func IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	count, err := GetRedisClient().Exists(ctx, "email_verified:"+userID).Result()
+	return count > 0, err
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	webAuthn   *webauthn.WebAuthn
+	webAuthnMu sync.RWMutex
+)
+
+// InitWebAuthn configures the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and WEBAUTHN_RP_ORIGINS
+func InitWebAuthn() error {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	origins := splitList(os.Getenv("WEBAUTHN_RP_ORIGINS"))
+	if rpID == "" || len(origins) == 0 {
+		return errors.New("WEBAUTHN_RP_ID and WEBAUTHN_RP_ORIGINS are required for passkeys")
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "DubaiDeals"
+	}
+
+	instance, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return fmt.Errorf("configure WebAuthn: %w", err)
+	}
+
+	webAuthnMu.Lock()
+	webAuthn = instance
+	webAuthnMu.Unlock()
+	return nil
+}
+
+// GetWebAuthn returns the configured relying party, false when passkeys are disabled
+func GetWebAuthn() (*webauthn.WebAuthn, bool) {
+	webAuthnMu.RLock()
+	defer webAuthnMu.RUnlock()
+	return webAuthn, webAuthn != nil
+}
+
+// StoreWebAuthnSession saves the challenge of a registration or login ceremony
+func StoreWebAuthnSession(ctx context.Context, key string, session *webauthn.SessionData, ttl time.Duration) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return GetRedisClient().Set(ctx, "webauthn:"+key, payload, ttl).Err()
+}
+
+// ConsumeWebAuthnSession loads and deletes a ceremony so each challenge can only be answered once
+func ConsumeWebAuthnSession(ctx context.Context, key string) (*webauthn.SessionData, error) {
+	payload, err := GetRedisClient().GetDel(ctx, "webauthn:"+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("unknown or expired WebAuthn challenge")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
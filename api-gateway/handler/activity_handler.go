@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+	events "user-service/core/models"
+)
+
+// MyActivity lists the audit trail of the signed-in user, newest first
+func (h *UserHandler) MyActivity(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var query models.ActivityListRequest
+	if err := c.Bind(&query); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	// the user is always the signed-in one
+	query.UserID, query.ActorID = "", ""
+	if err := query.Validate(); err != nil {
+		return validationErrorResponse(c, &query, err)
+	}
+
+	event := activityListEvent(query)
+	event.UserID = claims.UserID
+	return h.listActivity(c, "ActivityList", event)
+}
+
+// AdminListActivity lists the audit trail of every user, filtered by user, actor, action and time
+func (h *UserHandler) AdminListActivity(c echo.Context) error {
+	var query models.ActivityListRequest
+	if err := c.Bind(&query); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := query.Validate(); err != nil {
+		return validationErrorResponse(c, &query, err)
+	}
+
+	return h.listActivity(c, "AdminActivityList", activityListEvent(query))
+}
+
+// AdminUserActivity lists the audit trail of one user
+func (h *UserHandler) AdminUserActivity(c echo.Context) error {
+	var query models.ActivityListRequest
+	if err := c.Bind(&query); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	query.UserID = c.Param("id")
+	if err := query.Validate(); err != nil {
+		return validationErrorResponse(c, &query, err)
+	}
+
+	return h.listActivity(c, "AdminActivityList", activityListEvent(query))
+}
+
+// listActivity publishes eventName and answers with the page of the audit trail user-service replies with
+func (h *UserHandler) listActivity(c echo.Context, eventName string, event events.ActivityListEvent) error {
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), eventName, correlationID, event); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(c, false, http.StatusOK, h.Config.RequestTimeout, "Activity retrieved successfully", eventName+"Success", eventName+"Failed")
+}
+
+// activityListEvent converts a validated query, its times are already known to parse
+func activityListEvent(query models.ActivityListRequest) events.ActivityListEvent {
+	event := events.ActivityListEvent{
+		UserID:  query.UserID,
+		ActorID: query.ActorID,
+		Action:  query.Action,
+		Page:    query.Page,
+		Limit:   query.Limit,
+	}
+	if from, err := time.Parse(time.RFC3339, query.From); err == nil {
+		event.From = &from
+	}
+	if to, err := time.Parse(time.RFC3339, query.To); err == nil {
+		event.To = &to
+	}
+	return event
+}
+
+// requestMetadata describes the HTTP request behind an event, user-service records it in the audit trail
+func requestMetadata(c echo.Context) *events.RequestMetadata {
+	metadata := &events.RequestMetadata{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if claims, ok := c.Get("user").(*utils.JWTCustomClaims); ok && claims != nil {
+		metadata.ActorID = claims.UserID
+	}
+	return metadata
+}
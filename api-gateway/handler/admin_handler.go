@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"strings"
+	"user-service/core/apperror"
+)
+
+// AdminListUsers lists users with filters and pagination
+func (h *UserHandler) AdminListUsers(c echo.Context) error {
+	var query models.AdminListUsersRequest
+	if err := c.Bind(&query); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := query.Validate(); err != nil {
+		return validationErrorResponse(c, &query, err)
+	}
+
+	event := models.AdminListUsersEvent{
+		Query:  query.Query,
+		Role:   strings.ToUpper(query.Role),
+		Status: query.Status,
+		Page:   query.Page,
+		Limit:  query.Limit,
+	}
+	if query.EmailVerified != "" {
+		verified := query.EmailVerified == "true"
+		event.EmailVerified = &verified
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AdminListUsers", correlationID, event); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(c, false, http.StatusOK, h.Config.RequestTimeout, "Users retrieved successfully", "AdminListUsersSuccess", "AdminListUsersFailed")
+}
+
+// AdminGetUser returns the details of one user
+func (h *UserHandler) AdminGetUser(c echo.Context) error {
+	return h.adminUserAction(c, "AdminGetUser", models.AdminUserRequest{}, "User retrieved successfully", false)
+}
+
+// AdminChangeRole gives a user another role, the user has to sign in again to use it
+func (h *UserHandler) AdminChangeRole(c echo.Context) error {
+	var requestBody models.AdminChangeRoleRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.adminUserAction(c, "AdminChangeRole", models.AdminUserRequest{
+		Role: strings.ToUpper(requestBody.Role),
+	}, "Role changed successfully", true)
+}
+
+// AdminSuspendUser blocks every login of a user and signs it out
+func (h *UserHandler) AdminSuspendUser(c echo.Context) error {
+	var requestBody models.AdminSuspendRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.adminUserAction(c, "AdminSuspendUser", models.AdminUserRequest{
+		Reason: requestBody.Reason,
+		Until:  requestBody.Until,
+	}, "User suspended successfully", true)
+}
+
+// AdminReactivateUser lifts a suspension
+func (h *UserHandler) AdminReactivateUser(c echo.Context) error {
+	var requestBody models.AdminReactivateRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.adminUserAction(c, "AdminReactivateUser", models.AdminUserRequest{
+		Reason: requestBody.Reason,
+	}, "User reactivated successfully", false)
+}
+
+// AdminForcePasswordReset emails a reset link, blocks password logins until it is used and signs the user out
+func (h *UserHandler) AdminForcePasswordReset(c echo.Context) error {
+	var requestBody models.AdminReasonRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.adminUserAction(c, "AdminForcePasswordReset", models.AdminUserRequest{
+		Reason: requestBody.Reason,
+	}, "Password reset email sent", true)
+}
+
+// AdminDeleteUser deletes a user and signs it out
+func (h *UserHandler) AdminDeleteUser(c echo.Context) error {
+	var requestBody models.AdminReasonRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.adminUserAction(c, "AdminDeleteUser", models.AdminUserRequest{
+		Reason: requestBody.Reason,
+	}, "User deleted successfully", true)
+}
+
+// adminUserAction publishes an admin action on the user in the :id path parameter, naming the signed-in admin
+// as the actor. revokeSessions signs the user out once user-service confirms the action.
+func (h *UserHandler) adminUserAction(c echo.Context, eventName string, request models.AdminUserRequest, message string, revokeSessions bool) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	request.UserID = c.Param("id")
+	request.ActorID = claims.UserID
+	request.ActorRole = claims.Role
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), eventName, correlationID, request); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	successEvent, failedEvent := eventName+"Success", eventName+"Failed"
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", successEvent, failedEvent)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if revokeSessions && responseEvent.EventType == successEvent {
+		if err := config.RevokeUserSessions(c.Request().Context(), request.UserID); err != nil {
+			logrus.Errorf("Failed to revoke sessions of user %s: %v", request.UserID, err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, message, successEvent, failedEvent)
+}
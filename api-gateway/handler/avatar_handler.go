@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"messaging"
+	"net/http"
+	"strconv"
+	"strings"
+	"user-service/core/apperror"
+)
+
+// UploadAvatar resizes the uploaded image, stores every variant and points the signed-in user at them
+func (h *UserHandler) UploadAvatar(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("The avatar file is required"), nil)
+	}
+	if fileHeader.Size > utils.AvatarMaxBytes {
+		return webResponse.ResponseProblem(c, apperror.ErrPayloadTooLarge.WithMessage("Avatar must not be larger than 5 MB"), nil)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Failed to read the avatar file"), nil)
+	}
+	defer file.Close()
+
+	// the declared size can lie, never read more than the limit
+	data, err := io.ReadAll(io.LimitReader(file, utils.AvatarMaxBytes+1))
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Failed to read the avatar file"), nil)
+	}
+	if len(data) > utils.AvatarMaxBytes {
+		return webResponse.ResponseProblem(c, apperror.ErrPayloadTooLarge.WithMessage("Avatar must not be larger than 5 MB"), nil)
+	}
+
+	variants, err := utils.ProcessAvatar(data)
+	if errors.Is(err, utils.ErrUnsupportedImage) {
+		return webResponse.ResponseProblem(c, apperror.ErrUnsupportedMedia.WithMessage(err.Error()), nil)
+	}
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(err.Error()), nil)
+	}
+
+	// a new name per upload so caches never serve the previous avatar
+	version, err := utils.GenerateSecureToken(12)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store avatar"), nil)
+	}
+
+	ctx := c.Request().Context()
+	urls := make(map[string]string, len(variants))
+	var keys []string
+	for _, variant := range variants {
+		key := fmt.Sprintf("avatars/%s/%s-%d.%s", claims.UserID, version, variant.Size, variant.Extension)
+		url, err := h.Avatars.Put(ctx, key, variant.Data, variant.ContentType)
+		if err != nil {
+			logrus.Errorf("Failed to store avatar %s: %v", key, err)
+			h.deleteAvatarFiles(c, keys)
+			return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store avatar"), nil)
+		}
+		urls[strconv.Itoa(variant.Size)] = url
+		keys = append(keys, key)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AvatarUpdate", correlationID, models.AvatarUpdateRequest{
+		UserID:   claims.UserID,
+		Avatar:   urls["256"],
+		Variants: urls,
+	})
+	if err != nil {
+		h.deleteAvatarFiles(c, keys)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "AvatarUpdateSuccess", "AvatarUpdateFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+	if responseEvent.EventType == "AvatarUpdateFailed" {
+		h.deleteAvatarFiles(c, keys)
+	}
+	if payload, ok := responseEvent.Payload.(map[string]interface{}); ok {
+		h.deleteReplacedAvatar(c, claims.UserID, payload["replaced_variants"])
+		delete(payload, "replaced_variants")
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Avatar updated successfully", "AvatarUpdateSuccess", "AvatarUpdateFailed")
+}
+
+// deleteReplacedAvatar removes the files of the avatar an update replaced. Only uploads of the user are deleted,
+// a picture of a social login lives elsewhere
+func (h *UserHandler) deleteReplacedAvatar(c echo.Context, userID string, replaced interface{}) {
+	variants, _ := replaced.(map[string]interface{})
+	var keys []string
+	for _, value := range variants {
+		link, _ := value.(string)
+		if key, ok := h.Avatars.Key(link); ok && strings.HasPrefix(key, "avatars/"+userID+"/") {
+			keys = append(keys, key)
+		}
+	}
+	h.deleteAvatarFiles(c, keys)
+}
+
+// deleteAvatarFiles removes avatar files, a file that cannot be deleted is only logged
+func (h *UserHandler) deleteAvatarFiles(c echo.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.Avatars.Delete(c.Request().Context(), key); err != nil {
+			logrus.Warnf("Failed to delete avatar %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+	events "user-service/core/models"
+)
+
+// recentLoginWindow is how long after signing in a user without a password counts as re-authenticated
+const recentLoginWindow = 5 * time.Minute
+
+// pendingLinkTTL is how long a social login that matched an existing email can be confirmed
+const pendingLinkTTL = 10 * time.Minute
+
+// LinkIdentity re-authenticates the signed-in user and returns the provider URL to link an account
+func (h *UserHandler) LinkIdentity(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	provider, ok := config.GetOIDCProvider(c.Param("provider"))
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Unknown OAuth provider"), nil)
+	}
+
+	var requestBody models.ReauthenticateRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	requestBody.ID = claims.UserID
+	requestBody.RecentLogin = claims.IssuedAt != nil && time.Since(claims.IssuedAt.Time) < recentLoginWindow
+
+	if err := h.reauthenticate(c, claims.Email, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	state, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate OAuth state"), nil)
+	}
+	nonce, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate OAuth nonce"), nil)
+	}
+
+	err = config.StoreOAuthState(c.Request().Context(), state, config.OAuthState{
+		Provider: provider.Config.Name,
+		Nonce:    nonce,
+		Mode:     config.OAuthModeLink,
+		UserID:   claims.UserID,
+	}, oauthStateTTL)
+	if err != nil {
+		logrus.Errorf("Failed to store OAuth state: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store OAuth state"), nil)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusOK, echo.Map{
+		"authorization_url": provider.AuthCodeURL(state, nonce),
+	}, "Continue at the provider to link your account")
+}
+
+// UnlinkIdentity removes a provider from the signed-in user as long as another login method remains
+func (h *UserHandler) UnlinkIdentity(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "IdentityUnlink", correlationID, models.IdentityUnlinkRequest{
+		UserID:   claims.UserID,
+		Provider: c.Param("provider"),
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusOK,
+		h.Config.RequestTimeout,
+		"Identity unlinked successfully",
+		"IdentityUnlinkSuccess",
+		"IdentityUnlinkFailed",
+	)
+}
+
+// ConfirmLink links a pending provider identity to the account with the same email after checking its password
+func (h *UserHandler) ConfirmLink(c echo.Context) error {
+	var requestBody models.ConfirmLinkRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	// the link token is spent by every attempt, a wrong password needs a new social login
+	ctx := c.Request().Context()
+	pending, err := config.ConsumePendingLink(ctx, requestBody.LinkToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired link token"), nil)
+	}
+
+	// the password is checked like a login, so locked accounts and delayed IPs are refused first
+	if appErr := checkPasswordAllowed(c, pending.Email); appErr != nil {
+		return webResponse.ResponseProblem(c, appErr, nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "IdentityLinkConfirm", correlationID, models.IdentityLinkRequest{
+		Email:         pending.Email,
+		Password:      requestBody.Password,
+		Provider:      pending.Provider,
+		Subject:       pending.Subject,
+		ProviderEmail: pending.Email,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "IdentityLinkConfirmSuccess", "IdentityLinkConfirmFailed", "IdentityLinkConfirmMfaRequired")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	h.recordPasswordResult(c, pending.Email, replyError(responseEvent, "IdentityLinkConfirmFailed"))
+
+	// the identity is only linked once the second factor is proven
+	if responseEvent.EventType == "IdentityLinkConfirmMfaRequired" {
+		return h.respondMFAChallenge(c, responseEvent.Payload, pending)
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, http.StatusOK, "Identity linked successfully", "IdentityLinkConfirmSuccess", "IdentityLinkConfirmFailed")
+}
+
+// respondLinkRequired parks a verified identity whose email belongs to an existing account
+func (h *UserHandler) respondLinkRequired(c echo.Context, identity *config.OIDCIdentity, payload interface{}) error {
+	var linkRequired struct {
+		HasPassword bool `json:"has_password"`
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	_ = json.Unmarshal(payloadBytes, &linkRequired)
+
+	// only an address the provider verified may be matched to an existing account
+	if !identity.EmailVerified {
+		return webResponse.ResponseProblem(c, apperror.ErrProviderEmailUnverified, nil)
+	}
+	if !linkRequired.HasPassword {
+		return webResponse.ResponseProblem(c, apperror.ErrEmailTaken.WithMessage("Email already registered, sign in with your existing provider and link this one from your account"), nil)
+	}
+
+	linkToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate link token"), nil)
+	}
+
+	err = config.StorePendingLink(c.Request().Context(), linkToken, config.PendingLink{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+	}, pendingLinkTTL)
+	if err != nil {
+		logrus.Errorf("Failed to store pending link: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store pending link"), nil)
+	}
+
+	return webResponse.ResponseProblem(c, apperror.ErrEmailTaken.WithMessage("Email already registered, confirm your password to link this provider"), echo.Map{
+		"link_required": true,
+		"link_token":    linkToken,
+		"email":         identity.Email,
+		"provider":      identity.Provider,
+	})
+}
+
+// reauthenticate asks user-service to confirm the signed-in user's password (or a fresh login),
+// a password is checked like a login and counts towards the lockout of email
+func (h *UserHandler) reauthenticate(c echo.Context, email string, requestBody models.ReauthenticateRequest) error {
+	if requestBody.Password != "" {
+		if appErr := checkPasswordAllowed(c, email); appErr != nil {
+			return appErr
+		}
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessage("UserReauthenticate", correlationID, requestBody); err != nil {
+		return apperror.ErrUnavailable.WithMessage("Failed to publish message")
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "UserReauthenticateSuccess", "UserReauthenticateFailed")
+	if err != nil {
+		return apperror.ErrTimeout.WithMessage("Request timed out waiting for response")
+	}
+
+	failure := replyError(responseEvent, "UserReauthenticateFailed")
+	if requestBody.Password != "" {
+		h.recordPasswordResult(c, email, failure)
+	}
+	return failure
+}
+
+// replyError is the catalog error of a failedEvent reply, nil for any other reply
+func replyError(responseEvent events.Event, failedEvent string) error {
+	if responseEvent.EventType != failedEvent {
+		return nil
+	}
+	return apperror.FromPayload(responseEvent.Payload)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"math"
+	"messaging"
+	"net/http"
+	"strconv"
+	"time"
+	"user-service/core/apperror"
+)
+
+// loginBlockedError sets Retry-After and returns 423 for a locked account and 429 for a delayed IP
+func loginBlockedError(c echo.Context, wait time.Duration, locked bool) *apperror.Error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	if locked {
+		return apperror.ErrAccountLocked.WithMessage("Account temporarily locked after too many failed logins, check your email to unlock it")
+	}
+	return apperror.ErrRateLimited.WithMessage("Too many failed logins, please wait before trying again")
+}
+
+// checkPasswordAllowed refuses a password attempt on email like a login while the account is locked or the IP
+// delayed. Every endpoint that checks the account password goes through it, so none can be used to guess it
+func checkPasswordAllowed(c echo.Context, email string) *apperror.Error {
+	wait, locked, err := config.LoginBlocked(c.Request().Context(), email, c.RealIP())
+	if err != nil {
+		logrus.Errorf("Failed to check login lockout: %v", err)
+		return apperror.ErrInternal.WithMessage("Error accessing Redis")
+	}
+	if wait > 0 {
+		return loginBlockedError(c, wait, locked)
+	}
+	return nil
+}
+
+// recordPasswordResult counts a wrong password on email towards the lockout and forgets the failures once the
+// password was right, failure is the error of the reply and nil on success
+func (h *UserHandler) recordPasswordResult(c echo.Context, email string, failure error) {
+	if failure == nil {
+		if err := config.ResetLoginFailures(c.Request().Context(), email, c.RealIP()); err != nil {
+			logrus.Warnf("Failed to reset login failures: %v", err)
+		}
+		return
+	}
+	// only a wrong email or password counts towards the lockout
+	if errors.Is(failure, apperror.ErrInvalidCredentials) {
+		h.recordLoginFailure(c, email, c.RealIP())
+	}
+}
+
+// recordLoginFailure counts a failed password and tells user-service when it locked the account
+func (h *UserHandler) recordLoginFailure(c echo.Context, email, ip string) {
+	lockedFor, err := config.RecordLoginFailure(c.Request().Context(), config.LoadLockoutPolicy(), email, ip)
+	if err != nil {
+		logrus.Errorf("Failed to record login failure: %v", err)
+		return
+	}
+	if lockedFor == 0 {
+		return
+	}
+
+	logrus.Warnf("Account %s locked for %s after failed logins, last from %s", email, lockedFor, ip)
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AccountLocked", utils.GenerateCorrelationID(), models.AccountLockedRequest{
+		Email:       email,
+		IP:          ip,
+		LockedUntil: time.Now().Add(lockedFor),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to publish AccountLocked: %v", err)
+	}
+}
+
+// UnlockAccount lifts a lockout with the token from the lockout email
+func (h *UserHandler) UnlockAccount(c echo.Context) error {
+	var requestBody models.UnlockAccountRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AccountUnlock", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.respondAccountUnlock(c, "AccountUnlockSuccess", "AccountUnlockFailed")
+}
+
+// AdminUnlockAccount lifts the lockout of any account
+func (h *UserHandler) AdminUnlockAccount(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AccountUnlockAdmin", correlationID, models.AdminUnlockAccountRequest{
+		UserID:  c.Param("id"),
+		ActorID: claims.UserID,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.respondAccountUnlock(c, "AccountUnlockAdminSuccess", "AccountUnlockAdminFailed")
+}
+
+// respondAccountUnlock waits for user-service and clears the lockout of the returned email
+func (h *UserHandler) respondAccountUnlock(c echo.Context, successEvent, failedEvent string) error {
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", successEvent, failedEvent)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == successEvent {
+		var user struct {
+			Email string `json:"email"`
+		}
+		payloadBytes, _ := json.Marshal(responseEvent.Payload)
+		if err := json.Unmarshal(payloadBytes, &user); err != nil || user.Email == "" {
+			logrus.Errorf("Invalid unlock payload: %v", err)
+			return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Unexpected event payload format"), nil)
+		}
+		if err := config.UnlockAccount(c.Request().Context(), user.Email); err != nil {
+			logrus.Errorf("Failed to unlock account: %v", err)
+			return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Account unlocked successfully", successEvent, failedEvent)
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/core/apperror"
+)
+
+const (
+	// magicLinkTTL matches the lifetime of the emailed link in user-service
+	magicLinkTTL = 15 * time.Minute
+	// magicLinkCooldown is the minimum time between two sign-in links for the same email
+	magicLinkCooldown = time.Minute
+)
+
+// RequestMagicLink asks user-service to email a sign-in link, the answer is the same whether the email exists or not.
+// The returned device_token has to be sent back with the link, so the link only works where it was requested.
+func (h *UserHandler) RequestMagicLink(c echo.Context) error {
+	var requestBody models.MagicLinkRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(requestBody.Email))
+	allowed, err := config.AcquireCooldown(c.Request().Context(), "magic_link:"+email, magicLinkCooldown)
+	if err != nil {
+		logrus.Errorf("Failed to check magic link cooldown: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+	}
+	if !allowed {
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Please wait a minute before asking for another link"), nil)
+	}
+
+	deviceToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate device token"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "MagicLinkRequest", correlationID, models.MagicLinkSendRequest{
+		Email:      requestBody.Email,
+		DeviceHash: utils.HashOTP("magic_link", deviceToken),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to publish MagicLinkRequest: %v", err)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusAccepted, echo.Map{
+		"device_token": deviceToken,
+		"expires_in":   int(magicLinkTTL.Seconds()),
+	}, "If the email is registered, a sign-in link has been sent")
+}
+
+// VerifyMagicLink finishes a magic link login and issues a token like a password login
+func (h *UserHandler) VerifyMagicLink(c echo.Context) error {
+	var requestBody models.MagicLinkVerifyRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "MagicLinkLogin", correlationID, models.MagicLinkLoginRequest{
+		Token:      requestBody.Token,
+		DeviceHash: utils.HashOTP("magic_link", requestBody.DeviceToken),
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "MagicLinkLoginSuccess", "MagicLinkLoginFailed", "MagicLinkLoginMfaRequired")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	// the link verified the email, older tokens of the user should see it too
+	if responseEvent.EventType == "MagicLinkLoginSuccess" || responseEvent.EventType == "MagicLinkLoginMfaRequired" {
+		var user struct {
+			ID string `json:"id"`
+		}
+		payloadBytes, _ := json.Marshal(responseEvent.Payload)
+		if err := json.Unmarshal(payloadBytes, &user); err == nil && user.ID != "" {
+			if err := config.MarkEmailVerified(c.Request().Context(), user.ID, emailVerifiedFlagTTL); err != nil {
+				logrus.Errorf("Failed to mark email verified for user %s: %v", user.ID, err)
+			}
+		}
+	}
+
+	if responseEvent.EventType == "MagicLinkLoginMfaRequired" {
+		return h.respondMFAChallenge(c, responseEvent.Payload, nil)
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, http.StatusAccepted, "User login successfully", "MagicLinkLoginSuccess", "MagicLinkLoginFailed")
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter the second factor after the password
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts is how many wrong codes a challenge accepts before it is discarded
+	mfaMaxAttempts = 5
+)
+
+// LoginMFA finishes a login that was answered with an mfa_required challenge
+func (h *UserHandler) LoginMFA(c echo.Context) error {
+	var requestBody models.MFALoginRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	ctx := c.Request().Context()
+	challenge, err := config.GetMFAChallenge(ctx, requestBody.ChallengeToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid or expired MFA challenge"), nil)
+	}
+
+	attempts, err := config.IncrementMFAChallengeAttempts(ctx, requestBody.ChallengeToken, mfaChallengeTTL)
+	if err != nil {
+		logrus.Errorf("Failed to count MFA attempts: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to verify MFA challenge"), nil)
+	}
+	if attempts > mfaMaxAttempts {
+		_ = config.DeleteMFAChallenge(ctx, requestBody.ChallengeToken)
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Too many attempts, please log in again"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "UserLoginMfa", correlationID, models.MFARequest{
+		UserID:       challenge.UserID,
+		Code:         requestBody.Code,
+		RecoveryCode: requestBody.RecoveryCode,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "UserLoginMfaSuccess", "UserLoginMfaFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "UserLoginMfaSuccess" {
+		h.linkAfterMFA(c, challenge)
+		if err := config.DeleteMFAChallenge(ctx, requestBody.ChallengeToken); err != nil {
+			logrus.Warnf("Failed to delete MFA challenge: %v", err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, http.StatusAccepted, "User login successfully", "UserLoginMfaSuccess", "UserLoginMfaFailed")
+}
+
+// LoginMFAEnroll starts TOTP enrollment for a login whose role makes MFA mandatory
+func (h *UserHandler) LoginMFAEnroll(c echo.Context) error {
+	var requestBody models.MFAChallengeRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	challenge, err := config.GetMFAChallenge(c.Request().Context(), requestBody.ChallengeToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid or expired MFA challenge"), nil)
+	}
+	if !challenge.EnrollmentRequired {
+		return webResponse.ResponseProblem(c, apperror.ErrMFAAlreadyEnabled, nil)
+	}
+
+	return h.sendMFAEvent(c, "MfaEnroll", models.MFARequest{UserID: challenge.UserID}, "Scan the QR code and confirm with a code")
+}
+
+// EnrollMFA starts TOTP enrollment for the signed-in user
+func (h *UserHandler) EnrollMFA(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	return h.sendMFAEvent(c, "MfaEnroll", models.MFARequest{UserID: claims.UserID}, "Scan the QR code and confirm with a code")
+}
+
+// ConfirmMFA enables TOTP with the first code from the authenticator app
+func (h *UserHandler) ConfirmMFA(c echo.Context) error {
+	return h.handleMFACode(c, "MfaEnrollConfirm", "MFA enabled, store your recovery codes safely")
+}
+
+// DisableMFA turns TOTP off for the signed-in user
+func (h *UserHandler) DisableMFA(c echo.Context) error {
+	return h.handleMFACode(c, "MfaDisable", "MFA disabled successfully")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed-in user
+func (h *UserHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	return h.handleMFACode(c, "MfaRecoveryCodes", "Recovery codes regenerated, store them safely")
+}
+
+// respondMFAChallenge turns a UserLoginMfaRequired reply into a short-lived challenge token
+func (h *UserHandler) respondMFAChallenge(c echo.Context, payload interface{}, link *config.PendingLink) error {
+	var required struct {
+		ID                 string `json:"id"`
+		Email              string `json:"email"`
+		Role               string `json:"role"`
+		EnrollmentRequired bool   `json:"enrollment_required"`
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	if err := json.Unmarshal(payloadBytes, &required); err != nil || required.ID == "" {
+		logrus.Errorf("Invalid MFA required payload: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Unexpected event payload format"), nil)
+	}
+
+	challengeToken, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate MFA challenge"), nil)
+	}
+
+	err = config.StoreMFAChallenge(c.Request().Context(), challengeToken, config.MFAChallenge{
+		UserID:             required.ID,
+		Email:              required.Email,
+		Role:               required.Role,
+		EnrollmentRequired: required.EnrollmentRequired,
+		Link:               link,
+	}, mfaChallengeTTL)
+	if err != nil {
+		logrus.Errorf("Failed to store MFA challenge: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store MFA challenge"), nil)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusAccepted, echo.Map{
+		"mfa_required":        true,
+		"challenge_token":     challengeToken,
+		"enrollment_required": required.EnrollmentRequired,
+		"expires_in":          int(mfaChallengeTTL.Seconds()),
+	}, "Multi-factor authentication required")
+}
+
+// linkAfterMFA links the provider identity a link confirmation parked behind the second factor. The login
+// stands when linking fails, the provider can still be linked from the account
+func (h *UserHandler) linkAfterMFA(c echo.Context, challenge *config.MFAChallenge) {
+	if challenge.Link == nil {
+		return
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "IdentityLink", correlationID, models.IdentityLinkRequest{
+		UserID:        challenge.UserID,
+		Provider:      challenge.Link.Provider,
+		Subject:       challenge.Link.Subject,
+		ProviderEmail: challenge.Link.Email,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to publish IdentityLink after MFA: %v", err)
+		return
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "IdentityLinkSuccess", "IdentityLinkFailed")
+	if err != nil {
+		logrus.Errorf("Timed out linking %s identity after MFA: %v", challenge.Link.Provider, err)
+		return
+	}
+	if responseEvent.EventType == "IdentityLinkFailed" {
+		logrus.Warnf("Failed to link %s identity of user %s after MFA: %v", challenge.Link.Provider, challenge.UserID, responseEvent.Payload)
+	}
+}
+
+// handleMFACode sends an MFA event carrying a code from the signed-in user
+func (h *UserHandler) handleMFACode(c echo.Context, eventType, message string) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.MFACodeRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	return h.sendMFAEvent(c, eventType, models.MFARequest{
+		UserID:       claims.UserID,
+		Code:         requestBody.Code,
+		RecoveryCode: requestBody.RecoveryCode,
+	}, message)
+}
+
+// sendMFAEvent publishes an MFA event and waits for its Success / Failed reply
+func (h *UserHandler) sendMFAEvent(c echo.Context, eventType string, payload models.MFARequest, message string) error {
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), eventType, correlationID, payload); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusOK,
+		h.Config.RequestTimeout,
+		message,
+		eventType+"Success",
+		eventType+"Failed",
+	)
+}
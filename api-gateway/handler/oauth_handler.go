@@ -2,86 +2,161 @@ package handler
 
 import (
 	"api-gateway/config"
+	"api-gateway/models"
 	"api-gateway/utils"
 	"api-gateway/webResponse"
-	"context"
-	"encoding/json"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/oauth2"
-	"io"
+	"github.com/sirupsen/logrus"
+	"messaging"
 	"net/http"
+	"strings"
+	"time"
+	"user-service/core/apperror"
 )
 
-func (h *UserHandler) GoogleLogin(c echo.Context) error {
-	url := config.GoogleOauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	return c.Redirect(http.StatusTemporaryRedirect, url)
-}
+// oauthStateTTL is how long a user has to complete the provider's consent screen
+const oauthStateTTL = 10 * time.Minute
 
-func (h *UserHandler) GoogleCallback(c echo.Context) error {
-	code := c.QueryParam("code")
-	if code == "" {
-		return webResponse.ResponseJson(c, http.StatusBadRequest, nil, "Code not found")
+// OAuthLogin redirects the user to the authorization endpoint of the requested provider
+func (h *UserHandler) OAuthLogin(c echo.Context) error {
+	provider, ok := config.GetOIDCProvider(c.Param("provider"))
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Unknown OAuth provider"), nil)
 	}
 
-	token, err := config.GoogleOauthConfig.Exchange(c.Request().Context(), code)
+	state, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate OAuth state"), nil)
+	}
+	nonce, err := utils.GenerateSecureToken(32)
 	if err != nil {
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to exchange token")
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate OAuth nonce"), nil)
 	}
 
-	// fetch user info
-	userInfo, err := fetchGoogleUserInfo(token.AccessToken)
+	err = config.StoreOAuthState(c.Request().Context(), state, config.OAuthState{
+		Provider: provider.Config.Name,
+		Nonce:    nonce,
+		Mode:     config.OAuthModeLogin,
+	}, oauthStateTTL)
 	if err != nil {
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to fetch user info")
+		logrus.Errorf("Failed to store OAuth state: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store OAuth state"), nil)
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, provider.AuthCodeURL(state, nonce))
+}
+
+// OAuthCallback finishes a provider login, verifies the ID token and signs the user in
+func (h *UserHandler) OAuthCallback(c echo.Context) error {
+	provider, ok := config.GetOIDCProvider(c.Param("provider"))
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Unknown OAuth provider"), nil)
+	}
+
+	// Apple posts the callback as a form (response_mode=form_post), the others use the query string
+	if errParam := c.FormValue("error"); errParam != "" {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Provider returned an error: "+errParam), nil)
+	}
+	code := c.FormValue("code")
+	if code == "" {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Code not found"), nil)
+	}
+
+	ctx := c.Request().Context()
+	state, err := config.ConsumeOAuthState(ctx, c.FormValue("state"))
+	if err != nil || state.Provider != provider.Config.Name {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired OAuth state"), nil)
 	}
 
-	// generate JWT token
-	token, err = config.GoogleOauthConfig.Exchange(context.Background(), code)
+	token, err := provider.OAuth2.Exchange(ctx, code)
 	if err != nil {
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to generate JWT token")
+		logrus.Errorf("OAuth code exchange failed for %s: %v", provider.Config.Name, err)
+		return webResponse.ResponseProblem(c, apperror.ErrBadGateway.WithMessage("Failed to exchange token"), nil)
 	}
 
-	userInfo, err = fetchGoogleUserInfo(token.AccessToken)
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrBadGateway.WithMessage("Provider did not return an ID token"), nil)
+	}
+
+	idToken, err := provider.Verifier.Verify(ctx, rawIDToken)
 	if err != nil {
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to fetch user info")
+		logrus.Warnf("ID token verification failed for %s: %v", provider.Config.Name, err)
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid ID token"), nil)
 	}
-	requestBody, _ := json.Marshal(map[string]string{
-		"email":     userInfo["email"].(string),
-		"username":  userInfo["name"].(string),
-		"google_id": userInfo["id"].(string),
-	})
-	corrID := utils.GenerateCorrelationID()
-	err = h.SendMessage.SendingToMessage("UserRegisteredGoogle", corrID, requestBody)
+	if idToken.Nonce != state.Nonce {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid ID token nonce"), nil)
+	}
+
+	identity, err := provider.Identity(idToken)
 	if err != nil {
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to publish message")
+		return webResponse.ResponseProblem(c, apperror.ErrBadGateway.WithMessage(err.Error()), nil)
 	}
 
-	return h.ResponseHandler.HandleEventResponse(
-		c,
-		false,
-		http.StatusCreated,
-		h.Config.RequestTimeout,
-		"User registered successfully",
-		"UserRegisteredGoogleSuccess",
-		"UserRegisteredGoogleFailed",
-	)
-}
+	if state.Mode == config.OAuthModeLink {
+		return h.linkIdentity(c, state.UserID, identity)
+	}
+
+	if identity.Email == "" {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Provider did not share an email address"), nil)
+	}
 
-func fetchGoogleUserInfo(accessToken string) (map[string]interface{}, error) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
+	username := identity.Name
+	if username == "" {
+		username = strings.Split(identity.Email, "@")[0]
+	}
+
+	requestBody := models.OAuthUserRequest{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      username,
+		Avatar:        identity.Picture,
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	logrus.Infof("Sending UserOAuth event | Correlation ID: %s | Provider: %s", correlationID, identity.Provider)
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "UserOAuth", correlationID, requestBody)
 	if err != nil {
-		return nil, err
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "UserOAuthSuccess", "UserOAuthFailed", "UserOAuthLinkRequired", "UserOAuthMfaRequired")
 	if err != nil {
-		return nil, err
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
 	}
 
-	var userInfo map[string]interface{}
-	if err := json.Unmarshal(body, &userInfo); err != nil {
-		return nil, err
+	switch responseEvent.EventType {
+	case "UserOAuthLinkRequired":
+		return h.respondLinkRequired(c, identity, responseEvent.Payload)
+	case "UserOAuthMfaRequired":
+		return h.respondMFAChallenge(c, responseEvent.Payload, nil)
 	}
 
-	return userInfo, nil
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, http.StatusOK, "User login successfully", "UserOAuthSuccess", "UserOAuthFailed")
+}
+
+// linkIdentity attaches the identity returned by the provider to the user that started the link flow
+func (h *UserHandler) linkIdentity(c echo.Context, userID string, identity *config.OIDCIdentity) error {
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "IdentityLink", correlationID, models.IdentityLinkRequest{
+		UserID:        userID,
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		ProviderEmail: identity.Email,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusOK,
+		h.Config.RequestTimeout,
+		"Identity linked successfully",
+		"IdentityLinkSuccess",
+		"IdentityLinkFailed",
+	)
 }
@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+)
+
+// webAuthnSessionTTL is how long a registration or login challenge can be answered
+const webAuthnSessionTTL = 5 * time.Minute
+
+// passkeyUser adapts a user-service user to the webauthn.User interface, the user handle is the user ID
+type passkeyUser struct {
+	models.PasskeyUser
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.Passkeys))
+	for _, passkey := range u.Passkeys {
+		id, err := base64.RawURLEncoding.DecodeString(passkey.ID)
+		if err != nil {
+			logrus.Warnf("Skipping passkey with invalid credential ID for user %s", u.ID)
+			continue
+		}
+
+		transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+		for i, transport := range passkey.Transports {
+			transports[i] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       passkey.AAGUID,
+				SignCount:    passkey.SignCount,
+				CloneWarning: passkey.CloneWarning,
+			},
+		})
+	}
+	return credentials
+}
+
+// BeginPasskeyRegistration returns the creation options for a new passkey of the signed-in user
+func (h *UserHandler) BeginPasskeyRegistration(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	user, err := h.fetchPasskeyUser(claims.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Passkeys))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := relyingParty.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		logrus.Errorf("Failed to begin passkey registration: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to begin passkey registration"), nil)
+	}
+
+	if err := config.StoreWebAuthnSession(c.Request().Context(), "register:"+claims.UserID, session, webAuthnSessionTTL); err != nil {
+		logrus.Errorf("Failed to store WebAuthn session: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store passkey challenge"), nil)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusOK, creation, "Create a passkey on your device")
+}
+
+// FinishPasskeyRegistration verifies the attestation and stores the passkey, ?name= labels it
+func (h *UserHandler) FinishPasskeyRegistration(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	session, err := config.ConsumeWebAuthnSession(c.Request().Context(), "register:"+claims.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired passkey challenge"), nil)
+	}
+
+	user, err := h.fetchPasskeyUser(claims.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	credential, err := relyingParty.FinishRegistration(user, *session, c.Request())
+	if err != nil {
+		logrus.Warnf("Passkey registration failed for user %s: %v", claims.UserID, err)
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Passkey verification failed"), nil)
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasskeyRegister", correlationID, models.PasskeyRegisterRequest{
+		UserID: claims.UserID,
+		Passkey: models.Passkey{
+			ID:              base64.RawURLEncoding.EncodeToString(credential.ID),
+			Name:            c.QueryParam("name"),
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transports:      transports,
+			AAGUID:          credential.Authenticator.AAGUID,
+			SignCount:       credential.Authenticator.SignCount,
+			BackupEligible:  credential.Flags.BackupEligible,
+			BackupState:     credential.Flags.BackupState,
+		},
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusCreated,
+		h.Config.RequestTimeout,
+		"Passkey registered successfully",
+		"PasskeyRegisterSuccess",
+		"PasskeyRegisterFailed",
+	)
+}
+
+// DeletePasskey removes a passkey of the signed-in user as long as another login method remains
+func (h *UserHandler) DeletePasskey(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasskeyDelete", correlationID, models.PasskeyDeleteRequest{
+		UserID:       claims.UserID,
+		CredentialID: c.Param("id"),
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusOK,
+		h.Config.RequestTimeout,
+		"Passkey removed successfully",
+		"PasskeyDeleteSuccess",
+		"PasskeyDeleteFailed",
+	)
+}
+
+// BeginPasskeyLogin starts a passwordless login, the browser offers every passkey it has for this site
+func (h *UserHandler) BeginPasskeyLogin(c echo.Context) error {
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	assertion, session, err := relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		logrus.Errorf("Failed to begin passkey login: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to begin passkey login"), nil)
+	}
+
+	challengeID, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate passkey challenge"), nil)
+	}
+	if err := config.StoreWebAuthnSession(c.Request().Context(), "login:"+challengeID, session, webAuthnSessionTTL); err != nil {
+		logrus.Errorf("Failed to store WebAuthn session: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store passkey challenge"), nil)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusOK, echo.Map{
+		"challenge_id": challengeID,
+		"options":      assertion,
+	}, "Sign in with your passkey")
+}
+
+// FinishPasskeyLogin verifies the assertion for ?challenge_id= and issues a token
+func (h *UserHandler) FinishPasskeyLogin(c echo.Context) error {
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	session, err := config.ConsumeWebAuthnSession(c.Request().Context(), "login:"+c.QueryParam("challenge_id"))
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired passkey challenge"), nil)
+	}
+
+	var user *passkeyUser
+	credential, err := relyingParty.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err = h.fetchPasskeyUser(string(userHandle))
+		return user, err
+	}, *session, c.Request())
+	if err != nil || user == nil {
+		logrus.Warnf("Passkey login failed: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidPasskey.WithMessage("Passkey verification failed"), nil)
+	}
+
+	return h.recordPasskeyLogin(c, user.ID, credential, "", http.StatusOK, "User login successfully")
+}
+
+// BeginPasskeyMFA starts a passkey assertion as the second factor of an mfa_required login
+func (h *UserHandler) BeginPasskeyMFA(c echo.Context) error {
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	var requestBody models.MFAChallengeRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	ctx := c.Request().Context()
+	challenge, err := config.GetMFAChallenge(ctx, requestBody.ChallengeToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid or expired MFA challenge"), nil)
+	}
+
+	user, err := h.fetchPasskeyUser(challenge.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+	if len(user.Passkeys) == 0 {
+		return webResponse.ResponseProblem(c, apperror.ErrConflict.WithMessage("No passkey registered for this account"), nil)
+	}
+
+	assertion, session, err := relyingParty.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		logrus.Errorf("Failed to begin passkey MFA: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to begin passkey login"), nil)
+	}
+	if err := config.StoreWebAuthnSession(ctx, "mfa:"+requestBody.ChallengeToken, session, webAuthnSessionTTL); err != nil {
+		logrus.Errorf("Failed to store WebAuthn session: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store passkey challenge"), nil)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusOK, assertion, "Confirm with your passkey")
+}
+
+// FinishPasskeyMFA verifies the assertion for ?challenge_token= and finishes the login
+func (h *UserHandler) FinishPasskeyMFA(c echo.Context) error {
+	relyingParty, ok := config.GetWebAuthn()
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrNotFound.WithMessage("Passkeys are not enabled"), nil)
+	}
+
+	ctx := c.Request().Context()
+	challengeToken := c.QueryParam("challenge_token")
+	challenge, err := config.GetMFAChallenge(ctx, challengeToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid or expired MFA challenge"), nil)
+	}
+
+	attempts, err := config.IncrementMFAChallengeAttempts(ctx, challengeToken, mfaChallengeTTL)
+	if err != nil {
+		logrus.Errorf("Failed to count MFA attempts: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to verify MFA challenge"), nil)
+	}
+	if attempts > mfaMaxAttempts {
+		_ = config.DeleteMFAChallenge(ctx, challengeToken)
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Too many attempts, please log in again"), nil)
+	}
+
+	session, err := config.ConsumeWebAuthnSession(ctx, "mfa:"+challengeToken)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired passkey challenge"), nil)
+	}
+
+	user, err := h.fetchPasskeyUser(challenge.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	credential, err := relyingParty.FinishLogin(user, *session, c.Request())
+	if err != nil {
+		logrus.Warnf("Passkey MFA failed for user %s: %v", challenge.UserID, err)
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidPasskey.WithMessage("Passkey verification failed"), nil)
+	}
+
+	h.linkAfterMFA(c, challenge)
+	return h.recordPasskeyLogin(c, user.ID, credential, challengeToken, http.StatusAccepted, "User login successfully")
+}
+
+// recordPasskeyLogin stores the new authenticator counters in user-service and issues a token,
+// the MFA challenge (when not empty) is deleted so it cannot be replayed
+func (h *UserHandler) recordPasskeyLogin(c echo.Context, userID string, credential *webauthn.Credential, challengeToken string, statusCode int, message string) error {
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasskeyLogin", correlationID, models.PasskeyLoginRequest{
+		UserID:       userID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		SignCount:    credential.Authenticator.SignCount,
+		BackupState:  credential.Flags.BackupState,
+		CloneWarning: credential.Authenticator.CloneWarning,
+		SecondFactor: challengeToken != "",
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "PasskeyLoginSuccess", "PasskeyLoginFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "PasskeyLoginSuccess" && challengeToken != "" {
+		if err := config.DeleteMFAChallenge(c.Request().Context(), challengeToken); err != nil {
+			logrus.Warnf("Failed to delete MFA challenge: %v", err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, statusCode, message, "PasskeyLoginSuccess", "PasskeyLoginFailed")
+}
+
+// fetchPasskeyUser loads a user and its passkeys from user-service
+func (h *UserHandler) fetchPasskeyUser(userID string) (*passkeyUser, error) {
+	if userID == "" {
+		return nil, errors.New("user not found")
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessage("GetPasskeyUser", correlationID, models.PasskeyUser{ID: userID}); err != nil {
+		return nil, apperror.ErrUnavailable.WithMessage("Failed to publish message")
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "GetPasskeyUserSuccess", "GetPasskeyUserFailed")
+	if err != nil {
+		return nil, apperror.ErrTimeout.WithMessage("Request timed out waiting for response")
+	}
+	if responseEvent.EventType == "GetPasskeyUserFailed" {
+		return nil, apperror.FromPayload(responseEvent.Payload)
+	}
+
+	var user passkeyUser
+	payloadBytes, _ := json.Marshal(responseEvent.Payload)
+	if err := json.Unmarshal(payloadBytes, &user.PasskeyUser); err != nil || user.ID == "" {
+		return nil, apperror.ErrBadGateway.WithMessage("Unexpected event payload format")
+	}
+	return &user, nil
+}
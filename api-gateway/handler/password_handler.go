@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"user-service/core/apperror"
+)
+
+// ForgotPassword asks user-service to email a reset link, the answer is the same whether the email exists or not
+func (h *UserHandler) ForgotPassword(c echo.Context) error {
+	var requestBody models.ForgotPasswordRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasswordForgot", correlationID, requestBody); err != nil {
+		logrus.Errorf("Failed to publish PasswordForgot: %v", err)
+	}
+
+	return webResponse.ResponseJson(c, http.StatusAccepted, nil, "If the email is registered, a reset link has been sent")
+}
+
+// ResetPassword sets a new password with the emailed token and signs the user out of every session
+func (h *UserHandler) ResetPassword(c echo.Context) error {
+	var requestBody models.ResetPasswordRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasswordReset", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "PasswordResetSuccess", "PasswordResetFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "PasswordResetSuccess" {
+		var user struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		}
+		payloadBytes, _ := json.Marshal(responseEvent.Payload)
+		if err := json.Unmarshal(payloadBytes, &user); err == nil && user.ID != "" {
+			if err := config.RevokeUserSessions(c.Request().Context(), user.ID); err != nil {
+				logrus.Errorf("Failed to revoke sessions of user %s: %v", user.ID, err)
+			}
+			// a new password also lifts a lockout caused by guesses at the old one
+			if err := config.UnlockAccount(c.Request().Context(), user.Email); err != nil {
+				logrus.Errorf("Failed to unlock user %s: %v", user.ID, err)
+			}
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Password reset successfully, please log in again", "PasswordResetSuccess", "PasswordResetFailed")
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"crypto/subtle"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+)
+
+const (
+	// phoneOTPTTL is how long a phone verification code stays valid
+	phoneOTPTTL = 5 * time.Minute
+	// phoneOTPDigits is the length of a phone verification code
+	phoneOTPDigits = 6
+	// phoneOTPMaxAttempts is how many wrong codes are accepted before the code is discarded
+	phoneOTPMaxAttempts = 5
+	// phoneOTPCooldown is the minimum time between two codes for the same user
+	phoneOTPCooldown = time.Minute
+	// phoneOTPLimit is how many codes a user or a phone number can receive per phoneOTPWindow
+	phoneOTPLimit  = 5
+	phoneOTPWindow = time.Hour
+)
+
+// SendPhoneOTP sends a verification code to a phone number of the signed-in user by SMS or WhatsApp
+func (h *UserHandler) SendPhoneOTP(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.SendPhoneOTPRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	phone, err := utils.NormalizePhone(requestBody.Phone, requestBody.Region)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Invalid phone number"), nil)
+	}
+	channel := requestBody.Channel
+	if channel == "" {
+		channel = "sms"
+	}
+
+	ctx := c.Request().Context()
+	allowed, err := config.AcquireCooldown(ctx, "phone_otp:"+claims.UserID, phoneOTPCooldown)
+	if err != nil {
+		logrus.Errorf("Failed to check phone OTP cooldown: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+	}
+	if !allowed {
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Please wait a minute before asking for another code"), nil)
+	}
+
+	// limit per user and per number, so one account cannot flood a number and many accounts cannot flood one either
+	for _, key := range []string{"phone_otp:user:" + claims.UserID, "phone_otp:phone:" + phone} {
+		sent, err := config.IncrementCounter(ctx, key, phoneOTPWindow)
+		if err != nil {
+			logrus.Errorf("Failed to count phone OTPs: %v", err)
+			return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+		}
+		if sent > phoneOTPLimit {
+			return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Too many verification codes, please try again later"), nil)
+		}
+	}
+
+	code, err := utils.GenerateOTP(phoneOTPDigits)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate verification code"), nil)
+	}
+	err = config.StorePhoneOTP(ctx, claims.UserID, config.PhoneOTP{
+		Phone:    phone,
+		CodeHash: utils.HashOTP(claims.UserID+":"+phone, code),
+	}, phoneOTPTTL)
+	if err != nil {
+		logrus.Errorf("Failed to store phone OTP: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PhoneOtpSend", correlationID, models.PhoneOtpSendRequest{
+		UserID:    claims.UserID,
+		Phone:     phone,
+		Code:      code,
+		Channel:   channel,
+		ExpiresIn: int(phoneOTPTTL.Seconds()),
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "PhoneOtpSendSuccess", "PhoneOtpSendFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "PhoneOtpSendFailed" {
+		if err := config.DeletePhoneOTP(ctx, claims.UserID); err != nil {
+			logrus.Warnf("Failed to delete phone OTP: %v", err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusAccepted, "Verification code sent", "PhoneOtpSendSuccess", "PhoneOtpSendFailed")
+}
+
+// VerifyPhoneOTP confirms the phone number with the code that was sent to it
+func (h *UserHandler) VerifyPhoneOTP(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.VerifyPhoneOTPRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	ctx := c.Request().Context()
+	pending, err := config.GetPhoneOTP(ctx, claims.UserID)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired verification code"), nil)
+	}
+
+	attempts, err := config.IncrementPhoneOTPAttempts(ctx, claims.UserID, phoneOTPTTL)
+	if err != nil {
+		logrus.Errorf("Failed to count phone OTP attempts: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to verify phone number"), nil)
+	}
+	if attempts > phoneOTPMaxAttempts {
+		_ = config.DeletePhoneOTP(ctx, claims.UserID)
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Too many attempts, please ask for a new code"), nil)
+	}
+
+	codeHash := utils.HashOTP(claims.UserID+":"+pending.Phone, requestBody.Code)
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(pending.CodeHash)) != 1 {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidToken.WithMessage("Invalid or expired verification code"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PhoneVerify", correlationID, models.PhoneVerifyRequest{
+		UserID: claims.UserID,
+		Phone:  pending.Phone,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "PhoneVerifySuccess", "PhoneVerifyFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "PhoneVerifySuccess" {
+		if err := config.DeletePhoneOTP(ctx, claims.UserID); err != nil {
+			logrus.Warnf("Failed to delete phone OTP: %v", err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Phone number verified successfully", "PhoneVerifySuccess", "PhoneVerifyFailed")
+}
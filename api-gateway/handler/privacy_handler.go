@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+	events "user-service/core/models"
+)
+
+// ExportData starts an export of everything stored about the signed-in user, call it again to see when the
+// archive is ready, the response then carries its download URL
+func (h *UserHandler) ExportData(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	// sessions live in Redis, only the gateway can add them to the export
+	sessions, err := config.ListUserSessions(c.Request().Context(), claims.UserID)
+	if err != nil {
+		logrus.Errorf("Failed to list sessions of user %s: %v", claims.UserID, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "DataExport", correlationID, models.DataExportRequest{
+		UserID:   claims.UserID,
+		Sessions: sessions,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "DataExportSuccess", "DataExportFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+	if responseEvent.EventType != "DataExportSuccess" {
+		return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "", "DataExportSuccess", "DataExportFailed")
+	}
+
+	var export map[string]interface{}
+	payloadBytes, _ := json.Marshal(responseEvent.Payload)
+	if err := json.Unmarshal(payloadBytes, &export); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Invalid response from user service"), nil)
+	}
+
+	if export["status"] != "ready" {
+		return webResponse.ResponseJson(c, http.StatusAccepted, export, "Export is being prepared, you will get an email when it is ready")
+	}
+	export["download_url"] = fmt.Sprintf("/api/users/me/export/%v/download", export["id"])
+	return webResponse.ResponseJson(c, http.StatusOK, export, "Export is ready")
+}
+
+// DownloadExport sends the ZIP archive of a ready export of the signed-in user
+func (h *UserHandler) DownloadExport(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "DataExportDownload", correlationID, models.DataExportDownloadRequest{
+		UserID:   claims.UserID,
+		ExportID: c.Param("id"),
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "DataExportDownloadSuccess", "DataExportDownloadFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+	if responseEvent.EventType != "DataExportDownloadSuccess" {
+		return webResponse.ResponseProblem(c, apperror.FromPayload(responseEvent.Payload), nil)
+	}
+
+	var file struct {
+		FileName string `json:"file_name"`
+		Content  []byte `json:"content"`
+	}
+	payloadBytes, _ := json.Marshal(responseEvent.Payload)
+	if err := json.Unmarshal(payloadBytes, &file); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Invalid response from user service"), nil)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", file.FileName))
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.Blob(http.StatusOK, "application/zip", file.Content)
+}
+
+// DeleteAccount re-authenticates the signed-in user and schedules the account for deletion, every session is
+// signed out and the emailed link restores the account during the grace period
+func (h *UserHandler) DeleteAccount(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.DeleteAccountRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+
+	err := h.reauthenticate(c, claims.Email, models.ReauthenticateRequest{
+		ID:          claims.UserID,
+		Password:    requestBody.Password,
+		RecentLogin: claims.IssuedAt != nil && time.Since(claims.IssuedAt.Time) < recentLoginWindow,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AccountDeletion", correlationID, models.DeleteAccountRequest{UserID: claims.UserID})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "AccountDeletionSuccess", "AccountDeletionFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "AccountDeletionSuccess" {
+		if err := config.RevokeUserSessions(c.Request().Context(), claims.UserID); err != nil {
+			logrus.Errorf("Failed to revoke sessions of user %s: %v", claims.UserID, err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusAccepted, "Account scheduled for deletion, use the link in the email to restore it", "AccountDeletionSuccess", "AccountDeletionFailed")
+}
+
+// RestoreAccount cancels a scheduled deletion with the emailed token
+func (h *UserHandler) RestoreAccount(c echo.Context) error {
+	var requestBody models.RestoreAccountRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "AccountRestore", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(c, false, http.StatusOK, h.Config.RequestTimeout, "Account restored, you can log in again", "AccountRestoreSuccess", "AccountRestoreFailed")
+}
+
+// HandleUserDeleted purges what the gateway keeps of a user once user-service anonymized the account
+func (h *UserHandler) HandleUserDeleted(event events.Event) {
+	var deleted events.UserDeletedEvent
+	payloadBytes, _ := json.Marshal(event.Payload)
+	if err := json.Unmarshal(payloadBytes, &deleted); err != nil || deleted.UserID == "" {
+		logrus.Errorf("Invalid UserDeleted event: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := config.RevokeUserSessions(ctx, deleted.UserID); err != nil {
+		logrus.Errorf("Failed to revoke sessions of deleted user %s: %v", deleted.UserID, err)
+	}
+	if err := h.Avatars.DeletePrefix(ctx, "avatars/"+deleted.UserID+"/"); err != nil {
+		logrus.Errorf("Failed to delete avatars of deleted user %s: %v", deleted.UserID, err)
+	}
+	logrus.Infof("Purged gateway data of deleted user %s", deleted.UserID)
+}
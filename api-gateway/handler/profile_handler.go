@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"strings"
+	"time"
+	"user-service/core/apperror"
+)
+
+// UpdateProfile changes the fields sent by the signed-in user, send the version from GetProfile to avoid
+// overwriting a concurrent update
+func (h *UserHandler) UpdateProfile(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.UpdateProfileRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+	if requestBody.Username == nil && requestBody.Address == nil && requestBody.Phone == nil && requestBody.Age == nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Nothing to update"), nil)
+	}
+
+	// store phone numbers in E.164 so they can be verified and compared
+	if requestBody.Phone != nil {
+		phone, err := utils.NormalizePhone(*requestBody.Phone, requestBody.PhoneRegion)
+		if err != nil {
+			return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Invalid phone number"), nil)
+		}
+		requestBody.Phone = &phone
+	}
+	requestBody.UserID = claims.UserID
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "ProfileUpdate", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(c, false, http.StatusOK, h.Config.RequestTimeout, "Profile updated successfully", "ProfileUpdateSuccess", "ProfileUpdateFailed")
+}
+
+// ChangePassword replaces the password of the signed-in user and signs out every other session
+func (h *UserHandler) ChangePassword(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.ChangePasswordRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+	requestBody.UserID = claims.UserID
+
+	// the current password is checked like a login
+	if appErr := checkPasswordAllowed(c, claims.Email); appErr != nil {
+		return webResponse.ResponseProblem(c, appErr, nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "PasswordChange", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "PasswordChangeSuccess", "PasswordChangeFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+	h.recordPasswordResult(c, claims.Email, replyError(responseEvent, "PasswordChangeFailed"))
+
+	if responseEvent.EventType == "PasswordChangeSuccess" {
+		if err := config.RevokeOtherSessions(c.Request().Context(), claims.UserID, bearerToken(c)); err != nil {
+			logrus.Errorf("Failed to revoke other sessions of user %s: %v", claims.UserID, err)
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Password changed successfully", "PasswordChangeSuccess", "PasswordChangeFailed")
+}
+
+// ChangeEmail re-authenticates the signed-in user and emails a confirmation link to the new address,
+// the account keeps its email until the link is followed
+func (h *UserHandler) ChangeEmail(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	var requestBody models.ChangeEmailRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	err := h.reauthenticate(c, claims.Email, models.ReauthenticateRequest{
+		ID:          claims.UserID,
+		Password:    requestBody.Password,
+		RecentLogin: claims.IssuedAt != nil && time.Since(claims.IssuedAt.Time) < recentLoginWindow,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.From(err), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "EmailChange", correlationID, models.ChangeEmailRequest{
+		UserID:   claims.UserID,
+		NewEmail: requestBody.NewEmail,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(c, false, http.StatusAccepted, h.Config.RequestTimeout, "Confirmation link sent to the new email address", "EmailChangeSuccess", "EmailChangeFailed")
+}
+
+// ConfirmEmailChange switches to the new email with the emailed token, tokens carrying the old email are revoked
+func (h *UserHandler) ConfirmEmailChange(c echo.Context) error {
+	var requestBody models.ConfirmEmailChangeRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "EmailChangeConfirm", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "EmailChangeConfirmSuccess", "EmailChangeConfirmFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "EmailChangeConfirmSuccess" {
+		var user struct {
+			ID string `json:"id"`
+		}
+		payloadBytes, _ := json.Marshal(responseEvent.Payload)
+		if err := json.Unmarshal(payloadBytes, &user); err == nil && user.ID != "" {
+			ctx := c.Request().Context()
+			if err := config.RevokeUserSessions(ctx, user.ID); err != nil {
+				logrus.Errorf("Failed to revoke sessions of user %s: %v", user.ID, err)
+			}
+			if err := config.MarkEmailVerified(ctx, user.ID, emailVerifiedFlagTTL); err != nil {
+				logrus.Errorf("Failed to mark email verified for user %s: %v", user.ID, err)
+			}
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Email changed successfully, please log in again", "EmailChangeConfirmSuccess", "EmailChangeConfirmFailed")
+}
+
+// bearerToken returns the raw token of the request, JWTMiddleware has already checked it
+func bearerToken(c echo.Context) string {
+	return strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+}
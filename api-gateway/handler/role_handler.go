@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"api-gateway/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"messaging"
+	"user-service/core/apperror"
+)
+
+// FetchRolePermissions asks user-service for every role with its inherited permissions, it backs config.RoleCache
+func (h *UserHandler) FetchRolePermissions(ctx context.Context) (map[string][]string, error) {
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessage("GetRoles", correlationID, struct{}{}); err != nil {
+		return nil, err
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "GetRolesSuccess", "GetRolesFailed")
+	if err != nil {
+		return nil, err
+	}
+	if responseEvent.EventType != "GetRolesSuccess" {
+		return nil, fmt.Errorf("load roles: %w", apperror.FromPayload(responseEvent.Payload))
+	}
+
+	var reply struct {
+		Roles map[string][]string `json:"roles"`
+	}
+	payloadBytes, _ := json.Marshal(responseEvent.Payload)
+	if err := json.Unmarshal(payloadBytes, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Roles, nil
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"api-gateway/config"
 	"api-gateway/models"
+	"api-gateway/storage"
 	"api-gateway/utils"
 	"api-gateway/webResponse"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"messaging"
 	"net/http"
 	"user-service/api"
+	"user-service/core/apperror"
 )
 
 type UserHandler struct {
@@ -19,6 +21,7 @@ type UserHandler struct {
 	RMQ             *messaging.RabbitMQConnection
 	SendMessage     *api.SendingMessage
 	ResponseHandler *webResponse.ResponseHandler
+	Avatars         storage.BlobStore
 }
 
 func NewUserHandler(cfg *config.RateLimitConfig, rmq *messaging.RabbitMQConnection, res *webResponse.ResponseHandler) *UserHandler {
@@ -27,35 +30,38 @@ func NewUserHandler(cfg *config.RateLimitConfig, rmq *messaging.RabbitMQConnecti
 		RMQ:             rmq,
 		ResponseHandler: res,
 		SendMessage:     api.NewSendingMessage(rmq),
+		Avatars:         storage.NewBlobStoreFromEnv(),
 	}
 }
 
 // Register handles user registration event-driven
 func (h *UserHandler) Register(c echo.Context) error {
-	// Rate Limit
-	err := config.CheckRateLimit(c)
-	if err != nil {
-		return err
-	}
 	// bind & validate request
 	var requestBody models.RegisterRequest
 	if err := c.Bind(&requestBody); err != nil {
-		return webResponse.ResponseJson(c, http.StatusBadRequest, nil, "Invalid request format")
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
 	}
 
 	if err := requestBody.Validate(); err != nil {
 		var validationErrors validator.ValidationErrors
 		if errors.As(err, &validationErrors) {
 			formatterErrors := utils.FormatValidationError(&requestBody, validationErrors)
-			return webResponse.ResponseJson(c, http.StatusBadRequest, nil, formatterErrors)
+			return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(formatterErrors), nil)
 		}
-		return webResponse.ResponseJson(c, http.StatusBadRequest, nil, err.Error())
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(err.Error()), nil)
 	}
 
+	// store phone numbers in E.164 so they can be verified and compared
+	phone, err := utils.NormalizePhone(requestBody.Phone, requestBody.PhoneRegion)
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage("Invalid phone number"), nil)
+	}
+	requestBody.Phone = phone
+
 	// Generate Correlation ID
 	correlationID := utils.GenerateCorrelationID()
 	logrus.Infof("Sending UserRegistered event | Correlation ID: %s | Payload: %+v", correlationID, requestBody)
-	err = h.SendMessage.SendingToMessage("UserRegistered", correlationID, requestBody)
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "UserRegistered", correlationID, requestBody)
 	if err != nil {
 		return err
 	}
@@ -75,60 +81,59 @@ func (h *UserHandler) Register(c echo.Context) error {
 
 // Login handles user login event-driven
 func (h *UserHandler) Login(c echo.Context) error {
-	// Rate Limit
-	err := config.CheckRateLimit(c)
-	if err != nil {
-		return err
-	}
 	// bind & validate request
 	var requestBody models.LoginRequest
 	if err := c.Bind(&requestBody); err != nil {
-		return webResponse.ResponseJson(c, http.StatusBadRequest, nil, "Invalid request format")
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
 	}
 	if err := requestBody.Validate(); err != nil {
 		var validationErrors validator.ValidationErrors
 		if errors.As(err, &validationErrors) {
 			formatterErrors := utils.FormatValidationError(&requestBody, validationErrors)
-			return webResponse.ResponseJson(c, http.StatusBadRequest, nil, formatterErrors)
+			return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(formatterErrors), nil)
 		}
-		return webResponse.ResponseJson(c, http.StatusBadRequest, nil, err.Error())
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(err.Error()), nil)
+	}
+
+	// locked accounts and delayed IPs are refused before the password is checked
+	if appErr := checkPasswordAllowed(c, requestBody.Email); appErr != nil {
+		return webResponse.ResponseProblem(c, appErr, nil)
 	}
 
 	// Generate Correlation ID
 	correlationID := utils.GenerateCorrelationID()
 
-	err = h.SendMessage.SendingToMessage("UserLogin", correlationID, requestBody)
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "UserLogin", correlationID, requestBody)
 	if err != nil {
 		return err
 	}
 
-	return h.ResponseHandler.HandleEventResponse(
-		c,
-		true,
-		http.StatusAccepted,
-		h.Config.RequestTimeout,
-		"User login successfully",
-		"UserLoginSuccess",
-		"UserLoginFailed",
-	)
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "UserLoginSuccess", "UserLoginFailed", "UserLoginMfaRequired")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	h.recordPasswordResult(c, requestBody.Email, replyError(responseEvent, "UserLoginFailed"))
+
+	// accounts with MFA get a challenge token instead of a session
+	if responseEvent.EventType == "UserLoginMfaRequired" {
+		return h.respondMFAChallenge(c, responseEvent.Payload, nil)
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, true, http.StatusAccepted, "User login successfully", "UserLoginSuccess", "UserLoginFailed")
 }
 
 // GetProfile handles user testing
 func (h *UserHandler) GetProfile(c echo.Context) error {
-	err := config.CheckRateLimit(c)
-	if err != nil {
-		return err
-	}
-
 	claims, ok := c.Get("user").(*utils.JWTCustomClaims)
 	if !ok || claims == nil {
 		logrus.Error("Invalid claims type or nil claims")
-		return webResponse.ResponseJson(c, http.StatusUnauthorized, nil, "Invalid token claims")
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
 	}
 
 	if claims.UserID == "" {
 		logrus.Error("UserID not found in claims")
-		return webResponse.ResponseJson(c, http.StatusUnauthorized, nil, "UserID not found in token")
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("UserID not found in token"), nil)
 	}
 
 	requestBody := models.UserProfileRequest{
@@ -142,10 +147,10 @@ func (h *UserHandler) GetProfile(c echo.Context) error {
 
 	logrus.Infof("Sending GetProfile event | Correlation ID: %s | UserID: %s", correlationID, claims.UserID)
 
-	err = h.SendMessage.SendingToMessage("GetProfile", correlationID, requestBody)
+	err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "GetProfile", correlationID, requestBody)
 	if err != nil {
 		logrus.Errorf("Failed to send GetProfile message: %v", err)
-		return webResponse.ResponseJson(c, http.StatusInternalServerError, nil, "Failed to send GetProfile request")
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to send GetProfile request"), nil)
 	}
 
 	logrus.Infof("Waiting for GetProfileSuccess/GetProfileFailed response | Timeout: %v", h.Config.RequestTimeout)
@@ -160,3 +165,23 @@ func (h *UserHandler) GetProfile(c echo.Context) error {
 		"GetProfileFailed",
 	)
 }
+
+// currentClaims returns the verified JWT claims set by JWTMiddleware
+func currentClaims(c echo.Context) (*utils.JWTCustomClaims, bool) {
+	claims, ok := c.Get("user").(*utils.JWTCustomClaims)
+	if !ok || claims == nil || claims.UserID == "" {
+		logrus.Error("Invalid claims type or nil claims")
+		return nil, false
+	}
+	return claims, true
+}
+
+// validationErrorResponse formats validator errors the same way for every request model
+func validationErrorResponse(c echo.Context, model interface{}, err error) error {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		formatterErrors := utils.FormatValidationError(model, validationErrors)
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(formatterErrors), nil)
+	}
+	return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest.WithMessage(err.Error()), nil)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"api-gateway/config"
+	"api-gateway/models"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"messaging"
+	"net/http"
+	"time"
+	"user-service/core/apperror"
+)
+
+const (
+	// verificationResendCooldown is the minimum time between two verification emails
+	verificationResendCooldown = time.Minute
+	// verificationResendLimit is how many verification emails a user can ask for per verificationResendWindow
+	verificationResendLimit  = 5
+	verificationResendWindow = time.Hour
+	// emailVerifiedFlagTTL covers the lifetime of tokens issued before the verification
+	emailVerifiedFlagTTL = 72 * time.Hour
+)
+
+// VerifyEmail confirms the email address with the token from the verification email
+func (h *UserHandler) VerifyEmail(c echo.Context) error {
+	var requestBody models.VerifyEmailRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInvalidRequest, nil)
+	}
+	if err := requestBody.Validate(); err != nil {
+		return validationErrorResponse(c, &requestBody, err)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	if err := h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "EmailVerify", correlationID, requestBody); err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	responseEvent, err := messaging.WaitForEvent(h.RMQ, h.Config.RequestTimeout, "api-gateway", "EmailVerifySuccess", "EmailVerifyFailed")
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrTimeout, nil)
+	}
+
+	if responseEvent.EventType == "EmailVerifySuccess" {
+		var user struct {
+			ID string `json:"id"`
+		}
+		payloadBytes, _ := json.Marshal(responseEvent.Payload)
+		if err := json.Unmarshal(payloadBytes, &user); err == nil && user.ID != "" {
+			if err := config.MarkEmailVerified(c.Request().Context(), user.ID, emailVerifiedFlagTTL); err != nil {
+				logrus.Errorf("Failed to mark email verified for user %s: %v", user.ID, err)
+			}
+		}
+	}
+
+	return h.ResponseHandler.RespondWithEvent(c, responseEvent, false, http.StatusOK, "Email verified successfully", "EmailVerifySuccess", "EmailVerifyFailed")
+}
+
+// ResendVerificationEmail sends a new verification link to the signed-in user
+func (h *UserHandler) ResendVerificationEmail(c echo.Context) error {
+	claims, ok := currentClaims(c)
+	if !ok {
+		return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+	}
+
+	ctx := c.Request().Context()
+	allowed, err := config.AcquireCooldown(ctx, "email_verification:"+claims.UserID, verificationResendCooldown)
+	if err != nil {
+		logrus.Errorf("Failed to check verification resend cooldown: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+	}
+	if !allowed {
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Please wait a minute before asking for another email"), nil)
+	}
+
+	sent, err := config.IncrementCounter(ctx, "email_verification:"+claims.UserID, verificationResendWindow)
+	if err != nil {
+		logrus.Errorf("Failed to count verification emails: %v", err)
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+	}
+	if sent > verificationResendLimit {
+		return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Too many verification emails, please try again later"), nil)
+	}
+
+	correlationID := utils.GenerateCorrelationID()
+	err = h.SendMessage.SendingToMessageWithMetadata(requestMetadata(c), "EmailVerificationResend", correlationID, models.EmailVerificationResendRequest{
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to publish message"), nil)
+	}
+
+	return h.ResponseHandler.HandleEventResponse(
+		c,
+		false,
+		http.StatusAccepted,
+		h.Config.RequestTimeout,
+		"Verification email sent",
+		"EmailVerificationResendSuccess",
+		"EmailVerificationResendFailed",
+	)
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"api-gateway/config"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"user-service/core/apperror"
+)
+
+// isEmailVerified looks up verifications made after the token was issued, tests replace it
+var isEmailVerified = config.IsEmailVerified
+
+// RequireVerifiedEmail blocks routes until the user verified their email, use it after JWTMiddleware
+// on the routes that need it, e.g. r.POST("/deals/:id/claim", h.ClaimDeal, middleware.RequireVerifiedEmail()).
+// Unverified users get apperror.ErrEmailNotVerified, clients ask them to verify before retrying
+func RequireVerifiedEmail() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("user").(*utils.JWTCustomClaims)
+			if !ok || claims == nil {
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+			}
+
+			if claims.EmailVerified {
+				return next(c)
+			}
+
+			// the token may predate the verification
+			verified, err := isEmailVerified(c.Request().Context(), claims.UserID)
+			if err != nil {
+				logrus.Errorf("Error checking email verification in Redis: %v", err)
+				return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
+			}
+			if !verified {
+				return webResponse.ResponseProblem(c, apperror.ErrEmailNotVerified, nil)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	verifiedLater := map[string]bool{"verified-later": true}
+	lookup := isEmailVerified
+	isEmailVerified = func(ctx context.Context, userID string) (bool, error) {
+		if userID == "redis-down" {
+			return false, errors.New("connection refused")
+		}
+		return verifiedLater[userID], nil
+	}
+	t.Cleanup(func() { isEmailVerified = lookup })
+
+	tests := []struct {
+		name       string
+		claims     *utils.JWTCustomClaims
+		wantStatus int
+		wantCode   string
+	}{
+		{"verified in the token", &utils.JWTCustomClaims{UserID: "verified", EmailVerified: true}, http.StatusOK, ""},
+		{"verified since signing in", &utils.JWTCustomClaims{UserID: "verified-later"}, http.StatusOK, ""},
+		{"not verified", &utils.JWTCustomClaims{UserID: "unverified"}, http.StatusForbidden, "email_not_verified"},
+		{"lookup fails", &utils.JWTCustomClaims{UserID: "redis-down"}, http.StatusInternalServerError, "internal_error"},
+		{"no claims", nil, http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/deals/1/claim", nil), rec)
+			if tt.claims != nil {
+				c.Set("user", tt.claims)
+			}
+
+			handler := RequireVerifiedEmail()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+
+			var problem webResponse.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("body %s: %v", rec.Body, err)
+			}
+			if got := rec.Header().Get(echo.HeaderContentType); got != webResponse.ProblemContentType {
+				t.Fatalf("content type = %s, want %s", got, webResponse.ProblemContentType)
+			}
+			if problem.Code != tt.wantCode || problem.Status != tt.wantStatus {
+				t.Fatalf("problem = %+v, want code %s with status %d", problem, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+}
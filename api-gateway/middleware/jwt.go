@@ -3,6 +3,7 @@ package middleware
 import (
 	"api-gateway/config"
 	"api-gateway/utils"
+	"api-gateway/webResponse"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,9 +11,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"os"
 	"strings"
+	"user-service/core/apperror"
 )
 
 var Ctx = context.Background()
@@ -24,13 +25,13 @@ func JWTMiddleware() echo.MiddlewareFunc {
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" {
 				logrus.Warn("Missing Authorization header")
-				return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Missing token"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Missing token"), nil)
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 			if tokenString == authHeader {
 				logrus.Warn("Invalid token format")
-				return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Invalid token format"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token format"), nil)
 			}
 
 			claims := &utils.JWTCustomClaims{}
@@ -40,36 +41,36 @@ func JWTMiddleware() echo.MiddlewareFunc {
 
 			if err != nil {
 				logrus.Errorf("Error parsing token: %v", err)
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token"), nil)
 			}
 
 			if !token.Valid {
 				logrus.Warn("Token is not valid")
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token"), nil)
 			}
 
 			// Cek token di Redis
-			rdb := config.NewRedisClient()
+			rdb := config.GetRedisClient()
 			ctx := c.Request().Context()
 
 			storedToken, err := rdb.Get(ctx, tokenString).Result()
 			if errors.Is(err, redis.Nil) {
 				logrus.Warn("Token not found in Redis")
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token"), nil)
 			} else if err != nil {
 				logrus.Errorf("Error checking token in Redis: %v", err)
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error accessing Redis"})
+				return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error accessing Redis"), nil)
 			}
 
 			var tokenData map[string]interface{}
 			if err := json.Unmarshal([]byte(storedToken), &tokenData); err != nil {
 				logrus.Errorf("Error parsing stored token data: %v", err)
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error parsing token data"})
+				return webResponse.ResponseProblem(c, apperror.ErrInternal.WithMessage("Error parsing token data"), nil)
 			}
 
 			if claims.UserID != tokenData["userID"].(string) {
 				logrus.Warn("Token user ID mismatch")
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token"), nil)
 			}
 
 			c.Set("user", claims)
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"api-gateway/config"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"user-service/core/apperror"
+)
+
+// RequirePermission allows the request when the role of the signed-in user grants every permission.
+// It reads the claims verified by JWTMiddleware, so it has to run after it.
+func RequirePermission(roles *config.RoleCache, permissions ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("user").(*utils.JWTCustomClaims)
+			if !ok || claims == nil {
+				return webResponse.ResponseProblem(c, apperror.ErrUnauthorized.WithMessage("Invalid token claims"), nil)
+			}
+
+			for _, permission := range permissions {
+				allowed, err := roles.HasPermission(c.Request().Context(), claims.Role, permission)
+				if err != nil {
+					logrus.Errorf("Failed to load role permissions: %v", err)
+					return webResponse.ResponseProblem(c, apperror.ErrUnavailable.WithMessage("Permissions are unavailable, please try again later"), nil)
+				}
+				if !allowed {
+					return webResponse.ResponseProblem(c, apperror.ErrForbidden.WithMessage("You don't have permission to access this route"), nil)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
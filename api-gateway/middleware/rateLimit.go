@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"api-gateway/config"
+	"api-gateway/utils"
+	"api-gateway/webResponse"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"time"
+	"user-service/core/apperror"
+)
+
+// RateLimit applies the policies to every request, policies whose subject is missing
+// (no signed-in user, no API key) are skipped. The most restrictive policy sets the
+// RateLimit-* headers, a refused request gets 429 with Retry-After.
+func RateLimit(cfg *config.RateLimitConfig, policies ...config.RateLimitPolicy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !cfg.Enable {
+				return next(c)
+			}
+
+			var tightest *config.RateLimitResult
+			var tightestPolicy config.RateLimitPolicy
+			for _, policy := range policies {
+				subject := rateLimitSubject(c, policy.By)
+				if subject == "" {
+					continue
+				}
+
+				result, err := config.AllowRequest(c.Request().Context(), policy, subject)
+				if err != nil {
+					// an unavailable Redis must not take the API down with it
+					logrus.Errorf("Rate limit %s unavailable: %v", policy.Name, err)
+					continue
+				}
+				if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+					tightest, tightestPolicy = &result, policy
+				}
+				if !result.Allowed {
+					break
+				}
+			}
+			if tightest == nil {
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.ResetAfter)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", tightestPolicy.Limit, ceilSeconds(tightestPolicy.Period)))
+
+			if !tightest.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+				return webResponse.ResponseProblem(c, apperror.ErrRateLimited.WithMessage("Rate limit exceeded"), nil)
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimitSubject identifies who a request is counted for, the API key is hashed so it never reaches Redis
+func rateLimitSubject(c echo.Context, by string) string {
+	switch by {
+	case config.RateLimitByUser:
+		if claims, ok := c.Get("user").(*utils.JWTCustomClaims); ok && claims != nil {
+			return claims.UserID
+		}
+	case config.RateLimitByAPIKey:
+		if key := c.Request().Header.Get("X-API-Key"); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			return hex.EncodeToString(sum[:])
+		}
+	case config.RateLimitByIP:
+		return c.RealIP()
+	}
+	return ""
+}
+
+// ceilSeconds rounds up so clients never retry too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
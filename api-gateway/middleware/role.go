@@ -1,41 +0,0 @@
-package middleware
-
-import (
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/labstack/echo/v4"
-	"net/http"
-	"strings"
-)
-
-// RoleMiddleware function to check user role
-func RoleMiddleware(requiredRole string, jwtKey []byte) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader == "" {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token not found"})
-			}
-
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
-			})
-			if err != nil || !token.Valid {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
-			}
-
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
-			}
-
-			role, ok := claims["role"].(string)
-			if !ok || role != requiredRole {
-				return c.JSON(http.StatusForbidden, map[string]string{"error": "You don't have permission to access this route"})
-			}
-
-			return next(c)
-		}
-	}
-}
@@ -1,16 +1,20 @@
 package models
 
 import (
+	"api-gateway/config"
 	"github.com/go-playground/validator/v10"
+	"time"
 )
 
 type RegisterRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Username string `json:"username" validate:"required,min=3"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required,max=1024"`
 	Address  string `json:"address" validate:"required"`
 	Phone    string `json:"phone" validate:"required"`
 	Age      int    `json:"age" validate:"required,gt=0"`
+	// PhoneRegion is the ISO country code used to read a phone number written without +country code
+	PhoneRegion string `json:"phone_region,omitempty" validate:"omitempty,len=2,alpha"`
 }
 
 func (r *RegisterRequest) Validate() error {
@@ -21,7 +25,7 @@ func (r *RegisterRequest) Validate() error {
 // LoginRequest Request for login
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required,max=1024"`
 }
 
 func (l *LoginRequest) Validate() error {
@@ -29,11 +33,14 @@ func (l *LoginRequest) Validate() error {
 	return validate.Struct(l)
 }
 
-// OAuthUserRequest Request for Register OAuth
+// OAuthUserRequest Request for Login / Register via an OIDC provider
 type OAuthUserRequest struct {
-	GoogleID string `json:"google_id" validate:"required"`
-	Email    string `json:"email" validate:"required,email"`
-	Username string `json:"username" validate:"required"`
+	Provider      string `json:"provider" validate:"required"`
+	Subject       string `json:"subject" validate:"required"`
+	Email         string `json:"email" validate:"required,email"`
+	EmailVerified bool   `json:"email_verified"`
+	Username      string `json:"username" validate:"required"`
+	Avatar        string `json:"avatar"`
 }
 
 // UserProfileRequest Request for Get and Update Profile
@@ -50,3 +57,432 @@ func (u *UserProfileRequest) Validate() error {
 	validate := validator.New()
 	return validate.Struct(u)
 }
+
+// UpdateProfileRequest Request for a partial profile update, omitted fields keep their value
+type UpdateProfileRequest struct {
+	UserID   string  `json:"user_id"`
+	Username *string `json:"username,omitempty" validate:"omitempty,min=3"`
+	Address  *string `json:"address,omitempty" validate:"omitempty,min=1"`
+	Phone    *string `json:"phone,omitempty" validate:"omitempty,min=1"`
+	Age      *int    `json:"age,omitempty" validate:"omitempty,gt=0"`
+	// Version is the profile version the client edited, a newer stored version rejects the update
+	Version *int64 `json:"version,omitempty" validate:"omitempty,gte=0"`
+	// PhoneRegion is the ISO country code used to read a phone number written without +country code
+	PhoneRegion string `json:"phone_region,omitempty" validate:"omitempty,len=2,alpha"`
+}
+
+func (u *UpdateProfileRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(u)
+}
+
+// AvatarUpdateRequest Request for pointing a user at an uploaded avatar, Variants maps each size to its URL
+type AvatarUpdateRequest struct {
+	UserID   string            `json:"user_id"`
+	Avatar   string            `json:"avatar"`
+	Variants map[string]string `json:"variants"`
+}
+
+// ChangePasswordRequest Request for changing the password of the signed-in user
+type ChangePasswordRequest struct {
+	UserID          string `json:"user_id"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,max=1024"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// ChangeEmailRequest Request for moving the signed-in user to another email address
+type ChangeEmailRequest struct {
+	UserID   string `json:"user_id"`
+	NewEmail string `json:"new_email" validate:"required,email"`
+	Password string `json:"password,omitempty"`
+}
+
+func (r *ChangeEmailRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// ConfirmEmailChangeRequest Request for confirming a new email address with the emailed token
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *ConfirmEmailChangeRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// ReauthenticateRequest Request for confirming the identity of the signed-in user
+type ReauthenticateRequest struct {
+	ID          string `json:"id"`
+	Password    string `json:"password"`
+	RecentLogin bool   `json:"recent_login"`
+}
+
+// ConfirmLinkRequest Request for linking a provider to an existing account by proving its password
+type ConfirmLinkRequest struct {
+	LinkToken string `json:"link_token" validate:"required"`
+	Password  string `json:"password" validate:"required"`
+}
+
+func (r *ConfirmLinkRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// IdentityLinkRequest Request for attaching a provider identity to a user
+type IdentityLinkRequest struct {
+	UserID        string `json:"user_id,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	ProviderEmail string `json:"provider_email"`
+}
+
+// IdentityUnlinkRequest Request for removing a provider identity from a user
+type IdentityUnlinkRequest struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+}
+
+// MFALoginRequest Request for finishing a login with a second factor
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+func (r *MFALoginRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// MFAChallengeRequest Request for enrolling MFA during a login that requires it
+type MFAChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+}
+
+func (r *MFAChallengeRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// MFACodeRequest Request carrying a TOTP code or a recovery code from the signed-in user
+type MFACodeRequest struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (r *MFACodeRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// MFARequest Request for MFA operations on a user
+type MFARequest struct {
+	UserID       string `json:"user_id"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// Passkey WebAuthn credential as stored by user-service, ID is the base64url credential ID
+type Passkey struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	PublicKey       []byte     `json:"public_key"`
+	AttestationType string     `json:"attestation_type,omitempty"`
+	Transports      []string   `json:"transports,omitempty"`
+	AAGUID          []byte     `json:"aaguid,omitempty"`
+	SignCount       uint32     `json:"sign_count"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	CloneWarning    bool       `json:"clone_warning,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
+
+// PasskeyUser User data needed to run a WebAuthn ceremony
+type PasskeyUser struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Role     string    `json:"role,omitempty"`
+	Passkeys []Passkey `json:"passkeys,omitempty"`
+}
+
+// PasskeyRegisterRequest Request for storing a verified passkey
+type PasskeyRegisterRequest struct {
+	UserID  string  `json:"user_id"`
+	Passkey Passkey `json:"passkey"`
+}
+
+// PasskeyLoginRequest Request for recording a verified passkey assertion
+type PasskeyLoginRequest struct {
+	UserID       string `json:"user_id"`
+	CredentialID string `json:"credential_id"`
+	SignCount    uint32 `json:"sign_count"`
+	BackupState  bool   `json:"backup_state"`
+	CloneWarning bool   `json:"clone_warning"`
+	SecondFactor bool   `json:"second_factor"`
+}
+
+// PasskeyDeleteRequest Request for removing a passkey
+type PasskeyDeleteRequest struct {
+	UserID       string `json:"user_id"`
+	CredentialID string `json:"credential_id"`
+}
+
+// ForgotPasswordRequest Request for a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (r *ForgotPasswordRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// ResetPasswordRequest Request for choosing a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+func (r *ResetPasswordRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// VerifyEmailRequest Request for confirming an email address with the emailed token
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *VerifyEmailRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// EmailVerificationResendRequest Request for a new verification email
+type EmailVerificationResendRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// SendPhoneOTPRequest Request for a verification code to a phone number
+type SendPhoneOTPRequest struct {
+	Phone   string `json:"phone" validate:"required"`
+	Region  string `json:"region,omitempty" validate:"omitempty,len=2,alpha"`
+	Channel string `json:"channel,omitempty" validate:"omitempty,oneof=sms whatsapp"`
+}
+
+func (r *SendPhoneOTPRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// VerifyPhoneOTPRequest Request for confirming a phone number with the received code
+type VerifyPhoneOTPRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+func (r *VerifyPhoneOTPRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// PhoneOtpSendRequest Request for delivering a verification code
+type PhoneOtpSendRequest struct {
+	UserID    string `json:"user_id"`
+	Phone     string `json:"phone"`
+	Code      string `json:"code"`
+	Channel   string `json:"channel"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// PhoneVerifyRequest Request for storing a phone number whose code was confirmed
+type PhoneVerifyRequest struct {
+	UserID string `json:"user_id"`
+	Phone  string `json:"phone"`
+}
+
+// MagicLinkRequest Request for an emailed sign-in link
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (r *MagicLinkRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// MagicLinkVerifyRequest Request for signing in with the emailed token on the device that asked for it
+type MagicLinkVerifyRequest struct {
+	Token       string `json:"token" validate:"required"`
+	DeviceToken string `json:"device_token" validate:"required"`
+}
+
+func (r *MagicLinkVerifyRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// MagicLinkSendRequest Request for emailing a sign-in link bound to a device
+type MagicLinkSendRequest struct {
+	Email      string `json:"email"`
+	DeviceHash string `json:"device_hash"`
+}
+
+// MagicLinkLoginRequest Request for finishing a magic link login
+type MagicLinkLoginRequest struct {
+	Token      string `json:"token"`
+	DeviceHash string `json:"device_hash"`
+}
+
+// AccountLockedRequest Request for recording a lockout and emailing an unlock link
+type AccountLockedRequest struct {
+	Email       string    `json:"email"`
+	IP          string    `json:"ip"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// UnlockAccountRequest Request for lifting a lockout with the emailed token
+type UnlockAccountRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *UnlockAccountRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminUnlockAccountRequest Request for an admin lifting the lockout of a user
+type AdminUnlockAccountRequest struct {
+	UserID  string `json:"user_id"`
+	ActorID string `json:"actor_id"`
+}
+
+// AdminListUsersRequest Query for listing users
+type AdminListUsersRequest struct {
+	Query         string `query:"q"`
+	Role          string `query:"role"`
+	Status        string `query:"status" validate:"omitempty,oneof=active suspended"`
+	EmailVerified string `query:"email_verified" validate:"omitempty,oneof=true false"`
+	Page          int    `query:"page" validate:"omitempty,gte=1"`
+	Limit         int    `query:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+func (r *AdminListUsersRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminListUsersEvent Request for one page of users
+type AdminListUsersEvent struct {
+	Query         string `json:"query"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	EmailVerified *bool  `json:"email_verified"`
+	Page          int    `json:"page"`
+	Limit         int    `json:"limit"`
+}
+
+// AdminChangeRoleRequest Request for giving a user another role
+type AdminChangeRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func (r *AdminChangeRoleRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminSuspendRequest Request for suspending a user, without until the suspension lasts until reactivated
+type AdminSuspendRequest struct {
+	Reason string     `json:"reason" validate:"required,max=500"`
+	Until  *time.Time `json:"until"`
+}
+
+func (r *AdminSuspendRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminReactivateRequest Request for lifting a suspension
+type AdminReactivateRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+func (r *AdminReactivateRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminReasonRequest Request body with an optional reason
+type AdminReasonRequest struct {
+	Reason string `json:"reason" validate:"omitempty,max=500"`
+}
+
+func (r *AdminReasonRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// AdminUserRequest Request for an admin action on one user
+type AdminUserRequest struct {
+	UserID    string     `json:"user_id"`
+	ActorID   string     `json:"actor_id"`
+	ActorRole string     `json:"actor_role"`
+	Role      string     `json:"role,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+}
+
+// DataExportRequest Request for an export of the signed-in user's data with the sessions the gateway knows of
+type DataExportRequest struct {
+	UserID   string           `json:"user_id"`
+	Sessions []config.Session `json:"sessions"`
+}
+
+// DataExportDownloadRequest Request for the archive of a ready export
+type DataExportDownloadRequest struct {
+	UserID   string `json:"user_id"`
+	ExportID string `json:"export_id"`
+}
+
+// DeleteAccountRequest Request for deleting the signed-in account, the password is needed unless the login is recent
+type DeleteAccountRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password,omitempty"`
+}
+
+// RestoreAccountRequest Request for cancelling a scheduled deletion with the emailed token
+type RestoreAccountRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (r *RestoreAccountRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// ActivityListRequest Query for one page of the audit trail, from and to are RFC 3339 times
+type ActivityListRequest struct {
+	UserID  string `query:"user_id" validate:"omitempty,mongodb"`
+	ActorID string `query:"actor_id" validate:"omitempty,mongodb"`
+	Action  string `query:"action" validate:"omitempty,max=64"`
+	From    string `query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	To      string `query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Page    int    `query:"page" validate:"omitempty,gte=1"`
+	Limit   int    `query:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+func (r *ActivityListRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"api-gateway/config"
+	"api-gateway/handler"
+	"api-gateway/middleware"
+	"github.com/labstack/echo/v4"
+)
+
+// adminRoutes register user management routes, every route checks a permission of the signed-in role
+func adminRoutes(e *echo.Echo, userHandler *handler.UserHandler, roles *config.RoleCache) {
+	canRead := middleware.RequirePermission(roles, config.PermissionUsersRead)
+	canWrite := middleware.RequirePermission(roles, config.PermissionUsersWrite)
+
+	r := e.Group("/api/admin/users", middleware.JWTMiddleware())
+	r.GET("", userHandler.AdminListUsers, canRead)
+	r.GET("/:id", userHandler.AdminGetUser, canRead)
+	r.GET("/:id/activity", userHandler.AdminUserActivity, canRead)
+	r.PUT("/:id/role", userHandler.AdminChangeRole, canWrite)
+	r.POST("/:id/suspend", userHandler.AdminSuspendUser, canWrite)
+	r.POST("/:id/reactivate", userHandler.AdminReactivateUser, canWrite)
+	r.POST("/:id/password-reset", userHandler.AdminForcePasswordReset, canWrite)
+	r.POST("/:id/unlock", userHandler.AdminUnlockAccount, canWrite)
+	r.DELETE("/:id", userHandler.AdminDeleteUser, canWrite)
+
+	// audit trail of every user
+	e.GET("/api/admin/activity", userHandler.AdminListActivity, middleware.JWTMiddleware(), canRead)
+}
@@ -4,25 +4,89 @@ import (
 	"api-gateway/config"
 	"api-gateway/handler"
 	"api-gateway/middleware"
+	"api-gateway/storage"
 	"api-gateway/webResponse"
 	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	"messaging"
+	"time"
 )
 
 // UserRoutes register user routes
 func UserRoutes(e *echo.Echo, cfg *config.RateLimitConfig, rmq *messaging.RabbitMQConnection, res *webResponse.ResponseHandler) {
 	userHandler := handler.NewUserHandler(cfg, rmq, res)
+
+	// per route limits, each can be overridden with RATE_LIMIT_<NAME>=<limit>/<period>
+	loginLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("login", config.RateLimitByIP, "5/1m"))
+	mfaLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("login-mfa", config.RateLimitByIP, "10/1m"))
+	registerLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("register", config.RateLimitByIP, "10/1h"))
+	emailLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("email", config.RateLimitByIP, "5/1m"))
+	tokenLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("token", config.RateLimitByIP, "10/1m"))
+	profileLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("profile", config.RateLimitByUser, "60/1m"))
+	userLimit := middleware.RateLimit(cfg, config.LoadRateLimitPolicy("user", config.RateLimitByUser, "30/1m"))
+
+	// role permissions come from user-service and are cached for five minutes
+	roles := config.NewRoleCache(5*time.Minute, userHandler.FetchRolePermissions)
+
+	adminRoutes(e, userHandler, roles)
+
+	// avatars kept on this server are served by the gateway itself
+	if local, ok := userHandler.Avatars.(storage.LocalFiles); ok {
+		e.Static(local.URLPath(), local.Dir())
+	}
+
 	r := e.Group("/api/users")
-	r.POST("/login", userHandler.Login)
-	r.POST("/register", userHandler.Register)
+	r.POST("/login", userHandler.Login, loginLimit)
+	r.POST("/login/mfa", userHandler.LoginMFA, mfaLimit)
+	r.POST("/login/mfa/enroll", userHandler.LoginMFAEnroll, mfaLimit)
+	r.POST("/login/mfa/passkey/begin", userHandler.BeginPasskeyMFA, mfaLimit)
+	r.POST("/login/mfa/passkey/finish", userHandler.FinishPasskeyMFA, mfaLimit)
+	r.POST("/login/passkey/begin", userHandler.BeginPasskeyLogin, loginLimit)
+	r.POST("/login/passkey/finish", userHandler.FinishPasskeyLogin, loginLimit)
+	r.POST("/login/magic-link", userHandler.RequestMagicLink, emailLimit)
+	r.POST("/login/magic-link/verify", userHandler.VerifyMagicLink, tokenLimit)
+	r.POST("/register", userHandler.Register, registerLimit)
+	r.POST("/password/forgot", userHandler.ForgotPassword, emailLimit)
+	r.POST("/password/reset", userHandler.ResetPassword, tokenLimit)
+	r.POST("/email/verify", userHandler.VerifyEmail, tokenLimit)
+	r.POST("/email/change/confirm", userHandler.ConfirmEmailChange, tokenLimit)
+	r.POST("/account/unlock", userHandler.UnlockAccount, tokenLimit)
+	r.POST("/account/restore", userHandler.RestoreAccount, tokenLimit)
 
-	// oauthGroup
-	//r.GET("/oauth/google", userHandler.GoogleLogin)
-	//r.GET("/oauth/google/callback", userHandler.GoogleCallback)
-	// Get Secret Key
+	// oauth routes, Apple posts its callback with response_mode=form_post
+	r.GET("/oauth/:provider", userHandler.OAuthLogin)
+	r.GET("/oauth/:provider/callback", userHandler.OAuthCallback)
+	r.POST("/oauth/:provider/callback", userHandler.OAuthCallback)
+	r.POST("/oauth/link/confirm", userHandler.ConfirmLink, loginLimit)
 
 	// protected routes
 	r.Use(middleware.JWTMiddleware())
 	// profile routes
-	r.GET("/profile", userHandler.GetProfile)
+	r.GET("/profile", userHandler.GetProfile, profileLimit)
+	r.PATCH("/profile", userHandler.UpdateProfile, profileLimit)
+	// the whole multipart body is parsed before the handler sees the file, so its size is capped up front
+	r.PUT("/avatar", userHandler.UploadAvatar, userLimit, echoMiddleware.BodyLimit("6M"))
+	r.POST("/password", userHandler.ChangePassword, userLimit)
+	r.POST("/email", userHandler.ChangeEmail, userLimit)
+	r.POST("/email/verification", userHandler.ResendVerificationEmail, userLimit)
+	r.POST("/phone/otp", userHandler.SendPhoneOTP, userLimit)
+	r.POST("/phone/verify", userHandler.VerifyPhoneOTP, userLimit)
+
+	// data export and account deletion
+	r.GET("/me/export", userHandler.ExportData, userLimit)
+	r.GET("/me/export/:id/download", userHandler.DownloadExport, userLimit)
+	r.DELETE("/me", userHandler.DeleteAccount, userLimit)
+	r.GET("/me/activity", userHandler.MyActivity, userLimit)
+	// linked identity routes
+	r.POST("/identities/:provider", userHandler.LinkIdentity)
+	r.DELETE("/identities/:provider", userHandler.UnlinkIdentity)
+	// mfa routes
+	r.POST("/mfa/totp", userHandler.EnrollMFA)
+	r.POST("/mfa/totp/confirm", userHandler.ConfirmMFA)
+	r.POST("/mfa/totp/disable", userHandler.DisableMFA)
+	r.POST("/mfa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	// passkey routes
+	r.POST("/passkeys/register/begin", userHandler.BeginPasskeyRegistration)
+	r.POST("/passkeys/register/finish", userHandler.FinishPasskeyRegistration)
+	r.DELETE("/passkeys/:id", userHandler.DeletePasskey)
 }
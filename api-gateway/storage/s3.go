@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config points at an S3-compatible bucket, Endpoint is e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000 for MinIO
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is where the bucket is readable, e.g. a CDN, it defaults to Endpoint/Bucket
+	PublicURL string
+}
+
+type s3Store struct {
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Store stores files in an S3-compatible bucket with path-style requests signed with AWS Signature V4
+func NewS3Store(config S3Config) BlobStore {
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PublicURL == "" {
+		config.PublicURL = config.Endpoint + "/" + config.Bucket
+	}
+	config.PublicURL = strings.TrimSuffix(config.PublicURL, "/")
+
+	return &s3Store{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	req, err := s.request(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data, time.Now().UTC())
+
+	if err := s.do(req); err != nil {
+		return "", err
+	}
+	return s.config.PublicURL + "/" + escapePath(key), nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil, time.Now().UTC())
+	return s.do(req)
+}
+
+func (s *s3Store) Key(url string) (string, bool) {
+	return keyOf(s.config.PublicURL, url)
+}
+
+// DeletePrefix lists the keys under prefix with ListObjectsV2 and deletes them one by one
+func (s *s3Store) DeletePrefix(ctx context.Context, prefix string) error {
+	continuation := ""
+	for {
+		page, err := s.list(ctx, prefix, continuation)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			if err := s.Delete(ctx, object.Key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		continuation = page.NextContinuationToken
+	}
+}
+
+// listBucketResult is the part of the ListObjectsV2 response DeletePrefix needs
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Store) list(ctx context.Context, prefix, continuation string) (*listBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if continuation != "" {
+		query.Set("continuation-token", continuation)
+	}
+
+	endpoint := s.config.Endpoint + "/" + escapePath(s.config.Bucket)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the canonical query of SigV4 encodes spaces as %20, Encode already sorts the keys
+	req.URL.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3: list %s: status %d: %s", prefix, resp.StatusCode, body)
+	}
+
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *s3Store) request(ctx context.Context, method, key string, data []byte) (*http.Request, error) {
+	endpoint := s.config.Endpoint + "/" + escapePath(s.config.Bucket) + "/" + escapePath(key)
+	return http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(data))
+}
+
+func (s *s3Store) do(req *http.Request) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3: %s %s: status %d: %s", req.Method, req.URL.Path, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// sign adds the Authorization header of AWS Signature Version 4, every header already set is signed
+func (s *s3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+	// net/http sends Host from req.Host, not from the header map
+	req.Header.Del("Host")
+}
+
+// escapePath encodes each segment of a key the way S3 expects in the canonical request
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
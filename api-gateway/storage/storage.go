@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps uploaded files such as avatars and returns the URL they are served from
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every file whose key starts with prefix, e.g. all avatars of a user
+	DeletePrefix(ctx context.Context, prefix string) error
+	// Key returns the key of a URL returned by Put, false for URLs served from elsewhere
+	Key(url string) (string, bool)
+}
+
+// LocalFiles is implemented by stores that keep files on this server, the gateway serves Dir under URLPath
+type LocalFiles interface {
+	Dir() string
+	URLPath() string
+}
+
+// NewBlobStoreFromEnv picks the store configured with BLOB_DRIVER (local or s3), local is the default
+func NewBlobStoreFromEnv() BlobStore {
+	switch strings.ToLower(os.Getenv("BLOB_DRIVER")) {
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return NewLocalStore(os.Getenv("BLOB_LOCAL_DIR"), os.Getenv("BLOB_PUBLIC_URL"))
+	}
+}
+
+type localStore struct {
+	dir       string
+	publicURL string
+}
+
+// NewLocalStore writes files below dir, they are served under publicURL (default /uploads) by the gateway
+func NewLocalStore(dir, publicURL string) BlobStore {
+	if dir == "" {
+		dir = "tmp/uploads"
+	}
+	if publicURL == "" {
+		publicURL = "/uploads"
+	}
+	return &localStore{dir: dir, publicURL: strings.TrimSuffix(publicURL, "/")}
+}
+
+func (s *localStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return s.publicURL + "/" + key, nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix removes the directory prefix names, local keys are only grouped by directory
+func (s *localStore) DeletePrefix(ctx context.Context, prefix string) error {
+	path, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+func (s *localStore) Key(url string) (string, bool) {
+	return keyOf(s.publicURL, url)
+}
+
+func (s *localStore) Dir() string {
+	return s.dir
+}
+
+// URLPath is the path part of the public URL, the part the gateway has to route
+func (s *localStore) URLPath() string {
+	link, err := url.Parse(s.publicURL)
+	if err != nil || link.Path == "" {
+		return "/"
+	}
+	return link.Path
+}
+
+// keyOf cuts publicURL off a URL made by Put and decodes the rest into the key
+func keyOf(publicURL, link string) (string, bool) {
+	escaped, found := strings.CutPrefix(link, publicURL+"/")
+	if !found || escaped == "" {
+		return "", false
+	}
+	key, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// path maps a key inside dir, keys that would escape it are refused
+func (s *localStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", errors.New("storage: invalid key")
+	}
+	return filepath.Join(s.dir, clean), nil
+}
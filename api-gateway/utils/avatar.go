@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// AvatarSizes are the square variants generated for every avatar, in pixels
+var AvatarSizes = []int{64, 256, 512}
+
+const (
+	// AvatarMaxBytes is the largest avatar upload accepted
+	AvatarMaxBytes = 5 << 20
+	// avatarMaxPixels refuses images that would take too much memory to decode
+	avatarMaxPixels = 40_000_000
+)
+
+// ErrUnsupportedImage is returned for uploads that are not a JPEG, PNG or GIF image
+var ErrUnsupportedImage = errors.New("only JPEG, PNG and GIF images are supported")
+
+// AvatarVariant is one resized avatar, ready to be stored
+type AvatarVariant struct {
+	Size        int
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+// ProcessAvatar checks the image by its magic bytes and returns the square variants of AvatarSizes.
+// The pixels are re-encoded, so EXIF and every other metadata is dropped after the orientation is applied.
+func ProcessAvatar(data []byte) ([]AvatarVariant, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > avatarMaxPixels {
+		return nil, errors.New("image dimensions are too large")
+	}
+
+	var src image.Image
+	switch contentType {
+	case "image/jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			src = applyOrientation(src, jpegOrientation(data))
+		}
+	case "image/png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		// animated avatars keep their first frame
+		src, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	square := cropSquare(src)
+	variants := make([]AvatarVariant, 0, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		resized := resizeSquare(square, size)
+
+		var buf bytes.Buffer
+		variant := AvatarVariant{Size: size}
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+			variant.ContentType, variant.Extension = "image/jpeg", "jpg"
+		} else {
+			// PNG keeps the transparency of PNG and GIF uploads
+			err = png.Encode(&buf, resized)
+			variant.ContentType, variant.Extension = "image/png", "png"
+		}
+		if err != nil {
+			return nil, err
+		}
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// cropSquare keeps the centered square of the image
+func cropSquare(src image.Image) image.Image {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), src, image.Pt(x0, y0), draw.Src)
+	return dst
+}
+
+// resizeSquare scales a square image to size x size, every target pixel averages the source pixels it covers
+func resizeSquare(src image.Image, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		sy0, sy1 := y*side/size, (y+1)*side/size
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < size; x++ {
+			sx0, sx1 := x*side/size, (x+1)*side/size
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// RGBA returns premultiplied 16-bit values
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation (1-8) of a JPEG, 1 when it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			// image data starts, no EXIF before it
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds the orientation tag (0x0112) in the first IFD of an EXIF TIFF block
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and flips the image so it is shown upright without its EXIF orientation
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
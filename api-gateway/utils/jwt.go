@@ -9,17 +9,19 @@ import (
 )
 
 type JWTCustomClaims struct {
-	UserID string `json:"userID"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID        string `json:"userID"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"emailVerified"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID, email, role string) (string, error) {
+func GenerateToken(userID, email, role string, emailVerified bool) (string, error) {
 	claims := &JWTCustomClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:        userID,
+		Email:         email,
+		Role:          role,
+		EmailVerified: emailVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"github.com/nyaruka/phonenumbers"
+	"os"
+	"strings"
+)
+
+// ErrInvalidPhone is returned when a number is not valid in any accepted region
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// phoneRegions are tried in order for numbers written without a country code,
+// configured with PHONE_DEFAULT_REGIONS=ID,AE
+func phoneRegions() []string {
+	var regions []string
+	for _, region := range strings.Split(os.Getenv("PHONE_DEFAULT_REGIONS"), ",") {
+		if region = strings.ToUpper(strings.TrimSpace(region)); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	if len(regions) == 0 {
+		return []string{"ID", "AE"}
+	}
+	return regions
+}
+
+// NormalizePhone parses a number written in local or international format and returns it in E.164,
+// region (e.g. ID or AE) is a hint for local numbers, without it the default regions are tried
+func NormalizePhone(raw, region string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrInvalidPhone
+	}
+
+	regions := phoneRegions()
+	if region != "" {
+		regions = []string{strings.ToUpper(region)}
+	}
+
+	for _, candidate := range regions {
+		number, err := phonenumbers.Parse(raw, candidate)
+		if err != nil || !phonenumbers.IsValidNumber(number) {
+			continue
+		}
+		return phonenumbers.Format(number, phonenumbers.E164), nil
+	}
+	return "", ErrInvalidPhone
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	t.Setenv("PHONE_DEFAULT_REGIONS", "")
+
+	tests := []struct {
+		name    string
+		raw     string
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{"ID local", "0812-3456-7890", "ID", "+6281234567890", false},
+		{"ID international", "+62 812 3456 7890", "", "+6281234567890", false},
+		{"ID lowercase region", " 081234567890 ", "id", "+6281234567890", false},
+		{"ID without region", "081234567890", "", "+6281234567890", false},
+		{"AE local", "050 123 4567", "AE", "+971501234567", false},
+		{"AE international", "+971-50-123-4567", "", "+971501234567", false},
+		// the international prefix wins over the region hint
+		{"AE number with ID hint", "+971501234567", "ID", "+971501234567", false},
+		{"AE local with ID hint", "0501234567", "ID", "", true},
+		{"too short", "0812", "ID", "", true},
+		{"letters", "call me", "", "", true},
+		{"empty", "  ", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhone(tt.raw, tt.region)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPhone) {
+					t.Fatalf("NormalizePhone(%q, %q) = %s, %v, want ErrInvalidPhone", tt.raw, tt.region, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("NormalizePhone(%q, %q) = %s, %v, want %s", tt.raw, tt.region, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhoneRegions(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"", []string{"ID", "AE"}},
+		{"ae", []string{"AE"}},
+		{" sg , ID ,,", []string{"SG", "ID"}},
+	}
+	for _, tt := range tests {
+		t.Setenv("PHONE_DEFAULT_REGIONS", tt.env)
+		if got := phoneRegions(); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("phoneRegions with %q = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
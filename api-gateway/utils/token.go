@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateSecureToken returns a URL-safe random token built from n random bytes
+func GenerateSecureToken(n int) (string, error) {
+	randomBytes := make([]byte, n)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// GenerateOTP returns a random numeric code with the given number of digits
+func GenerateOTP(digits int) (string, error) {
+	randomBytes := make([]byte, digits)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, digits)
+	for i, b := range randomBytes {
+		// 250 is the largest multiple of 10 below 256, rejecting above it keeps digits uniform
+		for b >= 250 {
+			var retry [1]byte
+			if _, err := rand.Read(retry[:]); err != nil {
+				return "", err
+			}
+			b = retry[0]
+		}
+		code[i] = '0' + b%10
+	}
+	return string(code), nil
+}
+
+// HashOTP hashes a one-time code together with what it was issued for, so codes are never stored in clear
+func HashOTP(subject, code string) string {
+	sum := sha256.Sum256([]byte(subject + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -27,6 +27,8 @@ func FormatValidationError(model interface{}, errors validator.ValidationErrors)
 		switch err.Tag() {
 		case "required":
 			message = fmt.Sprintf("%s is required", fieldName)
+		case "required_without":
+			message = fmt.Sprintf("%s is required when %s is empty", fieldName, err.Param())
 		case "numeric":
 			message = fmt.Sprintf("%s must be a number", fieldName)
 		case "unique":
@@ -0,0 +1,92 @@
+package webResponse
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"user-service/core/apperror"
+)
+
+// ProblemContentType is the media type of RFC 7807 error bodies
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error body, Code is the stable catalog code clients should branch on
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// ResponseProblem writes err as application/problem+json with the status of its catalog code.
+// Extension members (link tokens, error codes of the gateway) are added next to the standard ones.
+func ResponseProblem(c echo.Context, err *apperror.Error, extensions map[string]interface{}) error {
+	if err == nil {
+		err = apperror.ErrInternal
+	}
+	status := err.Status()
+	problem := Problem{
+		Type:      "urn:problem:" + err.Code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    err.Message,
+		Instance:  c.Request().URL.Path,
+		Code:      err.Code,
+		Errors:    err.Fields,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, ProblemContentType)
+	if len(extensions) == 0 {
+		return c.JSON(status, problem)
+	}
+
+	body := map[string]interface{}{}
+	for name, value := range extensions {
+		body[name] = value
+	}
+	body["type"] = problem.Type
+	body["title"] = problem.Title
+	body["status"] = problem.Status
+	body["detail"] = problem.Detail
+	body["instance"] = problem.Instance
+	body["code"] = problem.Code
+	if len(problem.Errors) > 0 {
+		body["errors"] = problem.Errors
+	}
+	if problem.RequestID != "" {
+		body["request_id"] = problem.RequestID
+	}
+	return c.JSON(status, body)
+}
+
+// HTTPErrorHandler writes the errors echo raises itself (unknown routes, body limits, panics) as problem+json
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, message := http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		message = fmt.Sprint(httpErr.Message)
+	} else {
+		logrus.Errorf("Unhandled error: %v", err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = ResponseProblem(c, apperror.ForStatus(status).WithMessage(message), nil)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to write error response: %v", err)
+	}
+}
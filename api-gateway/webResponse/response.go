@@ -7,10 +7,12 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"messaging"
-	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+	"user-service/core/apperror"
+	"user-service/core/models"
 )
 
 type Meta struct {
@@ -38,7 +40,8 @@ type Response struct {
 	Data interface{} `json:"data"`
 }
 
-// ResponseJson is a utility function that sends a JSON response to the client
+// ResponseJson is a utility function that sends a JSON response to the client,
+// errors go through ResponseProblem with their catalog error
 func ResponseJson(c echo.Context, status int, payload interface{}, message string) error {
 	response := Response{
 		Meta: Meta{
@@ -65,39 +68,49 @@ func getStatusText(status int) string {
 func (h *ResponseHandler) HandleEventResponse(c echo.Context, generateToken bool, statusCode int, timeout time.Duration, message string, eventName ...string) error {
 	if h == nil {
 		logrus.Fatal("HandleEventResponse: ResponseHandler is nil!")
-		return ResponseJson(c, http.StatusInternalServerError, nil, "Internal Server Error: ResponseHandler is nil")
+		return ResponseProblem(c, apperror.ErrInternal.WithMessage("Internal Server Error: ResponseHandler is nil"), nil)
 	}
 
 	if h.RMQ == nil {
 		logrus.Fatal("HandleEventResponse: RabbitMQ connection is nil!")
-		return ResponseJson(c, http.StatusInternalServerError, nil, "Internal Server Error: RabbitMQ connection is nil")
+		return ResponseProblem(c, apperror.ErrInternal.WithMessage("Internal Server Error: RabbitMQ connection is nil"), nil)
 	}
 
 	responseEvent, err := messaging.WaitForEvent(h.RMQ, timeout, "api-gateway", eventName...)
 	if err != nil {
 		logrus.Errorf("Event timeout while waiting for: %s", eventName)
-		return ResponseJson(c, http.StatusGatewayTimeout, nil, "Request timed out waiting for response")
+		return ResponseProblem(c, apperror.ErrTimeout, nil)
 	}
 
+	return h.RespondWithEvent(c, responseEvent, generateToken, statusCode, message, eventName...)
+}
+
+// RespondWithEvent writes the response for an event that has already been received
+func (h *ResponseHandler) RespondWithEvent(c echo.Context, responseEvent models.Event, generateToken bool, statusCode int, message string, eventName ...string) error {
 	logrus.Infof("Received event: %s | CorrelationID: %s", eventName, responseEvent.CorrelationID)
 	ctx := c.Request().Context()
 
+	// failures carry a catalog error, its code decides the status
+	if strings.HasSuffix(responseEvent.EventType, "Failed") {
+		return ResponseProblem(c, apperror.FromPayload(responseEvent.Payload), nil)
+	}
+
 	var jsonResponse map[string]interface{}
 	if payloadStr, ok := responseEvent.Payload.(string); ok {
 		//logrus.Warnf("Payload is a string: %s", payloadStr)
 		if json.Valid([]byte(payloadStr)) {
 			if err := json.Unmarshal([]byte(payloadStr), &jsonResponse); err != nil {
 				logrus.Errorf("Failed to parse event payload: %v", err)
-				return ResponseJson(c, http.StatusInternalServerError, nil, "Failed to parse response")
+				return ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to parse response"), nil)
 			}
 		} else {
-			return ResponseJson(c, http.StatusConflict, nil, payloadStr)
+			return ResponseProblem(c, apperror.FromPayload(payloadStr), nil)
 		}
 	} else if payloadMap, ok := responseEvent.Payload.(map[string]interface{}); ok {
 		jsonResponse = payloadMap
 	} else {
 		logrus.Errorf("Unexpected payload type: %T", responseEvent.Payload)
-		return ResponseJson(c, http.StatusInternalServerError, nil, "Unexpected event payload format")
+		return ResponseProblem(c, apperror.ErrInternal.WithMessage("Unexpected event payload format"), nil)
 	}
 
 	for _, expectedEvent := range eventName {
@@ -108,10 +121,11 @@ func (h *ResponseHandler) HandleEventResponse(c echo.Context, generateToken bool
 				userID, _ := jsonResponse["id"].(string)
 				userEmail, _ := jsonResponse["email"].(string)
 				userRole, _ := jsonResponse["role"].(string)
-				token, err := utils.GenerateToken(userID, userEmail, userRole)
+				emailVerified, _ := jsonResponse["email_verified"].(bool)
+				token, err := utils.GenerateToken(userID, userEmail, userRole, emailVerified)
 				if err != nil {
 					logrus.Error("Failed to generate token")
-					return ResponseJson(c, http.StatusInternalServerError, nil, "Failed to generate token")
+					return ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to generate token"), nil)
 				}
 
 				// Simpan token ke Redis
@@ -129,7 +143,7 @@ func (h *ResponseHandler) HandleEventResponse(c echo.Context, generateToken bool
 				storeToken := config.StoreTokenInRedis(ctx, token, userID, userRole, ttlHours)
 				if storeToken != nil {
 					logrus.Errorf("Failed to store token in Redis: %v", storeToken)
-					return ResponseJson(c, http.StatusInternalServerError, nil, "Failed to store token in Redis")
+					return ResponseProblem(c, apperror.ErrInternal.WithMessage("Failed to store token in Redis"), nil)
 				}
 
 				logrus.Infof("Token stored in Redis: %s", token)
@@ -142,5 +156,5 @@ func (h *ResponseHandler) HandleEventResponse(c echo.Context, generateToken bool
 	}
 
 	logrus.Errorf("Unexpected event received: %s", responseEvent.EventType)
-	return ResponseJson(c, http.StatusInternalServerError, nil, "Unexpected event received")
+	return ResponseProblem(c, apperror.ErrInternal.WithMessage("Unexpected event received"), nil)
 }
@@ -157,3 +157,156 @@ func (rmq *RabbitMQConnection) GetChannel() (*amqp091.Channel, error) {
 	}
 	return rmq.channel, nil
 }
+
+const (
+	// deadLetterExchange receives the messages a batch consumer gave up on, each in the dead letter queue of its service
+	deadLetterExchange = "dead_letter_exchange"
+	// maxBatchAttempts is how often a batch is handed over before its events are retried one by one
+	maxBatchAttempts = 5
+)
+
+// ConsumeBatch listens for specific event types on a queue of its own and hands them to handler in batches of
+// up to size events, or whatever arrived within interval. Messages are acknowledged once handler succeeds. A
+// batch that still fails after maxBatchAttempts, with a growing delay, is handed over one event at a time and
+// the events that fail on their own, like unparsable messages, go to the <serviceName>_dead_letter_queue
+func ConsumeBatch(rmq *RabbitMQConnection, serviceName string, eventNames []string, size int, interval time.Duration, handler func(events []models.Event) error) {
+	conn, _, err := rmq.GetConnection()
+	if err != nil {
+		logrus.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		return
+	}
+	// a channel of its own, the prefetch limit would otherwise also throttle the other consumers
+	ch, err := conn.Channel()
+	if err != nil {
+		logrus.Fatalf("Failed to open a channel: %v", err)
+		return
+	}
+	if err := ch.Qos(size, 0, false); err != nil {
+		logrus.Fatalf("Failed to set the prefetch count: %v", err)
+		return
+	}
+
+	queueName := fmt.Sprintf("%s_queue", serviceName)
+	if err := declareDeadLetterQueue(ch, serviceName, queueName); err != nil {
+		logrus.Fatalf("Failed to declare the dead letter queue of %s: %v", queueName, err)
+		return
+	}
+
+	q, err := ch.QueueDeclare(
+		queueName,
+		true,
+		false,
+		false,
+		false,
+		amqp091.Table{
+			"x-dead-letter-exchange":    deadLetterExchange,
+			"x-dead-letter-routing-key": queueName,
+		},
+	)
+	if err != nil {
+		logrus.Fatalf("Failed to declare a queue: %v", err)
+		return
+	}
+
+	for _, eventName := range eventNames {
+		err = ch.QueueBind(q.Name, eventName, "events_exchange", false, nil)
+		if err != nil {
+			logrus.Fatalf("Failed to bind queue %s to event %s: %v", q.Name, eventName, err)
+			return
+		}
+	}
+
+	msgs, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		logrus.Fatalf("Failed to consume messages: %v", err)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		batch := make([]models.Event, 0, size)
+		tags := make([]uint64, 0, size)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := handleBatch(batch, handler); err == nil {
+				// acknowledges every message of the batch, the unparsable ones are already dead-lettered
+				if err := ch.Ack(tags[len(tags)-1], true); err != nil {
+					logrus.Errorf("Failed to acknowledge messages: %v", err)
+				}
+			} else {
+				logrus.Errorf("[RabbitMQ] Giving up on a batch of %d events, handling them one by one: %v", len(batch), err)
+				for i, event := range batch {
+					if err := handler([]models.Event{event}); err != nil {
+						logrus.Errorf("[RabbitMQ] Dead-lettering %s | CorrelationID: %s | Error: %v", event.EventType, event.CorrelationID, err)
+						err = ch.Nack(tags[i], false, false)
+					} else {
+						err = ch.Ack(tags[i], false)
+					}
+					if err != nil {
+						logrus.Errorf("Failed to acknowledge message: %v", err)
+					}
+				}
+			}
+			batch = batch[:0]
+			tags = tags[:0]
+		}
+
+		for {
+			select {
+			case d, ok := <-msgs:
+				if !ok {
+					// unacknowledged messages are delivered again once the channel is back
+					logrus.Warnf("[RabbitMQ] Batch consumer %s stopped", serviceName)
+					return
+				}
+
+				var event models.Event
+				if err := json.Unmarshal(d.Body, &event); err != nil {
+					logrus.Errorf("Failed to parse event data, dead-lettering it: %v", err)
+					if err := d.Nack(false, false); err != nil {
+						logrus.Errorf("Failed to reject message: %v", err)
+					}
+					continue
+				}
+				batch = append(batch, event)
+				tags = append(tags, d.DeliveryTag)
+				if len(batch) >= size {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// handleBatch hands a batch to handler up to maxBatchAttempts times, waiting longer after each failure
+func handleBatch(batch []models.Event, handler func(events []models.Event) error) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := handler(batch)
+		if err == nil || attempt == maxBatchAttempts {
+			return err
+		}
+		logrus.Errorf("[RabbitMQ] Failed to handle a batch of %d events, retrying in %v: %v", len(batch), delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// declareDeadLetterQueue declares the dead letter exchange and the queue that keeps the messages queueName
+// rejects, to be inspected and moved back by hand
+func declareDeadLetterQueue(ch *amqp091.Channel, serviceName, queueName string) error {
+	if err := ch.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return err
+	}
+	q, err := ch.QueueDeclare(fmt.Sprintf("%s_dead_letter_queue", serviceName), true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(q.Name, queueName, deadLetterExchange, false, nil)
+}
@@ -8,6 +8,11 @@ import (
 	"user-service/core/models"
 )
 
+// Publisher sends events to the message broker, SendingMessage publishes them over RabbitMQ
+type Publisher interface {
+	SendingToMessage(eventType string, correlationID string, payload interface{}) error
+}
+
 type SendingMessage struct {
 	Rmq *messaging.RabbitMQConnection
 }
@@ -20,6 +25,11 @@ func NewSendingMessage(rmq *messaging.RabbitMQConnection) *SendingMessage {
 
 // SendingToMessage is a function to send message to message broker
 func (s *SendingMessage) SendingToMessage(eventType string, correlationID string, payload interface{}) error {
+	return s.SendingToMessageWithMetadata(nil, eventType, correlationID, payload)
+}
+
+// SendingToMessageWithMetadata sends a message that also describes the HTTP request that caused it
+func (s *SendingMessage) SendingToMessageWithMetadata(metadata *models.RequestMetadata, eventType string, correlationID string, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	// Create Event Object
 	event := models.Event{
@@ -27,6 +37,7 @@ func (s *SendingMessage) SendingToMessage(eventType string, correlationID string
 		CorrelationID: correlationID,
 		Timestamp:     time.Now(),
 		Payload:       json.RawMessage(payloadBytes),
+		Metadata:      metadata,
 	}
 
 	// Serialize Event
@@ -13,11 +13,15 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 	"user-service/api"
 	"user-service/core/models"
 	"user-service/core/repository"
 	"user-service/core/service"
 	"user-service/database"
+	"user-service/database/postgres"
+	"user-service/mailer"
+	"user-service/sms"
 )
 
 // App struct for save instance of app
@@ -29,7 +33,20 @@ type App struct {
 }
 
 type Service struct {
-	UserService service.UserService
+	UserService              service.UserService
+	IdentityService          service.IdentityService
+	MfaService               service.MfaService
+	PasskeyService           service.PasskeyService
+	PasswordService          service.PasswordService
+	EmailVerificationService service.EmailVerificationService
+	PhoneService             service.PhoneService
+	MagicLinkService         service.MagicLinkService
+	LockoutService           service.LockoutService
+	RoleService              service.RoleService
+	AdminService             service.AdminService
+	ProfileService           service.ProfileService
+	PrivacyService           service.PrivacyService
+	AuditService             service.AuditService
 }
 
 // Initialize prepare environment and setup app
@@ -56,8 +73,30 @@ func (app *App) Initialize() {
 	app.RMQ = rmq
 
 	// Init Service
+	userRepo, uow := app.newUserRepo(db)
+	tokenRepo := repository.NewUserTokenRepo(db)
+	roleRepo := repository.NewRoleRepo(db)
+	exportRepo := repository.NewDataExportRepo(db)
+	sendMessage := api.NewSendingMessage(rmq)
+	mail := mailer.NewMailerFromEnv()
+	emailVerification := service.NewEmailVerificationService(userRepo, tokenRepo, mail, sendMessage)
+	passwords := service.NewPasswordService(userRepo, tokenRepo, uow, mail, sendMessage)
+	privacy := service.NewPrivacyService(userRepo, tokenRepo, exportRepo, uow, mail, sendMessage)
 	app.Service = &Service{
-		UserService: service.NewUserService(repository.NewUserRepo(db), rmq, api.NewSendingMessage(rmq)),
+		UserService:              service.NewUserService(userRepo, rmq, sendMessage, emailVerification),
+		EmailVerificationService: emailVerification,
+		PhoneService:             service.NewPhoneService(userRepo, sms.NewSMSSenderFromEnv(), sendMessage),
+		IdentityService:          service.NewIdentityService(userRepo, sendMessage),
+		MfaService:               service.NewMfaService(userRepo, sendMessage),
+		PasskeyService:           service.NewPasskeyService(userRepo, sendMessage),
+		PasswordService:          passwords,
+		MagicLinkService:         service.NewMagicLinkService(userRepo, tokenRepo, mail, sendMessage),
+		LockoutService:           service.NewLockoutService(userRepo, tokenRepo, mail, sendMessage),
+		RoleService:              service.NewRoleService(roleRepo, sendMessage),
+		AdminService:             service.NewAdminService(userRepo, roleRepo, passwords, privacy, sendMessage),
+		ProfileService:           service.NewProfileService(userRepo, tokenRepo, uow, mail, sendMessage),
+		PrivacyService:           privacy,
+		AuditService:             service.NewAuditService(userRepo, sendMessage),
 	}
 }
 
@@ -67,7 +106,7 @@ func (app *App) RunConsumer(wg *sync.WaitGroup) {
 
 	eventHandlers := map[string]func(models.Event){
 		"UserRegistered": func(event models.Event) {
-			ctx := context.Background()
+			ctx := service.WithEvent(context.Background(), event)
 
 			var req models.UserRegisteredEvent
 			payloadBytes, _ := json.Marshal(event.Payload)
@@ -81,7 +120,7 @@ func (app *App) RunConsumer(wg *sync.WaitGroup) {
 		},
 
 		"UserLogin": func(event models.Event) {
-			ctx := context.Background()
+			ctx := service.WithEvent(context.Background(), event)
 
 			var req models.UserLoginEvent
 			payloadBytes, _ := json.Marshal(event.Payload)
@@ -94,8 +133,22 @@ func (app *App) RunConsumer(wg *sync.WaitGroup) {
 			app.Service.UserService.HandleUserLogin(ctx, payloadBytes, event.CorrelationID)
 		},
 
+		"UserOAuth": func(event models.Event) {
+			ctx := service.WithEvent(context.Background(), event)
+
+			var req models.UserOAuthEvent
+			payloadBytes, _ := json.Marshal(event.Payload)
+			if err := json.Unmarshal(payloadBytes, &req); err != nil {
+				logrus.Errorf("Failed to parse event payload: %v", err)
+				return
+			}
+
+			logrus.Infof("[user-service] Processing UserOAuth | Provider: %s | Email: %s", req.Provider, req.Email)
+			app.Service.UserService.HandleUserOauth(ctx, payloadBytes, event.CorrelationID)
+		},
+
 		"GetProfile": func(event models.Event) {
-			ctx := context.Background()
+			ctx := service.WithEvent(context.Background(), event)
 
 			var req models.GetUserProfileEvent
 			payloadBytes, _ := json.Marshal(event.Payload)
@@ -107,6 +160,75 @@ func (app *App) RunConsumer(wg *sync.WaitGroup) {
 			logrus.Infof("[user-service] Processing GetProfile | UserID: %s", req.ID)
 			app.Service.UserService.HandleGetProfile(ctx, payloadBytes, event.CorrelationID)
 		},
+
+		// profile, password, email and avatar changes
+		"ProfileUpdate":      forward("ProfileUpdate", app.Service.ProfileService.HandleProfileUpdate),
+		"PasswordChange":     forward("PasswordChange", app.Service.ProfileService.HandlePasswordChange),
+		"EmailChange":        forward("EmailChange", app.Service.ProfileService.HandleEmailChange),
+		"EmailChangeConfirm": forward("EmailChangeConfirm", app.Service.ProfileService.HandleEmailChangeConfirm),
+		"AvatarUpdate":       forward("AvatarUpdate", app.Service.ProfileService.HandleAvatarUpdate),
+
+		// data export and account deletion
+		"DataExport":         forward("DataExport", app.Service.PrivacyService.HandleDataExport),
+		"DataExportDownload": forward("DataExportDownload", app.Service.PrivacyService.HandleDataExportDownload),
+		"AccountDeletion":    forward("AccountDeletion", app.Service.PrivacyService.HandleAccountDeletion),
+		"AccountRestore":     forward("AccountRestore", app.Service.PrivacyService.HandleAccountRestore),
+
+		// linked identities
+		"UserReauthenticate":  forward("UserReauthenticate", app.Service.IdentityService.HandleReauthenticate),
+		"IdentityLink":        forward("IdentityLink", app.Service.IdentityService.HandleIdentityLink),
+		"IdentityLinkConfirm": forward("IdentityLinkConfirm", app.Service.IdentityService.HandleIdentityLinkConfirm),
+		"IdentityUnlink":      forward("IdentityUnlink", app.Service.IdentityService.HandleIdentityUnlink),
+
+		// multi-factor authentication
+		"MfaEnroll":        forward("MfaEnroll", app.Service.MfaService.HandleMfaEnroll),
+		"MfaEnrollConfirm": forward("MfaEnrollConfirm", app.Service.MfaService.HandleMfaEnrollConfirm),
+		"MfaDisable":       forward("MfaDisable", app.Service.MfaService.HandleMfaDisable),
+		"MfaRecoveryCodes": forward("MfaRecoveryCodes", app.Service.MfaService.HandleMfaRecoveryCodes),
+		"UserLoginMfa":     forward("UserLoginMfa", app.Service.MfaService.HandleUserLoginMfa),
+
+		// passkeys
+		"GetPasskeyUser":  forward("GetPasskeyUser", app.Service.PasskeyService.HandleGetPasskeyUser),
+		"PasskeyRegister": forward("PasskeyRegister", app.Service.PasskeyService.HandlePasskeyRegister),
+		"PasskeyLogin":    forward("PasskeyLogin", app.Service.PasskeyService.HandlePasskeyLogin),
+		"PasskeyDelete":   forward("PasskeyDelete", app.Service.PasskeyService.HandlePasskeyDelete),
+
+		// password recovery
+		"PasswordForgot": forward("PasswordForgot", app.Service.PasswordService.HandlePasswordForgot),
+		"PasswordReset":  forward("PasswordReset", app.Service.PasswordService.HandlePasswordReset),
+
+		// magic link login
+		"MagicLinkRequest": forward("MagicLinkRequest", app.Service.MagicLinkService.HandleMagicLinkRequest),
+		"MagicLinkLogin":   forward("MagicLinkLogin", app.Service.MagicLinkService.HandleMagicLinkLogin),
+
+		// login lockout
+		"AccountLocked":      forward("AccountLocked", app.Service.LockoutService.HandleAccountLocked),
+		"AccountUnlock":      forward("AccountUnlock", app.Service.LockoutService.HandleAccountUnlock),
+		"AccountUnlockAdmin": forward("AccountUnlockAdmin", app.Service.LockoutService.HandleAccountUnlockAdmin),
+
+		// roles and permissions
+		"GetRoles": forward("GetRoles", app.Service.RoleService.HandleGetRoles),
+
+		// admin user management
+		"AdminListUsers":          forward("AdminListUsers", app.Service.AdminService.HandleAdminListUsers),
+		"AdminGetUser":            forward("AdminGetUser", app.Service.AdminService.HandleAdminGetUser),
+		"AdminChangeRole":         forward("AdminChangeRole", app.Service.AdminService.HandleAdminChangeRole),
+		"AdminSuspendUser":        forward("AdminSuspendUser", app.Service.AdminService.HandleAdminSuspendUser),
+		"AdminReactivateUser":     forward("AdminReactivateUser", app.Service.AdminService.HandleAdminReactivateUser),
+		"AdminForcePasswordReset": forward("AdminForcePasswordReset", app.Service.AdminService.HandleAdminForcePasswordReset),
+		"AdminDeleteUser":         forward("AdminDeleteUser", app.Service.AdminService.HandleAdminDeleteUser),
+
+		// audit trail
+		"ActivityList":      forward("ActivityList", app.Service.AuditService.HandleActivityList),
+		"AdminActivityList": forward("AdminActivityList", app.Service.AuditService.HandleAdminActivityList),
+
+		// email verification
+		"EmailVerify":             forward("EmailVerify", app.Service.EmailVerificationService.HandleEmailVerify),
+		"EmailVerificationResend": forward("EmailVerificationResend", app.Service.EmailVerificationService.HandleEmailVerificationResend),
+
+		// phone verification
+		"PhoneOtpSend": forward("PhoneOtpSend", app.Service.PhoneService.HandlePhoneOtpSend),
+		"PhoneVerify":  forward("PhoneVerify", app.Service.PhoneService.HandlePhoneVerify),
 	}
 
 	var eventNames []string
@@ -131,6 +253,51 @@ func (app *App) RunConsumer(wg *sync.WaitGroup) {
 	logrus.Warn("[RabbitMQ] Stopping consumers...")
 }
 
+// RunAuditConsumer writes the ActivityRecorded events of every service in batches, on a queue of its own so the
+// audit trail never slows the request handlers down
+func (app *App) RunAuditConsumer() {
+	size, interval := service.AuditBatchSize(), service.AuditFlushInterval()
+	logrus.Infof("[RabbitMQ] Writing activity in batches of %d every %v", size, interval)
+	messaging.ConsumeBatch(app.RMQ, "user-service-audit", []string{service.ActivityRecordedEvent}, size, interval, func(events []models.Event) error {
+		return app.Service.AuditService.HandleActivityBatch(context.Background(), events)
+	})
+}
+
+// forward passes the raw event payload to a service handler
+func forward(eventType string, handler func(ctx context.Context, eventData []byte, correlationID string)) func(models.Event) {
+	return func(event models.Event) {
+		payloadBytes, err := json.Marshal(event.Payload)
+		if err != nil {
+			logrus.Errorf("Failed to parse event payload: %v", err)
+			return
+		}
+
+		logrus.Infof("[user-service] Processing %s | CorrelationID: %s", eventType, event.CorrelationID)
+		handler(service.WithEvent(context.Background(), event), payloadBytes, event.CorrelationID)
+	}
+}
+
+// newUserRepo picks the user store of USER_STORE, mongo (default) or postgres, the other repositories stay on MongoDB.
+// The unit of work spans the stores in use
+func (app *App) newUserRepo(db *mongo.Database) (repository.UserRepo, repository.UnitOfWork) {
+	switch store := os.Getenv("USER_STORE"); store {
+	case "", "mongo":
+		return repository.NewUserRepo(db), repository.NewMongoUnitOfWork(db)
+	case "postgres":
+		pg, err := postgres.Connect()
+		if err != nil {
+			logrus.Fatalf("Error connecting to Postgres: %v", err)
+		}
+		if err := postgres.Migrate(pg); err != nil {
+			logrus.Fatalf("Postgres migration failed: %v", err)
+		}
+		return repository.NewPostgresUserRepo(pg), repository.NewUnitsOfWork(repository.NewMongoUnitOfWork(db), repository.NewPostgresUnitOfWork(pg))
+	default:
+		logrus.Fatalf("Unknown USER_STORE %q, use mongo or postgres", store)
+		return nil, nil
+	}
+}
+
 // Run function to run the app
 func (app *App) Run() {
 	port := os.Getenv("PORT")
@@ -142,6 +309,9 @@ func (app *App) Run() {
 	wg.Add(1)
 	// Run Consumer
 	go app.RunConsumer(&wg)
+	app.RunAuditConsumer()
+	go app.RunDeletionPurger()
+	go app.RunActivityRetention()
 
 	go func() {
 		if err := app.Server.Start(":" + port); err != nil {
@@ -157,6 +327,38 @@ func (app *App) Run() {
 	wg.Wait()
 }
 
+// RunDeletionPurger anonymizes accounts whose deletion grace period ended, once at startup and then every hour
+func (app *App) RunDeletionPurger() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.Service.PrivacyService.PurgeDueAccounts(context.Background())
+		if err != nil {
+			logrus.Errorf("Failed to purge deleted accounts: %v", err)
+		} else if purged > 0 {
+			logrus.Infof("Purged %d deleted accounts", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// RunActivityRetention removes audit entries older than AUDIT_LOG_RETENTION_DAYS, once at startup and then every day
+func (app *App) RunActivityRetention() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.Service.AuditService.PurgeExpiredActivity(context.Background())
+		if err != nil {
+			logrus.Errorf("Failed to purge expired activity: %v", err)
+		} else if purged > 0 {
+			logrus.Infof("Purged %d expired activity entries", purged)
+		}
+		<-ticker.C
+	}
+}
+
 // LoadEnv function to load environment variables
 func (app *App) LoadEnv() {
 	if err := godotenv.Load(); err != nil {
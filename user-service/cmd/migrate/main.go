@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+	"time"
+	"user-service/database"
+	"user-service/database/migrations"
+	"user-service/database/postgres"
+)
+
+const usage = `Usage: migrate [--db mongo|postgres] <command> [arguments]
+
+Commands:
+  up                      apply every pending migration
+  down --to <id>          roll back every migration applied after <id>, --to 0 rolls back everything
+  rollback                roll back the last batch applied by up
+  status                  list the migrations and whether they are applied
+  make:migration <name>   create database/migrations/<timestamp>_<name>.go and register it
+
+--db postgres runs the SQL migrations of database/postgres/migrations, used when USER_STORE=postgres
+`
+
+// migrator runs the migrations of one database
+type migrator struct {
+	up       func(ctx context.Context) ([]string, error)
+	down     func(ctx context.Context, target string) ([]string, error)
+	rollback func(ctx context.Context) ([]string, error)
+	status   func(ctx context.Context) ([]migrations.Status, error)
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	store := flag.String("db", "mongo", "database to migrate, mongo or postgres")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	if command == "make:migration" {
+		if *store != "mongo" {
+			logrus.Fatal("make:migration creates MongoDB migrations, add SQL migrations to database/postgres/migrations")
+		}
+		makeMigration(args)
+		return
+	}
+
+	// the variables can also come from the environment, e.g. in a container
+	_ = godotenv.Load()
+	if os.Getenv("APP_ENV") == "development" {
+		_ = godotenv.Overload(".env.development")
+	}
+
+	m := connect(*store)
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := m.up(ctx)
+		report("Applied", applied, err)
+	case "down":
+		flags := flag.NewFlagSet("down", flag.ExitOnError)
+		target := flags.String("to", "", "ID of the last migration to keep, 0 for none")
+		_ = flags.Parse(args)
+		if *target == "" {
+			logrus.Fatal("down needs --to <id>, use rollback to undo the last batch")
+		}
+		rolledBack, err := m.down(ctx, *target)
+		report("Rolled back", rolledBack, err)
+	case "rollback":
+		rolledBack, err := m.rollback(ctx)
+		report("Rolled back", rolledBack, err)
+	case "status":
+		printStatus(ctx, m)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// connect opens the database to migrate, the connection is closed when the process exits
+func connect(store string) migrator {
+	switch store {
+	case "mongo":
+		db, err := database.Connect()
+		if err != nil {
+			logrus.Fatalf("Error connecting to database: %v", err)
+		}
+		return migrator{
+			up:       func(ctx context.Context) ([]string, error) { return migrations.Up(ctx, db) },
+			down:     func(ctx context.Context, target string) ([]string, error) { return migrations.Down(ctx, db, target) },
+			rollback: func(ctx context.Context) ([]string, error) { return migrations.Rollback(ctx, db) },
+			status:   func(ctx context.Context) ([]migrations.Status, error) { return migrations.StatusOf(ctx, db) },
+		}
+	case "postgres":
+		db, err := postgres.Connect()
+		if err != nil {
+			logrus.Fatalf("Error connecting to Postgres: %v", err)
+		}
+		return migrator{
+			up:       func(ctx context.Context) ([]string, error) { return postgres.Up(ctx, db) },
+			down:     func(ctx context.Context, target string) ([]string, error) { return postgres.Down(ctx, db, target) },
+			rollback: func(ctx context.Context) ([]string, error) { return postgres.Rollback(ctx, db) },
+			status:   func(ctx context.Context) ([]migrations.Status, error) { return postgres.StatusOf(ctx, db) },
+		}
+	default:
+		logrus.Fatalf("Unknown database %q, use mongo or postgres", store)
+		return migrator{}
+	}
+}
+
+func makeMigration(args []string) {
+	flags := flag.NewFlagSet("make:migration", flag.ExitOnError)
+	dir := flags.String("dir", "database/migrations", "directory of the migrations")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		logrus.Fatal("make:migration needs a name, e.g. create_orders_collection")
+	}
+
+	path, err := migrations.Generate(*dir, flags.Arg(0), time.Now())
+	if err != nil {
+		logrus.Fatalf("Failed to create migration: %v", err)
+	}
+	fmt.Printf("Created %s\n", path)
+}
+
+func report(action string, ids []string, err error) {
+	for _, id := range ids {
+		fmt.Printf("%s %s\n", action, id)
+	}
+	if err != nil {
+		logrus.Fatalf("Migration stopped: %v", err)
+	}
+	if len(ids) == 0 {
+		fmt.Println("Nothing to do")
+	}
+}
+
+func printStatus(ctx context.Context, m migrator) {
+	statuses, err := m.status(ctx)
+	if err != nil {
+		logrus.Fatalf("Failed to read migration status: %v", err)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "MIGRATION\tSTATUS\tBATCH\tAPPLIED AT")
+	for _, status := range statuses {
+		state, batch, appliedAt := "pending", "", ""
+		if status.Applied {
+			state, batch, appliedAt = "applied", fmt.Sprint(status.Batch), status.AppliedAt.Local().Format(time.RFC3339)
+		}
+		if status.Missing {
+			state = "applied, file missing"
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", status.ID, state, batch, appliedAt)
+	}
+	out.Flush()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"user-service/database"
+	"user-service/database/seeder"
+)
+
+// fixtureFlags collects every --fixtures value
+type fixtureFlags []string
+
+func (f *fixtureFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fixtureFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var fixtures fixtureFlags
+	set := flag.String("set", "minimal", "seed set: "+strings.Join(seeder.SetNames(), ", "))
+	seed := flag.Uint64("seed", seeder.DefaultSeed, "seed of the fake data, the same seed gives the same users")
+	count := flag.Int("count", 0, "number of generated users, overrides the set")
+	password := flag.String("password", "", "password of accounts without one, SEED_PASSWORD or "+seeder.DefaultPassword+" by default")
+	list := flag.Bool("list", false, "list the seed sets and exit")
+	flag.Var(&fixtures, "fixtures", "YAML or JSON fixture file or directory, can be repeated")
+	flag.Parse()
+
+	if *list {
+		for _, name := range seeder.SetNames() {
+			fmt.Printf("%-10s %s\n", name, seeder.Sets[name].Description)
+		}
+		return
+	}
+
+	// the variables can also come from the environment, e.g. in a container
+	_ = godotenv.Load()
+	if os.Getenv("APP_ENV") == "development" {
+		_ = godotenv.Overload(".env.development")
+	}
+	if *password == "" {
+		*password = os.Getenv("SEED_PASSWORD")
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		logrus.Fatalf("Error connecting to database: %v", err)
+	}
+	defer database.CloseMongoDB()
+
+	err = seeder.Run(context.Background(), db, seeder.Options{
+		Set:      *set,
+		Seed:     *seed,
+		Count:    *count,
+		Password: *password,
+		Fixtures: fixtures,
+	})
+	if err != nil {
+		logrus.Fatalf("Seed failed: %v", err)
+	}
+}
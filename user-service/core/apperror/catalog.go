@@ -0,0 +1,92 @@
+package apperror
+
+import "net/http"
+
+// Request errors
+var (
+	ErrInvalidRequest   = define("invalid_request", http.StatusBadRequest, "Invalid request format")
+	ErrValidation       = define("validation_failed", http.StatusUnprocessableEntity, "The request is invalid")
+	ErrPayloadTooLarge  = define("payload_too_large", http.StatusRequestEntityTooLarge, "The request is too large")
+	ErrUnsupportedMedia = define("unsupported_media_type", http.StatusUnsupportedMediaType, "The content type is not supported")
+	ErrRateLimited      = define("rate_limited", http.StatusTooManyRequests, "Too many requests, please try again later")
+	ErrInvalidToken     = define("invalid_token", http.StatusBadRequest, "Invalid or expired link")
+	ErrNotFound         = define("not_found", http.StatusNotFound, "Not found")
+	ErrConflict         = define("conflict", http.StatusConflict, "The request conflicts with the current state")
+	ErrNotReady         = define("not_ready", http.StatusConflict, "Not ready yet, please try again later")
+)
+
+// Authentication and authorization errors
+var (
+	ErrUnauthorized             = define("unauthorized", http.StatusUnauthorized, "Authentication required")
+	ErrInvalidCredentials       = define("invalid_credentials", http.StatusUnauthorized, "Invalid email or password")
+	ErrReauthenticationRequired = define("reauthentication_required", http.StatusUnauthorized, "Please sign in again to continue")
+	ErrInvalidMFACode           = define("invalid_mfa_code", http.StatusUnauthorized, "Invalid verification code")
+	ErrMFAEnrollmentRequired    = define("mfa_enrollment_required", http.StatusForbidden, "MFA enrollment required")
+	ErrMFAAlreadyEnabled        = define("mfa_already_enabled", http.StatusConflict, "MFA is already enabled")
+	ErrMFANotEnabled            = define("mfa_not_enabled", http.StatusConflict, "MFA is not enabled")
+	ErrInvalidPasskey           = define("invalid_passkey", http.StatusUnauthorized, "Invalid passkey")
+	ErrForbidden                = define("forbidden", http.StatusForbidden, "You don't have permission to do this")
+)
+
+// Account errors
+var (
+	ErrUserNotFound           = define("user_not_found", http.StatusNotFound, "User not found")
+	ErrEmailTaken             = define("email_taken", http.StatusConflict, "Email already registered")
+	ErrPhoneTaken             = define("phone_taken", http.StatusConflict, "Phone number already in use")
+	ErrVersionConflict        = define("version_conflict", http.StatusConflict, "Profile was changed by another request, reload it and try again")
+	ErrAccountSuspended       = define("account_suspended", http.StatusForbidden, "Account suspended")
+	ErrAccountPendingDeletion = define("account_pending_deletion", http.StatusForbidden, "Account is scheduled for deletion, use the link in the confirmation email to restore it")
+	ErrAccountDeleted         = define("account_deleted", http.StatusGone, "Account deleted")
+	ErrAccountLocked          = define("account_locked", http.StatusLocked, "Account temporarily locked")
+	ErrPasswordResetRequired  = define("password_reset_required", http.StatusForbidden, "Password reset required, check your email for the reset link")
+	ErrEmailNotVerified       = define("email_not_verified", http.StatusForbidden, "Please verify your email address to continue")
+	ErrLastLoginMethod        = define("last_login_method", http.StatusConflict, "Cannot remove the only login method of this account")
+)
+
+// Password policy errors, each names the rule a new password breaks
+var (
+	ErrPasswordTooShort         = define("password_too_short", http.StatusUnprocessableEntity, "Password is too short")
+	ErrPasswordTooLong          = define("password_too_long", http.StatusUnprocessableEntity, "Password is too long")
+	ErrPasswordMissingLowercase = define("password_missing_lowercase", http.StatusUnprocessableEntity, "Password needs a lowercase letter")
+	ErrPasswordMissingUppercase = define("password_missing_uppercase", http.StatusUnprocessableEntity, "Password needs an uppercase letter")
+	ErrPasswordMissingDigit     = define("password_missing_digit", http.StatusUnprocessableEntity, "Password needs a digit")
+	ErrPasswordMissingSymbol    = define("password_missing_symbol", http.StatusUnprocessableEntity, "Password needs a symbol")
+	ErrPasswordContainsPersonal = define("password_contains_personal_info", http.StatusUnprocessableEntity, "Password must not contain your email or username")
+	ErrPasswordBreached         = define("password_breached", http.StatusUnprocessableEntity, "Password appears in a known data breach, choose another one")
+)
+
+// Linked identity and passkey errors
+var (
+	ErrProviderAlreadyLinked   = define("provider_already_linked", http.StatusConflict, "Provider already linked to this account")
+	ErrIdentityInUse           = define("identity_in_use", http.StatusConflict, "Identity already linked to another account")
+	ErrIdentityNotLinked       = define("identity_not_linked", http.StatusNotFound, "Provider is not linked to this account")
+	ErrProviderEmailUnverified = define("provider_email_unverified", http.StatusForbidden, "The provider has not verified this email address, verify it there and try again")
+	ErrPasskeyExists           = define("passkey_exists", http.StatusConflict, "Passkey already registered")
+	ErrPasskeyNotFound         = define("passkey_not_found", http.StatusNotFound, "Passkey not found")
+)
+
+// Server errors
+var (
+	ErrInternal    = define("internal_error", http.StatusInternalServerError, "Something went wrong, please try again later")
+	ErrBadGateway  = define("bad_gateway", http.StatusBadGateway, "An upstream service failed")
+	ErrUnavailable = define("service_unavailable", http.StatusServiceUnavailable, "Service unavailable, please try again later")
+	ErrTimeout     = define("timeout", http.StatusGatewayTimeout, "Request timed out waiting for response")
+)
+
+// generic is the error ForStatus returns for each status
+var generic = map[int]*Error{
+	http.StatusBadRequest:            ErrInvalidRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusRequestEntityTooLarge: ErrPayloadTooLarge,
+	http.StatusUnsupportedMediaType:  ErrUnsupportedMedia,
+	http.StatusUnprocessableEntity:   ErrValidation,
+	http.StatusLocked:                ErrAccountLocked,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusInternalServerError:   ErrInternal,
+	http.StatusBadGateway:            ErrBadGateway,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+	http.StatusGatewayTimeout:        ErrTimeout,
+}
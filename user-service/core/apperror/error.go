@@ -0,0 +1,111 @@
+// Package apperror is the catalog of errors user-service replies with. Every error has a stable
+// machine code that clients can rely on, the message is for people and may change. The HTTP status
+// of each code is kept here, next to the code, so the gateway maps every reply the same way.
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error is a catalog error, it is the payload of every *Failed reply event
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields maps invalid request fields to what is wrong with them
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// statuses maps every catalog code to its HTTP status
+var statuses = map[string]int{}
+
+// define adds an error to the catalog
+func define(code string, status int, message string) *Error {
+	statuses[code] = status
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches errors by code, so errors.Is(err, ErrUserNotFound) holds whatever the message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Status is the HTTP status of the error code, 500 for codes the catalog does not know
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// WithMessage returns a copy of the error with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
+
+// WithField returns a copy of the error that also names an invalid field
+func (e *Error) WithField(field, message string) *Error {
+	copied := *e
+	copied.Fields = map[string]string{}
+	for name, value := range e.Fields {
+		copied.Fields[name] = value
+	}
+	copied.Fields[field] = message
+	return &copied
+}
+
+// From returns the catalog error in the chain of err, ErrInternal for errors outside the catalog
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return ErrInternal
+}
+
+// FromPayload decodes the payload of a *Failed reply event. Replies of older services carry the
+// message only, those keep the conflict status they always had
+func FromPayload(payload interface{}) *Error {
+	switch p := payload.(type) {
+	case *Error:
+		return p
+	case string:
+		var appErr Error
+		if json.Unmarshal([]byte(p), &appErr) == nil && appErr.Code != "" {
+			return &appErr
+		}
+		return ErrConflict.WithMessage(p)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ErrInternal
+	}
+	var appErr Error
+	if err := json.Unmarshal(data, &appErr); err != nil || appErr.Code == "" {
+		return ErrInternal
+	}
+	return &appErr
+}
+
+// ForStatus returns the generic catalog error of an HTTP status, for errors the gateway raises itself
+func ForStatus(status int) *Error {
+	if err, ok := generic[status]; ok {
+		return err
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrInternal
+	}
+	return ErrInvalidRequest
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Status of a data export
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is a ZIP archive of everything stored about a user, built in the background
+type DataExport struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Status      string             `json:"status" bson:"status"`
+	FileName    string             `json:"file_name,omitempty" bson:"file_name,omitempty"`
+	File        []byte             `json:"-" bson:"file,omitempty"`
+	Size        int                `json:"size,omitempty" bson:"size,omitempty"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	RequestedAt time.Time          `json:"requested_at" bson:"requested_at"`
+	ReadyAt     *time.Time         `json:"ready_at,omitempty" bson:"ready_at,omitempty"`
+	// ExpiresAt removes the export through a TTL index
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// SessionInfo describes a signed-in session of the user as the gateway knows it, without the token
+type SessionInfo struct {
+	ID        string     `json:"id"`
+	Role      string     `json:"role,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
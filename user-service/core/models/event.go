@@ -10,6 +10,16 @@ type Event struct {
 	CorrelationID string      `json:"correlation_id"`
 	Timestamp     time.Time   `json:"timestamp"`
 	Payload       interface{} `json:"payload"`
+	// Metadata describes the HTTP request behind the event, nil for events no request caused
+	Metadata *RequestMetadata `json:"metadata,omitempty"`
+}
+
+// RequestMetadata is what the gateway knows about a request, the audit trail records it
+type RequestMetadata struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// ActorID is the signed-in user that made the request
+	ActorID string `json:"actor_id,omitempty"`
 }
 
 // UserRegisteredEvent struct is used for user registration event
@@ -25,31 +35,465 @@ type UserRegisteredEvent struct {
 
 // UserLoginEvent UserLoginSuccessEvent User Login Success Event
 type UserLoginEvent struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Role     string `json:"role"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
 }
 
-// UserOAuthEvent User Register via OAuth
+// UserOAuthEvent User Login / Register via an OIDC provider
 type UserOAuthEvent struct {
-	GoogleID string `json:"google_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	Avatar   string `json:"avatar"`
-	Role     string `json:"role"`
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Username      string `json:"username"`
+	Avatar        string `json:"avatar"`
 }
 
-// UserOAuthSuccessEvent User Register OAuth Success
+// UserOAuthSuccessEvent User Login / Register via OAuth Success
 type UserOAuthSuccessEvent struct {
-	Email string `json:"email"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	Avatar        string `json:"avatar,omitempty"`
+	Role          string `json:"role"`
+	Provider      string `json:"provider"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 type GetUserProfileEvent struct {
-	ID      string `json:"id"`
-	Name    string `json:"name" `
-	Email   string `json:"email" `
-	Address string `json:"address"`
-	Phone   string `json:"phone"`
-	Age     int    `json:"age" `
+	ID            string `json:"id"`
+	Name          string `json:"name" `
+	Email         string `json:"email" `
+	Address       string `json:"address"`
+	Phone         string `json:"phone"`
+	PhoneVerified bool   `json:"phone_verified"`
+	Age           int    `json:"age" `
+	Version       int64  `json:"version"`
+
+	Avatar         string            `json:"avatar,omitempty"`
+	AvatarVariants map[string]string `json:"avatar_variants,omitempty"`
+}
+
+// NewUserProfileEvent builds the profile the owner of the account sees
+func NewUserProfileEvent(user *User) GetUserProfileEvent {
+	return GetUserProfileEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Name:          user.Username,
+		Address:       user.Address,
+		Age:           user.Age,
+		Phone:         user.Phone,
+		PhoneVerified: user.PhoneVerified,
+		Version:       user.Version,
+
+		Avatar:         user.Avatar,
+		AvatarVariants: user.AvatarVariants,
+	}
+}
+
+// UserOAuthLinkRequiredEvent is sent when a social login matches the email of an existing account
+type UserOAuthLinkRequiredEvent struct {
+	Email       string `json:"email"`
+	Provider    string `json:"provider"`
+	HasPassword bool   `json:"has_password"`
+}
+
+// UserReauthenticateEvent asks to confirm the identity of an already signed-in user
+type UserReauthenticateEvent struct {
+	ID          string `json:"id"`
+	Password    string `json:"password"`
+	RecentLogin bool   `json:"recent_login"`
+}
+
+// IdentityLinkEvent attaches a verified provider identity to a user,
+// either by user ID (signed in) or by email and password (confirm flow)
+type IdentityLinkEvent struct {
+	UserID        string `json:"user_id,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	ProviderEmail string `json:"provider_email"`
+}
+
+// IdentityUnlinkEvent removes a provider identity from a user
+type IdentityUnlinkEvent struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+}
+
+// LinkedIdentitiesEvent is the reply to a link / unlink with the identities left on the account
+type LinkedIdentitiesEvent struct {
+	ID               string           `json:"id"`
+	Email            string           `json:"email"`
+	Role             string           `json:"role"`
+	LinkedIdentities []LinkedIdentity `json:"linked_identities"`
+	EmailVerified    bool             `json:"email_verified"`
+}
+
+// UserLoginMfaRequiredEvent is sent instead of UserLoginSuccess when the password is correct
+// but the account still has to pass (or enroll) a second factor
+type UserLoginMfaRequiredEvent struct {
+	ID                 string `json:"id"`
+	Email              string `json:"email"`
+	Role               string `json:"role"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+}
+
+// MfaEvent carries a TOTP or recovery code for the given user
+type MfaEvent struct {
+	UserID       string `json:"user_id"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// MfaEnrollmentEvent is the reply to an enrollment with the secret to show as a QR code
+type MfaEnrollmentEvent struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MfaRecoveryCodesEvent returns freshly generated recovery codes, they are only shown once
+type MfaRecoveryCodesEvent struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// UserLoginMfaSuccessEvent finishes a login that needed a second factor
+type UserLoginMfaSuccessEvent struct {
+	ID            string   `json:"id"`
+	Email         string   `json:"email"`
+	Role          string   `json:"role"`
+	Method        string   `json:"method"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+}
+
+// PasskeyUserEvent asks for (or returns) the data needed to run a WebAuthn ceremony for a user
+type PasskeyUserEvent struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Role     string    `json:"role,omitempty"`
+	Passkeys []Passkey `json:"passkeys,omitempty"`
+}
+
+// PasskeyRegisterEvent stores a credential created by a registration ceremony
+type PasskeyRegisterEvent struct {
+	UserID  string  `json:"user_id"`
+	Passkey Passkey `json:"passkey"`
+}
+
+// PasskeyLoginEvent records a successful assertion, as the primary login or as the second factor
+type PasskeyLoginEvent struct {
+	UserID       string `json:"user_id"`
+	CredentialID string `json:"credential_id"`
+	SignCount    uint32 `json:"sign_count"`
+	BackupState  bool   `json:"backup_state"`
+	CloneWarning bool   `json:"clone_warning"`
+	SecondFactor bool   `json:"second_factor"`
+}
+
+// PasskeyDeleteEvent removes a credential from a user
+type PasskeyDeleteEvent struct {
+	UserID       string `json:"user_id"`
+	CredentialID string `json:"credential_id"`
+}
+
+// PasskeyLoginSuccessEvent is the reply to a passkey login, CredentialID tells which passkey was used
+type PasskeyLoginSuccessEvent struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	Method        string `json:"method"`
+	CredentialID  string `json:"credential_id"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// PasswordForgotEvent asks for a password reset email
+type PasswordForgotEvent struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetEvent sets a new password with the token from a reset email
+type PasswordResetEvent struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// EmailVerifyEvent confirms an email address with the token from a verification email
+type EmailVerifyEvent struct {
+	Token string `json:"token"`
+}
+
+// EmailVerificationResendEvent asks for a new verification email for a signed-in user
+type EmailVerificationResendEvent struct {
+	UserID string `json:"user_id"`
+}
+
+// ProfileUpdateEvent changes the fields that are set, Version guards against overwriting a concurrent update
+type ProfileUpdateEvent struct {
+	UserID   string  `json:"user_id"`
+	Version  *int64  `json:"version,omitempty"`
+	Username *string `json:"username,omitempty"`
+	Address  *string `json:"address,omitempty"`
+	Phone    *string `json:"phone,omitempty"`
+	Age      *int    `json:"age,omitempty"`
+}
+
+// AvatarUpdateEvent stores the URLs of an avatar the gateway has resized and uploaded
+type AvatarUpdateEvent struct {
+	UserID   string            `json:"user_id"`
+	Avatar   string            `json:"avatar"`
+	Variants map[string]string `json:"variants"`
+}
+
+// AvatarUpdatedEvent is the profile after an avatar update, ReplacedVariants are the URLs of the avatar it
+// replaced so the gateway can delete those files
+type AvatarUpdatedEvent struct {
+	GetUserProfileEvent
+	ReplacedVariants map[string]string `json:"replaced_variants,omitempty"`
+}
+
+// PasswordChangeEvent replaces the password of a signed-in user who proved the current one
+type PasswordChangeEvent struct {
+	UserID          string `json:"user_id"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// EmailChangeEvent asks to move a signed-in user to another address, it is switched once the new one is verified
+type EmailChangeEvent struct {
+	UserID   string `json:"user_id"`
+	NewEmail string `json:"new_email"`
+}
+
+// EmailChangePendingEvent is the reply to EmailChange, the account keeps Email until PendingEmail is confirmed
+type EmailChangePendingEvent struct {
+	ID           string `json:"id"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pending_email"`
+}
+
+// EmailChangeConfirmEvent switches the email with the token sent to the new address
+type EmailChangeConfirmEvent struct {
+	Token string `json:"token"`
+}
+
+// PhoneOtpSendEvent asks for a one-time code to be delivered to a phone number in E.164 format
+type PhoneOtpSendEvent struct {
+	UserID    string `json:"user_id"`
+	Phone     string `json:"phone"`
+	Code      string `json:"code"`
+	Channel   string `json:"channel"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// PhoneVerifyEvent stores a phone number whose one-time code was confirmed by the gateway
+type PhoneVerifyEvent struct {
+	UserID string `json:"user_id"`
+	Phone  string `json:"phone"`
+}
+
+// PhoneVerifiedEvent is the reply to a phone verification
+type PhoneVerifiedEvent struct {
+	ID            string `json:"id"`
+	Phone         string `json:"phone"`
+	PhoneVerified bool   `json:"phone_verified"`
+}
+
+// MagicLinkRequestEvent asks for a sign-in link for the device identified by DeviceHash
+type MagicLinkRequestEvent struct {
+	Email      string `json:"email"`
+	DeviceHash string `json:"device_hash"`
+}
+
+// MagicLinkLoginEvent signs in with the token from a sign-in link on the device that asked for it
+type MagicLinkLoginEvent struct {
+	Token      string `json:"token"`
+	DeviceHash string `json:"device_hash"`
+}
+
+// AccountLockedEvent is sent by the gateway when failed logins locked an account
+type AccountLockedEvent struct {
+	Email       string    `json:"email"`
+	IP          string    `json:"ip"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// AccountUnlockEvent lifts a lockout with the token from the lockout email
+type AccountUnlockEvent struct {
+	Token string `json:"token"`
+}
+
+// AccountUnlockAdminEvent lifts the lockout of a user on behalf of an admin
+type AccountUnlockAdminEvent struct {
+	UserID  string `json:"user_id"`
+	ActorID string `json:"actor_id"`
+}
+
+// RolePermissionsEvent is the reply to GetRoles, every role with its inherited permissions resolved
+type RolePermissionsEvent struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// AdminUserListEvent asks for one page of users matching the filters
+type AdminUserListEvent struct {
+	Query         string `json:"query"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	EmailVerified *bool  `json:"email_verified"`
+	Page          int    `json:"page"`
+	Limit         int    `json:"limit"`
+}
+
+// AdminUserListResultEvent is one page of users for an admin
+type AdminUserListResultEvent struct {
+	Users []AdminUserView `json:"users"`
+	Total int64           `json:"total"`
+	Page  int             `json:"page"`
+	Limit int             `json:"limit"`
+}
+
+// AdminUserEvent is an admin action on one user, ActorID and ActorRole identify the admin
+type AdminUserEvent struct {
+	UserID    string     `json:"user_id"`
+	ActorID   string     `json:"actor_id"`
+	ActorRole string     `json:"actor_role"`
+	Role      string     `json:"role,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+}
+
+// AdminUserView is what admins see of a user, without any secret
+type AdminUserView struct {
+	ID                    string      `json:"id"`
+	Username              string      `json:"username"`
+	Email                 string      `json:"email"`
+	EmailVerified         bool        `json:"email_verified"`
+	Phone                 string      `json:"phone,omitempty"`
+	PhoneVerified         bool        `json:"phone_verified"`
+	Role                  string      `json:"role"`
+	Status                string      `json:"status"`
+	Suspension            *Suspension `json:"suspension,omitempty"`
+	PasswordResetRequired bool        `json:"password_reset_required"`
+	MFAEnabled            bool        `json:"mfa_enabled"`
+	Passkeys              int         `json:"passkeys"`
+	LinkedProviders       []string    `json:"linked_providers"`
+	CreatedAt             time.Time   `json:"created_at"`
+	UpdatedAt             time.Time   `json:"updated_at"`
+}
+
+// NewAdminUserView builds the admin view of a user
+func NewAdminUserView(user *User) AdminUserView {
+	status := user.Status
+	if status == "" {
+		status = UserStatusActive
+	}
+	providers := make([]string, 0, len(user.LinkedIdentities))
+	for _, identity := range user.LinkedIdentities {
+		providers = append(providers, identity.Provider)
+	}
+
+	return AdminUserView{
+		ID:                    user.ID.Hex(),
+		Username:              user.Username,
+		Email:                 user.Email,
+		EmailVerified:         user.EmailVerified,
+		Phone:                 user.Phone,
+		PhoneVerified:         user.PhoneVerified,
+		Role:                  user.Role,
+		Status:                status,
+		Suspension:            user.Suspension,
+		PasswordResetRequired: user.PasswordResetRequired,
+		MFAEnabled:            user.MFA.TOTPEnabled,
+		Passkeys:              len(user.Passkeys),
+		LinkedProviders:       providers,
+		CreatedAt:             user.CreatedAt,
+		UpdatedAt:             user.UpdatedAt,
+	}
+}
+
+// DataExportEvent asks for an export of the user's data, the gateway adds the sessions it knows of
+type DataExportEvent struct {
+	UserID   string        `json:"user_id"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// DataExportStatusEvent is the reply to DataExport, the archive is built in the background while it is pending
+type DataExportStatusEvent struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Size        int        `json:"size,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ReadyAt     *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// DataExportDownloadEvent asks for the archive of a ready export of the user
+type DataExportDownloadEvent struct {
+	UserID   string `json:"user_id"`
+	ExportID string `json:"export_id"`
+}
+
+// DataExportFileEvent is the reply to DataExportDownload, Content is base64 in JSON
+type DataExportFileEvent struct {
+	FileName string `json:"file_name"`
+	Content  []byte `json:"content"`
+}
+
+// AccountDeletionEvent asks to delete a signed-in user after the grace period
+type AccountDeletionEvent struct {
+	UserID string `json:"user_id"`
+}
+
+// AccountDeletionScheduledEvent is the reply to AccountDeletion
+type AccountDeletionScheduledEvent struct {
+	ID                  string    `json:"id"`
+	Email               string    `json:"email"`
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// AccountRestoreEvent cancels a scheduled deletion with the token from the deletion email
+type AccountRestoreEvent struct {
+	Token string `json:"token"`
+}
+
+// UserDeletedEvent is published once a user is anonymized, every service purges its copies of the user's data
+type UserDeletedEvent struct {
+	UserID    string    `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// NewDataExportStatusEvent builds the status of an export as the user sees it
+func NewDataExportStatusEvent(export *DataExport) DataExportStatusEvent {
+	return DataExportStatusEvent{
+		ID:          export.ID.Hex(),
+		Status:      export.Status,
+		Size:        export.Size,
+		RequestedAt: export.RequestedAt,
+		ReadyAt:     export.ReadyAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+}
+
+// ActivityListEvent asks for one page of the audit trail, empty filters match every entry
+type ActivityListEvent struct {
+	UserID  string     `json:"user_id"`
+	ActorID string     `json:"actor_id,omitempty"`
+	Action  string     `json:"action,omitempty"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	Page    int        `json:"page"`
+	Limit   int        `json:"limit"`
+}
+
+// ActivityListResultEvent is one page of the audit trail, newest first
+type ActivityListResultEvent struct {
+	Activities []ActivityView `json:"activities"`
+	Total      int64          `json:"total"`
+	Page       int            `json:"page"`
+	Limit      int            `json:"limit"`
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// Permissions checked by the gateway, written as <resource>:<action>
+const (
+	PermissionProfileRead  = "profile:read"
+	PermissionProfileWrite = "profile:write"
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionDealsPublish = "deals:publish"
+	PermissionRolesWrite   = "roles:write"
+)
+
+// Role is a named set of permissions, a role also has every permission of the roles it inherits
+type Role struct {
+	Name        string    `json:"name" bson:"_id"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Inherits    []string  `json:"inherits,omitempty" bson:"inherits,omitempty"`
+	Permissions []string  `json:"permissions" bson:"permissions"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// DefaultRoles are created by the roles migration, SUPER_ADMIN ⊃ ADMIN ⊃ USER
+func DefaultRoles() []Role {
+	return []Role{
+		{
+			Name:        RoleUser,
+			Description: "Registered deal hunter",
+			Permissions: []string{PermissionProfileRead, PermissionProfileWrite},
+		},
+		{
+			Name:        RoleAdmin,
+			Description: "Manages users and publishes deals",
+			Inherits:    []string{RoleUser},
+			Permissions: []string{PermissionUsersRead, PermissionUsersWrite, PermissionDealsPublish},
+		},
+		{
+			Name:        RoleSuperAdmin,
+			Description: "Manages admins and role definitions",
+			Inherits:    []string{RoleAdmin},
+			Permissions: []string{PermissionRolesWrite},
+		},
+	}
+}
+
+// ResolvePermissions flattens inheritance into the full sorted permission list of every role,
+// unknown parents are ignored and cycles stop at the first role seen twice
+func ResolvePermissions(roles []Role) map[string][]string {
+	byName := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+
+	resolved := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		granted := map[string]bool{}
+		visited := map[string]bool{}
+		var walk func(name string)
+		walk = func(name string) {
+			current, ok := byName[name]
+			if !ok || visited[name] {
+				return
+			}
+			visited[name] = true
+			for _, permission := range current.Permissions {
+				granted[permission] = true
+			}
+			for _, parent := range current.Inherits {
+				walk(parent)
+			}
+		}
+		walk(role.Name)
+
+		permissions := make([]string, 0, len(granted))
+		for permission := range granted {
+			permissions = append(permissions, permission)
+		}
+		sort.Strings(permissions)
+		resolved[role.Name] = permissions
+	}
+	return resolved
+}
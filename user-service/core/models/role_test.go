@@ -0,0 +1,78 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolvePermissions(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []Role
+		want  map[string][]string
+	}{
+		{
+			name:  "default roles",
+			roles: DefaultRoles(),
+			want: map[string][]string{
+				RoleUser:       {PermissionProfileRead, PermissionProfileWrite},
+				RoleAdmin:      {PermissionDealsPublish, PermissionProfileRead, PermissionProfileWrite, PermissionUsersRead, PermissionUsersWrite},
+				RoleSuperAdmin: {PermissionDealsPublish, PermissionProfileRead, PermissionProfileWrite, PermissionRolesWrite, PermissionUsersRead, PermissionUsersWrite},
+			},
+		},
+		{
+			name: "several parents share a grandparent",
+			roles: []Role{
+				{Name: "base", Permissions: []string{"a:read"}},
+				{Name: "left", Inherits: []string{"base"}, Permissions: []string{"b:read"}},
+				{Name: "right", Inherits: []string{"base"}, Permissions: []string{"c:read", "a:read"}},
+				{Name: "both", Inherits: []string{"left", "right"}},
+			},
+			want: map[string][]string{
+				"base":  {"a:read"},
+				"left":  {"a:read", "b:read"},
+				"right": {"a:read", "c:read"},
+				"both":  {"a:read", "b:read", "c:read"},
+			},
+		},
+		{
+			name: "unknown parent",
+			roles: []Role{
+				{Name: "orphan", Inherits: []string{"missing"}, Permissions: []string{"a:read"}},
+			},
+			want: map[string][]string{"orphan": {"a:read"}},
+		},
+		{
+			name: "cycle",
+			roles: []Role{
+				{Name: "first", Inherits: []string{"second"}, Permissions: []string{"a:read"}},
+				{Name: "second", Inherits: []string{"third"}, Permissions: []string{"b:read"}},
+				{Name: "third", Inherits: []string{"first"}, Permissions: []string{"c:read"}},
+			},
+			want: map[string][]string{
+				"first":  {"a:read", "b:read", "c:read"},
+				"second": {"a:read", "b:read", "c:read"},
+				"third":  {"a:read", "b:read", "c:read"},
+			},
+		},
+		{
+			name: "inherits itself",
+			roles: []Role{
+				{Name: "self", Inherits: []string{"self"}, Permissions: []string{"a:read"}},
+			},
+			want: map[string][]string{"self": {"a:read"}},
+		},
+		{
+			name:  "no permissions",
+			roles: []Role{{Name: "empty"}},
+			want:  map[string][]string{"empty": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolvePermissions(tt.roles); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ResolvePermissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Purposes of single-use user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMagicLink         = "magic_link"
+	TokenPurposeAccountUnlock     = "account_unlock"
+	TokenPurposeEmailChange       = "email_change"
+	TokenPurposeAccountRestore    = "account_restore"
+)
+
+// UserToken is a single-use token sent to a user, only its hash is stored
+type UserToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Purpose   string             `json:"purpose" bson:"purpose"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	// DeviceHash binds the token to the device that asked for it
+	DeviceHash string     `json:"-" bson:"device_hash,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+}
@@ -12,18 +12,93 @@ const (
 	RoleUser       = "USER"
 )
 
+// Account status, an empty status is active
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusPendingDeletion = "pending_deletion"
+	UserStatusDeleted         = "deleted"
+)
+
 // User Model struct
 type User struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Username  string             `json:"username" bson:"username"`
-	Email     string             `json:"email" bson:"email"`
-	Password  string             `json:"password" bson:"password"`
-	Address   string             `json:"address,omitempty" bson:"address,omitempty"`
-	Phone     string             `json:"phone,omitempty" bson:"phone,omitempty"`
-	Age       int                `json:"age,omitempty" bson:"age,omitempty"`
-	GoogleID  string             `json:"google_id,omitempty" bson:"google_id,omitempty"`
-	Avatar    string             `json:"avatar,omitempty" bson:"avatar,omitempty"`
-	Role      string             `json:"role" bson:"role"`
-	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
-	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username        string             `json:"username" bson:"username"`
+	Email           string             `json:"email" bson:"email"`
+	EmailVerified   bool               `json:"email_verified" bson:"email_verified"`
+	EmailVerifiedAt *time.Time         `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
+	Password        string             `json:"password" bson:"password"`
+	Address         string             `json:"address,omitempty" bson:"address,omitempty"`
+	Phone           string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	PhoneVerified   bool               `json:"phone_verified" bson:"phone_verified"`
+	PhoneVerifiedAt *time.Time         `json:"phone_verified_at,omitempty" bson:"phone_verified_at,omitempty"`
+	Age             int                `json:"age,omitempty" bson:"age,omitempty"`
+	Avatar          string             `json:"avatar,omitempty" bson:"avatar,omitempty"`
+	// AvatarVariants maps a square size in pixels to the URL of an uploaded avatar resized to it
+	AvatarVariants map[string]string `json:"avatar_variants,omitempty" bson:"avatar_variants,omitempty"`
+	Role           string            `json:"role" bson:"role"`
+	CreatedAt      time.Time         `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt      time.Time         `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+
+	LinkedIdentities []LinkedIdentity `json:"linked_identities,omitempty" bson:"linked_identities,omitempty"`
+	MFA              MFASettings      `json:"mfa" bson:"mfa,omitempty"`
+	Passkeys         []Passkey        `json:"passkeys,omitempty" bson:"passkeys,omitempty"`
+
+	Status                string      `json:"status,omitempty" bson:"status,omitempty"`
+	Suspension            *Suspension `json:"suspension,omitempty" bson:"suspension,omitempty"`
+	PasswordResetRequired bool        `json:"password_reset_required,omitempty" bson:"password_reset_required,omitempty"`
+	// DeletionScheduledAt is when a pending deletion anonymizes the account, DeletedAt when it happened
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+	DeletedAt           *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// Version is incremented by every profile update so concurrent edits are detected
+	Version int64 `json:"version" bson:"version"`
+}
+
+// Suspension records why and until when an admin suspended an account, a nil Until never expires
+type Suspension struct {
+	Reason      string     `json:"reason" bson:"reason"`
+	Until       *time.Time `json:"until,omitempty" bson:"until,omitempty"`
+	ActorID     string     `json:"actor_id" bson:"actor_id"`
+	SuspendedAt time.Time  `json:"suspended_at" bson:"suspended_at"`
+}
+
+// Passkey is a WebAuthn credential registered by a user, ID is the base64url credential ID
+type Passkey struct {
+	ID              string     `json:"id" bson:"id"`
+	Name            string     `json:"name" bson:"name"`
+	PublicKey       []byte     `json:"public_key" bson:"public_key"`
+	AttestationType string     `json:"attestation_type,omitempty" bson:"attestation_type,omitempty"`
+	Transports      []string   `json:"transports,omitempty" bson:"transports,omitempty"`
+	AAGUID          []byte     `json:"aaguid,omitempty" bson:"aaguid,omitempty"`
+	SignCount       uint32     `json:"sign_count" bson:"sign_count"`
+	BackupEligible  bool       `json:"backup_eligible" bson:"backup_eligible"`
+	BackupState     bool       `json:"backup_state" bson:"backup_state"`
+	CloneWarning    bool       `json:"clone_warning,omitempty" bson:"clone_warning,omitempty"`
+	CreatedAt       time.Time  `json:"created_at" bson:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+// MFASettings holds the TOTP second factor of a user, the secret is kept until enrollment is confirmed
+type MFASettings struct {
+	TOTPEnabled   bool       `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret    string     `json:"-" bson:"totp_secret,omitempty"`
+	TOTPLastStep  int64      `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes []string   `json:"-" bson:"recovery_codes,omitempty"`
+	EnrolledAt    *time.Time `json:"enrolled_at,omitempty" bson:"enrolled_at,omitempty"`
+}
+
+// LinkedIdentity is an account at an external OIDC provider that can be used to sign in
+type LinkedIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	Email    string    `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+	// Key is IdentityKey of Provider and Subject, the one field the unique index can cover in an array
+	Key string `json:"-" bson:"key,omitempty"`
+}
+
+// IdentityKey joins provider and subject into the key that identifies a provider account
+func IdentityKey(provider, subject string) string {
+	return provider + ":" + subject
 }
@@ -2,12 +2,89 @@ package models
 
 import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
-// UserActivityLog struct is used for user activity
+// Actions of the audit trail, written as <resource>.<verb> so they can be filtered on
+const (
+	ActionRegister             = "user.register"
+	ActionLogin                = "user.login"
+	ActionProfileView          = "profile.view"
+	ActionProfileUpdate        = "profile.update"
+	ActionAvatarUpdate         = "profile.avatar_update"
+	ActionPasswordChange       = "password.change"
+	ActionPasswordResetRequest = "password.reset_request"
+	ActionPasswordReset        = "password.reset"
+	ActionEmailChangeRequest   = "email.change_request"
+	ActionEmailChange          = "email.change"
+	ActionEmailVerify          = "email.verify"
+	ActionPhoneVerify          = "phone.verify"
+	ActionMagicLinkRequest     = "magic_link.request"
+	ActionIdentityLink         = "identity.link"
+	ActionIdentityUnlink       = "identity.unlink"
+	ActionMFAEnable            = "mfa.enable"
+	ActionMFADisable           = "mfa.disable"
+	ActionMFARecoveryCodes     = "mfa.recovery_codes"
+	ActionPasskeyRegister      = "passkey.register"
+	ActionPasskeyRemove        = "passkey.remove"
+	ActionAccountLock          = "account.lock"
+	ActionAccountUnlock        = "account.unlock"
+	ActionDataExportRequest    = "data_export.request"
+	ActionDataExportDownload   = "data_export.download"
+	ActionDeletionRequest      = "account.deletion_request"
+	ActionAccountRestore       = "account.restore"
+	ActionRoleChange           = "admin.role_change"
+	ActionSuspend              = "admin.suspend"
+	ActionReactivate           = "admin.reactivate"
+	ActionForcePasswordReset   = "admin.force_password_reset"
+	ActionAdminDelete          = "admin.delete"
+)
+
+// UserActivityLog is one entry of the audit trail, the actor did the action on the user.
+// It is also the payload of the ActivityRecorded event the audit consumer writes in batches
 type UserActivityLog struct {
-	ID                primitive.ObjectID `bson:"_id,omitempty"`
-	UserID            primitive.ObjectID `bson:"user_id"`
-	ActivityType      string             `bson:"activity_type"`
-	ActivityTimestamp primitive.DateTime `bson:"activity_timestamp"`
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	// UserID is the account the entry is about
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+	// ActorID is who acted, the user itself or an admin, zero when the service acted on its own
+	ActorID primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id"`
+	Action  string             `bson:"action" json:"action"`
+	// IP, UserAgent and CorrelationID come from the request the gateway published
+	IP            string            `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent     string            `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CorrelationID string            `bson:"correlation_id,omitempty" json:"correlation_id,omitempty"`
+	Metadata      map[string]string `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	// ActivityTimestamp is stored as a BSON date, the TTL index expires entries from it
+	ActivityTimestamp time.Time `bson:"activity_timestamp" json:"activity_timestamp"`
+}
+
+// ActivityView is an audit entry as users and admins see it
+type ActivityView struct {
+	ID            string            `json:"id"`
+	UserID        string            `json:"user_id"`
+	ActorID       string            `json:"actor_id,omitempty"`
+	Action        string            `json:"action"`
+	IP            string            `json:"ip,omitempty"`
+	UserAgent     string            `json:"user_agent,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	At            time.Time         `json:"at"`
+}
+
+// NewActivityView builds the view of an audit entry
+func NewActivityView(log *UserActivityLog) ActivityView {
+	view := ActivityView{
+		ID:            log.ID.Hex(),
+		UserID:        log.UserID.Hex(),
+		Action:        log.Action,
+		IP:            log.IP,
+		UserAgent:     log.UserAgent,
+		CorrelationID: log.CorrelationID,
+		Metadata:      log.Metadata,
+		At:            log.ActivityTimestamp.UTC(),
+	}
+	if !log.ActorID.IsZero() {
+		view.ActorID = log.ActorID.Hex()
+	}
+	return view
 }
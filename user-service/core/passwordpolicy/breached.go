@@ -0,0 +1,95 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed breached.txt
+var defaultBreached []byte
+
+// rangePrefixLength is the number of SHA-1 hex characters a range lookup is keyed by, as in Pwned Passwords
+const rangePrefixLength = 5
+
+// maxBreachedHashes caps the hashes a corpus file may hold. The whole corpus is kept in memory, a million
+// hashes take about 80 MB, so the full Pwned Passwords download of close to a billion does not fit: trim it
+// to the most common hashes first, e.g. sort -t: -k2 -rn pwned.txt | head -n 1000000
+const maxBreachedHashes = 1_000_000
+
+// BreachedCorpus answers k-anonymity range lookups: given the first five hex characters of the SHA-1 of a
+// password it returns the remaining 35 characters of every breached hash with that prefix, so the password
+// itself never has to leave the caller
+type BreachedCorpus interface {
+	Range(prefix string) []string
+}
+
+// IsBreached reports whether password is in corpus, comparing the hash suffix on the caller's side
+func IsBreached(corpus BreachedCorpus, password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, suffix := range corpus.Range(hash[:rangePrefixLength]) {
+		if suffix == hash[rangePrefixLength:] {
+			return true
+		}
+	}
+	return false
+}
+
+// fileCorpus keeps the hash suffixes of a corpus file by prefix
+type fileCorpus map[string][]string
+
+func (c fileCorpus) Range(prefix string) []string {
+	return c[strings.ToUpper(prefix)]
+}
+
+// ReadBreachedCorpus reads one SHA-1 hex hash per line, optionally followed by :COUNT as in the Pwned
+// Passwords download. Blank lines and lines starting with # are skipped. It reads the whole corpus into memory
+// and fails once it holds more than maxBreachedHashes hashes
+func ReadBreachedCorpus(r io.Reader) (BreachedCorpus, error) {
+	return readBreachedCorpus(r, maxBreachedHashes)
+}
+
+func readBreachedCorpus(r io.Reader, limit int) (BreachedCorpus, error) {
+	corpus := fileCorpus{}
+	scanner := bufio.NewScanner(r)
+	hashes := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hex hash", line)
+		}
+		if hashes++; hashes > limit {
+			return nil, fmt.Errorf("line %d: more than %d hashes, trim the corpus to the most common ones", line, limit)
+		}
+		corpus[hash[:rangePrefixLength]] = append(corpus[hash[:rangePrefixLength]], hash[rangePrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
+
+// LoadBreachedCorpus reads the corpus file at path, the corpus built into the service when path is empty
+func LoadBreachedCorpus(path string) (BreachedCorpus, error) {
+	if path == "" {
+		return ReadBreachedCorpus(bytes.NewReader(defaultBreached))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadBreachedCorpus(file)
+}
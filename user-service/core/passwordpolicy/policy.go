@@ -0,0 +1,167 @@
+// Package passwordpolicy checks new passwords against the rules of the deployment: length, character
+// classes, personal information and a corpus of breached passwords. Every broken rule has its own
+// catalog error so clients can tell the user exactly what to change.
+package passwordpolicy
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+	"user-service/core/apperror"
+)
+
+// maxPasswordLength bounds PASSWORD_MAX_LENGTH, the gateway refuses longer passwords before they get here
+const maxPasswordLength = 1024
+
+// minPersonalInfoLength keeps short usernames like "al" from ruling out most passwords
+const minPersonalInfoLength = 3
+
+// Policy is the set of rules a new password has to follow, lengths are counted in characters
+type Policy struct {
+	MinLength          int
+	MaxLength          int
+	RequireLowercase   bool
+	RequireUppercase   bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectPersonalInfo bool
+	// Breached is the corpus passwords are screened against, nil skips the screening
+	Breached BreachedCorpus
+}
+
+// DefaultPolicy follows NIST SP 800-63B: a minimum length and breach screening rather than composition rules
+var DefaultPolicy = Policy{
+	MinLength:          8,
+	MaxLength:          128,
+	RejectPersonalInfo: true,
+}
+
+// FromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH, PASSWORD_REQUIRE_LOWERCASE, PASSWORD_REQUIRE_UPPERCASE,
+// PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL and PASSWORD_REJECT_PERSONAL_INFO, the defaults fill in what is
+// unset. PASSWORD_BREACHED_CHECK=false turns off breach screening, PASSWORD_BREACHED_FILE replaces the built-in
+// corpus with a larger one of at most maxBreachedHashes hashes
+func FromEnv() Policy {
+	policy := DefaultPolicy
+	if length, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && length >= 1 {
+		policy.MinLength = length
+	}
+	if length, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil && length >= 1 {
+		policy.MaxLength = length
+	}
+	if policy.MaxLength > maxPasswordLength {
+		policy.MaxLength = maxPasswordLength
+	}
+	if policy.MinLength > policy.MaxLength {
+		policy.MinLength = policy.MaxLength
+	}
+	policy.RequireLowercase = envBool("PASSWORD_REQUIRE_LOWERCASE", policy.RequireLowercase)
+	policy.RequireUppercase = envBool("PASSWORD_REQUIRE_UPPERCASE", policy.RequireUppercase)
+	policy.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", policy.RequireDigit)
+	policy.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL", policy.RequireSymbol)
+	policy.RejectPersonalInfo = envBool("PASSWORD_REJECT_PERSONAL_INFO", policy.RejectPersonalInfo)
+
+	if envBool("PASSWORD_BREACHED_CHECK", true) {
+		path := os.Getenv("PASSWORD_BREACHED_FILE")
+		corpus, err := LoadBreachedCorpus(path)
+		if err != nil && path != "" {
+			// a missing corpus file must not leave screening off, the built-in corpus still catches the worst
+			logrus.Errorf("Failed to load breached password corpus %s, using the built-in one: %v", path, err)
+			corpus, err = LoadBreachedCorpus("")
+		}
+		if err != nil {
+			logrus.Errorf("Failed to load the built-in breached password corpus: %v", err)
+		}
+		policy.Breached = corpus
+	}
+	return policy
+}
+
+// envBool parses a boolean environment variable, fallback when it is unset or not a boolean
+func envBool(name string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Check returns the catalog error of the first rule password breaks, with field naming the request field it
+// came from, or nil when password follows the policy. personal is the email, username and the like of the
+// account, none of which may appear in the password
+func (p Policy) Check(field, password string, personal ...string) *apperror.Error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return apperror.ErrPasswordTooShort.WithField(field, fmt.Sprintf("Must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return apperror.ErrPasswordTooLong.WithField(field, fmt.Sprintf("Must be at most %d characters", p.MaxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireLowercase && !lower:
+		return apperror.ErrPasswordMissingLowercase.WithField(field, "Must contain a lowercase letter")
+	case p.RequireUppercase && !upper:
+		return apperror.ErrPasswordMissingUppercase.WithField(field, "Must contain an uppercase letter")
+	case p.RequireDigit && !digit:
+		return apperror.ErrPasswordMissingDigit.WithField(field, "Must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return apperror.ErrPasswordMissingSymbol.WithField(field, "Must contain a symbol")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personal) {
+		return apperror.ErrPasswordContainsPersonal.WithField(field, "Must not contain your email or username")
+	}
+	if p.Breached != nil && IsBreached(p.Breached, password) {
+		return apperror.ErrPasswordBreached.WithField(field, "Appears in a known data breach")
+	}
+	return nil
+}
+
+// containsPersonalInfo compares case-insensitively, an email is also matched by its local part
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if local, _, found := strings.Cut(value, "@"); found {
+			candidates = append(candidates, local)
+		}
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minPersonalInfoLength && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultPolicy Policy
+)
+
+// Default is the policy of the environment, the corpus file is read once
+func Default() Policy {
+	defaultOnce.Do(func() {
+		defaultPolicy = FromEnv()
+	})
+	return defaultPolicy
+}
@@ -0,0 +1,132 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"user-service/core/apperror"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	breached := corpusOf(t, "Breached-password-1")
+	strict := Policy{
+		MinLength:          8,
+		MaxLength:          16,
+		RequireLowercase:   true,
+		RequireUppercase:   true,
+		RequireDigit:       true,
+		RequireSymbol:      true,
+		RejectPersonalInfo: true,
+		Breached:           breached,
+	}
+	personal := []string{"Jane.Doe@example.com", "janedoe", "al"}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		want     *apperror.Error
+	}{
+		{"valid", strict, "Tr0ub4dor&3x", nil},
+		{"too short", strict, "Aa1!aaa", apperror.ErrPasswordTooShort},
+		{"length in characters", strict, "Äöü1!ßçé", nil},
+		{"too long", strict, "Aa1!aaaaaaaaaaaaa", apperror.ErrPasswordTooLong},
+		{"no maximum", Policy{MinLength: 8}, strings.Repeat("a", 2000), nil},
+		{"missing lowercase", strict, "TR0UB4DOR&3X", apperror.ErrPasswordMissingLowercase},
+		{"missing uppercase", strict, "tr0ub4dor&3x", apperror.ErrPasswordMissingUppercase},
+		{"missing digit", strict, "Troubador&xx", apperror.ErrPasswordMissingDigit},
+		{"missing symbol", strict, "Tr0ub4dor3xx", apperror.ErrPasswordMissingSymbol},
+		{"space is a symbol", strict, "Tr0ub4dor 3x", nil},
+		{"classes not required", DefaultPolicy, "troubadorxx", nil},
+		{"email", Policy{MinLength: 8, RejectPersonalInfo: true}, "1jane.doe@EXAMPLE.com", apperror.ErrPasswordContainsPersonal},
+		{"email local part", strict, "X1!JANE.DOEyz", apperror.ErrPasswordContainsPersonal},
+		{"username", strict, "Xy1!janedoe", apperror.ErrPasswordContainsPersonal},
+		{"short username ignored", strict, "Xy1!always", nil},
+		{"personal info allowed", Policy{MinLength: 8}, "janedoe123", nil},
+		{"breached exact", Policy{MinLength: 8, Breached: breached}, "Breached-password-1", apperror.ErrPasswordBreached},
+		{"breached is case sensitive", Policy{MinLength: 8, Breached: breached}, "breached-password-1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Check("new_password", tt.password, personal...)
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("Check(%q) = %s, want nil", tt.password, got.Code)
+				}
+				return
+			}
+			if got == nil || !errors.Is(got, tt.want) {
+				t.Fatalf("Check(%q) = %v, want %s", tt.password, got, tt.want.Code)
+			}
+			if got.Fields["new_password"] == "" {
+				t.Fatalf("Check(%q) = %+v, want the rule named on the new_password field", tt.password, got)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max string
+		wantMin  int
+		wantMax  int
+	}{
+		{"defaults", "", "", 8, 128},
+		{"set", "12", "64", 12, 64},
+		{"maximum capped", "", "5000", 8, maxPasswordLength},
+		{"minimum above maximum", "40", "20", 20, 20},
+		{"invalid", "zero", "-1", 8, 128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_MIN_LENGTH", tt.min)
+			t.Setenv("PASSWORD_MAX_LENGTH", tt.max)
+			t.Setenv("PASSWORD_BREACHED_CHECK", "false")
+			policy := FromEnv()
+			if policy.MinLength != tt.wantMin || policy.MaxLength != tt.wantMax || policy.Breached != nil {
+				t.Fatalf("FromEnv() = %d..%d, corpus %v, want %d..%d without corpus", policy.MinLength, policy.MaxLength,
+					policy.Breached, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestReadBreachedCorpus(t *testing.T) {
+	if _, err := ReadBreachedCorpus(strings.NewReader("# comment\n\nnot a hash\n")); err == nil {
+		t.Fatal("ReadBreachedCorpus of an invalid line succeeded, want an error")
+	}
+
+	three := "# comment\n" + strings.Repeat(strings.Repeat("a", 40)+":1\n", 3)
+	if _, err := readBreachedCorpus(strings.NewReader(three), 3); err != nil {
+		t.Fatalf("readBreachedCorpus of as many hashes as the limit: %v", err)
+	}
+	if _, err := readBreachedCorpus(strings.NewReader(three), 2); err == nil {
+		t.Fatal("readBreachedCorpus of more hashes than the limit succeeded, want an error")
+	}
+
+	corpus, err := LoadBreachedCorpus("")
+	if err != nil {
+		t.Fatalf("LoadBreachedCorpus of the built-in corpus: %v", err)
+	}
+	if !IsBreached(corpus, "password") {
+		t.Fatal(`the built-in corpus misses "password"`)
+	}
+}
+
+// corpusOf builds a corpus of passwords in the Pwned Passwords format, lowercase and with counts
+func corpusOf(t *testing.T, passwords ...string) BreachedCorpus {
+	t.Helper()
+	var lines []string
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, hex.EncodeToString(sum[:])+":42")
+	}
+	corpus, err := ReadBreachedCorpus(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return corpus
+}
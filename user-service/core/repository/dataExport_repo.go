@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"user-service/core/models"
+)
+
+type DataExportRepo interface {
+	CreateExport(ctx context.Context, export *models.DataExport) error
+	FindLatestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error)
+	FindExport(ctx context.Context, userID, exportID primitive.ObjectID) (*models.DataExport, error)
+	CompleteExport(ctx context.Context, exportID primitive.ObjectID, fileName string, file []byte) error
+	FailExport(ctx context.Context, exportID primitive.ObjectID, reason string) error
+	DeleteUserExports(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type dataExportRepo struct {
+	db *mongo.Database
+}
+
+// CreateExport stores a new export
+func (r *dataExportRepo) CreateExport(ctx context.Context, export *models.DataExport) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if export.ID.IsZero() {
+		export.ID = primitive.NewObjectID()
+	}
+	_, err := r.db.Collection("dataExports").InsertOne(ctx, export)
+	return err
+}
+
+// FindLatestExport returns the newest export of the user without its file, nil when there is none
+func (r *dataExportRepo) FindLatestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "requested_at", Value: -1}}).
+		SetProjection(bson.M{"file": 0})
+
+	var export models.DataExport
+	err := r.db.Collection("dataExports").FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindExport returns an export of the user with its file, ErrNotFound when it belongs to someone else
+func (r *dataExportRepo) FindExport(ctx context.Context, userID, exportID primitive.ObjectID) (*models.DataExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var export models.DataExport
+	err := r.db.Collection("dataExports").FindOne(ctx, bson.M{"_id": exportID, "user_id": userID}).Decode(&export)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// CompleteExport stores the archive of a pending export and marks it ready
+func (r *dataExportRepo) CompleteExport(ctx context.Context, exportID primitive.ObjectID, fileName string, file []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"status":    models.DataExportReady,
+		"file_name": fileName,
+		"file":      file,
+		"size":      len(file),
+		"ready_at":  time.Now(),
+	}}
+	result, err := r.db.Collection("dataExports").UpdateOne(ctx, bson.M{"_id": exportID, "status": models.DataExportPending}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FailExport marks a pending export as failed
+func (r *dataExportRepo) FailExport(ctx context.Context, exportID primitive.ObjectID, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"status": models.DataExportFailed, "error": reason}}
+	_, err := r.db.Collection("dataExports").UpdateOne(ctx, bson.M{"_id": exportID, "status": models.DataExportPending}, update)
+	return err
+}
+
+// DeleteUserExports removes every export of the user
+func (r *dataExportRepo) DeleteUserExports(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.Collection("dataExports").DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func NewDataExportRepo(db *mongo.Database) DataExportRepo {
+	return &dataExportRepo{db: db}
+}
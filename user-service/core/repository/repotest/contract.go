@@ -0,0 +1,795 @@
+// Package repotest is the contract every UserRepo and UserActivityLogRepo implementation has to meet,
+// so services behave the same on MongoDB, Postgres and in memory. Call it from a test of the backend:
+//
+//	func TestMemoryUserRepo(t *testing.T) {
+//		repotest.UserRepo(t, func(t *testing.T) repository.UserRepo { return repository.NewMemoryUserRepo() })
+//	}
+//
+// Backends on a shared database can return the same repository every time, the contract only
+// touches users and logs it created itself.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+// UserRepo runs the UserRepo contract, newRepo is called once per subtest
+func UserRepo(t *testing.T, newRepo func(t *testing.T) repository.UserRepo) {
+	t.Run("NotFound", func(t *testing.T) { userNotFound(t, newRepo(t)) })
+	t.Run("SaveAndFind", func(t *testing.T) { userSaveAndFind(t, newRepo(t)) })
+	t.Run("UniqueEmail", func(t *testing.T) { userUniqueEmail(t, newRepo(t)) })
+	t.Run("Timestamps", func(t *testing.T) { userTimestamps(t, newRepo(t)) })
+	t.Run("UpdateUserVersion", func(t *testing.T) { userUpdateVersion(t, newRepo(t)) })
+	t.Run("LinkedIdentities", func(t *testing.T) { userLinkedIdentities(t, newRepo(t)) })
+	t.Run("Passkeys", func(t *testing.T) { userPasskeys(t, newRepo(t)) })
+	t.Run("MFA", func(t *testing.T) { userMFA(t, newRepo(t)) })
+	t.Run("VerifiedPhone", func(t *testing.T) { userVerifiedPhone(t, newRepo(t)) })
+	t.Run("EmailVerification", func(t *testing.T) { userEmailVerification(t, newRepo(t)) })
+	t.Run("AccountAdministration", func(t *testing.T) { userAccountAdministration(t, newRepo(t)) })
+	t.Run("ListUsers", func(t *testing.T) { userList(t, newRepo(t)) })
+	t.Run("Deletion", func(t *testing.T) { userDeletion(t, newRepo(t)) })
+	t.Run("ActivityLogs", func(t *testing.T) { userActivityLogs(t, newRepo(t)) })
+	t.Run("AuditTrail", func(t *testing.T) { userAuditTrail(t, newRepo(t)) })
+	t.Run("ActivityBatch", func(t *testing.T) { userActivityBatch(t, newRepo(t)) })
+}
+
+// UserActivityLogRepo runs the UserActivityLogRepo contract, newRepo is called once per subtest
+func UserActivityLogRepo(t *testing.T, newRepo func(t *testing.T) repository.UserActivityLogRepo) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		base := time.Now().Truncate(time.Millisecond)
+
+		// saved out of order, read back oldest first
+		for i, action := range []string{models.ActionLogin, models.ActionRegister} {
+			log := &models.UserActivityLog{
+				UserID:            userID,
+				Action:            action,
+				ActivityTimestamp: base.Add(time.Duration(1-i) * time.Second),
+			}
+			result, err := repo.CreateUserActivityLog(ctx, log)
+			mustNot(t, err)
+			if log.ID.IsZero() || result.InsertedID != log.ID {
+				t.Fatalf("CreateUserActivityLog: ID %v, inserted %v, want the assigned ID", log.ID, result.InsertedID)
+			}
+		}
+		_, err := repo.CreateUserActivityLog(ctx, &models.UserActivityLog{UserID: primitive.NewObjectID(), Action: models.ActionLogin})
+		mustNot(t, err)
+
+		logs, err := repo.GetUserActivityLogs(ctx, userID.Hex())
+		mustNot(t, err)
+		if len(logs) != 2 || logs[0].Action != models.ActionRegister || logs[1].Action != models.ActionLogin {
+			t.Fatalf("GetUserActivityLogs: got %s, want register then login", actions(logs))
+		}
+		if logs[0].UserID != userID || logs[0].ActivityTimestamp.UnixMilli() != base.UnixMilli() {
+			t.Fatalf("GetUserActivityLogs: got user %v at %v", logs[0].UserID, logs[0].ActivityTimestamp)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		repo := newRepo(t)
+		logs, err := repo.GetUserActivityLogs(context.Background(), primitive.NewObjectID().Hex())
+		mustNot(t, err)
+		if len(logs) != 0 {
+			t.Fatalf("GetUserActivityLogs of an unknown user: got %d logs, want none", len(logs))
+		}
+		if _, err := repo.GetUserActivityLogs(context.Background(), "not-an-id"); err == nil {
+			t.Fatal("GetUserActivityLogs with an invalid ID: want an error")
+		}
+	})
+}
+
+// userNotFound pins the not-found convention: finders and everything addressed by ID return
+// repository.ErrNotFound, or the error of the missing sub-document
+func userNotFound(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	unknown := primitive.NewObjectID()
+
+	finders := map[string]func() (*models.User, error){
+		"FindUserByEmail":               func() (*models.User, error) { return repo.FindUserByEmail(ctx, unique("missing")+"@contract.test") },
+		"FindUserByID":                  func() (*models.User, error) { return repo.FindUserByID(ctx, unknown.Hex()) },
+		"FindUserByID of an invalid ID": func() (*models.User, error) { return repo.FindUserByID(ctx, "not-an-id") },
+		"FindUserByIdentity":            func() (*models.User, error) { return repo.FindUserByIdentity(ctx, "contract", unique("subject")) },
+		"FindUserByVerifiedPhone":       func() (*models.User, error) { return repo.FindUserByVerifiedPhone(ctx, unique("+phone")) },
+	}
+	for name, find := range finders {
+		user, err := find()
+		if user != nil {
+			t.Fatalf("%s of an unknown user: got %+v, want nil", name, user)
+		}
+		mustBe(t, name+" of an unknown user", err, repository.ErrNotFound)
+	}
+
+	ghost := &models.User{ID: unknown, Email: unique("ghost") + "@contract.test"}
+	updates := map[string]func() error{
+		"UpdateMFA":                func() error { return repo.UpdateMFA(ctx, unknown, models.MFASettings{}) },
+		"UpdatePassword":           func() error { return repo.UpdatePassword(ctx, unknown, "hash") },
+		"MarkEmailVerified":        func() error { return repo.MarkEmailVerified(ctx, unknown, "a@contract.test") },
+		"SetVerifiedPhone":         func() error { return repo.SetVerifiedPhone(ctx, unknown, unique("+phone")) },
+		"UpdateRole":               func() error { return repo.UpdateRole(ctx, unknown, models.RoleAdmin) },
+		"SetSuspension":            func() error { return repo.SetSuspension(ctx, unknown, nil) },
+		"SetPasswordResetRequired": func() error { return repo.SetPasswordResetRequired(ctx, unknown, true) },
+		"DeleteUser":               func() error { return repo.DeleteUser(ctx, unknown) },
+		"UpdateUser":               func() error { return repo.UpdateUser(ctx, ghost) },
+		"ScheduleDeletion":         func() error { return repo.ScheduleDeletion(ctx, unknown, time.Now()) },
+		"CancelDeletion":           func() error { return repo.CancelDeletion(ctx, unknown) },
+		"AnonymizeUser":            func() error { return repo.AnonymizeUser(ctx, unknown) },
+	}
+	for name, update := range updates {
+		mustBe(t, name+" of an unknown user", update(), repository.ErrNotFound)
+	}
+
+	mustBe(t, "RemoveLinkedIdentity of an unknown user", repo.RemoveLinkedIdentity(ctx, unknown, "contract"), repository.ErrIdentityNotLinked)
+	mustBe(t, "UpdatePasskeyUsage of an unknown user", repo.UpdatePasskeyUsage(ctx, unknown, "key", 1, false, false), repository.ErrPasskeyNotFound)
+	mustBe(t, "RemovePasskey of an unknown user", repo.RemovePasskey(ctx, unknown, "key"), repository.ErrPasskeyNotFound)
+
+	if ok, err := repo.MarkTOTPStepUsed(ctx, unknown, 1); ok || err != nil {
+		t.Fatalf("MarkTOTPStepUsed of an unknown user: got %v, %v, want false, nil", ok, err)
+	}
+	if ok, err := repo.ConsumeRecoveryCode(ctx, unknown, "code"); ok || err != nil {
+		t.Fatalf("ConsumeRecoveryCode of an unknown user: got %v, %v, want false, nil", ok, err)
+	}
+}
+
+func userSaveAndFind(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := newUser("save")
+	user.Address = "Dubai Marina"
+	user.Age = 31
+	user.AvatarVariants = map[string]string{"64": "https://cdn.contract.test/64.png"}
+
+	result, err := repo.SaveUser(ctx, user)
+	mustNot(t, err)
+	if user.ID.IsZero() || result.InsertedID != user.ID {
+		t.Fatalf("SaveUser: ID %v, inserted %v, want the assigned ID", user.ID, result.InsertedID)
+	}
+
+	byEmail, err := repo.FindUserByEmail(ctx, user.Email)
+	mustNot(t, err)
+	byID, err := repo.FindUserByID(ctx, user.ID.Hex())
+	mustNot(t, err)
+	for name, found := range map[string]*models.User{"FindUserByEmail": byEmail, "FindUserByID": byID} {
+		if found == nil || found.ID != user.ID || found.Email != user.Email || found.Username != user.Username ||
+			found.Role != user.Role || found.Address != user.Address || found.Age != user.Age || found.Password != user.Password {
+			t.Fatalf("%s: got %+v, want the saved user", name, found)
+		}
+		if found.AvatarVariants["64"] != user.AvatarVariants["64"] {
+			t.Fatalf("%s: got avatar variants %v, want %v", name, found.AvatarVariants, user.AvatarVariants)
+		}
+		sameTime(t, name+" created_at", found.CreatedAt, user.CreatedAt)
+	}
+
+	// changing a returned user does not change the stored one
+	byID.Username = "changed"
+	again, err := repo.FindUserByID(ctx, user.ID.Hex())
+	mustNot(t, err)
+	if again.Username != user.Username {
+		t.Fatalf("FindUserByID: got username %q after changing a copy, want %q", again.Username, user.Username)
+	}
+}
+
+func userUniqueEmail(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	first := newUser("unique")
+	_, err := repo.SaveUser(ctx, first)
+	mustNot(t, err)
+
+	second := newUser("unique")
+	second.Email = first.Email
+	_, err = repo.SaveUser(ctx, second)
+	mustBe(t, "SaveUser with a taken email", err, repository.ErrEmailInUse)
+
+	other := save(t, repo, newUser("unique"))
+	other.Email = first.Email
+	mustBe(t, "UpdateUser to a taken email", repo.UpdateUser(ctx, other), repository.ErrEmailInUse)
+}
+
+func userTimestamps(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("timestamps"))
+
+	before := time.Now().Add(-time.Millisecond)
+	mustNot(t, repo.UpdateRole(ctx, user.ID, models.RoleAdmin))
+	found := find(t, repo, user.ID)
+	sameTime(t, "created_at after an update", found.CreatedAt, user.CreatedAt)
+	if found.UpdatedAt.Before(before) {
+		t.Fatalf("UpdateRole: updated_at %v, want at least %v", found.UpdatedAt, before)
+	}
+
+	before = time.Now().Add(-time.Millisecond)
+	found.Username = "renamed"
+	mustNot(t, repo.UpdateUser(ctx, found))
+	if found.UpdatedAt.Before(before) {
+		t.Fatalf("UpdateUser: updated_at of the argument %v, want at least %v", found.UpdatedAt, before)
+	}
+	stored := find(t, repo, user.ID)
+	sameTime(t, "UpdateUser updated_at", stored.UpdatedAt, found.UpdatedAt)
+	sameTime(t, "created_at after UpdateUser", stored.CreatedAt, user.CreatedAt)
+}
+
+func userUpdateVersion(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("version"))
+
+	first := find(t, repo, user.ID)
+	second := find(t, repo, user.ID)
+
+	first.Username = "first"
+	first.AvatarVariants = map[string]string{"128": "https://cdn.contract.test/128.png"}
+	mustNot(t, repo.UpdateUser(ctx, first))
+	if first.Version != user.Version+1 {
+		t.Fatalf("UpdateUser: version %d, want %d", first.Version, user.Version+1)
+	}
+
+	second.Username = "second"
+	mustBe(t, "UpdateUser of a stale copy", repo.UpdateUser(ctx, second), repository.ErrVersionConflict)
+
+	stored := find(t, repo, user.ID)
+	if stored.Username != "first" || stored.Version != first.Version || stored.AvatarVariants["128"] == "" {
+		t.Fatalf("after a conflict: got %q version %d, want the first update", stored.Username, stored.Version)
+	}
+
+	// a verification between read and update must not be reverted by the stale copy
+	third := find(t, repo, user.ID)
+	mustNot(t, repo.MarkEmailVerified(ctx, user.ID, user.Email))
+	mustNot(t, repo.SetVerifiedPhone(ctx, user.ID, unique("+9715")))
+	third.Username = "third"
+	mustBe(t, "UpdateUser after a verification", repo.UpdateUser(ctx, third), repository.ErrVersionConflict)
+	if stored := find(t, repo, user.ID); !stored.EmailVerified || !stored.PhoneVerified || stored.Version != first.Version+2 {
+		t.Fatalf("after verifications: email %v phone %v version %d, want both verified and version %d",
+			stored.EmailVerified, stored.PhoneVerified, stored.Version, first.Version+2)
+	}
+}
+
+func userLinkedIdentities(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("identity"))
+	other := save(t, repo, newUser("identity"))
+	identity := models.LinkedIdentity{Provider: "contract", Subject: unique("subject"), Email: user.Email, LinkedAt: now()}
+
+	mustNot(t, repo.AddLinkedIdentity(ctx, user.ID, identity))
+	found, err := repo.FindUserByIdentity(ctx, identity.Provider, identity.Subject)
+	mustNot(t, err)
+	if found == nil || found.ID != user.ID || len(found.LinkedIdentities) != 1 || found.LinkedIdentities[0].Subject != identity.Subject {
+		t.Fatalf("FindUserByIdentity: got %+v, want the linked user", found)
+	}
+	sameTime(t, "linked_at", found.LinkedIdentities[0].LinkedAt, identity.LinkedAt)
+
+	second := models.LinkedIdentity{Provider: "contract", Subject: unique("subject"), LinkedAt: now()}
+	mustBe(t, "AddLinkedIdentity of a linked provider", repo.AddLinkedIdentity(ctx, user.ID, second), repository.ErrProviderAlreadyLinked)
+	mustBe(t, "AddLinkedIdentity of another user's identity", repo.AddLinkedIdentity(ctx, other.ID, identity), repository.ErrIdentityInUse)
+
+	mustNot(t, repo.RemoveLinkedIdentity(ctx, user.ID, identity.Provider))
+	mustBe(t, "RemoveLinkedIdentity twice", repo.RemoveLinkedIdentity(ctx, user.ID, identity.Provider), repository.ErrIdentityNotLinked)
+	_, err = repo.FindUserByIdentity(ctx, identity.Provider, identity.Subject)
+	mustBe(t, "FindUserByIdentity after unlinking", err, repository.ErrNotFound)
+
+	// the identity is free again
+	mustNot(t, repo.AddLinkedIdentity(ctx, other.ID, identity))
+}
+
+func userPasskeys(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("passkey"))
+	other := save(t, repo, newUser("passkey"))
+	passkey := models.Passkey{
+		ID:         unique("credential"),
+		Name:       "Laptop",
+		PublicKey:  []byte{1, 2, 3},
+		Transports: []string{"internal"},
+		SignCount:  1,
+		CreatedAt:  now(),
+	}
+
+	mustNot(t, repo.AddPasskey(ctx, user.ID, passkey))
+	mustBe(t, "AddPasskey twice", repo.AddPasskey(ctx, user.ID, passkey), repository.ErrPasskeyExists)
+	mustBe(t, "AddPasskey of another user's credential", repo.AddPasskey(ctx, other.ID, passkey), repository.ErrPasskeyExists)
+
+	mustNot(t, repo.UpdatePasskeyUsage(ctx, user.ID, passkey.ID, 7, true, false))
+	mustBe(t, "UpdatePasskeyUsage of another user's credential", repo.UpdatePasskeyUsage(ctx, other.ID, passkey.ID, 8, false, false), repository.ErrPasskeyNotFound)
+
+	found := find(t, repo, user.ID)
+	if len(found.Passkeys) != 1 {
+		t.Fatalf("passkeys: got %d, want 1", len(found.Passkeys))
+	}
+	stored := found.Passkeys[0]
+	if stored.ID != passkey.ID || stored.Name != passkey.Name || string(stored.PublicKey) != string(passkey.PublicKey) ||
+		stored.SignCount != 7 || !stored.BackupState || stored.LastUsedAt == nil || len(stored.Transports) != 1 {
+		t.Fatalf("passkey after use: got %+v", stored)
+	}
+
+	mustNot(t, repo.RemovePasskey(ctx, user.ID, passkey.ID))
+	mustBe(t, "RemovePasskey twice", repo.RemovePasskey(ctx, user.ID, passkey.ID), repository.ErrPasskeyNotFound)
+	if found := find(t, repo, user.ID); len(found.Passkeys) != 0 {
+		t.Fatalf("passkeys after removal: got %d, want none", len(found.Passkeys))
+	}
+}
+
+func userMFA(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("mfa"))
+	enrolledAt := now()
+	mfa := models.MFASettings{TOTPEnabled: true, TOTPSecret: "secret", RecoveryCodes: []string{"a", "b"}, EnrolledAt: &enrolledAt}
+
+	mustNot(t, repo.UpdateMFA(ctx, user.ID, mfa))
+	found := find(t, repo, user.ID)
+	if !found.MFA.TOTPEnabled || found.MFA.TOTPSecret != "secret" || len(found.MFA.RecoveryCodes) != 2 || found.MFA.EnrolledAt == nil {
+		t.Fatalf("UpdateMFA: got %+v", found.MFA)
+	}
+
+	steps := []struct {
+		step int64
+		want bool
+	}{{10, true}, {10, false}, {9, false}, {11, true}}
+	for _, s := range steps {
+		ok, err := repo.MarkTOTPStepUsed(ctx, user.ID, s.step)
+		mustNot(t, err)
+		if ok != s.want {
+			t.Fatalf("MarkTOTPStepUsed(%d): got %v, want %v", s.step, ok, s.want)
+		}
+	}
+
+	ok, err := repo.ConsumeRecoveryCode(ctx, user.ID, "a")
+	mustNot(t, err)
+	again, err := repo.ConsumeRecoveryCode(ctx, user.ID, "a")
+	mustNot(t, err)
+	if !ok || again {
+		t.Fatalf("ConsumeRecoveryCode: got %v then %v, want true then false", ok, again)
+	}
+	if codes := find(t, repo, user.ID).MFA.RecoveryCodes; len(codes) != 1 || codes[0] != "b" {
+		t.Fatalf("recovery codes: got %v, want [b]", codes)
+	}
+}
+
+func userVerifiedPhone(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("phone"))
+	other := save(t, repo, newUser("phone"))
+	phone := unique("+971")
+
+	mustNot(t, repo.SetVerifiedPhone(ctx, user.ID, phone))
+	found, err := repo.FindUserByVerifiedPhone(ctx, phone)
+	mustNot(t, err)
+	if found == nil || found.ID != user.ID || !found.PhoneVerified || found.PhoneVerifiedAt == nil {
+		t.Fatalf("FindUserByVerifiedPhone: got %+v, want the verified user", found)
+	}
+
+	mustBe(t, "SetVerifiedPhone of another user's phone", repo.SetVerifiedPhone(ctx, other.ID, phone), repository.ErrPhoneInUse)
+	// setting the same number again is not a conflict with itself
+	mustNot(t, repo.SetVerifiedPhone(ctx, user.ID, phone))
+}
+
+func userEmailVerification(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("verify"))
+
+	mustBe(t, "MarkEmailVerified of a changed email", repo.MarkEmailVerified(ctx, user.ID, "old-"+user.Email), repository.ErrNotFound)
+	if find(t, repo, user.ID).EmailVerified {
+		t.Fatal("MarkEmailVerified of a changed email verified the user")
+	}
+
+	mustNot(t, repo.MarkEmailVerified(ctx, user.ID, user.Email))
+	if found := find(t, repo, user.ID); !found.EmailVerified || found.EmailVerifiedAt == nil {
+		t.Fatalf("MarkEmailVerified: got verified %v at %v", found.EmailVerified, found.EmailVerifiedAt)
+	}
+}
+
+func userAccountAdministration(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("admin"))
+
+	mustNot(t, repo.UpdateRole(ctx, user.ID, models.RoleAdmin))
+	if found := find(t, repo, user.ID); found.Role != models.RoleAdmin {
+		t.Fatalf("UpdateRole: got role %q", found.Role)
+	}
+
+	until := now().Add(time.Hour)
+	suspension := &models.Suspension{Reason: "contract", Until: &until, ActorID: primitive.NewObjectID().Hex(), SuspendedAt: now()}
+	mustNot(t, repo.SetSuspension(ctx, user.ID, suspension))
+	found := find(t, repo, user.ID)
+	if found.Status != models.UserStatusSuspended || found.Suspension == nil || found.Suspension.Reason != "contract" || found.Suspension.Until == nil {
+		t.Fatalf("SetSuspension: got status %q, suspension %+v", found.Status, found.Suspension)
+	}
+	sameTime(t, "suspension until", *found.Suspension.Until, until)
+
+	mustNot(t, repo.SetSuspension(ctx, user.ID, nil))
+	if found := find(t, repo, user.ID); found.Status != models.UserStatusActive || found.Suspension != nil {
+		t.Fatalf("SetSuspension(nil): got status %q, suspension %+v", found.Status, found.Suspension)
+	}
+
+	mustNot(t, repo.SetPasswordResetRequired(ctx, user.ID, true))
+	if !find(t, repo, user.ID).PasswordResetRequired {
+		t.Fatal("SetPasswordResetRequired(true) did not require a reset")
+	}
+	// a rehash keeps the forced reset and only replaces the hash it was given
+	rehashed, err := repo.RehashPassword(ctx, user.ID, "hash", "rehashed")
+	mustNot(t, err)
+	if found := find(t, repo, user.ID); !rehashed || found.Password != "rehashed" || !found.PasswordResetRequired {
+		t.Fatalf("RehashPassword: rehashed %v, got password %q, reset required %v", rehashed, found.Password, found.PasswordResetRequired)
+	}
+	rehashed, err = repo.RehashPassword(ctx, user.ID, "hash", "stale")
+	mustNot(t, err)
+	if found := find(t, repo, user.ID); rehashed || found.Password != "rehashed" {
+		t.Fatalf("RehashPassword of a changed password: rehashed %v, got password %q", rehashed, found.Password)
+	}
+
+	mustNot(t, repo.UpdatePassword(ctx, user.ID, "new-hash"))
+	if found := find(t, repo, user.ID); found.Password != "new-hash" || found.PasswordResetRequired {
+		t.Fatalf("UpdatePassword: got password %q, reset required %v", found.Password, found.PasswordResetRequired)
+	}
+
+	mustNot(t, repo.DeleteUser(ctx, user.ID))
+	_, err = repo.FindUserByID(ctx, user.ID.Hex())
+	mustBe(t, "FindUserByID after DeleteUser", err, repository.ErrNotFound)
+	mustBe(t, "DeleteUser twice", repo.DeleteUser(ctx, user.ID), repository.ErrNotFound)
+}
+
+func userList(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	// the tag keeps users of other subtests or runs on a shared database out of the results
+	tag := unique("list")
+	base := now().Add(-time.Hour)
+
+	var users []*models.User
+	for i := 0; i < 5; i++ {
+		user := newUser(tag)
+		user.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		user.EmailVerified = i%2 == 0
+		if i == 0 {
+			user.Role = models.RoleAdmin
+		}
+		users = append(users, save(t, repo, user))
+	}
+	mustNot(t, repo.SetSuspension(ctx, users[1].ID, &models.Suspension{Reason: "contract", SuspendedAt: now()}))
+
+	page, total, err := repo.ListUsers(ctx, repository.UserFilter{Query: tag}, 1, 2)
+	mustNot(t, err)
+	if total != 5 || len(page) != 2 || page[0].ID != users[4].ID || page[1].ID != users[3].ID {
+		t.Fatalf("ListUsers page 1: got %d of %d (%s), want the 2 newest of 5", len(page), total, userIDs(page))
+	}
+	page, _, err = repo.ListUsers(ctx, repository.UserFilter{Query: tag}, 3, 2)
+	mustNot(t, err)
+	if len(page) != 1 || page[0].ID != users[0].ID {
+		t.Fatalf("ListUsers page 3: got %s, want the oldest user", userIDs(page))
+	}
+
+	// the query matches case insensitively and literally
+	_, total, err = repo.ListUsers(ctx, repository.UserFilter{Query: "LIST-" + tag[len("list-"):]}, 1, 10)
+	mustNot(t, err)
+	if total != 5 {
+		t.Fatalf("ListUsers with an upper case query: got %d, want 5", total)
+	}
+	_, total, err = repo.ListUsers(ctx, repository.UserFilter{Query: tag + ".*"}, 1, 10)
+	mustNot(t, err)
+	if total != 0 {
+		t.Fatalf("ListUsers with a pattern: got %d, want 0", total)
+	}
+
+	verified, unverified := true, false
+	filters := []struct {
+		name   string
+		filter repository.UserFilter
+		want   int64
+	}{
+		{"role", repository.UserFilter{Query: tag, Role: models.RoleAdmin}, 1},
+		{"suspended", repository.UserFilter{Query: tag, Status: models.UserStatusSuspended}, 1},
+		{"active", repository.UserFilter{Query: tag, Status: models.UserStatusActive}, 4},
+		{"verified", repository.UserFilter{Query: tag, EmailVerified: &verified}, 3},
+		{"unverified", repository.UserFilter{Query: tag, EmailVerified: &unverified}, 2},
+	}
+	for _, f := range filters {
+		_, total, err := repo.ListUsers(ctx, f.filter, 1, 10)
+		mustNot(t, err)
+		if total != f.want {
+			t.Fatalf("ListUsers %s: got %d, want %d", f.name, total, f.want)
+		}
+	}
+}
+
+func userDeletion(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := newUser("deletion")
+	user.Phone = unique("+971")
+	user = save(t, repo, user)
+	mustNot(t, repo.AddLinkedIdentity(ctx, user.ID, models.LinkedIdentity{Provider: "contract", Subject: unique("subject"), LinkedAt: now()}))
+
+	mustBe(t, "CancelDeletion of an active user", repo.CancelDeletion(ctx, user.ID), repository.ErrNotFound)
+	mustBe(t, "AnonymizeUser of an active user", repo.AnonymizeUser(ctx, user.ID), repository.ErrNotFound)
+
+	mustNot(t, repo.ScheduleDeletion(ctx, user.ID, now().Add(time.Hour)))
+	if found := find(t, repo, user.ID); found.Status != models.UserStatusPendingDeletion || found.DeletionScheduledAt == nil {
+		t.Fatalf("ScheduleDeletion: got status %q at %v", found.Status, found.DeletionScheduledAt)
+	}
+	mustNot(t, repo.CancelDeletion(ctx, user.ID))
+	if found := find(t, repo, user.ID); found.Status != models.UserStatusActive || found.DeletionScheduledAt != nil {
+		t.Fatalf("CancelDeletion: got status %q at %v", found.Status, found.DeletionScheduledAt)
+	}
+
+	// due long ago, so it is among the first due users even on a shared database
+	due := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	mustNot(t, repo.ScheduleDeletion(ctx, user.ID, due))
+	later := save(t, repo, newUser("deletion"))
+	mustNot(t, repo.ScheduleDeletion(ctx, later.ID, now().Add(time.Hour)))
+
+	dueUsers, err := repo.FindUsersDueForDeletion(ctx, now(), 1000)
+	mustNot(t, err)
+	if !containsUser(dueUsers, user.ID) || containsUser(dueUsers, later.ID) {
+		t.Fatalf("FindUsersDueForDeletion: got %s, want %s and not %s", userIDs(dueUsers), user.ID.Hex(), later.ID.Hex())
+	}
+
+	mustNot(t, repo.AnonymizeUser(ctx, user.ID))
+	found := find(t, repo, user.ID)
+	if found.Status != models.UserStatusDeleted || found.DeletedAt == nil || found.Email == user.Email || found.Phone != "" ||
+		found.Password != "" || len(found.LinkedIdentities) != 0 || found.DeletionScheduledAt != nil || found.Version != user.Version+1 {
+		t.Fatalf("AnonymizeUser: got %+v", found)
+	}
+	_, err = repo.FindUserByEmail(ctx, user.Email)
+	mustBe(t, "FindUserByEmail after AnonymizeUser", err, repository.ErrNotFound)
+	mustBe(t, "AnonymizeUser twice", repo.AnonymizeUser(ctx, user.ID), repository.ErrNotFound)
+}
+
+func userActivityLogs(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("activity"))
+	base := now()
+
+	for i, action := range []string{models.ActionLogin, models.ActionRegister} {
+		log := &models.UserActivityLog{
+			UserID:            user.ID,
+			Action:            action,
+			ActivityTimestamp: base.Add(time.Duration(1-i) * time.Second),
+		}
+		result, err := repo.SaveToActivityLog(ctx, log)
+		mustNot(t, err)
+		if log.ID.IsZero() || result.InsertedID != log.ID {
+			t.Fatalf("SaveToActivityLog: ID %v, inserted %v, want the assigned ID", log.ID, result.InsertedID)
+		}
+	}
+	actor := primitive.NewObjectID()
+	_, err := repo.SaveToActivityLog(ctx, &models.UserActivityLog{
+		UserID: user.ID, Action: models.ActionRoleChange, ActorID: actor, IP: "192.0.2.1", UserAgent: "agent",
+		Metadata: map[string]string{"email": "activity@example.com"}, ActivityTimestamp: base.Add(time.Minute),
+	})
+	mustNot(t, err)
+
+	logs, err := repo.FindActivityLogs(ctx, user.ID)
+	mustNot(t, err)
+	if len(logs) != 3 || logs[0].Action != models.ActionRegister || logs[1].Action != models.ActionLogin || logs[2].ActorID != actor {
+		t.Fatalf("FindActivityLogs: got %+v, want register, login, role change by the actor", logs)
+	}
+	if !logs[0].ActorID.IsZero() {
+		t.Fatalf("FindActivityLogs: got actor %v for the user's own activity, want none", logs[0].ActorID)
+	}
+
+	mustNot(t, repo.AnonymizeActivityLogs(ctx, user.ID))
+	logs, err = repo.FindActivityLogs(ctx, user.ID)
+	mustNot(t, err)
+	if len(logs) != 0 {
+		t.Fatalf("FindActivityLogs after AnonymizeActivityLogs: got %d logs, want none", len(logs))
+	}
+	logs, _, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{ActorID: actor}, 1, 10)
+	mustNot(t, err)
+	if len(logs) != 1 || logs[0].UserID == user.ID || logs[0].IP != "" || logs[0].UserAgent != "" || logs[0].Metadata != nil {
+		t.Fatalf("ListActivityLogs after AnonymizeActivityLogs: got %+v, want the entry without user, network details and metadata", logs)
+	}
+}
+
+func userAuditTrail(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("audit"))
+	admin := primitive.NewObjectID()
+	base := now()
+
+	// five entries a minute apart, the admin acted on the last two
+	var saved []*models.UserActivityLog
+	for i, action := range []string{models.ActionRegister, models.ActionLogin, models.ActionLogin, models.ActionSuspend, models.ActionReactivate} {
+		actor := user.ID
+		if i >= 3 {
+			actor = admin
+		}
+		log := &models.UserActivityLog{
+			UserID:            user.ID,
+			ActorID:           actor,
+			Action:            action,
+			IP:                "203.0.113.7",
+			UserAgent:         "contract/1.0",
+			CorrelationID:     unique("correlation"),
+			Metadata:          map[string]string{"step": string(rune('a' + i))},
+			ActivityTimestamp: base.Add(time.Duration(i) * time.Minute),
+		}
+		_, err := repo.SaveToActivityLog(ctx, log)
+		mustNot(t, err)
+		saved = append(saved, log)
+	}
+
+	logs, total, err := repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID}, 1, 2)
+	mustNot(t, err)
+	if total != 5 || len(logs) != 2 || logs[0].ID != saved[4].ID || logs[1].ID != saved[3].ID {
+		t.Fatalf("ListActivityLogs page 1: got %v of %d, want the two newest of 5", logIDs(logs), total)
+	}
+	got := logs[0]
+	if got.IP != "203.0.113.7" || got.UserAgent != "contract/1.0" || got.CorrelationID != saved[4].CorrelationID ||
+		got.Metadata["step"] != "e" || got.ActorID != admin || got.ActivityTimestamp.UnixMilli() != saved[4].ActivityTimestamp.UnixMilli() {
+		t.Fatalf("ListActivityLogs: got %+v, want the saved entry back", got)
+	}
+	logs, _, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID}, 3, 2)
+	mustNot(t, err)
+	if len(logs) != 1 || logs[0].ID != saved[0].ID {
+		t.Fatalf("ListActivityLogs page 3: got %v, want the oldest entry", logIDs(logs))
+	}
+
+	logs, total, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID, Action: models.ActionLogin}, 1, 10)
+	mustNot(t, err)
+	if total != 2 || len(logs) != 2 || logs[0].ID != saved[2].ID {
+		t.Fatalf("ListActivityLogs by action: got %v of %d, want both logins", logIDs(logs), total)
+	}
+	logs, total, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{ActorID: admin}, 1, 10)
+	mustNot(t, err)
+	if total != 2 || len(logs) != 2 || logs[1].ID != saved[3].ID {
+		t.Fatalf("ListActivityLogs by actor: got %v of %d, want the admin's two entries", logIDs(logs), total)
+	}
+	from, to := base.Add(time.Minute), base.Add(3*time.Minute)
+	logs, total, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID, From: &from, To: &to}, 1, 10)
+	mustNot(t, err)
+	if total != 2 || len(logs) != 2 || logs[0].ID != saved[2].ID || logs[1].ID != saved[1].ID {
+		t.Fatalf("ListActivityLogs between: got %v of %d, want entries 2 and 3", logIDs(logs), total)
+	}
+
+	// anonymizing the admin hides it as actor, the user's own entries keep their details
+	mustNot(t, repo.AnonymizeActivityLogs(ctx, admin))
+	logs, total, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{ActorID: admin}, 1, 10)
+	mustNot(t, err)
+	if total != 0 || len(logs) != 0 {
+		t.Fatalf("ListActivityLogs by an anonymized actor: got %d entries, want none", total)
+	}
+	logs, _, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID}, 1, 10)
+	mustNot(t, err)
+	if len(logs) != 5 || logs[0].ActorID == admin || logs[0].ActorID.IsZero() || logs[4].IP != "203.0.113.7" {
+		t.Fatalf("ListActivityLogs after anonymizing the actor: got %+v", logs)
+	}
+
+	// purging only removes entries older than the cutoff
+	ancient := &models.UserActivityLog{UserID: user.ID, Action: models.ActionLogin, ActivityTimestamp: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)}
+	_, err = repo.SaveToActivityLog(ctx, ancient)
+	mustNot(t, err)
+	purged, err := repo.PurgeActivityLogs(ctx, time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC))
+	mustNot(t, err)
+	_, total, err = repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID}, 1, 10)
+	mustNot(t, err)
+	if purged < 1 || total != 5 {
+		t.Fatalf("PurgeActivityLogs: purged %d, %d entries left, want the 1990 entry gone and 5 left", purged, total)
+	}
+}
+
+// userActivityBatch checks that a batch is written at once and that retrying it does not duplicate entries
+func userActivityBatch(t *testing.T, repo repository.UserRepo) {
+	ctx := context.Background()
+	user := save(t, repo, newUser("batch"))
+	base := now()
+
+	batch := make([]models.UserActivityLog, 3)
+	for i := range batch {
+		batch[i] = models.UserActivityLog{
+			ID:                primitive.NewObjectID(),
+			UserID:            user.ID,
+			ActorID:           user.ID,
+			Action:            models.ActionLogin,
+			Metadata:          map[string]string{"step": string(rune('a' + i))},
+			ActivityTimestamp: base.Add(time.Duration(i) * time.Second),
+		}
+	}
+	mustNot(t, repo.SaveActivityLogs(ctx, batch[:2]))
+	// the retry carries the entries already written and a new one
+	mustNot(t, repo.SaveActivityLogs(ctx, batch))
+	mustNot(t, repo.SaveActivityLogs(ctx, nil))
+
+	logs, total, err := repo.ListActivityLogs(ctx, repository.ActivityFilter{UserID: user.ID}, 1, 10)
+	mustNot(t, err)
+	if total != 3 || len(logs) != 3 || logs[0].ID != batch[2].ID || logs[2].ID != batch[0].ID {
+		t.Fatalf("SaveActivityLogs: got %v of %d, want the 3 entries of the batch once", logIDs(logs), total)
+	}
+	if logs[1].Metadata["step"] != "b" {
+		t.Fatalf("SaveActivityLogs: got %+v, want the saved entry back", logs[1])
+	}
+}
+
+// newUser returns an unsaved user with an email no other user has
+func newUser(tag string) *models.User {
+	return &models.User{
+		Username:  unique(tag),
+		Email:     unique(tag) + "@contract.test",
+		Password:  "hash",
+		Role:      models.RoleUser,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+}
+
+func save(t *testing.T, repo repository.UserRepo, user *models.User) *models.User {
+	t.Helper()
+	if _, err := repo.SaveUser(context.Background(), user); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	return user
+}
+
+func find(t *testing.T, repo repository.UserRepo, id primitive.ObjectID) *models.User {
+	t.Helper()
+	user, err := repo.FindUserByID(context.Background(), id.Hex())
+	if err != nil {
+		t.Fatalf("FindUserByID: %v", err)
+	}
+	return user
+}
+
+func mustNot(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustBe(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: got error %v, want %v", what, err, want)
+	}
+}
+
+// sameTime compares at millisecond precision, the precision MongoDB keeps
+func sameTime(t *testing.T, what string, got, want time.Time) {
+	t.Helper()
+	if got.UnixMilli() != want.UnixMilli() {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+// now is the current time at the precision every backend keeps
+func now() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+// unique prefixes a new ObjectID with tag, a cheap unique value on any backend
+func unique(tag string) string {
+	return tag + "-" + primitive.NewObjectID().Hex()
+}
+
+func containsUser(users []models.User, id primitive.ObjectID) bool {
+	for _, user := range users {
+		if user.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func userIDs(users []models.User) []string {
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.ID.Hex())
+	}
+	return ids
+}
+
+func actions(logs []*models.UserActivityLog) []string {
+	var actions []string
+	for _, log := range logs {
+		actions = append(actions, log.Action)
+	}
+	return actions
+}
+
+func logIDs(logs []models.UserActivityLog) []string {
+	var ids []string
+	for _, log := range logs {
+		ids = append(ids, log.ID.Hex())
+	}
+	return ids
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	"user-service/core/models"
+)
+
+type RoleRepo interface {
+	ListRoles(ctx context.Context) ([]models.Role, error)
+}
+
+type roleRepo struct {
+	db *mongo.Database
+}
+
+// ListRoles returns every role definition
+func (r *roleRepo) ListRoles(ctx context.Context) ([]models.Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := r.db.Collection("roles").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var roles []models.Role
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func NewRoleRepo(db *mongo.Database) RoleRepo {
+	return &roleRepo{
+		db: db,
+	}
+}
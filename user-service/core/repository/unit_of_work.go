@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+)
+
+// UnitOfWork runs several repository writes as one, every repository called with the ctx given to fn joins
+// the transaction, so either all of the writes are kept or none
+type UnitOfWork interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type mongoUnitOfWork struct {
+	client *mongo.Client
+
+	mu        sync.Mutex
+	checked   bool
+	supported bool
+}
+
+// WithTransaction runs fn in a MongoDB transaction, a standalone server has none and runs fn as it is
+func (u *mongoUnitOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// nested units join the transaction already open
+	if mongo.SessionFromContext(ctx) != nil || !u.transactionsSupported(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := u.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// the driver runs fn again when the transaction hits a transient error
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+// transactionsSupported asks the server once whether it is a replica set or sharded, the only deployments with
+// transactions. A failed check is not remembered so the next unit of work asks again
+func (u *mongoUnitOfWork) transactionsSupported(ctx context.Context) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.checked {
+		return u.supported
+	}
+
+	var reply struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := u.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&reply); err != nil {
+		logrus.Errorf("Failed to check MongoDB transaction support: %v", err)
+		return false
+	}
+	u.checked = true
+	u.supported = reply.SetName != "" || reply.Msg == "isdbgrid"
+	if !u.supported {
+		logrus.Warn("MongoDB is a standalone server, units of work run without transactions")
+	}
+	return u.supported
+}
+
+// NewMongoUnitOfWork runs units of work in transactions of the client of db
+func NewMongoUnitOfWork(db *mongo.Database) UnitOfWork {
+	return &mongoUnitOfWork{client: db.Client()}
+}
+
+// txContextKey carries the Postgres transaction of a unit of work
+type txContextKey struct{}
+
+// sqlConn is implemented by *sql.DB and *sql.Tx
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func txFromContext(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txContextKey{}).(*sql.Tx)
+	return tx
+}
+
+// conn returns the transaction of the unit of work ctx belongs to, or db outside of one
+func conn(ctx context.Context, db *sql.DB) sqlConn {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
+
+type postgresUnitOfWork struct {
+	db *sql.DB
+}
+
+// WithTransaction runs fn in a Postgres transaction that is committed when fn succeeds
+func (u *postgresUnitOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// NewPostgresUnitOfWork runs units of work in transactions of db
+func NewPostgresUnitOfWork(db *sql.DB) UnitOfWork {
+	return &postgresUnitOfWork{db: db}
+}
+
+type unitsOfWork []UnitOfWork
+
+// WithTransaction opens the transactions one inside the other, the innermost is committed first
+func (units unitsOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if len(units) == 0 {
+		return fn(ctx)
+	}
+	return units[0].WithTransaction(ctx, func(ctx context.Context) error {
+		return units[1:].WithTransaction(ctx, fn)
+	})
+}
+
+// NewUnitsOfWork spans a unit of work over several stores. Each store is all-or-nothing, across stores it is
+// best effort: a failed commit of an outer store cannot undo the inner stores already committed
+func NewUnitsOfWork(units ...UnitOfWork) UnitOfWork {
+	return unitsOfWork(units)
+}
+
+type noopUnitOfWork struct{}
+
+// WithTransaction runs fn without a transaction
+func (noopUnitOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// NewNoopUnitOfWork runs units of work without transactions, for the in-memory repositories
+func NewNoopUnitOfWork() UnitOfWork {
+	return noopUnitOfWork{}
+}
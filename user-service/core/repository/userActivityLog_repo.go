@@ -5,6 +5,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 	"user-service/core/models"
 )
@@ -19,11 +20,19 @@ type userActivityLogRepo struct {
 }
 
 func (r *userActivityLogRepo) CreateUserActivityLog(ctx context.Context, log *models.UserActivityLog) (*mongo.InsertOneResult, error) {
-	collection := r.db.Collection("UserActivityLog")
+	collection := r.db.Collection("userActivityLog")
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	// assign the ID up front so callers can reference the saved log
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	if log.ActivityTimestamp.IsZero() {
+		log.ActivityTimestamp = time.Now().UTC()
+	}
+
 	result, err := collection.InsertOne(ctx, log)
 	if err != nil {
 		return nil, err
@@ -33,7 +42,7 @@ func (r *userActivityLogRepo) CreateUserActivityLog(ctx context.Context, log *mo
 }
 
 func (r *userActivityLogRepo) GetUserActivityLogs(ctx context.Context, userId string) ([]*models.UserActivityLog, error) {
-	collection := r.db.Collection("UserActivityLog")
+	collection := r.db.Collection("userActivityLog")
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -43,9 +52,10 @@ func (r *userActivityLogRepo) GetUserActivityLogs(ctx context.Context, userId st
 		return nil, err
 	}
 
-	filter := bson.M{"userId": objectId}
+	filter := bson.M{"user_id": objectId}
 
-	cursor, err := collection.Find(ctx, filter)
+	opts := options.Find().SetSort(bson.D{{Key: "activity_timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
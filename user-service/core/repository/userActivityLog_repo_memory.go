@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+	"user-service/core/models"
+)
+
+type memoryUserActivityLogRepo struct {
+	mu   sync.Mutex
+	logs []models.UserActivityLog
+}
+
+func (r *memoryUserActivityLogRepo) CreateUserActivityLog(ctx context.Context, log *models.UserActivityLog) (*mongo.InsertOneResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if log.ID.IsZero() {
+		log.ID = primitive.NewObjectID()
+	}
+	if log.ActivityTimestamp.IsZero() {
+		log.ActivityTimestamp = time.Now().UTC()
+	}
+	r.logs = append(r.logs, cloneActivity(*log))
+	return &mongo.InsertOneResult{InsertedID: log.ID}, nil
+}
+
+func (r *memoryUserActivityLogRepo) GetUserActivityLogs(ctx context.Context, userId string) ([]*models.UserActivityLog, error) {
+	objectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	var matches []models.UserActivityLog
+	for _, log := range r.logs {
+		if log.UserID == objectId {
+			matches = append(matches, cloneActivity(log))
+		}
+	}
+	r.mu.Unlock()
+	sortOldestFirst(matches)
+
+	var logs []*models.UserActivityLog
+	for i := range matches {
+		logs = append(logs, &matches[i])
+	}
+	return logs, nil
+}
+
+// NewMemoryUserActivityLogRepo keeps activity logs in memory, for tests that do not need a database
+func NewMemoryUserActivityLogRepo() UserActivityLogRepo {
+	return &memoryUserActivityLogRepo{}
+}
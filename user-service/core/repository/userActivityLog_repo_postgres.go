@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+	"user-service/core/models"
+)
+
+type postgresUserActivityLogRepo struct {
+	db *sql.DB
+}
+
+func (r *postgresUserActivityLogRepo) CreateUserActivityLog(ctx context.Context, log *models.UserActivityLog) (*mongo.InsertOneResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := insertActivity(ctx, conn(ctx, r.db), log); err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: log.ID}, nil
+}
+
+func (r *postgresUserActivityLogRepo) GetUserActivityLogs(ctx context.Context, userId string) ([]*models.UserActivityLog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := queryActivity(ctx, conn(ctx, r.db), `SELECT `+activityColumns+` FROM user_activity_log
+		WHERE user_id = $1 ORDER BY activity_timestamp, id`, objectId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*models.UserActivityLog
+	for i := range logs {
+		result = append(result, &logs[i])
+	}
+	return result, nil
+}
+
+func NewPostgresUserActivityLogRepo(db *sql.DB) UserActivityLogRepo {
+	return &postgresUserActivityLogRepo{db: db}
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"user-service/core/apperror"
+	"user-service/core/models"
+)
+
+// ErrTokenInvalid is returned when a token is unknown, expired or already used
+var ErrTokenInvalid = apperror.ErrInvalidToken
+
+type UserTokenRepo interface {
+	CreateToken(ctx context.Context, token *models.UserToken) error
+	FindToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error)
+	ConsumeToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error)
+	ConsumeDeviceToken(ctx context.Context, purpose, tokenHash, deviceHash string) (*models.UserToken, error)
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error
+}
+
+type userTokenRepo struct {
+	db *mongo.Database
+}
+
+// CreateToken stores a new token
+func (r *userTokenRepo) CreateToken(ctx context.Context, token *models.UserToken) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := r.db.Collection("userTokens").InsertOne(ctx, token)
+	return err
+}
+
+// FindToken returns an unused, unexpired token without using it, ErrTokenInvalid when there is none
+func (r *userTokenRepo) FindToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"purpose":    purpose,
+		"token_hash": tokenHash,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	var token models.UserToken
+	err := r.db.Collection("userTokens").FindOne(ctx, filter).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeToken marks an unused, unexpired token as used and returns it, so each token works only once
+func (r *userTokenRepo) ConsumeToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	return r.consume(ctx, bson.M{"purpose": purpose, "token_hash": tokenHash})
+}
+
+// ConsumeDeviceToken is ConsumeToken for tokens bound to a device, a token used from another device stays valid
+func (r *userTokenRepo) ConsumeDeviceToken(ctx context.Context, purpose, tokenHash, deviceHash string) (*models.UserToken, error) {
+	if deviceHash == "" {
+		return nil, ErrTokenInvalid
+	}
+	return r.consume(ctx, bson.M{"purpose": purpose, "token_hash": tokenHash, "device_hash": deviceHash})
+}
+
+// consume marks the unused, unexpired token matching filter as used and returns it
+func (r *userTokenRepo) consume(ctx context.Context, filter bson.M) (*models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter["used_at"] = bson.M{"$exists": false}
+	filter["expires_at"] = bson.M{"$gt": now}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+
+	var token models.UserToken
+	err := r.db.Collection("userTokens").
+		FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteUserTokens removes every token of the user with the given purpose, an empty purpose removes all of them
+func (r *userTokenRepo) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if purpose != "" {
+		filter["purpose"] = purpose
+	}
+	_, err := r.db.Collection("userTokens").DeleteMany(ctx, filter)
+	return err
+}
+
+func NewUserTokenRepo(db *mongo.Database) UserTokenRepo {
+	return &userTokenRepo{db: db}
+}
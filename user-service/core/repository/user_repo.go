@@ -3,33 +3,110 @@ package repository
 import (
 	"context"
 	"errors"
-	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strings"
 	"time"
+	"user-service/core/apperror"
 	"user-service/core/models"
 )
 
+// The repository errors are catalog errors, so services can reply with them as they are
+var (
+	// ErrNotFound is returned by every backend when the user, or the record addressed by ID, does not exist
+	ErrNotFound = apperror.ErrNotFound
+	// ErrProviderAlreadyLinked is returned when the user already has an identity at that provider
+	ErrProviderAlreadyLinked = apperror.ErrProviderAlreadyLinked
+	// ErrIdentityInUse is returned when the provider identity belongs to another user
+	ErrIdentityInUse = apperror.ErrIdentityInUse
+	// ErrIdentityNotLinked is returned when unlinking a provider the user never linked
+	ErrIdentityNotLinked = apperror.ErrIdentityNotLinked
+	// ErrPasskeyExists is returned when a credential ID is registered twice
+	ErrPasskeyExists = apperror.ErrPasskeyExists
+	// ErrPasskeyNotFound is returned when the user has no passkey with that credential ID
+	ErrPasskeyNotFound = apperror.ErrPasskeyNotFound
+	// ErrPhoneInUse is returned when another account already verified the phone number
+	ErrPhoneInUse = apperror.ErrPhoneTaken
+	// ErrEmailInUse is returned when another account already has the email
+	ErrEmailInUse = apperror.ErrEmailTaken
+	// ErrVersionConflict is returned when the user changed since it was read
+	ErrVersionConflict = apperror.ErrVersionConflict
+)
+
 type UserRepo interface {
 	SaveUser(ctx context.Context, user *models.User) (*mongo.InsertOneResult, error)
 	SaveToActivityLog(ctx context.Context, activity *models.UserActivityLog) (*mongo.InsertOneResult, error)
+	SaveActivityLogs(ctx context.Context, activities []models.UserActivityLog) error
 	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
 	FindUserByID(ctx context.Context, id string) (*models.User, error)
-	FindByGoogleID(ctx context.Context, googleID string) (*models.User, error)
+	FindUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	AddLinkedIdentity(ctx context.Context, userID primitive.ObjectID, identity models.LinkedIdentity) error
+	RemoveLinkedIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error
+	UpdateMFA(ctx context.Context, userID primitive.ObjectID, mfa models.MFASettings) error
+	MarkTOTPStepUsed(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error)
+	ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error)
+	AddPasskey(ctx context.Context, userID primitive.ObjectID, passkey models.Passkey) error
+	UpdatePasskeyUsage(ctx context.Context, userID primitive.ObjectID, credentialID string, signCount uint32, backupState, cloneWarning bool) error
+	RemovePasskey(ctx context.Context, userID primitive.ObjectID, credentialID string) error
+	UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error
+	RehashPassword(ctx context.Context, userID primitive.ObjectID, currentHash, newHash string) (bool, error)
+	MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) error
+	FindUserByVerifiedPhone(ctx context.Context, phone string) (*models.User, error)
+	SetVerifiedPhone(ctx context.Context, userID primitive.ObjectID, phone string) error
+	ListUsers(ctx context.Context, filter UserFilter, page, limit int) ([]models.User, int64, error)
+	UpdateRole(ctx context.Context, userID primitive.ObjectID, role string) error
+	SetSuspension(ctx context.Context, userID primitive.ObjectID, suspension *models.Suspension) error
+	SetPasswordResetRequired(ctx context.Context, userID primitive.ObjectID, required bool) error
+	DeleteUser(ctx context.Context, userID primitive.ObjectID) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	FindActivityLogs(ctx context.Context, userID primitive.ObjectID) ([]models.UserActivityLog, error)
+	ListActivityLogs(ctx context.Context, filter ActivityFilter, page, limit int) ([]models.UserActivityLog, int64, error)
+	PurgeActivityLogs(ctx context.Context, before time.Time) (int64, error)
+	AnonymizeActivityLogs(ctx context.Context, userID primitive.ObjectID) error
+	ScheduleDeletion(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+	CancelDeletion(ctx context.Context, userID primitive.ObjectID) error
+	FindUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]models.User, error)
+	AnonymizeUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// UserFilter narrows ListUsers, empty fields match every user
+type UserFilter struct {
+	// Query matches part of the email or username, case insensitive
+	Query         string
+	Role          string
+	Status        string
+	EmailVerified *bool
+}
+
+// ActivityFilter narrows ListActivityLogs, empty fields match every entry
+type ActivityFilter struct {
+	UserID  primitive.ObjectID
+	ActorID primitive.ObjectID
+	Action  string
+	// From and To bound the time of the entries, From inclusive and To exclusive
+	From *time.Time
+	To   *time.Time
 }
 
 type userRepo struct {
 	db *mongo.Database
 }
 
-func (r *userRepo) FindByGoogleID(ctx context.Context, googleID string) (*models.User, error) {
+// FindUserByIdentity finds the user that has linked the given provider account
+func (r *userRepo) FindUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
 	var user models.User
-	err := r.db.Collection("users").FindOne(ctx, bson.M{"google_id": googleID}).Decode(&user)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"linked_identities.key": models.IdentityKey(provider, subject)}
+	err := r.db.Collection("users").FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
-		if errors.Is(mongo.ErrNoDocuments, err) {
-			return nil, nil
-		}
 		return nil, err
 	}
 	return &user, nil
@@ -39,7 +116,19 @@ func (r *userRepo) SaveUser(ctx context.Context, user *models.User) (*mongo.Inse
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	// assign the ID up front so callers can reference the saved user
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	for i := range user.LinkedIdentities {
+		user.LinkedIdentities[i].Key = models.IdentityKey(user.LinkedIdentities[i].Provider, user.LinkedIdentities[i].Subject)
+	}
+
 	result, err := r.db.Collection("users").InsertOne(ctx, user)
+	// email_1 is the unique email index, other duplicates are passkeys or identities of another user
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "email_1") {
+		return nil, ErrEmailInUse
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -53,8 +142,8 @@ func (r *userRepo) FindUserByEmail(ctx context.Context, email string) (*models.U
 	defer cancel()
 
 	err := r.db.Collection("users").FindOne(ctx, map[string]string{"email": email}).Decode(&user)
-	if errors.Is(mongo.ErrNoDocuments, err) {
-		return nil, nil
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
 	}
 
 	if err != nil {
@@ -69,12 +158,16 @@ func (r *userRepo) FindUserByID(ctx context.Context, id string) (*models.User, e
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	// no user has an ID that is not an ObjectID
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID format: %v", err)
+		return nil, ErrNotFound
 	}
 
 	err = r.db.Collection("users").FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -86,6 +179,13 @@ func (r *userRepo) SaveToActivityLog(ctx context.Context, activity *models.UserA
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	if activity.ID.IsZero() {
+		activity.ID = primitive.NewObjectID()
+	}
+	if activity.ActivityTimestamp.IsZero() {
+		activity.ActivityTimestamp = time.Now().UTC()
+	}
+
 	result, err := r.db.Collection("userActivityLog").InsertOne(ctx, activity)
 	if err != nil {
 		return nil, err
@@ -94,6 +194,641 @@ func (r *userRepo) SaveToActivityLog(ctx context.Context, activity *models.UserA
 	return result, nil
 }
 
+// SaveActivityLogs inserts a batch of entries in one write, entries whose ID is already stored are skipped
+// so a batch that is retried after a partial failure is written once
+func (r *userRepo) SaveActivityLogs(ctx context.Context, activities []models.UserActivityLog) error {
+	if len(activities) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	documents := make([]interface{}, 0, len(activities))
+	for i := range activities {
+		activity := activities[i]
+		if activity.ID.IsZero() {
+			activity.ID = primitive.NewObjectID()
+		}
+		if activity.ActivityTimestamp.IsZero() {
+			activity.ActivityTimestamp = time.Now().UTC()
+		}
+		documents = append(documents, activity)
+	}
+
+	_, err := r.db.Collection("userActivityLog").InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// AddLinkedIdentity links a provider identity, one identity per provider and user
+func (r *userRepo) AddLinkedIdentity(ctx context.Context, userID primitive.ObjectID, identity models.LinkedIdentity) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	identity.Key = models.IdentityKey(identity.Provider, identity.Subject)
+	filter := bson.M{"_id": userID, "linked_identities.provider": bson.M{"$ne": identity.Provider}}
+	update := bson.M{
+		"$push": bson.M{"linked_identities": identity},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityInUse
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrProviderAlreadyLinked
+	}
+	return nil
+}
+
+// RemoveLinkedIdentity unlinks the user's identity at the given provider
+func (r *userRepo) RemoveLinkedIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "linked_identities.provider": provider}
+	update := bson.M{
+		"$pull": bson.M{"linked_identities": bson.M{"provider": provider}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdentityNotLinked
+	}
+	return nil
+}
+
+// UpdateMFA replaces the MFA settings of a user
+func (r *userRepo) UpdateMFA(ctx context.Context, userID primitive.ObjectID, mfa models.MFASettings) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"mfa": mfa, "updated_at": time.Now()}}
+	result, err := r.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkTOTPStepUsed records the time step of an accepted code, it reports false when
+// that step (or a later one) was already used so a code cannot be replayed
+func (r *userRepo) MarkTOTPStepUsed(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": userID,
+		"$or": bson.A{
+			bson.M{"mfa.totp_last_step": bson.M{"$exists": false}},
+			bson.M{"mfa.totp_last_step": bson.M{"$lt": step}},
+		},
+	}
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa.totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ConsumeRecoveryCode removes a recovery code hash, it reports false when the code was not found
+func (r *userRepo) ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "mfa.recovery_codes": codeHash}
+	update := bson.M{"$pull": bson.M{"mfa.recovery_codes": codeHash}}
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// AddPasskey registers a WebAuthn credential for the user
+func (r *userRepo) AddPasskey(ctx context.Context, userID primitive.ObjectID, passkey models.Passkey) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "passkeys.id": bson.M{"$ne": passkey.ID}}
+	update := bson.M{
+		"$push": bson.M{"passkeys": passkey},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPasskeyExists
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPasskeyExists
+	}
+	return nil
+}
+
+// UpdatePasskeyUsage stores the counters reported by the authenticator after an assertion
+func (r *userRepo) UpdatePasskeyUsage(ctx context.Context, userID primitive.ObjectID, credentialID string, signCount uint32, backupState, cloneWarning bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "passkeys.id": credentialID}
+	update := bson.M{"$set": bson.M{
+		"passkeys.$.sign_count":    signCount,
+		"passkeys.$.backup_state":  backupState,
+		"passkeys.$.clone_warning": cloneWarning,
+		"passkeys.$.last_used_at":  time.Now(),
+	}}
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// RemovePasskey deletes a WebAuthn credential from the user
+func (r *userRepo) RemovePasskey(ctx context.Context, userID primitive.ObjectID, credentialID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "passkeys.id": credentialID}
+	update := bson.M{
+		"$pull": bson.M{"passkeys": bson.M{"id": credentialID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (r *userRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"password": passwordHash, "updated_at": time.Now()},
+		"$unset": bson.M{"password_reset_required": ""},
+	}
+	result, err := r.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RehashPassword swaps the hash of an unchanged password for one with current parameters, it reports false when
+// the password was changed since currentHash was read. A forced reset stays required
+func (r *userRepo) RehashPassword(ctx context.Context, userID primitive.ObjectID, currentHash, newHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "password": currentHash}
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": newHash}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// MarkEmailVerified verifies the user's email, only while it is still the given address
+func (r *userRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"email_verified": true, "email_verified_at": now, "updated_at": now}, "$inc": bson.M{"version": 1}}
+	result, err := r.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID, "email": email}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindUserByVerifiedPhone returns the account that verified the phone number, ErrNotFound when there is none
+func (r *userRepo) FindUserByVerifiedPhone(ctx context.Context, phone string) (*models.User, error) {
+	var user models.User
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := r.db.Collection("users").FindOne(ctx, bson.M{"phone": phone, "phone_verified": true}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetVerifiedPhone replaces the user's phone with a number that has just been verified
+func (r *userRepo) SetVerifiedPhone(ctx context.Context, userID primitive.ObjectID, phone string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{"phone": phone, "phone_verified": true, "phone_verified_at": now, "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := r.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPhoneInUse
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListUsers returns one page of users matching the filter, newest first, with the total number of matches
+func (r *userRepo) ListUsers(ctx context.Context, filter UserFilter, page, limit int) ([]models.User, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"}
+		query["$or"] = bson.A{bson.M{"email": pattern}, bson.M{"username": pattern}}
+	}
+	if filter.Role != "" {
+		query["role"] = filter.Role
+	}
+	switch filter.Status {
+	case models.UserStatusSuspended:
+		query["status"] = models.UserStatusSuspended
+	case models.UserStatusActive:
+		query["status"] = bson.M{"$ne": models.UserStatusSuspended}
+	}
+	if filter.EmailVerified != nil {
+		if *filter.EmailVerified {
+			query["email_verified"] = true
+		} else {
+			query["email_verified"] = bson.M{"$ne": true}
+		}
+	}
+
+	collection := r.db.Collection("users")
+	total, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// UpdateRole changes the role of a user
+func (r *userRepo) UpdateRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	return r.updateUser(ctx, userID, bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}})
+}
+
+// SetSuspension suspends the user, a nil suspension reactivates it
+func (r *userRepo) SetSuspension(ctx context.Context, userID primitive.ObjectID, suspension *models.Suspension) error {
+	update := bson.M{
+		"$set":   bson.M{"status": models.UserStatusActive, "updated_at": time.Now()},
+		"$unset": bson.M{"suspension": ""},
+	}
+	if suspension != nil {
+		update = bson.M{"$set": bson.M{"status": models.UserStatusSuspended, "suspension": suspension, "updated_at": time.Now()}}
+	}
+	return r.updateUser(ctx, userID, update)
+}
+
+// SetPasswordResetRequired makes password logins fail until the user chose a new password
+func (r *userRepo) SetPasswordResetRequired(ctx context.Context, userID primitive.ObjectID, required bool) error {
+	update := bson.M{"$set": bson.M{"password_reset_required": true, "updated_at": time.Now()}}
+	if !required {
+		update = bson.M{"$set": bson.M{"updated_at": time.Now()}, "$unset": bson.M{"password_reset_required": ""}}
+	}
+	return r.updateUser(ctx, userID, update)
+}
+
+// DeleteUser removes the user document, the activity log is kept for auditing
+func (r *userRepo) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := r.db.Collection("users").DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateUser saves the profile fields of user if nobody changed it since it was read, then increments its version.
+// Every other writer of these fields increments the version too, otherwise its write could be overwritten here
+func (r *userRepo) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": user.ID, "version": user.Version}
+	if user.Version == 0 {
+		// users saved before versioning have no version field
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"username":          user.Username,
+			"email":             user.Email,
+			"email_verified":    user.EmailVerified,
+			"email_verified_at": user.EmailVerifiedAt,
+			"address":           user.Address,
+			"phone":             user.Phone,
+			"phone_verified":    user.PhoneVerified,
+			"phone_verified_at": user.PhoneVerifiedAt,
+			"age":               user.Age,
+			"avatar":            user.Avatar,
+			"avatar_variants":   user.AvatarVariants,
+			"updated_at":        now,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	collection := r.db.Collection("users")
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailInUse
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		exists, err := collection.CountDocuments(ctx, bson.M{"_id": user.ID})
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrNotFound
+		}
+		return ErrVersionConflict
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+	return nil
+}
+
+// FindActivityLogs returns the activity of the user, oldest first
+func (r *userRepo) FindActivityLogs(ctx context.Context, userID primitive.ObjectID) ([]models.UserActivityLog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "activity_timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.db.Collection("userActivityLog").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []models.UserActivityLog{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// ListActivityLogs returns one page of the audit trail, newest first
+func (r *userRepo) ListActivityLogs(ctx context.Context, filter ActivityFilter, page, limit int) ([]models.UserActivityLog, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	if !filter.ActorID.IsZero() {
+		query["actor_id"] = filter.ActorID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.From != nil || filter.To != nil {
+		at := bson.M{}
+		if filter.From != nil {
+			at["$gte"] = *filter.From
+		}
+		if filter.To != nil {
+			at["$lt"] = *filter.To
+		}
+		query["activity_timestamp"] = at
+	}
+
+	collection := r.db.Collection("userActivityLog")
+	total, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "activity_timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []models.UserActivityLog{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// PurgeActivityLogs removes the entries older than before, the TTL index of the collection usually got there first
+func (r *userRepo) PurgeActivityLogs(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := r.db.Collection("userActivityLog").DeleteMany(ctx, bson.M{"activity_timestamp": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// AnonymizeActivityLogs moves the activity of the user to a random ID that leads back to nobody,
+// without the network details and the metadata of the entries, which can hold an email or an IP
+func (r *userRepo) AnonymizeActivityLogs(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	anonymous := primitive.NewObjectID()
+	collection := r.db.Collection("userActivityLog")
+	update := bson.M{"$set": bson.M{"user_id": anonymous}, "$unset": bson.M{"ip": "", "user_agent": "", "metadata": ""}}
+	if _, err := collection.UpdateMany(ctx, bson.M{"user_id": userID}, update); err != nil {
+		return err
+	}
+	_, err := collection.UpdateMany(ctx, bson.M{"actor_id": userID}, bson.M{"$set": bson.M{"actor_id": anonymous}})
+	return err
+}
+
+// ScheduleDeletion blocks the account until it is anonymized at the given time or restored
+func (r *userRepo) ScheduleDeletion(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$set": bson.M{"status": models.UserStatusPendingDeletion, "deletion_scheduled_at": at, "updated_at": time.Now()}}
+	return r.updateUser(ctx, userID, update)
+}
+
+// CancelDeletion restores an account whose deletion is still pending
+func (r *userRepo) CancelDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"status": models.UserStatusActive, "updated_at": time.Now()},
+		"$unset": bson.M{"deletion_scheduled_at": ""},
+	}
+	filter := bson.M{"_id": userID, "status": models.UserStatusPendingDeletion}
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindUsersDueForDeletion returns users whose grace period ended before now
+func (r *userRepo) FindUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": models.UserStatusPendingDeletion, "deletion_scheduled_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "deletion_scheduled_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.db.Collection("users").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// AnonymizeUser erases the personal data of a user whose deletion is due, the document stays so references resolve
+func (r *userRepo) AnonymizeUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"username":       "Deleted user",
+			"email":          "deleted-" + userID.Hex() + "@deleted.invalid",
+			"email_verified": false,
+			"phone_verified": false,
+			"status":         models.UserStatusDeleted,
+			"deleted_at":     now,
+			"updated_at":     now,
+		},
+		"$unset": bson.M{
+			"password":                "",
+			"address":                 "",
+			"phone":                   "",
+			"age":                     "",
+			"avatar":                  "",
+			"avatar_variants":         "",
+			"email_verified_at":       "",
+			"phone_verified_at":       "",
+			"linked_identities":       "",
+			"mfa":                     "",
+			"passkeys":                "",
+			"suspension":              "",
+			"password_reset_required": "",
+			"deletion_scheduled_at":   "",
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	filter := bson.M{"_id": userID, "status": models.UserStatusPendingDeletion}
+	result, err := r.db.Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// updateUser applies an update to one user, ErrNotFound when it does not exist
+func (r *userRepo) updateUser(ctx context.Context, userID primitive.ObjectID, update bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := r.db.Collection("users").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func NewUserRepo(db *mongo.Database) UserRepo {
 	return &userRepo{db: db}
 }
@@ -0,0 +1,699 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"user-service/core/models"
+)
+
+// memoryUserRepo keeps users in a map, for unit tests of the services. It enforces the same unique keys
+// and returns the same errors as the MongoDB repository, users are copied in and out so callers cannot
+// change stored users without going through the repository
+type memoryUserRepo struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]*models.User
+	logs  []models.UserActivityLog
+}
+
+func (r *memoryUserRepo) SaveUser(ctx context.Context, user *models.User) (*mongo.InsertOneResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// assign the ID up front so callers can reference the saved user
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.users[user.ID]; ok {
+		return nil, fmt.Errorf("user %s already exists", user.ID.Hex())
+	}
+	if r.findLocked(func(u *models.User) bool { return u.Email == user.Email }) != nil {
+		return nil, ErrEmailInUse
+	}
+	if user.PhoneVerified && r.findLocked(func(u *models.User) bool { return u.PhoneVerified && u.Phone == user.Phone }) != nil {
+		return nil, ErrPhoneInUse
+	}
+	for _, identity := range user.LinkedIdentities {
+		if r.identityOwnerLocked(identity.Provider, identity.Subject) != nil {
+			return nil, ErrIdentityInUse
+		}
+	}
+	for _, passkey := range user.Passkeys {
+		if r.passkeyOwnerLocked(passkey.ID) != nil {
+			return nil, ErrPasskeyExists
+		}
+	}
+
+	r.users[user.ID] = cloneUser(user)
+	return &mongo.InsertOneResult{InsertedID: user.ID}, nil
+}
+
+func (r *memoryUserRepo) SaveToActivityLog(ctx context.Context, activity *models.UserActivityLog) (*mongo.InsertOneResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if activity.ID.IsZero() {
+		activity.ID = primitive.NewObjectID()
+	}
+	if activity.ActivityTimestamp.IsZero() {
+		activity.ActivityTimestamp = time.Now().UTC()
+	}
+	r.logs = append(r.logs, cloneActivity(*activity))
+	return &mongo.InsertOneResult{InsertedID: activity.ID}, nil
+}
+
+func (r *memoryUserRepo) SaveActivityLogs(ctx context.Context, activities []models.UserActivityLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := make(map[primitive.ObjectID]bool, len(r.logs))
+	for _, log := range r.logs {
+		stored[log.ID] = true
+	}
+	for _, activity := range activities {
+		if activity.ID.IsZero() {
+			activity.ID = primitive.NewObjectID()
+		}
+		if activity.ActivityTimestamp.IsZero() {
+			activity.ActivityTimestamp = time.Now().UTC()
+		}
+		if stored[activity.ID] {
+			continue
+		}
+		stored[activity.ID] = true
+		r.logs = append(r.logs, cloneActivity(activity))
+	}
+	return nil
+}
+
+func (r *memoryUserRepo) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(func(u *models.User) bool { return u.Email == email })
+}
+
+func (r *memoryUserRepo) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return r.findOne(func(u *models.User) bool { return u.ID == objectID })
+}
+
+// FindUserByIdentity finds the user that has linked the given provider account
+func (r *memoryUserRepo) FindUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user := r.identityOwnerLocked(provider, subject); user != nil {
+		return cloneUser(user), nil
+	}
+	return nil, ErrNotFound
+}
+
+// AddLinkedIdentity links a provider identity, one identity per provider and user
+func (r *memoryUserRepo) AddLinkedIdentity(ctx context.Context, userID primitive.ObjectID, identity models.LinkedIdentity) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		for _, linked := range user.LinkedIdentities {
+			if linked.Provider == identity.Provider {
+				return ErrProviderAlreadyLinked
+			}
+		}
+		if r.identityOwnerLocked(identity.Provider, identity.Subject) != nil {
+			return ErrIdentityInUse
+		}
+		user.LinkedIdentities = append(user.LinkedIdentities, identity)
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// RemoveLinkedIdentity unlinks the user's identity at the given provider
+func (r *memoryUserRepo) RemoveLinkedIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	return r.update(userID, ErrIdentityNotLinked, func(user *models.User) error {
+		for i, linked := range user.LinkedIdentities {
+			if linked.Provider == provider {
+				user.LinkedIdentities = append(user.LinkedIdentities[:i], user.LinkedIdentities[i+1:]...)
+				user.UpdatedAt = time.Now()
+				return nil
+			}
+		}
+		return ErrIdentityNotLinked
+	})
+}
+
+// UpdateMFA replaces the MFA settings of a user
+func (r *memoryUserRepo) UpdateMFA(ctx context.Context, userID primitive.ObjectID, mfa models.MFASettings) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.MFA = mfa
+		user.MFA.RecoveryCodes = append([]string(nil), mfa.RecoveryCodes...)
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// MarkTOTPStepUsed records the time step of an accepted code, it reports false when
+// that step (or a later one) was already used so a code cannot be replayed
+func (r *memoryUserRepo) MarkTOTPStepUsed(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	marked := false
+	err := r.update(userID, nil, func(user *models.User) error {
+		if user.MFA.TOTPLastStep < step {
+			user.MFA.TOTPLastStep = step
+			marked = true
+		}
+		return nil
+	})
+	return marked, err
+}
+
+// ConsumeRecoveryCode removes a recovery code hash, it reports false when the code was not found
+func (r *memoryUserRepo) ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	consumed := false
+	err := r.update(userID, nil, func(user *models.User) error {
+		var kept []string
+		for _, code := range user.MFA.RecoveryCodes {
+			if code == codeHash {
+				consumed = true
+				continue
+			}
+			kept = append(kept, code)
+		}
+		user.MFA.RecoveryCodes = kept
+		return nil
+	})
+	return consumed, err
+}
+
+// AddPasskey registers a WebAuthn credential for the user
+func (r *memoryUserRepo) AddPasskey(ctx context.Context, userID primitive.ObjectID, passkey models.Passkey) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		if r.passkeyOwnerLocked(passkey.ID) != nil {
+			return ErrPasskeyExists
+		}
+		user.Passkeys = append(user.Passkeys, passkey)
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// UpdatePasskeyUsage stores the counters reported by the authenticator after an assertion
+func (r *memoryUserRepo) UpdatePasskeyUsage(ctx context.Context, userID primitive.ObjectID, credentialID string, signCount uint32, backupState, cloneWarning bool) error {
+	return r.update(userID, ErrPasskeyNotFound, func(user *models.User) error {
+		for i := range user.Passkeys {
+			if user.Passkeys[i].ID == credentialID {
+				now := time.Now()
+				user.Passkeys[i].SignCount = signCount
+				user.Passkeys[i].BackupState = backupState
+				user.Passkeys[i].CloneWarning = cloneWarning
+				user.Passkeys[i].LastUsedAt = &now
+				return nil
+			}
+		}
+		return ErrPasskeyNotFound
+	})
+}
+
+// RemovePasskey deletes a WebAuthn credential from the user
+func (r *memoryUserRepo) RemovePasskey(ctx context.Context, userID primitive.ObjectID, credentialID string) error {
+	return r.update(userID, ErrPasskeyNotFound, func(user *models.User) error {
+		for i := range user.Passkeys {
+			if user.Passkeys[i].ID == credentialID {
+				user.Passkeys = append(user.Passkeys[:i], user.Passkeys[i+1:]...)
+				user.UpdatedAt = time.Now()
+				return nil
+			}
+		}
+		return ErrPasskeyNotFound
+	})
+}
+
+// UpdatePassword replaces the password hash of the user
+func (r *memoryUserRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.Password = passwordHash
+		user.PasswordResetRequired = false
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// RehashPassword swaps the hash of an unchanged password for one with current parameters, it reports false when
+// the password was changed since currentHash was read. A forced reset stays required
+func (r *memoryUserRepo) RehashPassword(ctx context.Context, userID primitive.ObjectID, currentHash, newHash string) (bool, error) {
+	rehashed := false
+	err := r.update(userID, nil, func(user *models.User) error {
+		if user.Password == currentHash {
+			user.Password = newHash
+			rehashed = true
+		}
+		return nil
+	})
+	return rehashed, err
+}
+
+// MarkEmailVerified verifies the user's email, only while it is still the given address
+func (r *memoryUserRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		if user.Email != email {
+			return ErrNotFound
+		}
+		now := time.Now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		user.Version++
+		return nil
+	})
+}
+
+// FindUserByVerifiedPhone returns the account that verified the phone number, ErrNotFound when there is none
+func (r *memoryUserRepo) FindUserByVerifiedPhone(ctx context.Context, phone string) (*models.User, error) {
+	return r.findOne(func(u *models.User) bool { return u.PhoneVerified && u.Phone == phone })
+}
+
+// SetVerifiedPhone replaces the user's phone with a number that has just been verified
+func (r *memoryUserRepo) SetVerifiedPhone(ctx context.Context, userID primitive.ObjectID, phone string) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		owner := r.findLocked(func(u *models.User) bool { return u.PhoneVerified && u.Phone == phone })
+		if owner != nil && owner.ID != userID {
+			return ErrPhoneInUse
+		}
+		now := time.Now()
+		user.Phone = phone
+		user.PhoneVerified = true
+		user.PhoneVerifiedAt = &now
+		user.UpdatedAt = now
+		user.Version++
+		return nil
+	})
+}
+
+// ListUsers returns one page of users matching the filter, newest first, with the total number of matches
+func (r *memoryUserRepo) ListUsers(ctx context.Context, filter UserFilter, page, limit int) ([]models.User, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	var matches []*models.User
+	for _, user := range r.users {
+		if query != "" && !strings.Contains(strings.ToLower(user.Email), query) && !strings.Contains(strings.ToLower(user.Username), query) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		suspended := user.Status == models.UserStatusSuspended
+		if (filter.Status == models.UserStatusSuspended && !suspended) || (filter.Status == models.UserStatusActive && suspended) {
+			continue
+		}
+		if filter.EmailVerified != nil && user.EmailVerified != *filter.EmailVerified {
+			continue
+		}
+		matches = append(matches, user)
+	}
+	sortNewestFirst(matches)
+
+	users := []models.User{}
+	for i := (page - 1) * limit; i >= 0 && i < len(matches) && len(users) < limit; i++ {
+		users = append(users, *cloneUser(matches[i]))
+	}
+	return users, int64(len(matches)), nil
+}
+
+// UpdateRole changes the role of a user
+func (r *memoryUserRepo) UpdateRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.Role = role
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// SetSuspension suspends the user, a nil suspension reactivates it
+func (r *memoryUserRepo) SetSuspension(ctx context.Context, userID primitive.ObjectID, suspension *models.Suspension) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.Status, user.Suspension = models.UserStatusActive, nil
+		if suspension != nil {
+			copied := *suspension
+			user.Status, user.Suspension = models.UserStatusSuspended, &copied
+		}
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// SetPasswordResetRequired makes password logins fail until the user chose a new password
+func (r *memoryUserRepo) SetPasswordResetRequired(ctx context.Context, userID primitive.ObjectID, required bool) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.PasswordResetRequired = required
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// DeleteUser removes the user, the activity log is kept for auditing
+func (r *memoryUserRepo) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, userID)
+	return nil
+}
+
+// UpdateUser saves the profile fields of user if nobody changed it since it was read, then increments its version
+func (r *memoryUserRepo) UpdateUser(ctx context.Context, user *models.User) error {
+	now := time.Now()
+	err := r.update(user.ID, ErrNotFound, func(stored *models.User) error {
+		if stored.Version != user.Version {
+			return ErrVersionConflict
+		}
+		if owner := r.findLocked(func(u *models.User) bool { return u.Email == user.Email }); owner != nil && owner.ID != user.ID {
+			return ErrEmailInUse
+		}
+		if user.PhoneVerified {
+			owner := r.findLocked(func(u *models.User) bool { return u.PhoneVerified && u.Phone == user.Phone })
+			if owner != nil && owner.ID != user.ID {
+				return ErrPhoneInUse
+			}
+		}
+
+		profile := cloneUser(user)
+		stored.Username = profile.Username
+		stored.Email = profile.Email
+		stored.EmailVerified = profile.EmailVerified
+		stored.EmailVerifiedAt = profile.EmailVerifiedAt
+		stored.Address = profile.Address
+		stored.Phone = profile.Phone
+		stored.PhoneVerified = profile.PhoneVerified
+		stored.PhoneVerifiedAt = profile.PhoneVerifiedAt
+		stored.Age = profile.Age
+		stored.Avatar = profile.Avatar
+		stored.AvatarVariants = profile.AvatarVariants
+		stored.UpdatedAt = now
+		stored.Version++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+	return nil
+}
+
+// FindActivityLogs returns the activity of the user, oldest first
+func (r *memoryUserRepo) FindActivityLogs(ctx context.Context, userID primitive.ObjectID) ([]models.UserActivityLog, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logs := []models.UserActivityLog{}
+	for _, log := range r.logs {
+		if log.UserID == userID {
+			logs = append(logs, cloneActivity(log))
+		}
+	}
+	sortOldestFirst(logs)
+	return logs, nil
+}
+
+// ListActivityLogs returns one page of the audit trail, newest first
+func (r *memoryUserRepo) ListActivityLogs(ctx context.Context, filter ActivityFilter, page, limit int) ([]models.UserActivityLog, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []models.UserActivityLog
+	for _, log := range r.logs {
+		switch {
+		case !filter.UserID.IsZero() && log.UserID != filter.UserID:
+		case !filter.ActorID.IsZero() && log.ActorID != filter.ActorID:
+		case filter.Action != "" && log.Action != filter.Action:
+		case filter.From != nil && log.ActivityTimestamp.Before(*filter.From):
+		case filter.To != nil && !log.ActivityTimestamp.Before(*filter.To):
+		default:
+			matches = append(matches, log)
+		}
+	}
+	sortOldestFirst(matches)
+
+	logs := []models.UserActivityLog{}
+	for i := len(matches) - 1 - (page-1)*limit; i >= 0 && len(logs) < limit; i-- {
+		logs = append(logs, cloneActivity(matches[i]))
+	}
+	return logs, int64(len(matches)), nil
+}
+
+// PurgeActivityLogs removes the entries older than before
+func (r *memoryUserRepo) PurgeActivityLogs(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.logs[:0]
+	for _, log := range r.logs {
+		if !log.ActivityTimestamp.Before(before) {
+			kept = append(kept, log)
+		}
+	}
+	purged := int64(len(r.logs) - len(kept))
+	r.logs = kept
+	return purged, nil
+}
+
+// AnonymizeActivityLogs moves the activity of the user to a random ID that leads back to nobody,
+// without the network details and the metadata of the entries, which can hold an email or an IP
+func (r *memoryUserRepo) AnonymizeActivityLogs(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	anonymous := primitive.NewObjectID()
+	for i := range r.logs {
+		if r.logs[i].UserID == userID {
+			r.logs[i].UserID = anonymous
+			r.logs[i].IP = ""
+			r.logs[i].UserAgent = ""
+			r.logs[i].Metadata = nil
+		}
+		if r.logs[i].ActorID == userID {
+			r.logs[i].ActorID = anonymous
+		}
+	}
+	return nil
+}
+
+// ScheduleDeletion blocks the account until it is anonymized at the given time or restored
+func (r *memoryUserRepo) ScheduleDeletion(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		user.Status = models.UserStatusPendingDeletion
+		user.DeletionScheduledAt = &at
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// CancelDeletion restores an account whose deletion is still pending
+func (r *memoryUserRepo) CancelDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		if user.Status != models.UserStatusPendingDeletion {
+			return ErrNotFound
+		}
+		user.Status = models.UserStatusActive
+		user.DeletionScheduledAt = nil
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// FindUsersDueForDeletion returns users whose grace period ended before now
+func (r *memoryUserRepo) FindUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []*models.User
+	for _, user := range r.users {
+		if user.Status == models.UserStatusPendingDeletion && user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now) {
+			due = append(due, user)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].DeletionScheduledAt.Before(*due[j].DeletionScheduledAt) })
+
+	users := []models.User{}
+	for i := 0; i < len(due) && i < limit; i++ {
+		users = append(users, *cloneUser(due[i]))
+	}
+	return users, nil
+}
+
+// AnonymizeUser erases the personal data of a user whose deletion is due, the user stays so references resolve
+func (r *memoryUserRepo) AnonymizeUser(ctx context.Context, userID primitive.ObjectID) error {
+	return r.update(userID, ErrNotFound, func(user *models.User) error {
+		if user.Status != models.UserStatusPendingDeletion {
+			return ErrNotFound
+		}
+		now := time.Now()
+		*user = models.User{
+			ID:        user.ID,
+			Username:  "Deleted user",
+			Email:     "deleted-" + userID.Hex() + "@deleted.invalid",
+			Role:      user.Role,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: now,
+			Status:    models.UserStatusDeleted,
+			DeletedAt: &now,
+			Version:   user.Version + 1,
+		}
+		return nil
+	})
+}
+
+// update runs fn on the stored user under the lock, notFound when the user does not exist.
+// Changes are only kept when fn succeeds
+func (r *memoryUserRepo) update(userID primitive.ObjectID, notFound error, fn func(user *models.User) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userID]
+	if !ok {
+		return notFound
+	}
+	user := cloneUser(stored)
+	if err := fn(user); err != nil {
+		return err
+	}
+	r.users[userID] = user
+	return nil
+}
+
+// find returns a copy of the first user matching, nil when there is none
+func (r *memoryUserRepo) find(match func(user *models.User) bool) *models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user := r.findLocked(match); user != nil {
+		return cloneUser(user)
+	}
+	return nil
+}
+
+// findOne is find for the finders, ErrNotFound when no user matches
+func (r *memoryUserRepo) findOne(match func(user *models.User) bool) (*models.User, error) {
+	if user := r.find(match); user != nil {
+		return user, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUserRepo) findLocked(match func(user *models.User) bool) *models.User {
+	for _, user := range r.users {
+		if match(user) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepo) identityOwnerLocked(provider, subject string) *models.User {
+	return r.findLocked(func(u *models.User) bool {
+		for _, identity := range u.LinkedIdentities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *memoryUserRepo) passkeyOwnerLocked(credentialID string) *models.User {
+	return r.findLocked(func(u *models.User) bool {
+		for _, passkey := range u.Passkeys {
+			if passkey.ID == credentialID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// sortNewestFirst orders users like ListUsers in MongoDB, by creation time then ID, both descending
+func sortNewestFirst(users []*models.User) {
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID.Hex() > users[j].ID.Hex()
+	})
+}
+
+// sortOldestFirst orders activity by timestamp then ID
+func sortOldestFirst(logs []models.UserActivityLog) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		if !logs[i].ActivityTimestamp.Equal(logs[j].ActivityTimestamp) {
+			return logs[i].ActivityTimestamp.Before(logs[j].ActivityTimestamp)
+		}
+		return logs[i].ID.Hex() < logs[j].ID.Hex()
+	})
+}
+
+// cloneActivity copies the metadata of an entry, so callers cannot change the stored one
+func cloneActivity(log models.UserActivityLog) models.UserActivityLog {
+	if log.Metadata != nil {
+		metadata := make(map[string]string, len(log.Metadata))
+		for key, value := range log.Metadata {
+			metadata[key] = value
+		}
+		log.Metadata = metadata
+	}
+	return log
+}
+
+// cloneUser deep copies the slices, maps and pointers of a user
+func cloneUser(user *models.User) *models.User {
+	copied := *user
+	copied.EmailVerifiedAt = cloneTime(user.EmailVerifiedAt)
+	copied.PhoneVerifiedAt = cloneTime(user.PhoneVerifiedAt)
+	copied.DeletionScheduledAt = cloneTime(user.DeletionScheduledAt)
+	copied.DeletedAt = cloneTime(user.DeletedAt)
+	copied.MFA.EnrolledAt = cloneTime(user.MFA.EnrolledAt)
+	copied.MFA.RecoveryCodes = append([]string(nil), user.MFA.RecoveryCodes...)
+	copied.LinkedIdentities = append([]models.LinkedIdentity(nil), user.LinkedIdentities...)
+
+	if user.AvatarVariants != nil {
+		copied.AvatarVariants = make(map[string]string, len(user.AvatarVariants))
+		for size, url := range user.AvatarVariants {
+			copied.AvatarVariants[size] = url
+		}
+	}
+	if user.Suspension != nil {
+		suspension := *user.Suspension
+		suspension.Until = cloneTime(user.Suspension.Until)
+		copied.Suspension = &suspension
+	}
+
+	copied.Passkeys = nil
+	for _, passkey := range user.Passkeys {
+		passkey.PublicKey = append([]byte(nil), passkey.PublicKey...)
+		passkey.AAGUID = append([]byte(nil), passkey.AAGUID...)
+		passkey.Transports = append([]string(nil), passkey.Transports...)
+		passkey.LastUsedAt = cloneTime(passkey.LastUsedAt)
+		copied.Passkeys = append(copied.Passkeys, passkey)
+	}
+	return &copied
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// NewMemoryUserRepo keeps users in memory, for tests that do not need a database
+func NewMemoryUserRepo() UserRepo {
+	return &memoryUserRepo{users: map[primitive.ObjectID]*models.User{}}
+}
@@ -0,0 +1,19 @@
+package repository_test
+
+import (
+	"testing"
+	"user-service/core/repository"
+	"user-service/core/repository/repotest"
+)
+
+func TestMemoryUserRepo(t *testing.T) {
+	repotest.UserRepo(t, func(t *testing.T) repository.UserRepo {
+		return repository.NewMemoryUserRepo()
+	})
+}
+
+func TestMemoryUserActivityLogRepo(t *testing.T) {
+	repotest.UserActivityLogRepo(t, func(t *testing.T) repository.UserActivityLogRepo {
+		return repository.NewMemoryUserActivityLogRepo()
+	})
+}
@@ -0,0 +1,59 @@
+package repository_test
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"testing"
+	"time"
+	"user-service/core/repository"
+	"user-service/core/repository/repotest"
+	"user-service/database/migrations"
+)
+
+// openMongo connects to MONGO_TEST_URI and applies the migrations to MONGO_TEST_DB, user_service_test by default, e.g.
+//
+//	docker run -p 27017:27017 mongo:7
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test ./core/repository/
+//
+// The contract saves users with unique emails, so the database can be reused between runs
+func openMongo(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	name := os.Getenv("MONGO_TEST_DB")
+	if name == "" {
+		name = "user_service_test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	db := client.Database(name)
+	if _, err := migrations.Up(ctx, db); err != nil {
+		t.Fatalf("migrate MongoDB: %v", err)
+	}
+	return db
+}
+
+func TestMongoUserRepo(t *testing.T) {
+	db := openMongo(t)
+	repotest.UserRepo(t, func(t *testing.T) repository.UserRepo {
+		return repository.NewUserRepo(db)
+	})
+}
+
+func TestMongoUserActivityLogRepo(t *testing.T) {
+	db := openMongo(t)
+	repotest.UserActivityLogRepo(t, func(t *testing.T) repository.UserActivityLogRepo {
+		return repository.NewUserActivityLogRepo(db)
+	})
+}
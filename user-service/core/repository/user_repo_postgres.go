@@ -0,0 +1,887 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+	"user-service/core/models"
+)
+
+// userColumns are the columns of users in the order scanUser reads them
+const userColumns = `id, username, email, email_verified, email_verified_at, password, address, phone, phone_verified,
+	phone_verified_at, age, avatar, avatar_variants, role, created_at, updated_at, mfa_totp_enabled, mfa_totp_secret,
+	mfa_totp_last_step, mfa_recovery_codes, mfa_enrolled_at, status, suspension, password_reset_required,
+	deletion_scheduled_at, deleted_at, version`
+
+// postgresUserRepo keeps users in Postgres, it returns the same repository errors as the MongoDB repository
+// so services work with either
+type postgresUserRepo struct {
+	db *sql.DB
+}
+
+func (r *postgresUserRepo) SaveUser(ctx context.Context, user *models.User) (*mongo.InsertOneResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// assign the ID up front so callers can reference the saved user
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	avatarVariants, err := jsonColumn(user.AvatarVariants)
+	if err != nil {
+		return nil, err
+	}
+	suspension, err := jsonColumn(user.Suspension)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO users (`+userColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)`,
+			user.ID.Hex(), user.Username, user.Email, user.EmailVerified, user.EmailVerifiedAt, user.Password, user.Address,
+			user.Phone, user.PhoneVerified, user.PhoneVerifiedAt, user.Age, user.Avatar, avatarVariants, user.Role,
+			nullTime(user.CreatedAt), nullTime(user.UpdatedAt), user.MFA.TOTPEnabled, user.MFA.TOTPSecret, user.MFA.TOTPLastStep,
+			pq.Array(nonNil(user.MFA.RecoveryCodes)), user.MFA.EnrolledAt, user.Status, suspension, user.PasswordResetRequired,
+			user.DeletionScheduledAt, user.DeletedAt, user.Version)
+		if err != nil {
+			return userWriteError(err)
+		}
+
+		for _, identity := range user.LinkedIdentities {
+			if err := insertIdentity(ctx, tx, user.ID, identity); err != nil {
+				return err
+			}
+		}
+		for _, passkey := range user.Passkeys {
+			if err := insertPasskey(ctx, tx, user.ID, passkey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: user.ID}, nil
+}
+
+func (r *postgresUserRepo) SaveToActivityLog(ctx context.Context, activity *models.UserActivityLog) (*mongo.InsertOneResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := insertActivity(ctx, conn(ctx, r.db), activity); err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: activity.ID}, nil
+}
+
+// SaveActivityLogs inserts a batch of entries in one statement, entries whose ID is already stored are skipped
+func (r *postgresUserRepo) SaveActivityLogs(ctx context.Context, activities []models.UserActivityLog) error {
+	if len(activities) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows := make([]string, 0, len(activities))
+	args := make([]interface{}, 0, len(activities)*9)
+	for i := range activities {
+		values, err := activityValues(&activities[i])
+		if err != nil {
+			return err
+		}
+		placeholders := make([]string, len(values))
+		for j := range values {
+			placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, values...)
+	}
+
+	_, err := conn(ctx, r.db).ExecContext(ctx, `INSERT INTO user_activity_log (`+activityColumns+`)
+		VALUES `+strings.Join(rows, ", ")+`
+		ON CONFLICT (id) DO NOTHING`, args...)
+	return err
+}
+
+func (r *postgresUserRepo) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findUser(ctx, `email = $1`, email)
+}
+
+func (r *postgresUserRepo) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	// no user has an ID that is not an ObjectID
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return r.findUser(ctx, `id = $1`, objectID.Hex())
+}
+
+// FindUserByIdentity finds the user that has linked the given provider account
+func (r *postgresUserRepo) FindUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.findUser(ctx, `id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)`, provider, subject)
+}
+
+// AddLinkedIdentity links a provider identity, one identity per provider and user
+func (r *postgresUserRepo) AddLinkedIdentity(ctx context.Context, userID primitive.ObjectID, identity models.LinkedIdentity) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := insertIdentity(ctx, tx, userID, identity); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, userID)
+	})
+}
+
+// RemoveLinkedIdentity unlinks the user's identity at the given provider
+func (r *postgresUserRepo) RemoveLinkedIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`, userID.Hex(), provider)
+		if err := affectedOr(result, err, ErrIdentityNotLinked); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, userID)
+	})
+}
+
+// UpdateMFA replaces the MFA settings of a user
+func (r *postgresUserRepo) UpdateMFA(ctx context.Context, userID primitive.ObjectID, mfa models.MFASettings) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET mfa_totp_enabled = $2, mfa_totp_secret = $3, mfa_totp_last_step = $4,
+		mfa_recovery_codes = $5, mfa_enrolled_at = $6, updated_at = $7 WHERE id = $1`,
+		userID.Hex(), mfa.TOTPEnabled, mfa.TOTPSecret, mfa.TOTPLastStep, pq.Array(nonNil(mfa.RecoveryCodes)), mfa.EnrolledAt, time.Now())
+}
+
+// MarkTOTPStepUsed records the time step of an accepted code, it reports false when
+// that step (or a later one) was already used so a code cannot be replayed
+func (r *postgresUserRepo) MarkTOTPStepUsed(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	return r.execChanged(ctx, `UPDATE users SET mfa_totp_last_step = $2 WHERE id = $1 AND mfa_totp_last_step < $2`, userID.Hex(), step)
+}
+
+// ConsumeRecoveryCode removes a recovery code hash, it reports false when the code was not found
+func (r *postgresUserRepo) ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	return r.execChanged(ctx, `UPDATE users SET mfa_recovery_codes = array_remove(mfa_recovery_codes, $2)
+		WHERE id = $1 AND $2 = ANY (mfa_recovery_codes)`, userID.Hex(), codeHash)
+}
+
+// AddPasskey registers a WebAuthn credential for the user
+func (r *postgresUserRepo) AddPasskey(ctx context.Context, userID primitive.ObjectID, passkey models.Passkey) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := insertPasskey(ctx, tx, userID, passkey); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, userID)
+	})
+}
+
+// UpdatePasskeyUsage stores the counters reported by the authenticator after an assertion
+func (r *postgresUserRepo) UpdatePasskeyUsage(ctx context.Context, userID primitive.ObjectID, credentialID string, signCount uint32, backupState, cloneWarning bool) error {
+	return r.exec(ctx, ErrPasskeyNotFound, `UPDATE user_passkeys SET sign_count = $3, backup_state = $4, clone_warning = $5,
+		last_used_at = $6 WHERE user_id = $1 AND id = $2`,
+		userID.Hex(), credentialID, int64(signCount), backupState, cloneWarning, time.Now())
+}
+
+// RemovePasskey deletes a WebAuthn credential from the user
+func (r *postgresUserRepo) RemovePasskey(ctx context.Context, userID primitive.ObjectID, credentialID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM user_passkeys WHERE user_id = $1 AND id = $2`, userID.Hex(), credentialID)
+		if err := affectedOr(result, err, ErrPasskeyNotFound); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, userID)
+	})
+}
+
+// UpdatePassword replaces the password hash of the user
+func (r *postgresUserRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET password = $2, password_reset_required = FALSE, updated_at = $3
+		WHERE id = $1`, userID.Hex(), passwordHash, time.Now())
+}
+
+// RehashPassword swaps the hash of an unchanged password for one with current parameters, it reports false when
+// the password was changed since currentHash was read. A forced reset stays required
+func (r *postgresUserRepo) RehashPassword(ctx context.Context, userID primitive.ObjectID, currentHash, newHash string) (bool, error) {
+	return r.execChanged(ctx, `UPDATE users SET password = $3 WHERE id = $1 AND password = $2`, userID.Hex(), currentHash, newHash)
+}
+
+// MarkEmailVerified verifies the user's email, only while it is still the given address
+func (r *postgresUserRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) error {
+	now := time.Now()
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET email_verified = TRUE, email_verified_at = $3, updated_at = $3,
+		version = version + 1 WHERE id = $1 AND email = $2`, userID.Hex(), email, now)
+}
+
+// FindUserByVerifiedPhone returns the account that verified the phone number, ErrNotFound when there is none
+func (r *postgresUserRepo) FindUserByVerifiedPhone(ctx context.Context, phone string) (*models.User, error) {
+	return r.findUser(ctx, `phone = $1 AND phone_verified`, phone)
+}
+
+// SetVerifiedPhone replaces the user's phone with a number that has just been verified
+func (r *postgresUserRepo) SetVerifiedPhone(ctx context.Context, userID primitive.ObjectID, phone string) error {
+	now := time.Now()
+	err := r.exec(ctx, ErrNotFound, `UPDATE users SET phone = $2, phone_verified = TRUE, phone_verified_at = $3,
+		updated_at = $3, version = version + 1 WHERE id = $1`, userID.Hex(), phone, now)
+	return userWriteError(err)
+}
+
+// ListUsers returns one page of users matching the filter, newest first, with the total number of matches
+func (r *postgresUserRepo) ListUsers(ctx context.Context, filter UserFilter, page, limit int) ([]models.User, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Query != "" {
+		pattern := arg("%" + escapeLike(filter.Query) + "%")
+		conditions = append(conditions, "(email ILIKE "+pattern+" OR username ILIKE "+pattern+")")
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "role = "+arg(filter.Role))
+	}
+	switch filter.Status {
+	case models.UserStatusSuspended:
+		conditions = append(conditions, "status = "+arg(models.UserStatusSuspended))
+	case models.UserStatusActive:
+		conditions = append(conditions, "status <> "+arg(models.UserStatusSuspended))
+	}
+	if filter.EmailVerified != nil {
+		conditions = append(conditions, "email_verified = "+arg(*filter.EmailVerified))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := conn(ctx, r.db).QueryRowContext(ctx, `SELECT count(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users` + where +
+		` ORDER BY created_at DESC NULLS LAST, id DESC LIMIT ` + arg(limit) + ` OFFSET ` + arg((page-1)*limit)
+	users, err := r.queryUsers(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// UpdateRole changes the role of a user
+func (r *postgresUserRepo) UpdateRole(ctx context.Context, userID primitive.ObjectID, role string) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET role = $2, updated_at = $3 WHERE id = $1`, userID.Hex(), role, time.Now())
+}
+
+// SetSuspension suspends the user, a nil suspension reactivates it
+func (r *postgresUserRepo) SetSuspension(ctx context.Context, userID primitive.ObjectID, suspension *models.Suspension) error {
+	status := models.UserStatusActive
+	if suspension != nil {
+		status = models.UserStatusSuspended
+	}
+	value, err := jsonColumn(suspension)
+	if err != nil {
+		return err
+	}
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET status = $2, suspension = $3, updated_at = $4 WHERE id = $1`,
+		userID.Hex(), status, value, time.Now())
+}
+
+// SetPasswordResetRequired makes password logins fail until the user chose a new password
+func (r *postgresUserRepo) SetPasswordResetRequired(ctx context.Context, userID primitive.ObjectID, required bool) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET password_reset_required = $2, updated_at = $3 WHERE id = $1`,
+		userID.Hex(), required, time.Now())
+}
+
+// DeleteUser removes the user row with its identities and passkeys, the activity log is kept for auditing
+func (r *postgresUserRepo) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	return r.exec(ctx, ErrNotFound, `DELETE FROM users WHERE id = $1`, userID.Hex())
+}
+
+// UpdateUser saves the profile fields of user if nobody changed it since it was read, then increments its version.
+// Every other writer of these fields increments the version too, otherwise its write could be overwritten here
+func (r *postgresUserRepo) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	avatarVariants, err := jsonColumn(user.AvatarVariants)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := conn(ctx, r.db).ExecContext(ctx, `UPDATE users SET username = $3, email = $4, email_verified = $5, email_verified_at = $6,
+		address = $7, phone = $8, phone_verified = $9, phone_verified_at = $10, age = $11, avatar = $12, avatar_variants = $13,
+		updated_at = $14, version = version + 1 WHERE id = $1 AND version = $2`,
+		user.ID.Hex(), user.Version, user.Username, user.Email, user.EmailVerified, user.EmailVerifiedAt, user.Address,
+		user.Phone, user.PhoneVerified, user.PhoneVerifiedAt, user.Age, user.Avatar, avatarVariants, now)
+	if err != nil {
+		return userWriteError(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		if err != nil {
+			return err
+		}
+		var exists bool
+		if err := conn(ctx, r.db).QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, user.ID.Hex()).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrVersionConflict
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+	return nil
+}
+
+// FindActivityLogs returns the activity of the user, oldest first
+func (r *postgresUserRepo) FindActivityLogs(ctx context.Context, userID primitive.ObjectID) ([]models.UserActivityLog, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return queryActivity(ctx, conn(ctx, r.db), `SELECT `+activityColumns+` FROM user_activity_log
+		WHERE user_id = $1 ORDER BY activity_timestamp, id`, userID.Hex())
+}
+
+// ListActivityLogs returns one page of the audit trail, newest first
+func (r *postgresUserRepo) ListActivityLogs(ctx context.Context, filter ActivityFilter, page, limit int) ([]models.UserActivityLog, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !filter.UserID.IsZero() {
+		conditions = append(conditions, "user_id = "+arg(filter.UserID.Hex()))
+	}
+	if !filter.ActorID.IsZero() {
+		conditions = append(conditions, "actor_id = "+arg(filter.ActorID.Hex()))
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = "+arg(filter.Action))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "activity_timestamp >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "activity_timestamp < "+arg(*filter.To))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := conn(ctx, r.db).QueryRowContext(ctx, `SELECT count(*) FROM user_activity_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + activityColumns + ` FROM user_activity_log` + where +
+		` ORDER BY activity_timestamp DESC, id DESC LIMIT ` + arg(limit) + ` OFFSET ` + arg((page-1)*limit)
+	logs, err := queryActivity(ctx, conn(ctx, r.db), query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// PurgeActivityLogs removes the entries older than before, Postgres has no TTL so the service calls it periodically
+func (r *postgresUserRepo) PurgeActivityLogs(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := conn(ctx, r.db).ExecContext(ctx, `DELETE FROM user_activity_log WHERE activity_timestamp < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AnonymizeActivityLogs moves the activity of the user to a random ID that leads back to nobody,
+// without the network details and the metadata of the entries, which can hold an email or an IP
+func (r *postgresUserRepo) AnonymizeActivityLogs(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		anonymous := primitive.NewObjectID().Hex()
+		_, err := tx.ExecContext(ctx, `UPDATE user_activity_log SET user_id = $2, ip = '', user_agent = '', metadata = NULL WHERE user_id = $1`,
+			userID.Hex(), anonymous)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE user_activity_log SET actor_id = $2 WHERE actor_id = $1`, userID.Hex(), anonymous)
+		return err
+	})
+}
+
+// ScheduleDeletion blocks the account until it is anonymized at the given time or restored
+func (r *postgresUserRepo) ScheduleDeletion(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET status = $2, deletion_scheduled_at = $3, updated_at = $4 WHERE id = $1`,
+		userID.Hex(), models.UserStatusPendingDeletion, at, time.Now())
+}
+
+// CancelDeletion restores an account whose deletion is still pending
+func (r *postgresUserRepo) CancelDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	return r.exec(ctx, ErrNotFound, `UPDATE users SET status = $3, deletion_scheduled_at = NULL, updated_at = $4
+		WHERE id = $1 AND status = $2`, userID.Hex(), models.UserStatusPendingDeletion, models.UserStatusActive, time.Now())
+}
+
+// FindUsersDueForDeletion returns users whose grace period ended before now
+func (r *postgresUserRepo) FindUsersDueForDeletion(ctx context.Context, now time.Time, limit int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return r.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE status = $1 AND deletion_scheduled_at <= $2
+		ORDER BY deletion_scheduled_at LIMIT $3`, models.UserStatusPendingDeletion, now, limit)
+}
+
+// AnonymizeUser erases the personal data of a user whose deletion is due, the row stays so references resolve
+func (r *postgresUserRepo) AnonymizeUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		result, err := tx.ExecContext(ctx, `UPDATE users SET username = 'Deleted user', email = $3, email_verified = FALSE,
+			email_verified_at = NULL, password = '', address = '', phone = '', phone_verified = FALSE, phone_verified_at = NULL,
+			age = 0, avatar = '', avatar_variants = NULL, mfa_totp_enabled = FALSE, mfa_totp_secret = '', mfa_totp_last_step = 0,
+			mfa_recovery_codes = '{}', mfa_enrolled_at = NULL, status = $4, suspension = NULL, password_reset_required = FALSE,
+			deletion_scheduled_at = NULL, deleted_at = $5, updated_at = $5, version = version + 1
+			WHERE id = $1 AND status = $2`,
+			userID.Hex(), models.UserStatusPendingDeletion, "deleted-"+userID.Hex()+"@deleted.invalid", models.UserStatusDeleted, now)
+		if err := affectedOr(result, err, ErrNotFound); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1`, userID.Hex()); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM user_passkeys WHERE user_id = $1`, userID.Hex())
+		return err
+	})
+}
+
+// findUser returns the one user matching the condition, ErrNotFound when there is none
+func (r *postgresUserRepo) findUser(ctx context.Context, condition string, args ...interface{}) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	users, err := r.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE `+condition+` LIMIT 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// queryUsers reads the users of a query selecting userColumns, with their identities and passkeys
+func (r *postgresUserRepo) queryUsers(ctx context.Context, query string, args ...interface{}) ([]models.User, error) {
+	rows, err := conn(ctx, r.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return users, nil
+	}
+
+	if err := r.loadIdentities(ctx, users); err != nil {
+		return nil, err
+	}
+	if err := r.loadPasskeys(ctx, users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *postgresUserRepo) loadIdentities(ctx context.Context, users []models.User) error {
+	byID := usersByID(users)
+	rows, err := conn(ctx, r.db).QueryContext(ctx, `SELECT user_id, provider, subject, email, linked_at FROM user_identities
+		WHERE user_id = ANY ($1) ORDER BY linked_at, provider`, pq.Array(userIDs(users)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var identity models.LinkedIdentity
+		if err := rows.Scan(&userID, &identity.Provider, &identity.Subject, &identity.Email, &identity.LinkedAt); err != nil {
+			return err
+		}
+		user := byID[userID]
+		user.LinkedIdentities = append(user.LinkedIdentities, identity)
+	}
+	return rows.Err()
+}
+
+func (r *postgresUserRepo) loadPasskeys(ctx context.Context, users []models.User) error {
+	byID := usersByID(users)
+	rows, err := conn(ctx, r.db).QueryContext(ctx, `SELECT user_id, id, name, public_key, attestation_type, transports, aaguid, sign_count,
+		backup_eligible, backup_state, clone_warning, created_at, last_used_at FROM user_passkeys
+		WHERE user_id = ANY ($1) ORDER BY created_at, id`, pq.Array(userIDs(users)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var passkey models.Passkey
+		var signCount int64
+		var lastUsedAt sql.NullTime
+		err := rows.Scan(&userID, &passkey.ID, &passkey.Name, &passkey.PublicKey, &passkey.AttestationType,
+			pq.Array(&passkey.Transports), &passkey.AAGUID, &signCount, &passkey.BackupEligible, &passkey.BackupState,
+			&passkey.CloneWarning, &passkey.CreatedAt, &lastUsedAt)
+		if err != nil {
+			return err
+		}
+		passkey.SignCount = uint32(signCount)
+		passkey.LastUsedAt = timePtr(lastUsedAt)
+		user := byID[userID]
+		user.Passkeys = append(user.Passkeys, passkey)
+	}
+	return rows.Err()
+}
+
+// exec runs an update of one row, notFound when no row matched
+func (r *postgresUserRepo) exec(ctx context.Context, notFound error, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := conn(ctx, r.db).ExecContext(ctx, query, args...)
+	return affectedOr(result, err, notFound)
+}
+
+// execChanged runs an update and reports whether it changed a row
+func (r *postgresUserRepo) execChanged(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := conn(ctx, r.db).ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// inTx runs fn in a transaction, or in the one of the unit of work ctx belongs to
+func (r *postgresUserRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if tx := txFromContext(ctx); tx != nil {
+		return fn(tx)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var id string
+	var emailVerifiedAt, phoneVerifiedAt, createdAt, updatedAt, enrolledAt, deletionScheduledAt, deletedAt sql.NullTime
+	var avatarVariants, suspension []byte
+
+	err := row.Scan(&id, &user.Username, &user.Email, &user.EmailVerified, &emailVerifiedAt, &user.Password, &user.Address,
+		&user.Phone, &user.PhoneVerified, &phoneVerifiedAt, &user.Age, &user.Avatar, &avatarVariants, &user.Role, &createdAt,
+		&updatedAt, &user.MFA.TOTPEnabled, &user.MFA.TOTPSecret, &user.MFA.TOTPLastStep, pq.Array(&user.MFA.RecoveryCodes),
+		&enrolledAt, &user.Status, &suspension, &user.PasswordResetRequired, &deletionScheduledAt, &deletedAt, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = timePtr(emailVerifiedAt)
+	user.PhoneVerifiedAt = timePtr(phoneVerifiedAt)
+	user.CreatedAt = createdAt.Time
+	user.UpdatedAt = updatedAt.Time
+	user.MFA.EnrolledAt = timePtr(enrolledAt)
+	user.DeletionScheduledAt = timePtr(deletionScheduledAt)
+	user.DeletedAt = timePtr(deletedAt)
+	if len(user.MFA.RecoveryCodes) == 0 {
+		user.MFA.RecoveryCodes = nil
+	}
+	if avatarVariants != nil {
+		if err := json.Unmarshal(avatarVariants, &user.AvatarVariants); err != nil {
+			return nil, err
+		}
+	}
+	if suspension != nil {
+		if err := json.Unmarshal(suspension, &user.Suspension); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+func insertIdentity(ctx context.Context, tx *sql.Tx, userID primitive.ObjectID, identity models.LinkedIdentity) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO user_identities (user_id, provider, subject, email, linked_at)
+		VALUES ($1, $2, $3, $4, $5)`, userID.Hex(), identity.Provider, identity.Subject, identity.Email, identity.LinkedAt)
+	switch constraintViolated(err) {
+	case "user_identities_pkey":
+		return ErrProviderAlreadyLinked
+	case "user_identities_provider_subject_key":
+		return ErrIdentityInUse
+	case "user_identities_user_id_fkey":
+		return ErrNotFound
+	}
+	return err
+}
+
+func insertPasskey(ctx context.Context, tx *sql.Tx, userID primitive.ObjectID, passkey models.Passkey) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO user_passkeys (id, user_id, name, public_key, attestation_type, transports, aaguid,
+		sign_count, backup_eligible, backup_state, clone_warning, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		passkey.ID, userID.Hex(), passkey.Name, passkey.PublicKey, passkey.AttestationType, pq.Array(nonNil(passkey.Transports)),
+		passkey.AAGUID, int64(passkey.SignCount), passkey.BackupEligible, passkey.BackupState, passkey.CloneWarning,
+		passkey.CreatedAt, passkey.LastUsedAt)
+	switch constraintViolated(err) {
+	case "user_passkeys_pkey":
+		return ErrPasskeyExists
+	case "user_passkeys_user_id_fkey":
+		return ErrNotFound
+	}
+	return err
+}
+
+// touchUser sets updated_at, ErrNotFound when the user does not exist
+func touchUser(ctx context.Context, tx *sql.Tx, userID primitive.ObjectID) error {
+	result, err := tx.ExecContext(ctx, `UPDATE users SET updated_at = $2 WHERE id = $1`, userID.Hex(), time.Now())
+	return affectedOr(result, err, ErrNotFound)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// activityColumns are read by queryActivity, in its scan order
+const activityColumns = `id, user_id, actor_id, action, ip, user_agent, correlation_id, metadata, activity_timestamp`
+
+func insertActivity(ctx context.Context, db execer, activity *models.UserActivityLog) error {
+	values, err := activityValues(activity)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO user_activity_log (`+activityColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, values...)
+	return err
+}
+
+// activityValues defaults the ID and time of activity and returns its values in the order of activityColumns
+func activityValues(activity *models.UserActivityLog) ([]interface{}, error) {
+	if activity.ID.IsZero() {
+		activity.ID = primitive.NewObjectID()
+	}
+	if activity.ActivityTimestamp.IsZero() {
+		activity.ActivityTimestamp = time.Now().UTC()
+	}
+	var actorID interface{}
+	if !activity.ActorID.IsZero() {
+		actorID = activity.ActorID.Hex()
+	}
+	metadata, err := jsonColumn(activity.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		activity.ID.Hex(), activity.UserID.Hex(), actorID, activity.Action, activity.IP, activity.UserAgent,
+		activity.CorrelationID, metadata, activity.ActivityTimestamp,
+	}, nil
+}
+
+func queryActivity(ctx context.Context, db sqlConn, query string, args ...interface{}) ([]models.UserActivityLog, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []models.UserActivityLog{}
+	for rows.Next() {
+		var id, userID string
+		var actorID sql.NullString
+		var metadata []byte
+		var log models.UserActivityLog
+		if err := rows.Scan(&id, &userID, &actorID, &log.Action, &log.IP, &log.UserAgent, &log.CorrelationID,
+			&metadata, &log.ActivityTimestamp); err != nil {
+			return nil, err
+		}
+		if log.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if log.UserID, err = primitive.ObjectIDFromHex(userID); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			if log.ActorID, err = primitive.ObjectIDFromHex(actorID.String); err != nil {
+				return nil, err
+			}
+		}
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &log.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// affectedOr returns err, or notFound when the statement changed no row
+func affectedOr(result sql.Result, err error, notFound error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// userWriteError maps the unique constraints of users to the repository errors
+func userWriteError(err error) error {
+	switch constraintViolated(err) {
+	case "users_email_key":
+		return ErrEmailInUse
+	case "users_verified_phone_key":
+		return ErrPhoneInUse
+	}
+	return err
+}
+
+// constraintViolated names the unique or foreign key constraint err violated, empty for other errors
+func constraintViolated(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == "23505" || pqErr.Code == "23503") {
+		return pqErr.Constraint
+	}
+	return ""
+}
+
+// escapeLike makes a search term match literally in LIKE
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+// jsonColumn encodes a JSONB value, nil maps and pointers become NULL
+func jsonColumn(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]string:
+		if v == nil {
+			return nil, nil
+		}
+	case *models.Suspension:
+		if v == nil {
+			return nil, nil
+		}
+	}
+	return json.Marshal(value)
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func userIDs(users []models.User) []string {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID.Hex()
+	}
+	return ids
+}
+
+func usersByID(users []models.User) map[string]*models.User {
+	byID := make(map[string]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID.Hex()] = &users[i]
+	}
+	return byID
+}
+
+// NewPostgresUserRepo stores users in the tables of database/postgres/migrations
+func NewPostgresUserRepo(db *sql.DB) UserRepo {
+	return &postgresUserRepo{db: db}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+const (
+	// adminDefaultPageSize and adminMaxPageSize bound the user list
+	adminDefaultPageSize = 20
+	adminMaxPageSize     = 100
+	// maxAdminReasonLength keeps reasons readable in the activity log
+	maxAdminReasonLength = 500
+)
+
+type AdminService interface {
+	HandleAdminListUsers(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminGetUser(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminChangeRole(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminSuspendUser(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminReactivateUser(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminForcePasswordReset(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminDeleteUser(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type adminService struct {
+	userRepo    repository.UserRepo
+	roleRepo    repository.RoleRepo
+	passwords   PasswordService
+	privacy     PrivacyService
+	sendMessage *api.SendingMessage
+}
+
+// HandleAdminListUsers replies with one page of users matching the filters
+func (s *adminService) HandleAdminListUsers(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserListEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AdminListUsersFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 1 {
+		req.Limit = adminDefaultPageSize
+	}
+	if req.Limit > adminMaxPageSize {
+		req.Limit = adminMaxPageSize
+	}
+
+	users, total, err := s.userRepo.ListUsers(ctx, repository.UserFilter{
+		Query:         strings.TrimSpace(req.Query),
+		Role:          req.Role,
+		Status:        req.Status,
+		EmailVerified: req.EmailVerified,
+	}, req.Page, req.Limit)
+	if err != nil {
+		logrus.Errorf("Failed to list users: %v", err)
+		s.publish("AdminListUsersFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to list users"))
+		return
+	}
+
+	views := make([]models.AdminUserView, 0, len(users))
+	for i := range users {
+		views = append(views, models.NewAdminUserView(&users[i]))
+	}
+	s.publish("AdminListUsersSuccess", correlationID, models.AdminUserListResultEvent{
+		Users: views,
+		Total: total,
+		Page:  req.Page,
+		Limit: req.Limit,
+	})
+}
+
+// HandleAdminGetUser replies with the details of one user
+func (s *adminService) HandleAdminGetUser(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminGetUserFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	s.publish("AdminGetUserSuccess", correlationID, models.NewAdminUserView(user))
+}
+
+// HandleAdminChangeRole gives a user another role, only a super admin can grant or take away SUPER_ADMIN
+func (s *adminService) HandleAdminChangeRole(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminChangeRoleFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	if user.ID.Hex() == req.ActorID {
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrForbidden.WithMessage("You cannot change your own role"))
+		return
+	}
+	if (req.Role == models.RoleSuperAdmin || user.Role == models.RoleSuperAdmin) && req.ActorRole != models.RoleSuperAdmin {
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrForbidden.WithMessage("Only a super admin can change super admin roles"))
+		return
+	}
+	if user.Role == req.Role {
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrConflict.WithMessage("User already has this role"))
+		return
+	}
+
+	exists, err := s.roleExists(ctx, req.Role)
+	if err != nil {
+		logrus.Errorf("Failed to load roles: %v", err)
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to change role"))
+		return
+	}
+	if !exists {
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrValidation.WithField("role", "Unknown role"))
+		return
+	}
+
+	if err := s.userRepo.UpdateRole(ctx, user.ID, req.Role); err != nil {
+		logrus.Errorf("Failed to update role: %v", err)
+		s.publish("AdminChangeRoleFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to change role"))
+		return
+	}
+
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionRoleChange, map[string]string{"from": user.Role, "to": req.Role})
+	s.replyUser(ctx, user.ID.Hex(), "AdminChangeRoleSuccess", "AdminChangeRoleFailed", correlationID)
+}
+
+// HandleAdminSuspendUser blocks every login of a user until Until, or until reactivated when Until is empty
+func (s *adminService) HandleAdminSuspendUser(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminSuspendUserFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	if user.ID.Hex() == req.ActorID {
+		s.publish("AdminSuspendUserFailed", correlationID, apperror.ErrForbidden.WithMessage("You cannot suspend yourself"))
+		return
+	}
+	if user.Role == models.RoleSuperAdmin && req.ActorRole != models.RoleSuperAdmin {
+		s.publish("AdminSuspendUserFailed", correlationID, apperror.ErrForbidden.WithMessage("Only a super admin can suspend a super admin"))
+		return
+	}
+	reason, ok := adminReason(req.Reason)
+	if !ok {
+		s.publish("AdminSuspendUserFailed", correlationID, apperror.ErrValidation.WithField("reason", "A reason is required"))
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		s.publish("AdminSuspendUserFailed", correlationID, apperror.ErrValidation.WithField("until", "Suspension end must be in the future"))
+		return
+	}
+
+	err := s.userRepo.SetSuspension(ctx, user.ID, &models.Suspension{
+		Reason:      reason,
+		Until:       req.Until,
+		ActorID:     req.ActorID,
+		SuspendedAt: time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to suspend user: %v", err)
+		s.publish("AdminSuspendUserFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to suspend user"))
+		return
+	}
+
+	metadata := map[string]string{"reason": reason}
+	if req.Until != nil {
+		metadata["until"] = req.Until.UTC().Format(time.RFC3339)
+	}
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionSuspend, metadata)
+	s.replyUser(ctx, user.ID.Hex(), "AdminSuspendUserSuccess", "AdminSuspendUserFailed", correlationID)
+}
+
+// HandleAdminReactivateUser lifts a suspension before it ends
+func (s *adminService) HandleAdminReactivateUser(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminReactivateUserFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	if user.Status != models.UserStatusSuspended {
+		s.publish("AdminReactivateUserFailed", correlationID, apperror.ErrConflict.WithMessage("User is not suspended"))
+		return
+	}
+	reason, ok := adminReason(req.Reason)
+	if !ok {
+		s.publish("AdminReactivateUserFailed", correlationID, apperror.ErrValidation.WithField("reason", "A reason is required"))
+		return
+	}
+
+	if err := s.userRepo.SetSuspension(ctx, user.ID, nil); err != nil {
+		logrus.Errorf("Failed to reactivate user: %v", err)
+		s.publish("AdminReactivateUserFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to reactivate user"))
+		return
+	}
+
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionReactivate, map[string]string{"reason": reason})
+	s.replyUser(ctx, user.ID.Hex(), "AdminReactivateUserSuccess", "AdminReactivateUserFailed", correlationID)
+}
+
+// HandleAdminForcePasswordReset blocks password logins until the user chose a new password from the emailed link
+func (s *adminService) HandleAdminForcePasswordReset(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminForcePasswordResetFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	if user.ID.Hex() == req.ActorID {
+		s.publish("AdminForcePasswordResetFailed", correlationID, apperror.ErrForbidden.WithMessage("Use change password to replace your own password"))
+		return
+	}
+	if user.Role == models.RoleSuperAdmin && req.ActorRole != models.RoleSuperAdmin {
+		s.publish("AdminForcePasswordResetFailed", correlationID, apperror.ErrForbidden.WithMessage("Only a super admin can force a password reset of a super admin"))
+		return
+	}
+	if user.Password == "" {
+		s.publish("AdminForcePasswordResetFailed", correlationID, apperror.ErrConflict.WithMessage("User has no password"))
+		return
+	}
+	if err := s.userRepo.SetPasswordResetRequired(ctx, user.ID, true); err != nil {
+		logrus.Errorf("Failed to require password reset: %v", err)
+		s.publish("AdminForcePasswordResetFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to force password reset"))
+		return
+	}
+	if err := s.passwords.SendPasswordResetEmail(ctx, user); err != nil {
+		logrus.Errorf("Failed to send password reset email: %v", err)
+		s.publish("AdminForcePasswordResetFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to send password reset email"))
+		return
+	}
+
+	var metadata map[string]string
+	if reason, ok := adminReason(req.Reason); ok {
+		metadata = map[string]string{"reason": reason}
+	}
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionForcePasswordReset, metadata)
+	s.replyUser(ctx, user.ID.Hex(), "AdminForcePasswordResetSuccess", "AdminForcePasswordResetFailed", correlationID)
+}
+
+// HandleAdminDeleteUser removes a user with its pending tokens, the activity log is kept
+func (s *adminService) HandleAdminDeleteUser(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AdminUserEvent
+	user, ok := s.loadTarget(ctx, eventData, "AdminDeleteUserFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	if user.ID.Hex() == req.ActorID {
+		s.publish("AdminDeleteUserFailed", correlationID, apperror.ErrForbidden.WithMessage("You cannot delete yourself"))
+		return
+	}
+	if user.Role == models.RoleSuperAdmin && req.ActorRole != models.RoleSuperAdmin {
+		s.publish("AdminDeleteUserFailed", correlationID, apperror.ErrForbidden.WithMessage("Only a super admin can delete a super admin"))
+		return
+	}
+
+	if err := s.privacy.DeleteAccount(ctx, user.ID); err != nil {
+		logrus.Errorf("Failed to delete user: %v", err)
+		s.publish("AdminDeleteUserFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to delete user"))
+		return
+	}
+
+	// the entry outlives the purge, so it keeps no personal data of the user
+	metadata := map[string]string{}
+	if reason, ok := adminReason(req.Reason); ok {
+		metadata["reason"] = reason
+	}
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionAdminDelete, metadata)
+	s.publish("AdminDeleteUserSuccess", correlationID, models.NewAdminUserView(user))
+}
+
+// loadTarget decodes an admin event and loads the user it targets, replying failedEvent when that fails
+func (s *adminService) loadTarget(ctx context.Context, eventData []byte, failedEvent, correlationID string, req *models.AdminUserEvent) (*models.User, bool) {
+	if err := json.Unmarshal(eventData, req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish(failedEvent, correlationID, apperror.ErrInvalidRequest)
+		return nil, false
+	}
+	if req.ActorID == "" {
+		s.publish(failedEvent, correlationID, apperror.ErrInvalidRequest.WithMessage("Missing admin"))
+		return nil, false
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish(failedEvent, correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return nil, false
+	}
+	return user, true
+}
+
+// replyUser reloads the user and replies with its admin view
+func (s *adminService) replyUser(ctx context.Context, userID string, successEvent, failedEvent, correlationID string) {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		s.publish(failedEvent, correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	s.publish(successEvent, correlationID, models.NewAdminUserView(user))
+}
+
+// roleExists checks the role against the definitions in the roles collection
+func (s *adminService) roleExists(ctx context.Context, name string) (bool, error) {
+	roles, err := s.roleRepo.ListRoles(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *adminService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// adminReason trims the reason an admin gave, false when it is empty
+func adminReason(reason string) (string, bool) {
+	reason = strings.TrimSpace(reason)
+	if len([]rune(reason)) > maxAdminReasonLength {
+		reason = string([]rune(reason)[:maxAdminReasonLength])
+	}
+	return reason, reason != ""
+}
+
+// NewAdminService for user management by admins
+func NewAdminService(userRepo repository.UserRepo, roleRepo repository.RoleRepo, passwords PasswordService, privacy PrivacyService, sendMessage *api.SendingMessage) AdminService {
+	return &adminService{
+		userRepo:    userRepo,
+		roleRepo:    roleRepo,
+		passwords:   passwords,
+		privacy:     privacy,
+		sendMessage: sendMessage,
+	}
+}
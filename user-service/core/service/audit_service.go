@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"strconv"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+// ActivityRecordedEvent carries one audit entry from the services to the audit consumer
+const ActivityRecordedEvent = "ActivityRecorded"
+
+const (
+	// activityDefaultPageSize and activityMaxPageSize bound a page of the audit trail
+	activityDefaultPageSize = 20
+	activityMaxPageSize     = 100
+	// defaultActivityRetention is how long entries are kept without AUDIT_LOG_RETENTION_DAYS
+	defaultActivityRetention = 365 * 24 * time.Hour
+	// defaultAuditBatchSize and defaultAuditFlushInterval bound how long an entry waits before it is written
+	defaultAuditBatchSize     = 100
+	defaultAuditFlushInterval = time.Second
+)
+
+type AuditService interface {
+	HandleActivityList(ctx context.Context, eventData []byte, correlationID string)
+	HandleAdminActivityList(ctx context.Context, eventData []byte, correlationID string)
+	PurgeExpiredActivity(ctx context.Context) (int64, error)
+	HandleActivityBatch(ctx context.Context, events []models.Event) error
+}
+
+type auditService struct {
+	userRepo    repository.UserRepo
+	sendMessage *api.SendingMessage
+}
+
+// HandleActivityList replies with one page of the signed-in user's own activity
+func (s *auditService) HandleActivityList(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.ActivityListEvent
+	if err := json.Unmarshal(eventData, &req); err != nil || req.UserID == "" {
+		s.publish("ActivityListFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+	// users see everything done to their account, whoever did it
+	req.ActorID = ""
+	s.list(ctx, req, "ActivityList", correlationID)
+}
+
+// HandleAdminActivityList replies with one page of the audit trail of every user
+func (s *auditService) HandleAdminActivityList(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.ActivityListEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		s.publish("AdminActivityListFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+	s.list(ctx, req, "AdminActivityList", correlationID)
+}
+
+// PurgeExpiredActivity removes the entries older than AUDIT_LOG_RETENTION_DAYS, MongoDB also expires them with a TTL index
+func (s *auditService) PurgeExpiredActivity(ctx context.Context) (int64, error) {
+	return s.userRepo.PurgeActivityLogs(ctx, time.Now().Add(-ActivityRetention()))
+}
+
+// HandleActivityBatch writes the entries of a batch of ActivityRecorded events at once. An error means nothing
+// may be acknowledged, the consumer retries the batch and then its events one by one, entries already written
+// are skipped by ID
+func (s *auditService) HandleActivityBatch(ctx context.Context, events []models.Event) error {
+	activities := make([]models.UserActivityLog, 0, len(events))
+	for _, event := range events {
+		payloadBytes, err := json.Marshal(event.Payload)
+		if err != nil {
+			logrus.Errorf("Failed to parse event payload: %v", err)
+			continue
+		}
+		var activity models.UserActivityLog
+		if err := json.Unmarshal(payloadBytes, &activity); err != nil || activity.Action == "" {
+			logrus.Errorf("Dropping malformed activity | CorrelationID: %s | Error: %v", event.CorrelationID, err)
+			continue
+		}
+		activities = append(activities, activity)
+	}
+
+	if err := s.userRepo.SaveActivityLogs(ctx, activities); err != nil {
+		return err
+	}
+	logrus.Infof("[user-service] Wrote %d activity entries", len(activities))
+	return nil
+}
+
+// list replies to eventType with the page of the audit trail req asks for
+func (s *auditService) list(ctx context.Context, req models.ActivityListEvent, eventType, correlationID string) {
+	filter := repository.ActivityFilter{Action: req.Action, From: req.From, To: req.To}
+	var err error
+	if req.UserID != "" {
+		if filter.UserID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+			s.publish(eventType+"Failed", correlationID, apperror.ErrValidation.WithField("user_id", "Invalid user ID"))
+			return
+		}
+	}
+	if req.ActorID != "" {
+		if filter.ActorID, err = primitive.ObjectIDFromHex(req.ActorID); err != nil {
+			s.publish(eventType+"Failed", correlationID, apperror.ErrValidation.WithField("actor_id", "Invalid actor ID"))
+			return
+		}
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		s.publish(eventType+"Failed", correlationID, apperror.ErrValidation.WithField("to", "To must be after from"))
+		return
+	}
+
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.Limit < 1 {
+		req.Limit = activityDefaultPageSize
+	}
+	if req.Limit > activityMaxPageSize {
+		req.Limit = activityMaxPageSize
+	}
+
+	logs, total, err := s.userRepo.ListActivityLogs(ctx, filter, req.Page, req.Limit)
+	if err != nil {
+		logrus.Errorf("Failed to list activity: %v", err)
+		s.publish(eventType+"Failed", correlationID, apperror.ErrInternal.WithMessage("Failed to list activity"))
+		return
+	}
+
+	views := make([]models.ActivityView, 0, len(logs))
+	for i := range logs {
+		views = append(views, models.NewActivityView(&logs[i]))
+	}
+	s.publish(eventType+"Success", correlationID, models.ActivityListResultEvent{
+		Activities: views,
+		Total:      total,
+		Page:       req.Page,
+		Limit:      req.Limit,
+	})
+}
+
+func (s *auditService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// ActivityRetention is how long audit entries are kept, AUDIT_LOG_RETENTION_DAYS or a year
+func ActivityRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("AUDIT_LOG_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		return defaultActivityRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// AuditBatchSize is how many entries the audit consumer writes at once, AUDIT_BATCH_SIZE or 100
+func AuditBatchSize() int {
+	size, err := strconv.Atoi(os.Getenv("AUDIT_BATCH_SIZE"))
+	if err != nil || size < 1 {
+		return defaultAuditBatchSize
+	}
+	return size
+}
+
+// AuditFlushInterval is the longest an entry waits for its batch to fill, AUDIT_FLUSH_INTERVAL or a second
+func AuditFlushInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("AUDIT_FLUSH_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultAuditFlushInterval
+	}
+	return interval
+}
+
+func NewAuditService(userRepo repository.UserRepo, sendMessage *api.SendingMessage) AuditService {
+	return &auditService{
+		userRepo:    userRepo,
+		sendMessage: sendMessage,
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/utils"
+)
+
+type IdentityService interface {
+	HandleReauthenticate(ctx context.Context, eventData []byte, correlationID string)
+	HandleIdentityLink(ctx context.Context, eventData []byte, correlationID string)
+	HandleIdentityLinkConfirm(ctx context.Context, eventData []byte, correlationID string)
+	HandleIdentityUnlink(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type identityService struct {
+	userRepo    repository.UserRepo
+	sendMessage *api.SendingMessage
+}
+
+// HandleReauthenticate confirms that the signed-in user is still the account owner
+func (s *identityService) HandleReauthenticate(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.UserReauthenticateEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("UserReauthenticateFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.ID)
+	if err != nil {
+		s.publish("UserReauthenticateFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	switch {
+	case req.Password != "":
+		if user.Password == "" || !utils.CheckPasswordHash(req.Password, user.Password) {
+			s.publish("UserReauthenticateFailed", correlationID, apperror.ErrInvalidCredentials.WithMessage("Invalid password"))
+			return
+		}
+	case user.Password != "":
+		s.publish("UserReauthenticateFailed", correlationID, apperror.ErrReauthenticationRequired.WithMessage("Password is required to continue"))
+		return
+	case !req.RecentLogin:
+		// accounts without a password re-authenticate by signing in again
+		s.publish("UserReauthenticateFailed", correlationID, apperror.ErrReauthenticationRequired)
+		return
+	}
+
+	s.publish("UserReauthenticateSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// HandleIdentityLink links a provider identity to a signed-in user who already re-authenticated
+func (s *identityService) HandleIdentityLink(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.IdentityLinkEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("IdentityLinkFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("IdentityLinkFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	s.link(ctx, user, req, "IdentityLinkSuccess", "IdentityLinkFailed", correlationID)
+}
+
+// HandleIdentityLinkConfirm links a provider identity to the account owning its email once the password is proven
+func (s *identityService) HandleIdentityLinkConfirm(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.IdentityLinkEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("IdentityLinkConfirmFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByEmail(ctx, req.Email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logrus.Errorf("Failed to find user by email: %v", err)
+		s.publish("IdentityLinkConfirmFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to sign in"))
+		return
+	}
+	if user == nil || user.Password == "" || !utils.CheckPasswordHash(req.Password, user.Password) {
+		s.publish("IdentityLinkConfirmFailed", correlationID, apperror.ErrInvalidCredentials)
+		return
+	}
+
+	// the confirmation signs the user in, so it is refused whenever a password login would be
+	if refusal := loginRefusal(user); refusal != nil {
+		s.publish("IdentityLinkConfirmFailed", correlationID, refusal)
+		return
+	}
+	if user.PasswordResetRequired {
+		s.publish("IdentityLinkConfirmFailed", correlationID, apperror.ErrPasswordResetRequired)
+		return
+	}
+	// the password alone must not attach a provider account, the gateway links it after the second factor
+	if requiresMFA(user) {
+		s.publish("IdentityLinkConfirmMfaRequired", correlationID, models.UserLoginMfaRequiredEvent{
+			ID:                 user.ID.Hex(),
+			Email:              user.Email,
+			Role:               user.Role,
+			EnrollmentRequired: !user.MFA.TOTPEnabled,
+		})
+		return
+	}
+
+	s.link(ctx, user, req, "IdentityLinkConfirmSuccess", "IdentityLinkConfirmFailed", correlationID)
+}
+
+// HandleIdentityUnlink removes a provider identity while keeping at least one way to sign in
+func (s *identityService) HandleIdentityUnlink(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.IdentityUnlinkEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("IdentityUnlinkFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("IdentityUnlinkFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	if loginMethodCount(user) <= 1 {
+		s.publish("IdentityUnlinkFailed", correlationID, apperror.ErrLastLoginMethod.WithMessage("Cannot unlink the only login method of this account"))
+		return
+	}
+
+	err = s.userRepo.RemoveLinkedIdentity(ctx, user.ID, req.Provider)
+	if errors.Is(err, repository.ErrIdentityNotLinked) {
+		s.publish("IdentityUnlinkFailed", correlationID, apperror.ErrIdentityNotLinked)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to unlink identity: %v", err)
+		s.publish("IdentityUnlinkFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to unlink identity"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionIdentityUnlink, map[string]string{"provider": req.Provider})
+	s.replyIdentities(ctx, user.ID.Hex(), "IdentityUnlinkSuccess", "IdentityUnlinkFailed", correlationID)
+}
+
+// link stores the identity and replies with the user's linked identities
+func (s *identityService) link(ctx context.Context, user *models.User, req models.IdentityLinkEvent, successEvent, failedEvent, correlationID string) {
+	err := s.userRepo.AddLinkedIdentity(ctx, user.ID, models.LinkedIdentity{
+		Provider: req.Provider,
+		Subject:  req.Subject,
+		Email:    req.ProviderEmail,
+		LinkedAt: time.Now(),
+	})
+	switch {
+	case errors.Is(err, repository.ErrProviderAlreadyLinked):
+		s.publish(failedEvent, correlationID, apperror.ErrProviderAlreadyLinked.WithMessage("This provider is already linked to your account"))
+		return
+	case errors.Is(err, repository.ErrIdentityInUse):
+		s.publish(failedEvent, correlationID, apperror.ErrIdentityInUse.WithMessage("This provider account is already linked to another user"))
+		return
+	case err != nil:
+		logrus.Errorf("Failed to link identity: %v", err)
+		s.publish(failedEvent, correlationID, apperror.ErrInternal.WithMessage("Failed to link identity"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionIdentityLink, map[string]string{"provider": req.Provider})
+	s.replyIdentities(ctx, user.ID.Hex(), successEvent, failedEvent, correlationID)
+}
+
+// replyIdentities reloads the user and replies with its current identities
+func (s *identityService) replyIdentities(ctx context.Context, userID string, successEvent, failedEvent, correlationID string) {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		s.publish(failedEvent, correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	s.publish(successEvent, correlationID, models.LinkedIdentitiesEvent{
+		ID:               user.ID.Hex(),
+		Email:            user.Email,
+		Role:             user.Role,
+		LinkedIdentities: user.LinkedIdentities,
+		EmailVerified:    user.EmailVerified,
+	})
+}
+
+func (s *identityService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// loginMethodCount counts the ways a user can sign in: a password, each linked identity and each passkey
+func loginMethodCount(user *models.User) int {
+	count := len(user.LinkedIdentities) + len(user.Passkeys)
+	if user.Password != "" {
+		count++
+	}
+	return count
+}
+
+// NewIdentityService for linking and unlinking external identities
+func NewIdentityService(userRepo repository.UserRepo, sendMessage *api.SendingMessage) IdentityService {
+	return &identityService{
+		userRepo:    userRepo,
+		sendMessage: sendMessage,
+	}
+}
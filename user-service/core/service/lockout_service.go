@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+// accountUnlockTTL is how long an unlock link stays valid, longer than the longest lockout
+const accountUnlockTTL = 24 * time.Hour
+
+type LockoutService interface {
+	HandleAccountLocked(ctx context.Context, eventData []byte, correlationID string)
+	HandleAccountUnlock(ctx context.Context, eventData []byte, correlationID string)
+	HandleAccountUnlockAdmin(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type lockoutService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+}
+
+// HandleAccountLocked records a lockout from the gateway and emails an unlock link, it never replies
+func (s *lockoutService) HandleAccountLocked(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AccountLockedEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByEmail(ctx, req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		// unknown emails are locked too, so a lockout does not reveal which accounts exist
+		logrus.Infof("Lockout of unknown email | CorrelationID: %s", correlationID)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to find locked user: %v", err)
+		return
+	}
+
+	until := req.LockedUntil.UTC().Format(time.RFC1123)
+	// the lock follows failed logins of somebody else, the service is the actor
+	saveActivity(ctx, s.sendMessage, models.UserActivityLog{
+		UserID:   user.ID,
+		Action:   models.ActionAccountLock,
+		Metadata: map[string]string{"until": req.LockedUntil.UTC().Format(time.RFC3339), "failed_login_ip": req.IP},
+	})
+
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeAccountUnlock); err != nil {
+		logrus.Errorf("Failed to delete old unlock tokens: %v", err)
+		return
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		logrus.Errorf("Failed to generate unlock token: %v", err)
+		return
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeAccountUnlock,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(accountUnlockTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to save unlock token: %v", err)
+		return
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nWe locked your account until %s after several failed sign-in attempts, the last one from %s.\n\nIf that was you, use the link below to unlock it now.\n\n%s\n\nIf it was not you, unlock your account and change your password.\n",
+			user.Username, until, req.IP, linkWithToken(os.Getenv("ACCOUNT_UNLOCK_URL"), token)),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send unlock email: %v", err)
+	}
+}
+
+// HandleAccountUnlock lifts a lockout with the token from the lockout email
+func (s *lockoutService) HandleAccountUnlock(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AccountUnlockEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AccountUnlockFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	token, err := s.tokenRepo.ConsumeToken(ctx, models.TokenPurposeAccountUnlock, utils.HashToken(req.Token))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("AccountUnlockFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired unlock link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to verify unlock token: %v", err)
+		s.publish("AccountUnlockFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to unlock account"))
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, token.UserID.Hex())
+	if err != nil {
+		s.publish("AccountUnlockFailed", correlationID, findUserError(err, apperror.ErrInvalidToken.WithMessage("Invalid or expired unlock link")))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionAccountUnlock, map[string]string{"method": "email_link"})
+	s.publish("AccountUnlockSuccess", correlationID, models.UserLoginEvent{
+		ID:    user.ID.Hex(),
+		Email: user.Email,
+	})
+}
+
+// HandleAccountUnlockAdmin lifts the lockout of a user on behalf of an admin
+func (s *lockoutService) HandleAccountUnlockAdmin(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AccountUnlockAdminEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AccountUnlockAdminFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("AccountUnlockAdminFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeAccountUnlock); err != nil {
+		logrus.Warnf("Failed to delete unlock tokens: %v", err)
+	}
+
+	recordAdminActivity(ctx, s.sendMessage, user.ID, req.ActorID, models.ActionAccountUnlock, map[string]string{"method": "admin"})
+	s.publish("AccountUnlockAdminSuccess", correlationID, models.UserLoginEvent{
+		ID:    user.ID.Hex(),
+		Email: user.Email,
+	})
+}
+
+func (s *lockoutService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewLockoutService for recording and lifting login lockouts
+func NewLockoutService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, mail mailer.Mailer, sendMessage *api.SendingMessage) LockoutService {
+	return &lockoutService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		mailer:      mail,
+		sendMessage: sendMessage,
+	}
+}
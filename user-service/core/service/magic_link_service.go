@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+// magicLinkTTL is how long a sign-in link stays valid
+const magicLinkTTL = 15 * time.Minute
+
+type MagicLinkService interface {
+	HandleMagicLinkRequest(ctx context.Context, eventData []byte, correlationID string)
+	HandleMagicLinkLogin(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type magicLinkService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+}
+
+// HandleMagicLinkRequest emails a sign-in link when the account exists, it never replies so callers learn nothing
+func (s *magicLinkService) HandleMagicLinkRequest(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MagicLinkRequestEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		return
+	}
+	if req.DeviceHash == "" {
+		logrus.Errorf("Magic link requested without a device | CorrelationID: %s", correlationID)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByEmail(ctx, req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		logrus.Infof("Magic link requested for unknown email | CorrelationID: %s", correlationID)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to find user for magic link: %v", err)
+		return
+	}
+
+	// only the newest link works
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeMagicLink); err != nil {
+		logrus.Errorf("Failed to delete old magic link tokens: %v", err)
+		return
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		logrus.Errorf("Failed to generate magic link token: %v", err)
+		return
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:     user.ID,
+		Purpose:    models.TokenPurposeMagicLink,
+		Email:      user.Email,
+		TokenHash:  utils.HashToken(token),
+		DeviceHash: req.DeviceHash,
+		ExpiresAt:  time.Now().Add(magicLinkTTL),
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to save magic link token: %v", err)
+		return
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to sign in. It expires in %d minutes, works once and only in the browser where you asked for it.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, int(magicLinkTTL.Minutes()), linkWithToken(os.Getenv("MAGIC_LINK_URL"), token)),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send magic link email: %v", err)
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionMagicLinkRequest, nil)
+}
+
+// HandleMagicLinkLogin signs in with a sign-in link, following the link proves the email address
+func (s *magicLinkService) HandleMagicLinkLogin(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MagicLinkLoginEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("MagicLinkLoginFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	token, err := s.tokenRepo.ConsumeDeviceToken(ctx, models.TokenPurposeMagicLink, utils.HashToken(req.Token), req.DeviceHash)
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("MagicLinkLoginFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired sign-in link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to verify magic link token: %v", err)
+		s.publish("MagicLinkLoginFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to sign in"))
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, token.UserID.Hex())
+	if err != nil {
+		s.publish("MagicLinkLoginFailed", correlationID, findUserError(err, apperror.ErrInvalidToken.WithMessage("Invalid or expired sign-in link")))
+		return
+	}
+	if user.Email != token.Email {
+		s.publish("MagicLinkLoginFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired sign-in link"))
+		return
+	}
+
+	if refusal := loginRefusal(user); refusal != nil {
+		s.publish("MagicLinkLoginFailed", correlationID, refusal)
+		return
+	}
+
+	if !user.EmailVerified {
+		if err := s.userRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			logrus.Errorf("Failed to mark email verified: %v", err)
+			s.publish("MagicLinkLoginFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to sign in"))
+			return
+		}
+		recordActivity(ctx, s.sendMessage, user.ID, models.ActionEmailVerify, nil)
+	}
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeMagicLink); err != nil {
+		logrus.Warnf("Failed to delete magic link tokens: %v", err)
+	}
+
+	// a sign-in link replaces the password, not the second factor
+	if requiresMFA(user) {
+		s.publish("MagicLinkLoginMfaRequired", correlationID, models.UserLoginMfaRequiredEvent{
+			ID:                 user.ID.Hex(),
+			Email:              user.Email,
+			Role:               user.Role,
+			EnrollmentRequired: !user.MFA.TOTPEnabled,
+		})
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionLogin, map[string]string{"method": "magic_link"})
+	s.publish("MagicLinkLoginSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: true,
+	})
+}
+
+func (s *magicLinkService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewMagicLinkService for passwordless sign-in with emailed links
+func NewMagicLinkService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, mail mailer.Mailer, sendMessage *api.SendingMessage) MagicLinkService {
+	return &magicLinkService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		mailer:      mail,
+		sendMessage: sendMessage,
+	}
+}
@@ -0,0 +1,350 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/utils"
+)
+
+// recoveryCodeCount is how many one-time recovery codes a user gets on enrollment
+const recoveryCodeCount = 10
+
+// MFA methods reported on a successful second factor
+const (
+	MfaMethodTOTP         = "totp"
+	MfaMethodRecoveryCode = "recovery_code"
+)
+
+type MfaService interface {
+	HandleMfaEnroll(ctx context.Context, eventData []byte, correlationID string)
+	HandleMfaEnrollConfirm(ctx context.Context, eventData []byte, correlationID string)
+	HandleMfaDisable(ctx context.Context, eventData []byte, correlationID string)
+	HandleMfaRecoveryCodes(ctx context.Context, eventData []byte, correlationID string)
+	HandleUserLoginMfa(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type mfaService struct {
+	userRepo    repository.UserRepo
+	sendMessage *api.SendingMessage
+}
+
+// HandleMfaEnroll creates a pending TOTP secret and replies with its provisioning URI
+func (s *mfaService) HandleMfaEnroll(ctx context.Context, eventData []byte, correlationID string) {
+	user, ok := s.loadUser(ctx, eventData, "MfaEnrollFailed", correlationID, nil)
+	if !ok {
+		return
+	}
+	if user.MFA.TOTPEnabled {
+		s.publish("MfaEnrollFailed", correlationID, apperror.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		s.publish("MfaEnrollFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to generate MFA secret"))
+		return
+	}
+	sealed, err := utils.EncryptSecret(secret)
+	if err != nil {
+		logrus.Errorf("Failed to encrypt MFA secret: %v", err)
+		s.publish("MfaEnrollFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to generate MFA secret"))
+		return
+	}
+
+	if err := s.userRepo.UpdateMFA(ctx, user.ID, models.MFASettings{TOTPSecret: sealed}); err != nil {
+		logrus.Errorf("Failed to save MFA secret: %v", err)
+		s.publish("MfaEnrollFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to save MFA secret"))
+		return
+	}
+
+	s.publish("MfaEnrollSuccess", correlationID, models.MfaEnrollmentEvent{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(mfaIssuer(), user.Email, secret),
+	})
+}
+
+// HandleMfaEnrollConfirm enables TOTP once the user proves the authenticator app works
+func (s *mfaService) HandleMfaEnrollConfirm(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MfaEvent
+	user, ok := s.loadUser(ctx, eventData, "MfaEnrollConfirmFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+	if user.MFA.TOTPEnabled {
+		s.publish("MfaEnrollConfirmFailed", correlationID, apperror.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	codes, err := s.confirmEnrollment(ctx, user, req.Code)
+	if err != nil {
+		s.publish("MfaEnrollConfirmFailed", correlationID, err)
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionMFAEnable, nil)
+	s.publish("MfaEnrollConfirmSuccess", correlationID, models.MfaRecoveryCodesEvent{RecoveryCodes: codes})
+}
+
+// HandleMfaDisable turns TOTP off after checking a current code or a recovery code
+func (s *mfaService) HandleMfaDisable(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MfaEvent
+	user, ok := s.loadUser(ctx, eventData, "MfaDisableFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+	if !user.MFA.TOTPEnabled {
+		s.publish("MfaDisableFailed", correlationID, apperror.ErrMFANotEnabled)
+		return
+	}
+	if mfaMandatory(user.Role) {
+		s.publish("MfaDisableFailed", correlationID, apperror.ErrForbidden.WithMessage("MFA is mandatory for your role"))
+		return
+	}
+
+	if _, err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		s.publish("MfaDisableFailed", correlationID, err)
+		return
+	}
+
+	if err := s.userRepo.UpdateMFA(ctx, user.ID, models.MFASettings{}); err != nil {
+		logrus.Errorf("Failed to disable MFA: %v", err)
+		s.publish("MfaDisableFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to disable MFA"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionMFADisable, nil)
+	s.publish("MfaDisableSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// HandleMfaRecoveryCodes replaces all recovery codes, the old ones stop working
+func (s *mfaService) HandleMfaRecoveryCodes(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MfaEvent
+	user, ok := s.loadUser(ctx, eventData, "MfaRecoveryCodesFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+	if !user.MFA.TOTPEnabled {
+		s.publish("MfaRecoveryCodesFailed", correlationID, apperror.ErrMFANotEnabled)
+		return
+	}
+
+	if _, err := s.verifySecondFactor(ctx, user, req.Code, ""); err != nil {
+		s.publish("MfaRecoveryCodesFailed", correlationID, err)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		s.publish("MfaRecoveryCodesFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to generate recovery codes"))
+		return
+	}
+
+	mfa := user.MFA
+	mfa.RecoveryCodes = hashes
+	if err := s.userRepo.UpdateMFA(ctx, user.ID, mfa); err != nil {
+		logrus.Errorf("Failed to save recovery codes: %v", err)
+		s.publish("MfaRecoveryCodesFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to save recovery codes"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionMFARecoveryCodes, nil)
+	s.publish("MfaRecoveryCodesSuccess", correlationID, models.MfaRecoveryCodesEvent{RecoveryCodes: codes})
+}
+
+// HandleUserLoginMfa finishes a login that was answered with UserLoginMfaRequired
+func (s *mfaService) HandleUserLoginMfa(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.MfaEvent
+	user, ok := s.loadUser(ctx, eventData, "UserLoginMfaFailed", correlationID, &req)
+	if !ok {
+		return
+	}
+
+	reply := models.UserLoginMfaSuccessEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		Method:        MfaMethodTOTP,
+		EmailVerified: user.EmailVerified,
+	}
+
+	if !user.MFA.TOTPEnabled {
+		// accounts with mandatory MFA finish their enrollment with the first valid code
+		if !mfaMandatory(user.Role) || user.MFA.TOTPSecret == "" {
+			s.publish("UserLoginMfaFailed", correlationID, apperror.ErrMFAEnrollmentRequired)
+			return
+		}
+
+		codes, err := s.confirmEnrollment(ctx, user, req.Code)
+		if err != nil {
+			s.publish("UserLoginMfaFailed", correlationID, err)
+			return
+		}
+		recordActivity(ctx, s.sendMessage, user.ID, models.ActionMFAEnable, nil)
+		reply.RecoveryCodes = codes
+	} else {
+		method, err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+		if err != nil {
+			s.publish("UserLoginMfaFailed", correlationID, err)
+			return
+		}
+		reply.Method = method
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionLogin, map[string]string{"method": "mfa_" + reply.Method})
+	s.publish("UserLoginMfaSuccess", correlationID, reply)
+}
+
+// confirmEnrollment validates a code against the pending secret and enables TOTP
+func (s *mfaService) confirmEnrollment(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.MFA.TOTPSecret == "" {
+		return nil, apperror.ErrConflict.WithMessage("Start MFA enrollment first")
+	}
+
+	secret, err := utils.DecryptSecret(user.MFA.TOTPSecret)
+	if err != nil {
+		logrus.Errorf("Failed to decrypt MFA secret: %v", err)
+		return nil, apperror.ErrInternal.WithMessage("Failed to verify code")
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, apperror.ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, apperror.ErrInternal.WithMessage("Failed to generate recovery codes")
+	}
+
+	now := time.Now()
+	err = s.userRepo.UpdateMFA(ctx, user.ID, models.MFASettings{
+		TOTPEnabled:   true,
+		TOTPSecret:    user.MFA.TOTPSecret,
+		TOTPLastStep:  step,
+		RecoveryCodes: hashes,
+		EnrolledAt:    &now,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to enable MFA: %v", err)
+		return nil, apperror.ErrInternal.WithMessage("Failed to enable MFA")
+	}
+
+	return codes, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code and returns which one matched
+func (s *mfaService) verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (string, error) {
+	if recoveryCode != "" {
+		consumed, err := s.userRepo.ConsumeRecoveryCode(ctx, user.ID, utils.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			logrus.Errorf("Failed to consume recovery code: %v", err)
+			return "", apperror.ErrInternal.WithMessage("Failed to verify recovery code")
+		}
+		if !consumed {
+			return "", apperror.ErrInvalidMFACode.WithMessage("Invalid recovery code")
+		}
+		return MfaMethodRecoveryCode, nil
+	}
+
+	secret, err := utils.DecryptSecret(user.MFA.TOTPSecret)
+	if err != nil {
+		logrus.Errorf("Failed to decrypt MFA secret: %v", err)
+		return "", apperror.ErrInternal.WithMessage("Failed to verify code")
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return "", apperror.ErrInvalidMFACode
+	}
+
+	fresh, err := s.userRepo.MarkTOTPStepUsed(ctx, user.ID, step)
+	if err != nil {
+		logrus.Errorf("Failed to store TOTP step: %v", err)
+		return "", apperror.ErrInternal.WithMessage("Failed to verify code")
+	}
+	if !fresh {
+		return "", apperror.ErrInvalidMFACode.WithMessage("Verification code already used")
+	}
+	return MfaMethodTOTP, nil
+}
+
+// loadUser decodes an MfaEvent (into req when given) and finds its user
+func (s *mfaService) loadUser(ctx context.Context, eventData []byte, failedEvent, correlationID string, req *models.MfaEvent) (*models.User, bool) {
+	if req == nil {
+		req = &models.MfaEvent{}
+	}
+	if err := json.Unmarshal(eventData, req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish(failedEvent, correlationID, apperror.ErrInvalidRequest)
+		return nil, false
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish(failedEvent, correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return nil, false
+	}
+	return user, true
+}
+
+func (s *mfaService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// newRecoveryCodes returns plain codes for the user together with the hashes we store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// mfaMandatory reports whether the role must use MFA, configured with MFA_REQUIRED_ROLES=ADMIN,SUPER_ADMIN
+func mfaMandatory(role string) bool {
+	for _, required := range strings.Split(os.Getenv("MFA_REQUIRED_ROLES"), ",") {
+		if strings.TrimSpace(required) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresMFA reports whether a login has to pass a second factor before a token is issued
+func requiresMFA(user *models.User) bool {
+	return user.MFA.TOTPEnabled || mfaMandatory(user.Role)
+}
+
+// mfaIssuer is the account issuer shown in authenticator apps
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "DubaiDeals"
+}
+
+// NewMfaService for TOTP enrollment and second factor verification
+func NewMfaService(userRepo repository.UserRepo, sendMessage *api.SendingMessage) MfaService {
+	return &mfaService{
+		userRepo:    userRepo,
+		sendMessage: sendMessage,
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+// MfaMethodPasskey is reported when a WebAuthn assertion completed the login
+const MfaMethodPasskey = "passkey"
+
+// maxPasskeyNameLength keeps user supplied passkey names readable in the activity log
+const maxPasskeyNameLength = 64
+
+type PasskeyService interface {
+	HandleGetPasskeyUser(ctx context.Context, eventData []byte, correlationID string)
+	HandlePasskeyRegister(ctx context.Context, eventData []byte, correlationID string)
+	HandlePasskeyLogin(ctx context.Context, eventData []byte, correlationID string)
+	HandlePasskeyDelete(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type passkeyService struct {
+	userRepo    repository.UserRepo
+	sendMessage *api.SendingMessage
+}
+
+// HandleGetPasskeyUser replies with what the gateway needs to run a WebAuthn ceremony for a user
+func (s *passkeyService) HandleGetPasskeyUser(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasskeyUserEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("GetPasskeyUserFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.ID)
+	if err != nil {
+		s.publish("GetPasskeyUserFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	s.publish("GetPasskeyUserSuccess", correlationID, models.PasskeyUserEvent{
+		ID:       user.ID.Hex(),
+		Email:    user.Email,
+		Username: user.Username,
+		Role:     user.Role,
+		Passkeys: user.Passkeys,
+	})
+}
+
+// HandlePasskeyRegister stores a credential created by a verified registration ceremony
+func (s *passkeyService) HandlePasskeyRegister(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasskeyRegisterEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PasskeyRegisterFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PasskeyRegisterFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	passkey := req.Passkey
+	if passkey.ID == "" || len(passkey.PublicKey) == 0 {
+		s.publish("PasskeyRegisterFailed", correlationID, apperror.ErrInvalidPasskey)
+		return
+	}
+	passkey.Name = passkeyName(passkey.Name, len(user.Passkeys))
+	passkey.CreatedAt = time.Now()
+	passkey.LastUsedAt = nil
+
+	err = s.userRepo.AddPasskey(ctx, user.ID, passkey)
+	if errors.Is(err, repository.ErrPasskeyExists) {
+		s.publish("PasskeyRegisterFailed", correlationID, apperror.ErrPasskeyExists)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to save passkey: %v", err)
+		s.publish("PasskeyRegisterFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to save passkey"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPasskeyRegister, map[string]string{"passkey": passkeyLabel(passkey)})
+	s.replyPasskeys(ctx, user.ID.Hex(), "PasskeyRegisterSuccess", "PasskeyRegisterFailed", correlationID)
+}
+
+// HandlePasskeyLogin records a verified assertion and replies with the user to issue a token for.
+// Assertions are only accepted with user verification, so a passkey satisfies MFA on its own.
+func (s *passkeyService) HandlePasskeyLogin(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasskeyLoginEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PasskeyLoginFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PasskeyLoginFailed", correlationID, findUserError(err, apperror.ErrInvalidPasskey))
+		return
+	}
+
+	passkey := findPasskey(user, req.CredentialID)
+	if passkey == nil {
+		s.publish("PasskeyLoginFailed", correlationID, apperror.ErrInvalidPasskey)
+		return
+	}
+	if refusal := loginRefusal(user); refusal != nil {
+		s.publish("PasskeyLoginFailed", correlationID, refusal)
+		return
+	}
+	if req.CloneWarning {
+		logrus.Warnf("Passkey %s of user %s reported a sign counter regression", passkey.ID, user.ID.Hex())
+	}
+
+	err = s.userRepo.UpdatePasskeyUsage(ctx, user.ID, passkey.ID, req.SignCount, req.BackupState, req.CloneWarning)
+	if err != nil {
+		logrus.Errorf("Failed to update passkey usage: %v", err)
+		s.publish("PasskeyLoginFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to verify passkey"))
+		return
+	}
+
+	metadata := map[string]string{"method": "passkey", "passkey": passkeyLabel(*passkey)}
+	if req.SecondFactor {
+		metadata["method"] = "mfa_passkey"
+	}
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionLogin, metadata)
+
+	s.publish("PasskeyLoginSuccess", correlationID, models.PasskeyLoginSuccessEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		Method:        MfaMethodPasskey,
+		CredentialID:  passkey.ID,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// HandlePasskeyDelete removes a passkey while keeping at least one way to sign in
+func (s *passkeyService) HandlePasskeyDelete(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasskeyDeleteEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PasskeyDeleteFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PasskeyDeleteFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	passkey := findPasskey(user, req.CredentialID)
+	if passkey == nil {
+		s.publish("PasskeyDeleteFailed", correlationID, apperror.ErrPasskeyNotFound)
+		return
+	}
+	if loginMethodCount(user) <= 1 {
+		s.publish("PasskeyDeleteFailed", correlationID, apperror.ErrLastLoginMethod)
+		return
+	}
+
+	err = s.userRepo.RemovePasskey(ctx, user.ID, passkey.ID)
+	if errors.Is(err, repository.ErrPasskeyNotFound) {
+		s.publish("PasskeyDeleteFailed", correlationID, apperror.ErrPasskeyNotFound)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to remove passkey: %v", err)
+		s.publish("PasskeyDeleteFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to remove passkey"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPasskeyRemove, map[string]string{"passkey": passkeyLabel(*passkey)})
+	s.replyPasskeys(ctx, user.ID.Hex(), "PasskeyDeleteSuccess", "PasskeyDeleteFailed", correlationID)
+}
+
+// replyPasskeys reloads the user and replies with its current passkeys
+func (s *passkeyService) replyPasskeys(ctx context.Context, userID string, successEvent, failedEvent, correlationID string) {
+	user, err := s.userRepo.FindUserByID(ctx, userID)
+	if err != nil {
+		s.publish(failedEvent, correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	s.publish(successEvent, correlationID, models.PasskeyUserEvent{
+		ID:       user.ID.Hex(),
+		Email:    user.Email,
+		Username: user.Username,
+		Role:     user.Role,
+		Passkeys: user.Passkeys,
+	})
+}
+
+func (s *passkeyService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// findPasskey returns the user's passkey with the given credential ID
+func findPasskey(user *models.User, credentialID string) *models.Passkey {
+	for i := range user.Passkeys {
+		if user.Passkeys[i].ID == credentialID {
+			return &user.Passkeys[i]
+		}
+	}
+	return nil
+}
+
+// passkeyName trims the name chosen by the user, falling back to a numbered default
+func passkeyName(name string, existing int) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Sprintf("Passkey %d", existing+1)
+	}
+	if len([]rune(name)) > maxPasskeyNameLength {
+		name = string([]rune(name)[:maxPasskeyNameLength])
+	}
+	return name
+}
+
+// passkeyLabel identifies a passkey in the activity log by name and credential ID
+func passkeyLabel(passkey models.Passkey) string {
+	return fmt.Sprintf("passkey %q [%s]", passkey.Name, passkey.ID)
+}
+
+// NewPasskeyService for WebAuthn credential storage and passkey logins
+func NewPasskeyService(userRepo repository.UserRepo, sendMessage *api.SendingMessage) PasskeyService {
+	return &passkeyService{
+		userRepo:    userRepo,
+		sendMessage: sendMessage,
+	}
+}
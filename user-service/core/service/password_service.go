@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"os"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/passwordpolicy"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+// passwordResetTTL is how long a reset link stays valid
+const passwordResetTTL = 30 * time.Minute
+
+type PasswordService interface {
+	HandlePasswordForgot(ctx context.Context, eventData []byte, correlationID string)
+	HandlePasswordReset(ctx context.Context, eventData []byte, correlationID string)
+	SendPasswordResetEmail(ctx context.Context, user *models.User) error
+}
+
+type passwordService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	uow         repository.UnitOfWork
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+	policy      passwordpolicy.Policy
+}
+
+// HandlePasswordForgot emails a reset link when the account exists, it never replies so callers learn nothing
+func (s *passwordService) HandlePasswordForgot(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasswordForgotEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByEmail(ctx, req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		logrus.Infof("Password reset requested for unknown email | CorrelationID: %s", correlationID)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to find user for password reset: %v", err)
+		return
+	}
+
+	if err := s.SendPasswordResetEmail(ctx, user); err != nil {
+		logrus.Errorf("Failed to send password reset email: %v", err)
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPasswordResetRequest, nil)
+}
+
+// HandlePasswordReset sets a new password with a single-use token from a reset email
+func (s *passwordService) HandlePasswordReset(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasswordResetEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	// the whole policy is checked before the token is spent, a rejected password leaves the link usable
+	tokenHash := utils.HashToken(req.Token)
+	token, err := s.tokenRepo.FindToken(ctx, models.TokenPurposePasswordReset, tokenHash)
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired reset link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to find password reset token: %v", err)
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to reset password"))
+		return
+	}
+	user, err := s.userRepo.FindUserByID(ctx, token.UserID.Hex())
+	if err != nil {
+		s.publish("PasswordResetFailed", correlationID, findUserError(err, apperror.ErrInvalidToken.WithMessage("Invalid or expired reset link")))
+		return
+	}
+	if appErr := s.policy.Check("password", req.Password, user.Email, user.Username); appErr != nil {
+		s.publish("PasswordResetFailed", correlationID, appErr)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to hash password"))
+		return
+	}
+
+	// consuming again settles concurrent resets with the same link, only one of them saves its password
+	err = s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.tokenRepo.ConsumeToken(ctx, models.TokenPurposePasswordReset, tokenHash); err != nil {
+			return err
+		}
+		if err := s.userRepo.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+		return s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposePasswordReset)
+	})
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired reset link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to reset password: %v", err)
+		s.publish("PasswordResetFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to reset password"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPasswordReset, nil)
+	s.publish("PasswordResetSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// SendPasswordResetEmail replaces any pending reset link of the user and emails a new one
+func (s *passwordService) SendPasswordResetEmail(ctx context.Context, user *models.User) error {
+	// only the newest link works
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposePasswordReset,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and works once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, int(passwordResetTTL.Minutes()), linkWithToken(os.Getenv("PASSWORD_RESET_URL"), token)),
+	})
+}
+
+func (s *passwordService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// linkWithToken adds the token as a query parameter to a frontend URL
+func linkWithToken(baseURL, token string) string {
+	link, err := url.Parse(baseURL)
+	if err != nil || baseURL == "" {
+		return token
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// NewPasswordService for forgotten password recovery
+func NewPasswordService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, uow repository.UnitOfWork, mail mailer.Mailer, sendMessage *api.SendingMessage) PasswordService {
+	return &passwordService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		uow:         uow,
+		mailer:      mail,
+		sendMessage: sendMessage,
+		policy:      passwordpolicy.Default(),
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/sms"
+)
+
+type PhoneService interface {
+	HandlePhoneOtpSend(ctx context.Context, eventData []byte, correlationID string)
+	HandlePhoneVerify(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type phoneService struct {
+	userRepo    repository.UserRepo
+	smsSender   sms.SMSSender
+	sendMessage *api.SendingMessage
+}
+
+// HandlePhoneOtpSend delivers a one-time code generated by the gateway over SMS or WhatsApp
+func (s *phoneService) HandlePhoneOtpSend(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PhoneOtpSendEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PhoneOtpSendFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PhoneOtpSendFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if user.PhoneVerified && user.Phone == req.Phone {
+		s.publish("PhoneOtpSendFailed", correlationID, apperror.ErrConflict.WithMessage("Phone number is already verified"))
+		return
+	}
+	if ok := s.phoneAvailable(ctx, user, req.Phone, "PhoneOtpSendFailed", correlationID); !ok {
+		return
+	}
+
+	err = s.smsSender.Send(ctx, sms.Message{
+		To:      req.Phone,
+		Channel: req.Channel,
+		Body:    fmt.Sprintf("Your verification code is %s. It expires in %d minutes. Never share this code.", req.Code, req.ExpiresIn/60),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send phone OTP: %v", err)
+		s.publish("PhoneOtpSendFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to send verification code"))
+		return
+	}
+
+	s.publish("PhoneOtpSendSuccess", correlationID, models.PhoneVerifiedEvent{
+		ID:            user.ID.Hex(),
+		Phone:         req.Phone,
+		PhoneVerified: false,
+	})
+}
+
+// HandlePhoneVerify stores the phone number once the gateway has checked its one-time code
+func (s *phoneService) HandlePhoneVerify(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PhoneVerifyEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PhoneVerifyFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PhoneVerifyFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if ok := s.phoneAvailable(ctx, user, req.Phone, "PhoneVerifyFailed", correlationID); !ok {
+		return
+	}
+
+	err = s.userRepo.SetVerifiedPhone(ctx, user.ID, req.Phone)
+	if errors.Is(err, repository.ErrPhoneInUse) {
+		s.publish("PhoneVerifyFailed", correlationID, apperror.ErrPhoneTaken)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to save verified phone: %v", err)
+		s.publish("PhoneVerifyFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to verify phone number"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPhoneVerify, nil)
+	s.publish("PhoneVerifySuccess", correlationID, models.PhoneVerifiedEvent{
+		ID:            user.ID.Hex(),
+		Phone:         req.Phone,
+		PhoneVerified: true,
+	})
+}
+
+// phoneAvailable replies with failedEvent when another account already verified the number
+func (s *phoneService) phoneAvailable(ctx context.Context, user *models.User, phone, failedEvent, correlationID string) bool {
+	owner, err := s.userRepo.FindUserByVerifiedPhone(ctx, phone)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		logrus.Errorf("Failed to find user by phone: %v", err)
+		s.publish(failedEvent, correlationID, apperror.ErrInternal.WithMessage("Failed to verify phone number"))
+		return false
+	}
+	if owner.ID != user.ID {
+		s.publish(failedEvent, correlationID, apperror.ErrPhoneTaken)
+		return false
+	}
+	return true
+}
+
+func (s *phoneService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewPhoneService for phone number verification over SMS or WhatsApp
+func NewPhoneService(userRepo repository.UserRepo, smsSender sms.SMSSender, sendMessage *api.SendingMessage) PhoneService {
+	return &phoneService{
+		userRepo:    userRepo,
+		smsSender:   smsSender,
+		sendMessage: sendMessage,
+	}
+}
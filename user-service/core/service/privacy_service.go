@@ -0,0 +1,437 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"strconv"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+const (
+	// dataExportTTL is how long a ready export can be downloaded
+	dataExportTTL = 7 * 24 * time.Hour
+	// dataExportBuildTimeout is when a pending export is considered lost, e.g. after a restart
+	dataExportBuildTimeout = 15 * time.Minute
+	// dataExportMaxBytes keeps the archive below the MongoDB document limit
+	dataExportMaxBytes = 15 << 20
+	// defaultDeletionGraceDays applies when ACCOUNT_DELETION_GRACE_DAYS is not set
+	defaultDeletionGraceDays = 30
+	// purgeBatchSize is how many due accounts one purge run anonymizes
+	purgeBatchSize = 100
+)
+
+type PrivacyService interface {
+	HandleDataExport(ctx context.Context, eventData []byte, correlationID string)
+	HandleDataExportDownload(ctx context.Context, eventData []byte, correlationID string)
+	HandleAccountDeletion(ctx context.Context, eventData []byte, correlationID string)
+	HandleAccountRestore(ctx context.Context, eventData []byte, correlationID string)
+	PurgeDueAccounts(ctx context.Context) (int, error)
+	DeleteAccount(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type privacyService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	exportRepo  repository.DataExportRepo
+	uow         repository.UnitOfWork
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+}
+
+// HandleDataExport returns the current export of the user and starts a new one when there is none to wait for
+func (s *privacyService) HandleDataExport(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.DataExportEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("DataExportFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("DataExportFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+
+	latest, err := s.exportRepo.FindLatestExport(ctx, user.ID)
+	if err != nil {
+		logrus.Errorf("Failed to find data export: %v", err)
+		s.publish("DataExportFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to export data"))
+		return
+	}
+	if latest != nil && exportUsable(latest) {
+		s.publish("DataExportSuccess", correlationID, models.NewDataExportStatusEvent(latest))
+		return
+	}
+
+	now := time.Now()
+	export := &models.DataExport{
+		UserID:      user.ID,
+		Status:      models.DataExportPending,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(dataExportTTL),
+	}
+	if err := s.exportRepo.CreateExport(ctx, export); err != nil {
+		logrus.Errorf("Failed to create data export: %v", err)
+		s.publish("DataExportFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to export data"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionDataExportRequest, nil)
+	s.publish("DataExportSuccess", correlationID, models.NewDataExportStatusEvent(export))
+
+	// the archive is built after the reply, the user is emailed once it is ready
+	go s.buildExport(context.Background(), export, req.Sessions)
+}
+
+// HandleDataExportDownload returns the archive of a ready export of the user
+func (s *privacyService) HandleDataExportDownload(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.DataExportDownloadEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("DataExportDownloadFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		s.publish("DataExportDownloadFailed", correlationID, apperror.ErrNotFound.WithMessage("Export not found"))
+		return
+	}
+	exportID, err := primitive.ObjectIDFromHex(req.ExportID)
+	if err != nil {
+		s.publish("DataExportDownloadFailed", correlationID, apperror.ErrNotFound.WithMessage("Export not found"))
+		return
+	}
+
+	export, err := s.exportRepo.FindExport(ctx, userID, exportID)
+	if err != nil || time.Now().After(export.ExpiresAt) {
+		s.publish("DataExportDownloadFailed", correlationID, apperror.ErrNotFound.WithMessage("Export not found"))
+		return
+	}
+	if export.Status != models.DataExportReady {
+		s.publish("DataExportDownloadFailed", correlationID, apperror.ErrNotReady.WithMessage("Export is not ready yet"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, userID, models.ActionDataExportDownload, nil)
+	s.publish("DataExportDownloadSuccess", correlationID, models.DataExportFileEvent{
+		FileName: export.FileName,
+		Content:  export.File,
+	})
+}
+
+// HandleAccountDeletion blocks the account and schedules its anonymization, the emailed link restores it until then
+func (s *privacyService) HandleAccountDeletion(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AccountDeletionEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AccountDeletionFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("AccountDeletionFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if refusal := loginRefusal(user); refusal != nil {
+		s.publish("AccountDeletionFailed", correlationID, refusal)
+		return
+	}
+
+	scheduledAt := time.Now().Add(deletionGracePeriod())
+	if err := s.userRepo.ScheduleDeletion(ctx, user.ID, scheduledAt); err != nil {
+		logrus.Errorf("Failed to schedule account deletion: %v", err)
+		s.publish("AccountDeletionFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to delete account"))
+		return
+	}
+
+	if err := s.sendRestoreLink(ctx, user, scheduledAt); err != nil {
+		logrus.Errorf("Failed to send account restore link: %v", err)
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionDeletionRequest, nil)
+	s.publish("AccountDeletionSuccess", correlationID, models.AccountDeletionScheduledEvent{
+		ID:                  user.ID.Hex(),
+		Email:               user.Email,
+		DeletionScheduledAt: scheduledAt,
+	})
+}
+
+// HandleAccountRestore cancels a scheduled deletion with the token from the deletion email
+func (s *privacyService) HandleAccountRestore(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AccountRestoreEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AccountRestoreFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	var token *models.UserToken
+	err := s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if token, err = s.tokenRepo.ConsumeToken(ctx, models.TokenPurposeAccountRestore, utils.HashToken(req.Token)); err != nil {
+			return err
+		}
+		if err := s.userRepo.CancelDeletion(ctx, token.UserID); err != nil {
+			// the account was purged or restored already
+			return fmt.Errorf("%w: %v", repository.ErrTokenInvalid, err)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("AccountRestoreFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired restore link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to restore account: %v", err)
+		s.publish("AccountRestoreFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to restore account"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, token.UserID, models.ActionAccountRestore, nil)
+	s.publish("AccountRestoreSuccess", correlationID, models.UserLoginEvent{
+		ID:    token.UserID.Hex(),
+		Email: token.Email,
+	})
+}
+
+// PurgeDueAccounts anonymizes the accounts whose grace period ended and tells other services to purge them
+func (s *privacyService) PurgeDueAccounts(ctx context.Context) (int, error) {
+	users, err := s.userRepo.FindUsersDueForDeletion(ctx, time.Now(), purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err := s.purge(ctx, user.ID); err != nil {
+			logrus.Errorf("Failed to purge user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// DeleteAccount anonymizes a user right away, without the grace period. The account is scheduled first so
+// a failed purge leaves it pending and PurgeDueAccounts retries it
+func (s *privacyService) DeleteAccount(ctx context.Context, userID primitive.ObjectID) error {
+	if err := s.userRepo.ScheduleDeletion(ctx, userID, time.Now()); err != nil {
+		return fmt.Errorf("schedule deletion: %w", err)
+	}
+	return s.purge(ctx, userID)
+}
+
+// purge anonymizes one user in a unit of work, a failure leaves the account pending and it is retried
+func (s *privacyService) purge(ctx context.Context, userID primitive.ObjectID) error {
+	err := s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.AnonymizeActivityLogs(ctx, userID); err != nil {
+			return fmt.Errorf("anonymize activity: %w", err)
+		}
+		if err := s.tokenRepo.DeleteUserTokens(ctx, userID, ""); err != nil {
+			return fmt.Errorf("delete tokens: %w", err)
+		}
+		if err := s.exportRepo.DeleteUserExports(ctx, userID); err != nil {
+			return fmt.Errorf("delete exports: %w", err)
+		}
+		if err := s.userRepo.AnonymizeUser(ctx, userID); err != nil {
+			return fmt.Errorf("anonymize user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// nobody waits for this event, the ID only traces it
+	s.publish("UserDeleted", primitive.NewObjectID().Hex(), models.UserDeletedEvent{
+		UserID:    userID.Hex(),
+		DeletedAt: time.Now(),
+	})
+	logrus.Infof("User %s anonymized", userID.Hex())
+	return nil
+}
+
+// buildExport writes the archive of a pending export and emails the user when it is ready
+func (s *privacyService) buildExport(ctx context.Context, export *models.DataExport, sessions []models.SessionInfo) {
+	ctx, cancel := context.WithTimeout(ctx, dataExportBuildTimeout)
+	defer cancel()
+
+	user, file, err := s.exportArchive(ctx, export.UserID, sessions)
+	if err == nil && len(file) > dataExportMaxBytes {
+		err = fmt.Errorf("archive of %d bytes is too large", len(file))
+	}
+	if err != nil {
+		logrus.Errorf("Failed to build data export %s: %v", export.ID.Hex(), err)
+		if err := s.exportRepo.FailExport(ctx, export.ID, "Failed to build the export"); err != nil {
+			logrus.Errorf("Failed to mark data export %s failed: %v", export.ID.Hex(), err)
+		}
+		return
+	}
+
+	fileName := fmt.Sprintf("data-export-%s.zip", export.RequestedAt.UTC().Format("20060102-150405"))
+	if err := s.exportRepo.CompleteExport(ctx, export.ID, fileName, file); err != nil {
+		logrus.Errorf("Failed to save data export %s: %v", export.ID.Hex(), err)
+		return
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("Hi %s,\n\nThe export of your data is ready. Download it from your account within %d days.\n\n%s\n",
+			user.Username, int(dataExportTTL.Hours()/24), os.Getenv("DATA_EXPORT_URL")),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send data export email: %v", err)
+	}
+}
+
+// exportArchive zips the profile, activity log and sessions of the user as JSON files
+func (s *privacyService) exportArchive(ctx context.Context, userID primitive.ObjectID, sessions []models.SessionInfo) (*models.User, []byte, error) {
+	user, err := s.userRepo.FindUserByID(ctx, userID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+	activity, err := s.userRepo.FindActivityLogs(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// secrets are not personal data, the password hash never leaves the service
+	profile := *user
+	profile.Password = ""
+
+	activityEntries := make([]map[string]interface{}, 0, len(activity))
+	for _, entry := range activity {
+		item := map[string]interface{}{
+			"action": entry.Action,
+			"at":     entry.ActivityTimestamp.UTC(),
+		}
+		if entry.IP != "" {
+			item["ip"] = entry.IP
+			item["user_agent"] = entry.UserAgent
+		}
+		if len(entry.Metadata) > 0 {
+			item["details"] = entry.Metadata
+		}
+		// admins stay anonymous, the user only learns that one acted
+		if !entry.ActorID.IsZero() && entry.ActorID != userID {
+			item["by_admin"] = true
+		}
+		activityEntries = append(activityEntries, item)
+	}
+	if sessions == nil {
+		sessions = []models.SessionInfo{}
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"profile.json", profile},
+		{"activity.json", activityEntries},
+		{"sessions.json", sessions},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, nil, err
+		}
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, nil, err
+	}
+	return user, buf.Bytes(), nil
+}
+
+// sendRestoreLink emails a link that cancels the deletion until it is carried out
+func (s *privacyService) sendRestoreLink(ctx context.Context, user *models.User, scheduledAt time.Time) error {
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeAccountRestore); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeAccountRestore,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: scheduledAt,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account is scheduled for deletion on %s. Until then you can restore it with the link below.\n\n%s\n\nAfter that date your personal data is erased and cannot be recovered.\n",
+			user.Username, scheduledAt.UTC().Format("2 January 2006"), linkWithToken(os.Getenv("ACCOUNT_RESTORE_URL"), token)),
+	})
+}
+
+func (s *privacyService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// exportUsable tells whether an export is ready to download or still being built
+func exportUsable(export *models.DataExport) bool {
+	switch export.Status {
+	case models.DataExportReady:
+		return time.Now().Before(export.ExpiresAt)
+	case models.DataExportPending:
+		return time.Since(export.RequestedAt) < dataExportBuildTimeout
+	}
+	return false
+}
+
+// deletionGracePeriod reads ACCOUNT_DELETION_GRACE_DAYS
+func deletionGracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		days = defaultDeletionGraceDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// NewPrivacyService for data exports and account deletion
+func NewPrivacyService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, exportRepo repository.DataExportRepo, uow repository.UnitOfWork, mail mailer.Mailer, sendMessage *api.SendingMessage) PrivacyService {
+	return &privacyService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		exportRepo:  exportRepo,
+		uow:         uow,
+		mailer:      mail,
+		sendMessage: sendMessage,
+	}
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/passwordpolicy"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+const (
+	// emailChangeTTL is how long the link sent to a new email address stays valid
+	emailChangeTTL = 24 * time.Hour
+	// maxUpdateAttempts is how often an update is retried after losing a race with another update
+	maxUpdateAttempts = 3
+)
+
+// errStaleProfile is returned when the client edited an older version of the profile
+var errStaleProfile = errors.New("profile was changed by another request, reload it and try again")
+
+type ProfileService interface {
+	HandleProfileUpdate(ctx context.Context, eventData []byte, correlationID string)
+	HandlePasswordChange(ctx context.Context, eventData []byte, correlationID string)
+	HandleEmailChange(ctx context.Context, eventData []byte, correlationID string)
+	HandleEmailChangeConfirm(ctx context.Context, eventData []byte, correlationID string)
+	HandleAvatarUpdate(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type profileService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	uow         repository.UnitOfWork
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+	policy      passwordpolicy.Policy
+}
+
+// HandleProfileUpdate changes the profile fields that are set, a new phone number has to be verified again
+func (s *profileService) HandleProfileUpdate(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.ProfileUpdateEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("ProfileUpdateFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.update(ctx, req.UserID, func(user *models.User) error {
+		if req.Version != nil && *req.Version != user.Version {
+			return errStaleProfile
+		}
+		if req.Username != nil {
+			user.Username = *req.Username
+		}
+		if req.Address != nil {
+			user.Address = *req.Address
+		}
+		if req.Age != nil {
+			user.Age = *req.Age
+		}
+		if req.Phone != nil && *req.Phone != user.Phone {
+			user.Phone = *req.Phone
+			user.PhoneVerified = false
+			user.PhoneVerifiedAt = nil
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errStaleProfile), errors.Is(err, repository.ErrVersionConflict):
+		s.publish("ProfileUpdateFailed", correlationID, apperror.ErrVersionConflict)
+		return
+	case errors.Is(err, repository.ErrNotFound):
+		s.publish("ProfileUpdateFailed", correlationID, apperror.ErrUserNotFound)
+		return
+	case err != nil:
+		logrus.Errorf("Failed to update profile: %v", err)
+		s.publish("ProfileUpdateFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to update profile"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionProfileUpdate, nil)
+	s.publish("ProfileUpdateSuccess", correlationID, models.NewUserProfileEvent(user))
+}
+
+// HandlePasswordChange replaces the password once the current one is proven
+func (s *profileService) HandlePasswordChange(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.PasswordChangeEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("PasswordChangeFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if user.Password == "" {
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrConflict.WithMessage("This account has no password yet, use forgot password to set one"))
+		return
+	}
+	if !utils.CheckPasswordHash(req.CurrentPassword, user.Password) {
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrInvalidCredentials.WithMessage("Current password is incorrect"))
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrValidation.WithField("new_password", "New password must be different from the current one"))
+		return
+	}
+	if appErr := s.policy.Check("new_password", req.NewPassword, user.Email, user.Username); appErr != nil {
+		s.publish("PasswordChangeFailed", correlationID, appErr)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to hash password"))
+		return
+	}
+	err = s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+			return err
+		}
+		// reset links sent before the change must not undo it
+		return s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposePasswordReset)
+	})
+	if err != nil {
+		logrus.Errorf("Failed to update password: %v", err)
+		s.publish("PasswordChangeFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to change password"))
+		return
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was changed and your other sessions were signed out.\n\nIf you did not do this, reset your password right away.\n",
+			user.Username),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send password change notice: %v", err)
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionPasswordChange, nil)
+	s.publish("PasswordChangeSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// HandleEmailChange emails a confirmation link to the new address, the account keeps its email until it is followed
+func (s *profileService) HandleEmailChange(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.EmailChangeEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("EmailChangeFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("EmailChangeFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if strings.EqualFold(req.NewEmail, user.Email) {
+		s.publish("EmailChangeFailed", correlationID, apperror.ErrValidation.WithField("new_email", "New email is the same as the current one"))
+		return
+	}
+
+	_, err = s.userRepo.FindUserByEmail(ctx, req.NewEmail)
+	if err == nil {
+		s.publish("EmailChangeFailed", correlationID, apperror.ErrEmailTaken)
+		return
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		logrus.Errorf("Failed to find user by email: %v", err)
+		s.publish("EmailChangeFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to change email"))
+		return
+	}
+
+	if err := s.sendEmailChangeLink(ctx, user, req.NewEmail); err != nil {
+		logrus.Errorf("Failed to send email change link: %v", err)
+		s.publish("EmailChangeFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to send confirmation email"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionEmailChangeRequest, nil)
+	s.publish("EmailChangeSuccess", correlationID, models.EmailChangePendingEvent{
+		ID:           user.ID.Hex(),
+		Email:        user.Email,
+		PendingEmail: req.NewEmail,
+	})
+}
+
+// HandleEmailChangeConfirm switches the account to the address the confirmation link was sent to
+func (s *profileService) HandleEmailChangeConfirm(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.EmailChangeConfirmEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("EmailChangeConfirmFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	// the link is only used up when the address is changed
+	var user *models.User
+	var previousEmail string
+	err := s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		token, err := s.tokenRepo.ConsumeToken(ctx, models.TokenPurposeEmailChange, utils.HashToken(req.Token))
+		if err != nil {
+			return err
+		}
+		user, err = s.update(ctx, token.UserID.Hex(), func(user *models.User) error {
+			now := time.Now()
+			previousEmail = user.Email
+			user.Email = token.Email
+			user.EmailVerified = true
+			user.EmailVerifiedAt = &now
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// links sent to the previous address no longer apply
+		for _, purpose := range []string{models.TokenPurposeEmailChange, models.TokenPurposeEmailVerification} {
+			if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, purpose); err != nil {
+				return fmt.Errorf("delete %s tokens: %w", purpose, err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("EmailChangeConfirmFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired confirmation link"))
+		return
+	}
+	if errors.Is(err, repository.ErrEmailInUse) {
+		s.publish("EmailChangeConfirmFailed", correlationID, apperror.ErrEmailTaken)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to change email: %v", err)
+		s.publish("EmailChangeConfirmFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to change email"))
+		return
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      previousEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s.\n\nIf you did not do this, contact support right away.\n",
+			user.Username, user.Email),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send email change notice: %v", err)
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionEmailChange, nil)
+	s.publish("EmailChangeConfirmSuccess", correlationID, models.UserLoginEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: true,
+	})
+}
+
+// HandleAvatarUpdate points the user at a new uploaded avatar, the gateway stored the files before
+func (s *profileService) HandleAvatarUpdate(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.AvatarUpdateEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("AvatarUpdateFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+	if req.Avatar == "" || len(req.Variants) == 0 {
+		s.publish("AvatarUpdateFailed", correlationID, apperror.ErrValidation.WithMessage("Avatar URLs are required"))
+		return
+	}
+
+	// the avatar actually replaced, a retried update reads it again
+	var replaced map[string]string
+	user, err := s.update(ctx, req.UserID, func(user *models.User) error {
+		replaced = user.AvatarVariants
+		user.Avatar = req.Avatar
+		user.AvatarVariants = req.Variants
+		return nil
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		s.publish("AvatarUpdateFailed", correlationID, apperror.ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to update avatar: %v", err)
+		s.publish("AvatarUpdateFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to update avatar"))
+		return
+	}
+
+	recordActivity(ctx, s.sendMessage, user.ID, models.ActionAvatarUpdate, nil)
+	s.publish("AvatarUpdateSuccess", correlationID, models.AvatarUpdatedEvent{
+		GetUserProfileEvent: models.NewUserProfileEvent(user),
+		ReplacedVariants:    replaced,
+	})
+}
+
+// sendEmailChangeLink replaces any pending email change of the user and emails a link to the new address
+func (s *profileService) sendEmailChangeLink(ctx context.Context, user *models.User, newEmail string) error {
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeEmailChange); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeEmailChange,
+		Email:     newEmail,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm that this is the new email address of your account with the link below. It expires in %d hours.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, int(emailChangeTTL.Hours()), linkWithToken(os.Getenv("EMAIL_CHANGE_URL"), token)),
+	})
+}
+
+// update loads the user, applies change and saves it, starting over when a concurrent update saved first
+func (s *profileService) update(ctx context.Context, userID string, change func(user *models.User) error) (*models.User, error) {
+	for attempt := 1; ; attempt++ {
+		user, err := s.userRepo.FindUserByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := change(user); err != nil {
+			return nil, err
+		}
+
+		err = s.userRepo.UpdateUser(ctx, user)
+		if errors.Is(err, repository.ErrVersionConflict) && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+}
+
+func (s *profileService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewProfileService for users managing their own profile, password and email
+func NewProfileService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, uow repository.UnitOfWork, mail mailer.Mailer, sendMessage *api.SendingMessage) ProfileService {
+	return &profileService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		uow:         uow,
+		mailer:      mail,
+		sendMessage: sendMessage,
+		policy:      passwordpolicy.Default(),
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+type RoleService interface {
+	HandleGetRoles(ctx context.Context, eventData []byte, correlationID string)
+}
+
+type roleService struct {
+	roleRepo    repository.RoleRepo
+	sendMessage *api.SendingMessage
+}
+
+// HandleGetRoles replies with the permissions of every role, the gateway caches them for RequirePermission
+func (s *roleService) HandleGetRoles(ctx context.Context, eventData []byte, correlationID string) {
+	roles, err := s.roleRepo.ListRoles(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to list roles: %v", err)
+		s.publish("GetRolesFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to load roles"))
+		return
+	}
+
+	s.publish("GetRolesSuccess", correlationID, models.RolePermissionsEvent{
+		Roles: models.ResolvePermissions(roles),
+	})
+}
+
+func (s *roleService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewRoleService for role definitions used by permission checks
+func NewRoleService(roleRepo repository.RoleRepo, sendMessage *api.SendingMessage) RoleService {
+	return &roleService{
+		roleRepo:    roleRepo,
+		sendMessage: sendMessage,
+	}
+}
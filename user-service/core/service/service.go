@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+)
+
+// publishReply sends a reply event and logs when it could not be delivered. An error payload is
+// sent as its catalog error, errors outside the catalog are logged and sent as apperror.ErrInternal
+func publishReply(sendMessage api.Publisher, eventType string, correlationID string, payload interface{}) {
+	if err, ok := payload.(error); ok {
+		appErr := apperror.From(err)
+		if appErr == apperror.ErrInternal && err != apperror.ErrInternal {
+			logrus.Errorf("%s: %v", eventType, err)
+		}
+		payload = appErr
+	}
+	if err := sendMessage.SendingToMessage(eventType, correlationID, payload); err != nil {
+		logrus.Errorf("Failed to publish %s: %v", eventType, err)
+	}
+}
+
+// findUserError is the reply to a failed user lookup: notFound when the user does not exist, otherwise
+// the error itself, which publishReply logs and sends as apperror.ErrInternal
+func findUserError(err error, notFound *apperror.Error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return notFound
+	}
+	return err
+}
+
+// eventContextKey carries the correlation ID and request metadata of the event being handled
+type eventContextKey struct{}
+
+type eventContext struct {
+	correlationID string
+	metadata      models.RequestMetadata
+}
+
+// WithEvent returns ctx carrying the correlation ID and request metadata of event, the audit trail reads them
+func WithEvent(ctx context.Context, event models.Event) context.Context {
+	value := eventContext{correlationID: event.CorrelationID}
+	if event.Metadata != nil {
+		value.metadata = *event.Metadata
+	}
+	return context.WithValue(ctx, eventContextKey{}, value)
+}
+
+// eventFrom returns what WithEvent stored in ctx
+func eventFrom(ctx context.Context) eventContext {
+	value, _ := ctx.Value(eventContextKey{}).(eventContext)
+	return value
+}
+
+// recordActivity adds an entry the user caused to the audit trail, failures are logged and never block the flow.
+// The actor is the signed-in user of the request, or the user itself for requests nobody signed in for
+func recordActivity(ctx context.Context, sendMessage api.Publisher, userID primitive.ObjectID, action string, metadata map[string]string) {
+	actor := userID
+	if id, err := primitive.ObjectIDFromHex(eventFrom(ctx).metadata.ActorID); err == nil {
+		actor = id
+	}
+	saveActivity(ctx, sendMessage, models.UserActivityLog{UserID: userID, ActorID: actor, Action: action, Metadata: metadata})
+}
+
+// recordAdminActivity adds an audit entry for an action an admin took on a user
+func recordAdminActivity(ctx context.Context, sendMessage api.Publisher, userID primitive.ObjectID, actorID string, action string, metadata map[string]string) {
+	actor, err := primitive.ObjectIDFromHex(actorID)
+	if err != nil {
+		logrus.Errorf("Invalid actor ID %q for %s", actorID, action)
+	}
+	saveActivity(ctx, sendMessage, models.UserActivityLog{UserID: userID, ActorID: actor, Action: action, Metadata: metadata})
+}
+
+// saveActivity completes entry with the request of ctx and the current time, then publishes it for the audit
+// consumer to write. The ID is set here so a batch the consumer retries is not written twice
+func saveActivity(ctx context.Context, sendMessage api.Publisher, entry models.UserActivityLog) {
+	event := eventFrom(ctx)
+	entry.ID = primitive.NewObjectID()
+	entry.IP = event.metadata.IP
+	entry.UserAgent = event.metadata.UserAgent
+	entry.CorrelationID = event.correlationID
+	entry.ActivityTimestamp = time.Now().UTC()
+	if err := sendMessage.SendingToMessage(ActivityRecordedEvent, event.correlationID, entry); err != nil {
+		logrus.Errorf("Failed to publish %s to the activity log: %v", entry.Action, err)
+	}
+}
+
+// loginRefusal explains why an account may not sign in right now, nil when it may
+func loginRefusal(user *models.User) *apperror.Error {
+	switch user.Status {
+	case models.UserStatusPendingDeletion:
+		return apperror.ErrAccountPendingDeletion
+	case models.UserStatusDeleted:
+		return apperror.ErrAccountDeleted
+	}
+	if user.Status == models.UserStatusSuspended {
+		if user.Suspension == nil || user.Suspension.Until == nil {
+			return apperror.ErrAccountSuspended
+		}
+		if time.Now().Before(*user.Suspension.Until) {
+			return apperror.ErrAccountSuspended.WithMessage("Account suspended until " + user.Suspension.Until.UTC().Format(time.RFC1123))
+		}
+	}
+	return nil
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"messaging"
 	"time"
 	"user-service/api"
+	"user-service/core/apperror"
 	"user-service/core/models"
+	"user-service/core/passwordpolicy"
 	"user-service/core/repository"
 	"user-service/utils"
 
@@ -24,9 +24,12 @@ type UserService interface {
 }
 
 type userService struct {
-	userRepo    repository.UserRepo
-	rmq         *messaging.RabbitMQConnection
-	sendMessage *api.SendingMessage
+	userRepo          repository.UserRepo
+	rmq               *messaging.RabbitMQConnection
+	sendMessage       api.Publisher
+	emailVerification EmailVerificationService
+	hasher            utils.PasswordHasher
+	policy            passwordpolicy.Policy
 }
 
 // HandleUserRegistered is a function to handle user registration
@@ -43,22 +46,26 @@ func (c *userService) HandleUserRegistered(ctx context.Context, eventData []byte
 	}
 
 	// Cek if user already registered
-	existingUser, _ := c.userRepo.FindUserByEmail(ctx, req.Email)
-	if existingUser != nil {
-		errorResponse := c.sendMessage.SendingToMessage("UserRegisteredFailed", correlationID, "Email already registered")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserRegisteredFailed: %v", errorResponse)
-		}
+	_, err := c.userRepo.FindUserByEmail(ctx, req.Email)
+	if err == nil {
+		c.publish("UserRegisteredFailed", correlationID, apperror.ErrEmailTaken)
+		return
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		logrus.Errorf("Failed to find user by email: %v", err)
+		c.publish("UserRegisteredFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to register user"))
+		return
+	}
+
+	if appErr := c.policy.Check("password", req.Password, req.Email, req.Username); appErr != nil {
+		c.publish("UserRegisteredFailed", correlationID, appErr)
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := utils.HashPassword(req.Password)
+	hashedPassword, err := c.hasher.Hash(req.Password)
 	if err != nil {
-		errorResponse := c.sendMessage.SendingToMessage("UserRegisteredFailed", correlationID, "Failed to hash password")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserRegisteredFailed: %v", errorResponse)
-		}
+		c.publish("UserRegisteredFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to hash password"))
 		return
 	}
 
@@ -77,22 +84,21 @@ func (c *userService) HandleUserRegistered(ctx context.Context, eventData []byte
 
 	// save to user
 	_, err = c.userRepo.SaveUser(ctx, &newUser)
+	if errors.Is(err, repository.ErrEmailInUse) {
+		c.publish("UserRegisteredFailed", correlationID, apperror.ErrEmailTaken)
+		return
+	}
 	if err != nil {
-		errorResponse := c.sendMessage.SendingToMessage("UserRegisteredFailed", correlationID, "Failed to save user")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserRegisteredFailed: %v", errorResponse)
-		}
+		logrus.Errorf("Failed to save user: %v", err)
+		c.publish("UserRegisteredFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to save user"))
 		return
 	}
 	// save to userActivityLog
-	SaveActivityLog := &models.UserActivityLog{
-		UserID:            newUser.ID,
-		ActivityType:      "Register",
-		ActivityTimestamp: primitive.DateTime(time.Now().Unix()),
-	}
-	_, err = c.userRepo.SaveToActivityLog(ctx, SaveActivityLog)
-	if err != nil {
-		logrus.Errorf("Failed to save user activity log: %v", err)
+	recordActivity(ctx, c.sendMessage, newUser.ID, models.ActionRegister, map[string]string{"method": "password"})
+
+	// the account works right away, verified-only features wait for the link
+	if err := c.emailVerification.SendVerificationEmail(ctx, &newUser); err != nil {
+		logrus.Errorf("Failed to send verification email: %v", err)
 	}
 
 	successResponse := c.sendMessage.SendingToMessage("UserRegisteredSuccess", correlationID, models.UserRegisteredEvent{
@@ -125,39 +131,53 @@ func (c *userService) HandleUserLogin(ctx context.Context, eventData []byte, cor
 
 	// find user by email
 	user, err := c.userRepo.FindUserByEmail(ctx, req.Email)
+	// unknown emails get the same answer as a wrong password
+	if errors.Is(err, repository.ErrNotFound) {
+		c.publish("UserLoginFailed", correlationID, apperror.ErrInvalidCredentials)
+		return
+	}
 	if err != nil {
-		errorResponse := c.sendMessage.SendingToMessage("UserLoginFailed", correlationID, "User Already Registered")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserLoginFailed: %v", errorResponse)
-		}
+		logrus.Errorf("Failed to find user by email: %v", err)
+		c.publish("UserLoginFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to sign in"))
 		return
 	}
-
-	// Cek if password is correct
-	if !utils.CheckPasswordHash(req.Password, user.Password) {
-		errorResponse := c.sendMessage.SendingToMessage("UserLoginFailed", correlationID, "Invalid Email or Password")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserLoginFailed: %v", errorResponse)
-		}
+	match, rehash := c.hasher.Verify(req.Password, user.Password)
+	if !match {
+		c.publish("UserLoginFailed", correlationID, apperror.ErrInvalidCredentials)
 		return
 	}
 
-	// save to userActivityLog
-	SaveActivityLog := models.UserActivityLog{
-		ID:                primitive.NewObjectID(),
-		UserID:            user.ID,
-		ActivityType:      "Login",
-		ActivityTimestamp: primitive.DateTime(time.Now().Unix()),
+	if refusal := loginRefusal(user); refusal != nil {
+		c.publish("UserLoginFailed", correlationID, refusal)
+		return
+	}
+	if user.PasswordResetRequired {
+		c.publish("UserLoginFailed", correlationID, apperror.ErrPasswordResetRequired)
+		return
+	}
+	if rehash {
+		c.rehashPassword(ctx, user, req.Password)
 	}
 
-	_, err = c.userRepo.SaveToActivityLog(ctx, &SaveActivityLog)
-	if err != nil {
-		logrus.Errorf("Failed to save user activity log: %v", err)
+	// a second factor is checked by HandleUserLoginMfa before any token is issued
+	if requiresMFA(user) {
+		c.publish("UserLoginMfaRequired", correlationID, models.UserLoginMfaRequiredEvent{
+			ID:                 user.ID.Hex(),
+			Email:              user.Email,
+			Role:               user.Role,
+			EnrollmentRequired: !user.MFA.TOTPEnabled,
+		})
+		return
 	}
+
+	// save to userActivityLog
+	recordActivity(ctx, c.sendMessage, user.ID, models.ActionLogin, map[string]string{"method": "password"})
+
 	successResponse := c.sendMessage.SendingToMessage("UserLoginSuccess", correlationID, models.UserLoginEvent{
-		ID:    user.ID.Hex(),
-		Email: user.Email,
-		Role:  user.Role,
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
 	})
 
 	if successResponse != nil {
@@ -165,7 +185,22 @@ func (c *userService) HandleUserLogin(ctx context.Context, eventData []byte, cor
 	}
 }
 
-// HandleUserOauth is a function to handle user oauth
+// rehashPassword replaces a hash made with an outdated algorithm or parameters while the password is at hand,
+// a failure is logged and the next login tries again
+func (c *userService) rehashPassword(ctx context.Context, user *models.User, password string) {
+	hash, err := c.hasher.Hash(password)
+	if err != nil {
+		logrus.Errorf("Failed to rehash password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if _, err := c.userRepo.RehashPassword(ctx, user.ID, user.Password, hash); err != nil {
+		logrus.Errorf("Failed to rehash password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	user.Password = hash
+}
+
+// HandleUserOauth is a function to handle login / registration through an OIDC provider
 func (c *userService) HandleUserOauth(ctx context.Context, eventData []byte, correlationID string) {
 	if c.sendMessage == nil {
 		logrus.Fatalf("Failed to initialize SendingMessage")
@@ -176,56 +211,101 @@ func (c *userService) HandleUserOauth(ctx context.Context, eventData []byte, cor
 
 	// Unmarshal event JSON ke struct `UserOauthEvent`
 	if err := json.Unmarshal(eventData, &req); err != nil {
-		errorResponse := c.sendMessage.SendingToMessage("UserOauthFailed", correlationID, "Failed to unmarshal event data")
-		if errorResponse != nil {
-			logrus.Errorf("Failed to publish UserOauthFailed: %v", errorResponse)
-		}
+		c.publish("UserOAuthFailed", correlationID, apperror.ErrInvalidRequest)
 		return
 	}
 
-	// find user by email
-	user, err := c.userRepo.FindUserByEmail(ctx, req.Email)
-	if err != nil {
-		// if not found
-		if errors.Is(mongo.ErrNoDocuments, err) {
-			newUser := models.User{
-				GoogleID:  req.GoogleID,
-				Email:     req.Email,
-				Username:  req.Username,
-				Avatar:    req.Avatar,
-				Role:      models.RoleUser,
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-
-			_, saveErr := c.userRepo.SaveUser(ctx, &newUser)
-			if saveErr != nil {
-				logrus.Errorf("Failed to save new user: %v", saveErr)
-				errorResponse := c.sendMessage.SendingToMessage("UserOauthFailed", correlationID, "Failed to save user")
-				if errorResponse != nil {
-					logrus.Errorf("Failed to publish UserOauthFailed: %v", errorResponse)
-				}
-				return
-			}
-		} else {
-			errorResponse := c.sendMessage.SendingToMessage("UserOauthFailed", correlationID, "Failed to find user")
-			if errorResponse != nil {
-				logrus.Errorf("Failed to publish UserOauthFailed: %v", errorResponse)
-			}
-		}
+	// find user by linked identity
+	user, err := c.userRepo.FindUserByIdentity(ctx, req.Provider, req.Subject)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logrus.Errorf("Failed to find user by identity: %v", err)
+		c.publish("UserOAuthFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to find user"))
+		return
+	}
 
-		successResponse := c.sendMessage.SendingToMessage("UserOauthSuccess", correlationID, models.UserOAuthEvent{
-			GoogleID: user.GoogleID,
-			Email:    user.Email,
-			Username: user.Username,
-			Avatar:   user.Avatar,
-			Role:     user.Role,
-		})
+	action := models.ActionLogin
+	if user == nil && !req.EmailVerified {
+		// an address the provider never verified proves nothing, it must neither match nor claim an account
+		c.publish("UserOAuthFailed", correlationID, apperror.ErrProviderEmailUnverified)
+		return
+	}
+	if user == nil {
+		// the email may already belong to an account that signs in another way
+		existingUser, err := c.userRepo.FindUserByEmail(ctx, req.Email)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			logrus.Errorf("Failed to find user by email: %v", err)
+			c.publish("UserOAuthFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to find user"))
+			return
+		}
+		if existingUser != nil {
+			// linking needs proof that the caller owns the existing account
+			c.publish("UserOAuthLinkRequired", correlationID, models.UserOAuthLinkRequiredEvent{
+				Email:       existingUser.Email,
+				Provider:    req.Provider,
+				HasPassword: existingUser.Password != "",
+			})
+			return
+		}
 
-		if successResponse != nil {
-			logrus.Errorf("failed to publish User Oauth Success %v", successResponse)
+		now := time.Now()
+		newUser := models.User{
+			Email:     req.Email,
+			Username:  req.Username,
+			Avatar:    req.Avatar,
+			Role:      models.RoleUser,
+			CreatedAt: now,
+			UpdatedAt: now,
+			LinkedIdentities: []models.LinkedIdentity{
+				{Provider: req.Provider, Subject: req.Subject, Email: req.Email, LinkedAt: now},
+			},
 		}
+		// the provider vouches for the address
+		newUser.EmailVerified = true
+		newUser.EmailVerifiedAt = &now
+
+		_, err = c.userRepo.SaveUser(ctx, &newUser)
+		// another request may have registered the address since it was looked up
+		if errors.Is(err, repository.ErrEmailInUse) {
+			c.publish("UserOAuthFailed", correlationID, apperror.ErrEmailTaken)
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to save new user: %v", err)
+			c.publish("UserOAuthFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to save user"))
+			return
+		}
+		user = &newUser
+		action = models.ActionRegister
+	}
+
+	if refusal := loginRefusal(user); refusal != nil {
+		c.publish("UserOAuthFailed", correlationID, refusal)
+		return
+	}
+
+	// a social login does not replace the second factor
+	if requiresMFA(user) {
+		c.publish("UserOAuthMfaRequired", correlationID, models.UserLoginMfaRequiredEvent{
+			ID:                 user.ID.Hex(),
+			Email:              user.Email,
+			Role:               user.Role,
+			EnrollmentRequired: !user.MFA.TOTPEnabled,
+		})
+		return
 	}
+
+	// save to userActivityLog
+	recordActivity(ctx, c.sendMessage, user.ID, action, map[string]string{"method": "oauth", "provider": req.Provider})
+
+	c.publish("UserOAuthSuccess", correlationID, models.UserOAuthSuccessEvent{
+		ID:            user.ID.Hex(),
+		Email:         user.Email,
+		Username:      user.Username,
+		Avatar:        user.Avatar,
+		Role:          user.Role,
+		Provider:      req.Provider,
+		EmailVerified: user.EmailVerified,
+	})
 }
 
 // HandleGetProfile is a function to get user profile
@@ -233,62 +313,38 @@ func (c *userService) HandleGetProfile(ctx context.Context, payloadBytes []byte,
 	var event models.GetUserProfileEvent
 	if err := json.Unmarshal(payloadBytes, &event); err != nil {
 		logrus.Errorf("Failed to unmarshal GetProfile event: %v", err)
-		err := c.sendMessage.SendingToMessage(correlationID, "GetProfileFailed", "Invalid request format")
-		if err != nil {
-			logrus.Errorf("Failed to publish GetProfileFailed: %v", err)
-		}
+		c.publish("GetProfileFailed", correlationID, apperror.ErrInvalidRequest)
 		return
 	}
 
 	// Get user profile from database
 	user, err := c.userRepo.FindUserByID(ctx, event.ID)
 	if err != nil {
-		logrus.Errorf("Failed to get user profile: %v", err)
-		err := c.sendMessage.SendingToMessage(correlationID, "GetProfileFailed", "Failed to get user profile")
-		if err != nil {
-			logrus.Errorf("Failed to publish GetProfileFailed: %v", err)
-		}
-		return
-	}
-
-	if user == nil {
-		err := c.sendMessage.SendingToMessage(correlationID, "GetProfileFailed", "User not found")
-		if err != nil {
-			logrus.Errorf("Failed to publish GetProfileFailed: %v", err)
-		}
+		c.publish("GetProfileFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
 		return
 	}
 
 	// save to userActivityLog
-	SaveActivityLog := models.UserActivityLog{
-		ID:                primitive.NewObjectID(),
-		UserID:            user.ID,
-		ActivityType:      "User Profile",
-		ActivityTimestamp: primitive.DateTime(time.Now().Unix()),
-	}
+	recordActivity(ctx, c.sendMessage, user.ID, models.ActionProfileView, nil)
 
-	_, err = c.userRepo.SaveToActivityLog(ctx, &SaveActivityLog)
-	if err != nil {
-		logrus.Errorf("Failed to save user activity log: %v", err)
-	}
-
-	if err := c.sendMessage.SendingToMessage("GetProfileSuccess", correlationID, models.GetUserProfileEvent{
-		ID:      user.ID.Hex(),
-		Email:   user.Email,
-		Name:    user.Username,
-		Address: user.Address,
-		Age:     user.Age,
-		Phone:   user.Phone,
-	}); err != nil {
+	if err := c.sendMessage.SendingToMessage("GetProfileSuccess", correlationID, models.NewUserProfileEvent(user)); err != nil {
 		logrus.Errorf("Failed to publish GetProfileSuccess: %v", err)
 	}
 }
 
+// publish sends a reply event and logs when it could not be delivered
+func (c *userService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(c.sendMessage, eventType, correlationID, payload)
+}
+
 // NewUserService for handling user service
-func NewUserService(userRepo repository.UserRepo, rmq *messaging.RabbitMQConnection, sendMessage *api.SendingMessage) UserService {
+func NewUserService(userRepo repository.UserRepo, rmq *messaging.RabbitMQConnection, sendMessage api.Publisher, emailVerification EmailVerificationService) UserService {
 	return &userService{
-		userRepo:    userRepo,
-		rmq:         rmq,
-		sendMessage: sendMessage,
+		userRepo:          userRepo,
+		rmq:               rmq,
+		sendMessage:       sendMessage,
+		emailVerification: emailVerification,
+		hasher:            utils.DefaultPasswordHasher(),
+		policy:            passwordpolicy.Default(),
 	}
 }
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/passwordpolicy"
+	"user-service/core/repository"
+	"user-service/utils"
+)
+
+// sentEvent is an event the recordingPublisher was asked to send
+type sentEvent struct {
+	eventType string
+	payload   interface{}
+}
+
+// recordingPublisher keeps the events instead of sending them to RabbitMQ
+type recordingPublisher struct {
+	events []sentEvent
+}
+
+func (p *recordingPublisher) SendingToMessage(eventType string, correlationID string, payload interface{}) error {
+	p.events = append(p.events, sentEvent{eventType: eventType, payload: payload})
+	return nil
+}
+
+// reply returns the last event that is not an audit entry
+func (p *recordingPublisher) reply(t *testing.T) sentEvent {
+	t.Helper()
+	for i := len(p.events) - 1; i >= 0; i-- {
+		if p.events[i].eventType != ActivityRecordedEvent {
+			return p.events[i]
+		}
+	}
+	t.Fatal("no reply was published")
+	return sentEvent{}
+}
+
+// noopEmailVerification sends no verification emails
+type noopEmailVerification struct {
+	EmailVerificationService
+	sent []string
+}
+
+func (v *noopEmailVerification) SendVerificationEmail(ctx context.Context, user *models.User) error {
+	v.sent = append(v.sent, user.Email)
+	return nil
+}
+
+// testHashParams keep the Argon2id hashes of the tests fast
+var testHashParams = utils.Argon2idParams{Memory: 1024, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestUserService() (*userService, repository.UserRepo, *recordingPublisher, *noopEmailVerification) {
+	userRepo := repository.NewMemoryUserRepo()
+	publisher := &recordingPublisher{}
+	verification := &noopEmailVerification{}
+	return &userService{
+		userRepo:          userRepo,
+		sendMessage:       publisher,
+		emailVerification: verification,
+		hasher:            utils.NewArgon2idHasher(testHashParams),
+		policy:            passwordpolicy.Policy{MinLength: 8, MaxLength: 64},
+	}, userRepo, publisher, verification
+}
+
+func eventData(t *testing.T, event interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// wantError fails unless the reply is eventType carrying want
+func wantError(t *testing.T, reply sentEvent, eventType string, want *apperror.Error) {
+	t.Helper()
+	err, ok := reply.payload.(error)
+	if reply.eventType != eventType || !ok || !errors.Is(err, want) {
+		t.Fatalf("reply = %s %+v, want %s %s", reply.eventType, reply.payload, eventType, want.Code)
+	}
+}
+
+func TestHandleUserRegistered(t *testing.T) {
+	ctx := context.Background()
+	service, userRepo, publisher, verification := newTestUserService()
+	register := models.UserRegisteredEvent{Email: "jane@example.com", Username: "jane", Password: "correct horse battery"}
+
+	service.HandleUserRegistered(ctx, eventData(t, register), "register")
+	if reply := publisher.reply(t); reply.eventType != "UserRegisteredSuccess" {
+		t.Fatalf("reply = %s %+v, want UserRegisteredSuccess", reply.eventType, reply.payload)
+	}
+	user, err := userRepo.FindUserByEmail(ctx, register.Email)
+	if err != nil {
+		t.Fatalf("FindUserByEmail after registering: %v", err)
+	}
+	if user.Role != models.RoleUser || !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("saved user = %s with %q, want a user with an Argon2id hash", user.Role, user.Password)
+	}
+	if len(verification.sent) != 1 || verification.sent[0] != register.Email {
+		t.Fatalf("verification emails = %v, want one to %s", verification.sent, register.Email)
+	}
+
+	tests := []struct {
+		name  string
+		event models.UserRegisteredEvent
+		want  *apperror.Error
+	}{
+		{"duplicate email", models.UserRegisteredEvent{Email: register.Email, Username: "other", Password: "another long password"}, apperror.ErrEmailTaken},
+		{"password too short", models.UserRegisteredEvent{Email: "short@example.com", Username: "short", Password: "short"}, apperror.ErrPasswordTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.HandleUserRegistered(ctx, eventData(t, tt.event), tt.name)
+			wantError(t, publisher.reply(t), "UserRegisteredFailed", tt.want)
+		})
+	}
+}
+
+func TestHandleUserLogin(t *testing.T) {
+	t.Setenv("MFA_REQUIRED_ROLES", "")
+	ctx := context.Background()
+	service, userRepo, publisher, _ := newTestUserService()
+	hash, err := service.hasher.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userRepo.SaveUser(ctx, &models.User{Email: "jane@example.com", Password: hash, Role: models.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		email     string
+		password  string
+		wantEvent string
+		wantErr   *apperror.Error
+	}{
+		{"valid", "jane@example.com", "correct horse battery", "UserLoginSuccess", nil},
+		{"unknown email", "nobody@example.com", "correct horse battery", "UserLoginFailed", apperror.ErrInvalidCredentials},
+		{"wrong password", "jane@example.com", "wrong horse battery", "UserLoginFailed", apperror.ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.HandleUserLogin(ctx, eventData(t, models.UserLoginEvent{Email: tt.email, Password: tt.password}), tt.name)
+			reply := publisher.reply(t)
+			if tt.wantErr != nil {
+				wantError(t, reply, tt.wantEvent, tt.wantErr)
+				return
+			}
+			if reply.eventType != tt.wantEvent {
+				t.Fatalf("reply = %s %+v, want %s", reply.eventType, reply.payload, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestHandleUserLoginRehash(t *testing.T) {
+	t.Setenv("MFA_REQUIRED_ROLES", "")
+	ctx := context.Background()
+	service, userRepo, publisher, _ := newTestUserService()
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userRepo.SaveUser(ctx, &models.User{Email: "jane@example.com", Password: string(legacy), Role: models.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a wrong password leaves the old hash alone
+	service.HandleUserLogin(ctx, eventData(t, models.UserLoginEvent{Email: "jane@example.com", Password: "wrong horse battery"}), "wrong")
+	wantError(t, publisher.reply(t), "UserLoginFailed", apperror.ErrInvalidCredentials)
+	if user, _ := userRepo.FindUserByEmail(ctx, "jane@example.com"); user.Password != string(legacy) {
+		t.Fatalf("hash after a failed login = %q, want the bcrypt hash kept", user.Password)
+	}
+
+	service.HandleUserLogin(ctx, eventData(t, models.UserLoginEvent{Email: "jane@example.com", Password: "correct horse battery"}), "login")
+	if reply := publisher.reply(t); reply.eventType != "UserLoginSuccess" {
+		t.Fatalf("reply = %s %+v, want UserLoginSuccess", reply.eventType, reply.payload)
+	}
+	user, err := userRepo.FindUserByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("hash after login = %q, want the bcrypt hash replaced with Argon2id", user.Password)
+	}
+	if match, rehash := service.hasher.Verify("correct horse battery", user.Password); !match || rehash {
+		t.Fatalf("Verify of the new hash = %v, %v, want true, false", match, rehash)
+	}
+}
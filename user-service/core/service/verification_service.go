@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+	"user-service/api"
+	"user-service/core/apperror"
+	"user-service/core/models"
+	"user-service/core/repository"
+	"user-service/mailer"
+	"user-service/utils"
+)
+
+// emailVerificationTTL is how long a verification link stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+type EmailVerificationService interface {
+	HandleEmailVerify(ctx context.Context, eventData []byte, correlationID string)
+	HandleEmailVerificationResend(ctx context.Context, eventData []byte, correlationID string)
+	SendVerificationEmail(ctx context.Context, user *models.User) error
+}
+
+type emailVerificationService struct {
+	userRepo    repository.UserRepo
+	tokenRepo   repository.UserTokenRepo
+	mailer      mailer.Mailer
+	sendMessage *api.SendingMessage
+}
+
+// HandleEmailVerify marks the email as verified with the token from a verification email
+func (s *emailVerificationService) HandleEmailVerify(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.EmailVerifyEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("EmailVerifyFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	token, err := s.tokenRepo.ConsumeToken(ctx, models.TokenPurposeEmailVerification, utils.HashToken(req.Token))
+	if errors.Is(err, repository.ErrTokenInvalid) {
+		s.publish("EmailVerifyFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired verification link"))
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to verify email token: %v", err)
+		s.publish("EmailVerifyFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to verify email"))
+		return
+	}
+
+	// the link only verifies the address it was sent to
+	err = s.userRepo.MarkEmailVerified(ctx, token.UserID, token.Email)
+	if err != nil {
+		s.publish("EmailVerifyFailed", correlationID, apperror.ErrInvalidToken.WithMessage("Invalid or expired verification link"))
+		return
+	}
+	if err := s.tokenRepo.DeleteUserTokens(ctx, token.UserID, models.TokenPurposeEmailVerification); err != nil {
+		logrus.Warnf("Failed to delete email verification tokens: %v", err)
+	}
+
+	recordActivity(ctx, s.sendMessage, token.UserID, models.ActionEmailVerify, nil)
+	s.publish("EmailVerifySuccess", correlationID, models.UserLoginEvent{
+		ID:            token.UserID.Hex(),
+		Email:         token.Email,
+		EmailVerified: true,
+	})
+}
+
+// HandleEmailVerificationResend sends a new verification email to a signed-in user, the gateway throttles it
+func (s *emailVerificationService) HandleEmailVerificationResend(ctx context.Context, eventData []byte, correlationID string) {
+	var req models.EmailVerificationResendEvent
+	if err := json.Unmarshal(eventData, &req); err != nil {
+		logrus.Errorf("Invalid event data: %v", err)
+		s.publish("EmailVerificationResendFailed", correlationID, apperror.ErrInvalidRequest)
+		return
+	}
+
+	user, err := s.userRepo.FindUserByID(ctx, req.UserID)
+	if err != nil {
+		s.publish("EmailVerificationResendFailed", correlationID, findUserError(err, apperror.ErrUserNotFound))
+		return
+	}
+	if user.EmailVerified {
+		s.publish("EmailVerificationResendFailed", correlationID, apperror.ErrConflict.WithMessage("Email is already verified"))
+		return
+	}
+
+	if err := s.SendVerificationEmail(ctx, user); err != nil {
+		logrus.Errorf("Failed to send verification email: %v", err)
+		s.publish("EmailVerificationResendFailed", correlationID, apperror.ErrInternal.WithMessage("Failed to send verification email"))
+		return
+	}
+
+	s.publish("EmailVerificationResendSuccess", correlationID, models.UserLoginEvent{
+		ID:    user.ID.Hex(),
+		Email: user.Email,
+	})
+}
+
+// SendVerificationEmail replaces any pending verification link of the user and emails a new one
+func (s *emailVerificationService) SendVerificationEmail(ctx context.Context, user *models.User) error {
+	if err := s.tokenRepo.DeleteUserTokens(ctx, user.ID, models.TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenPurposeEmailVerification,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address with the link below. It expires in %d hours.\n\n%s\n\nIf you did not create an account, you can ignore this email.\n",
+			user.Username, int(emailVerificationTTL.Hours()), linkWithToken(os.Getenv("EMAIL_VERIFICATION_URL"), token)),
+	})
+}
+
+func (s *emailVerificationService) publish(eventType string, correlationID string, payload interface{}) {
+	publishReply(s.sendMessage, eventType, correlationID, payload)
+}
+
+// NewEmailVerificationService for verifying the email address of new accounts
+func NewEmailVerificationService(userRepo repository.UserRepo, tokenRepo repository.UserTokenRepo, mail mailer.Mailer, sendMessage *api.SendingMessage) EmailVerificationService {
+	return &emailVerificationService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		mailer:      mail,
+		sendMessage: sendMessage,
+	}
+}
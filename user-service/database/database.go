@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 	"user-service/database/migrations"
+	"user-service/database/seeder"
 )
 
 var (
@@ -17,7 +18,31 @@ var (
 	once   sync.Once
 )
 
+// InitMongoDB connects to MongoDB, then applies pending migrations when AUTO_MIGRATE=true and seeds when AUTO_SEED=true
 func InitMongoDB() (*mongo.Database, error) {
+	db, err := Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	// migrate
+	if err := migrations.Migrate(db); err != nil {
+		log.Fatalf("Error migrating: %v", err)
+	}
+	// the TTL index follows AUDIT_LOG_RETENTION_DAYS from one start to the next
+	if err := migrations.SyncAuditRetention(context.Background(), db); err != nil {
+		log.Errorf("Error updating the audit log retention: %v", err)
+	}
+
+	if os.Getenv("AUTO_SEED") == "true" {
+		seeder.SeedAll(db)
+	}
+
+	return db, nil
+}
+
+// Connect connects to MongoDB once without migrating, for the migrate command
+func Connect() (*mongo.Database, error) {
 	var err error
 
 	once.Do(func() {
@@ -35,11 +60,6 @@ func InitMongoDB() (*mongo.Database, error) {
 		}
 
 		db = client.Database(os.Getenv("MONGO_DB"))
-
-		// migrate
-		if err := migrations.Migrate(db); err != nil {
-			log.Fatalf("Error migrating: %v", err)
-		}
 	})
 	if err != nil {
 		return nil, err
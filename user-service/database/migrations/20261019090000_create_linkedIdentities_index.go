@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const linkedIdentitiesIndexName = "linked_identities_provider_subject"
+
+// Migration function for create_linkedIdentities_index
+// Moves the legacy google_id field into linked_identities and makes every provider account unique
+func createLinkedidentitiesIndexMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019090000_create_linkedIdentities_index",
+		Migrate: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			_, err := collection.UpdateMany(ctx,
+				bson.M{"google_id": bson.M{"$exists": true, "$ne": ""}},
+				mongo.Pipeline{
+					{{Key: "$set", Value: bson.M{"linked_identities": bson.A{bson.M{
+						"provider":  "google",
+						"subject":   "$google_id",
+						"email":     "$email",
+						"linked_at": "$$NOW",
+					}}}}},
+					{{Key: "$unset", Value: "google_id"}},
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			indexModel := mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "linked_identities.provider", Value: 1},
+					{Key: "linked_identities.subject", Value: 1},
+				},
+				Options: options.Index().
+					SetName(linkedIdentitiesIndexName).
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"linked_identities.subject": bson.M{"$exists": true}}),
+			}
+			if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "create_linkedIdentities_index", "linked_identities")
+			return nil
+		},
+		Rollback: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if _, err := collection.Indexes().DropOne(ctx, linkedIdentitiesIndexName); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_linkedIdentities_index")
+			return nil
+		},
+	}
+}
+
+// isIndexNotFound reports whether dropping an index failed only because it (or its collection) is already gone
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 26 || cmdErr.Code == 27
+	}
+	return false
+}
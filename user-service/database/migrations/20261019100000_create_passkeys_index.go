@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const passkeysIndexName = "passkeys_id"
+
+// Migration function for create_passkeys_index
+// A WebAuthn credential ID can only belong to one user
+func createPasskeysIndexMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019100000_create_passkeys_index",
+		Migrate: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			indexModel := mongo.IndexModel{
+				Keys: bson.D{{Key: "passkeys.id", Value: 1}},
+				Options: options.Index().
+					SetName(passkeysIndexName).
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"passkeys.id": bson.M{"$exists": true}}),
+			}
+			if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "create_passkeys_index", "passkeys.id")
+			return nil
+		},
+		Rollback: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if _, err := collection.Indexes().DropOne(ctx, passkeysIndexName); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_passkeys_index")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Migration function for create_userTokens_collection
+// Token hashes are unique and MongoDB removes tokens once they expire
+func createUsertokensCollectionMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019110000_create_userTokens_collection",
+		Migrate: func() error {
+			collection := database.Collection("userTokens")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			indexModels := []mongo.IndexModel{
+				{
+					Keys:    bson.M{"token_hash": 1},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}},
+					Options: options.Index(),
+				},
+				{
+					Keys:    bson.M{"expires_at": 1},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				},
+			}
+			if _, err := collection.Indexes().CreateMany(ctx, indexModels); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "create_userTokens_collection", "token_hash")
+			return nil
+		},
+		Rollback: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := database.Collection("userTokens").Drop(ctx)
+			if err != nil {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_userTokens_collection")
+			return nil
+		},
+	}
+}
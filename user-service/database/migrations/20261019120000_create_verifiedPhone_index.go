@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const verifiedPhoneIndexName = "phone_verified_unique"
+
+// Migration function for create_verifiedPhone_index
+// A verified phone number can only belong to one user, unverified numbers may repeat
+func createVerifiedphoneIndexMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019120000_create_verifiedPhone_index",
+		Migrate: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			indexModel := mongo.IndexModel{
+				Keys: bson.M{"phone": 1},
+				Options: options.Index().
+					SetName(verifiedPhoneIndexName).
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"phone_verified": true}),
+			}
+			if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "create_verifiedPhone_index", "phone")
+			return nil
+		},
+		Rollback: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if _, err := collection.Indexes().DropOne(ctx, verifiedPhoneIndexName); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_verifiedPhone_index")
+			return nil
+		},
+	}
+}
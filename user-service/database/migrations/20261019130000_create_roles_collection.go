@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"user-service/core/models"
+)
+
+// Migration function for create_roles_collection
+// Default roles are only inserted when missing, so edited definitions survive a rerun
+func createRolesCollectionMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019130000_create_roles_collection",
+		Migrate: func() error {
+			collection := database.Collection("roles")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			for _, role := range models.DefaultRoles() {
+				update := bson.M{"$setOnInsert": bson.M{
+					"description": role.Description,
+					"inherits":    role.Inherits,
+					"permissions": role.Permissions,
+					"updated_at":  time.Now(),
+				}}
+				_, err := collection.UpdateByID(ctx, role.Name, update, options.Update().SetUpsert(true))
+				if err != nil {
+					return err
+				}
+			}
+
+			logrus.Printf("Migration: %s completed. Default roles created", "create_roles_collection")
+			return nil
+		},
+		Rollback: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := database.Collection("roles").Drop(ctx)
+			if err != nil {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_roles_collection")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Migration function for create_dataExports_collection
+// Exports are looked up per user, newest first, and MongoDB removes them once they expire
+func createDataexportsCollectionMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019140000_create_dataExports_collection",
+		Migrate: func() error {
+			collection := database.Collection("dataExports")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			indexModels := []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "requested_at", Value: -1}},
+					Options: options.Index(),
+				},
+				{
+					Keys:    bson.M{"expires_at": 1},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				},
+			}
+			if _, err := collection.Indexes().CreateMany(ctx, indexModels); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "create_dataExports_collection", "user_id")
+			return nil
+		},
+		Rollback: func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := database.Collection("dataExports").Drop(ctx)
+			if err != nil {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "create_dataExports_collection")
+			return nil
+		},
+	}
+}
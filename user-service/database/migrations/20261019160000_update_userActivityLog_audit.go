@@ -0,0 +1,147 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"strconv"
+	"time"
+)
+
+// activityTimestampTTLIndex expires the audit trail after AUDIT_LOG_RETENTION_DAYS
+const activityTimestampTTLIndex = "activity_timestamp_ttl"
+
+var userActivityLogAuditIndexes = []string{
+	"user_activity_timestamp",
+	"actor_activity_timestamp",
+	"action_activity_timestamp",
+	activityTimestampTTLIndex,
+}
+
+// Migration function for update_userActivityLog_audit
+// Repairs the entries written before the audit trail and indexes it for its queries and retention
+func updateUseractivitylogAuditMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019160000_update_userActivityLog_audit",
+		Migrate: func() error {
+			collection := database.Collection("userActivityLog")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			// the unique index on the missing userID field let the collection hold a single entry
+			if _, err := collection.Indexes().DropOne(ctx, "userID_1"); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			// entries used to be written with Unix seconds read as milliseconds, which dates them in January 1970
+			_, err := collection.UpdateMany(ctx,
+				bson.M{"activity_timestamp": bson.M{"$lt": time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)}},
+				mongo.Pipeline{
+					{{Key: "$set", Value: bson.M{"activity_timestamp": bson.M{
+						"$toDate": bson.M{"$multiply": bson.A{bson.M{"$toLong": "$activity_timestamp"}, 1000}},
+					}}}},
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			if _, err := collection.UpdateMany(ctx,
+				bson.M{"activity_type": bson.M{"$exists": true}},
+				bson.M{"$rename": bson.M{"activity_type": "action"}},
+			); err != nil {
+				return err
+			}
+
+			indexModels := []mongo.IndexModel{
+				{
+					Keys: bson.D{
+						{Key: "user_id", Value: 1},
+						{Key: "activity_timestamp", Value: -1},
+						{Key: "_id", Value: -1},
+					},
+					Options: options.Index().SetName(userActivityLogAuditIndexes[0]),
+				},
+				{
+					Keys: bson.D{
+						{Key: "actor_id", Value: 1},
+						{Key: "activity_timestamp", Value: -1},
+					},
+					Options: options.Index().
+						SetName(userActivityLogAuditIndexes[1]).
+						SetPartialFilterExpression(bson.M{"actor_id": bson.M{"$exists": true}}),
+				},
+				{
+					Keys: bson.D{
+						{Key: "action", Value: 1},
+						{Key: "activity_timestamp", Value: -1},
+					},
+					Options: options.Index().SetName(userActivityLogAuditIndexes[2]),
+				},
+				{
+					Keys: bson.D{{Key: "activity_timestamp", Value: 1}},
+					Options: options.Index().
+						SetName(activityTimestampTTLIndex).
+						SetExpireAfterSeconds(auditRetentionSeconds()),
+				},
+			}
+			if _, err := collection.Indexes().CreateMany(ctx, indexModels); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "update_userActivityLog_audit", "activity_timestamp")
+			return nil
+		},
+		Rollback: func() error {
+			collection := database.Collection("userActivityLog")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			for _, name := range userActivityLogAuditIndexes {
+				if _, err := collection.Indexes().DropOne(ctx, name); err != nil && !isIndexNotFound(err) {
+					return err
+				}
+			}
+			if _, err := collection.UpdateMany(ctx,
+				bson.M{"action": bson.M{"$exists": true}},
+				bson.M{"$rename": bson.M{"action": "activity_type"}},
+			); err != nil {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "update_userActivityLog_audit")
+			return nil
+		},
+	}
+}
+
+// SyncAuditRetention sets the TTL index of the audit trail to the current AUDIT_LOG_RETENTION_DAYS, the index keeps
+// the value it was created with otherwise. Nothing happens before the migration created the index
+func SyncAuditRetention(ctx context.Context, db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: "userActivityLog"},
+		{Key: "index", Value: bson.D{
+			{Key: "name", Value: activityTimestampTTLIndex},
+			{Key: "expireAfterSeconds", Value: auditRetentionSeconds()},
+		}},
+	}).Err()
+	if err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// auditRetentionSeconds is the TTL of the audit trail, AUDIT_LOG_RETENTION_DAYS or a year
+func auditRetentionSeconds() int32 {
+	days, err := strconv.Atoi(os.Getenv("AUDIT_LOG_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		days = 365
+	}
+	return int32(days * 24 * 60 * 60)
+}
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const linkedIdentitiesKeyIndexName = "linked_identities_key"
+
+// Migration function for update_linkedIdentities_key
+// A compound unique index over two fields of the same array indexes every provider with every subject of a
+// user, so two users with unrelated identities could conflict. The identities get a single provider:subject
+// key and the unique index moves to it
+func updateLinkedidentitiesKeyMigration(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "20261019170000_update_linkedIdentities_key",
+		Migrate: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			_, err := collection.UpdateMany(ctx,
+				bson.M{"linked_identities.0": bson.M{"$exists": true}},
+				mongo.Pipeline{
+					{{Key: "$set", Value: bson.M{"linked_identities": bson.M{"$map": bson.M{
+						"input": "$linked_identities",
+						"as":    "identity",
+						"in": bson.M{"$mergeObjects": bson.A{"$$identity", bson.M{
+							"key": bson.M{"$concat": bson.A{"$$identity.provider", ":", "$$identity.subject"}},
+						}}},
+					}}}}},
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			if _, err := collection.Indexes().DropOne(ctx, linkedIdentitiesIndexName); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			indexModel := mongo.IndexModel{
+				Keys: bson.D{{Key: "linked_identities.key", Value: 1}},
+				Options: options.Index().
+					SetName(linkedIdentitiesKeyIndexName).
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"linked_identities.key": bson.M{"$exists": true}}),
+			}
+			if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+				return err
+			}
+
+			logrus.Printf("Migration: %s completed. Index created on field: %s", "update_linkedIdentities_key", "linked_identities.key")
+			return nil
+		},
+		Rollback: func() error {
+			collection := database.Collection("users")
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			if _, err := collection.Indexes().DropOne(ctx, linkedIdentitiesKeyIndexName); err != nil && !isIndexNotFound(err) {
+				return err
+			}
+
+			indexModel := mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "linked_identities.provider", Value: 1},
+					{Key: "linked_identities.subject", Value: 1},
+				},
+				Options: options.Index().
+					SetName(linkedIdentitiesIndexName).
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"linked_identities.subject": bson.M{"$exists": true}}),
+			}
+			if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+				return err
+			}
+
+			_, err := collection.UpdateMany(ctx, bson.M{"linked_identities.key": bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{"linked_identities.$[].key": ""}})
+			if err != nil {
+				return err
+			}
+
+			logrus.Printf("Rollback: %s completed", "update_linkedIdentities_key")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// registryMarker is the line of All that new migrations are registered above
+const registryMarker = "\t\t// make:migration\n"
+
+var migrationName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*(_[a-zA-Z0-9]+)*$`)
+
+var migrationTemplate = template.Must(template.New("migration").Parse(`package migrations
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration function for {{.Name}}
+func {{.Func}}(database *mongo.Database) *Migration {
+	return &Migration{
+		ID: "{{.ID}}",
+		Migrate: func() error {
+			// create collections and indexes on database here
+
+			logrus.Printf("Migration: %s completed", "{{.Name}}")
+			return nil
+		},
+		Rollback: func() error {
+			// undo Migrate here
+
+			logrus.Printf("Rollback: %s completed", "{{.Name}}")
+			return nil
+		},
+	}
+}
+`))
+
+// Generate writes a new timestamped migration named like create_orders_collection into dir and registers it in All
+func Generate(dir, name string, now time.Time) (string, error) {
+	if !migrationName.MatchString(name) {
+		return "", fmt.Errorf("invalid migration name %q, use letters, digits and underscores", name)
+	}
+
+	id := now.UTC().Format("20060102150405") + "_" + name
+	path := filepath.Join(dir, id+".go")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+
+	registryPath := filepath.Join(dir, "migrate.go")
+	registry, err := os.ReadFile(registryPath)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Contains(registry, []byte(registryMarker)) {
+		return "", fmt.Errorf("%s has no %q line to register the migration at", registryPath, strings.TrimSpace(registryMarker))
+	}
+
+	funcName := migrationFunc(name)
+	var source bytes.Buffer
+	err = migrationTemplate.Execute(&source, struct{ ID, Name, Func string }{id, name, funcName})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, source.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	registry = bytes.Replace(registry, []byte(registryMarker), []byte("\t\t"+funcName+"(db),\n"+registryMarker), 1)
+	if err := os.WriteFile(registryPath, registry, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// migrationFunc names the constructor the way the existing migrations do, create_dataExports_collection
+// becomes createDataexportsCollectionMigration
+func migrationFunc(name string) string {
+	parts := strings.Split(name, "_")
+	var funcName strings.Builder
+	funcName.WriteString(strings.ToLower(parts[0]))
+	for _, part := range parts[1:] {
+		funcName.WriteString(strings.ToUpper(part[:1]) + strings.ToLower(part[1:]))
+	}
+	funcName.WriteString("Migration")
+	return funcName.String()
+}
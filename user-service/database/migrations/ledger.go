@@ -0,0 +1,269 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"time"
+)
+
+const (
+	// ledgerCollection records every applied migration
+	ledgerCollection = "schema_migrations"
+	// lockCollection holds the lock that keeps two instances from migrating at once
+	lockCollection = "schema_migrations_lock"
+	lockID         = "migrate"
+	// lockTTL frees the lock of an instance that died while migrating
+	lockTTL = 15 * time.Minute
+	// lockWait is how long another instance's migration is waited for
+	lockWait = 2 * time.Minute
+)
+
+// ErrLocked is returned when another instance keeps the migration lock longer than lockWait
+var ErrLocked = errors.New("migrations are locked by another instance")
+
+// AppliedMigration is one entry of the schema_migrations ledger
+type AppliedMigration struct {
+	ID        string    `bson:"_id"`
+	Batch     int       `bson:"batch"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Status is the state of one migration, Missing marks a ledger entry whose migration is no longer in the code
+type Status struct {
+	ID        string
+	Applied   bool
+	Batch     int
+	AppliedAt *time.Time
+	Missing   bool
+}
+
+// Up applies the pending migrations in ID order as one batch and returns their IDs
+func Up(ctx context.Context, db *mongo.Database) ([]string, error) {
+	return up(ctx, db, All(db))
+}
+
+// up is Up over migrations, which must be ordered by ID like All
+func up(ctx context.Context, db *mongo.Database, migrations []*Migration) ([]string, error) {
+	var done []string
+	err := withLock(ctx, db, func() error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		batch := 1
+		for _, migration := range applied {
+			if migration.Batch >= batch {
+				batch = migration.Batch + 1
+			}
+		}
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.ID]; ok {
+				continue
+			}
+			if err := migration.Migrate(); err != nil {
+				return fmt.Errorf("migration %s failed: %w", migration.ID, err)
+			}
+
+			_, err := db.Collection(ledgerCollection).InsertOne(ctx, AppliedMigration{
+				ID:        migration.ID,
+				Batch:     batch,
+				AppliedAt: time.Now(),
+			})
+			if err != nil {
+				return fmt.Errorf("record migration %s: %w", migration.ID, err)
+			}
+			done = append(done, migration.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back, newest first, every applied migration after target, target itself stays applied.
+// A target of "0" rolls back everything.
+func Down(ctx context.Context, db *mongo.Database, target string) ([]string, error) {
+	return down(ctx, db, All(db), target)
+}
+
+// down is Down over migrations
+func down(ctx context.Context, db *mongo.Database, migrations []*Migration, target string) ([]string, error) {
+	if target != "0" && findMigration(migrations, target) == nil {
+		return nil, fmt.Errorf("unknown migration %s", target)
+	}
+
+	return rollback(ctx, db, migrations, func(migration AppliedMigration) bool {
+		return target == "0" || migration.ID > target
+	})
+}
+
+// Rollback rolls back the migrations of the last batch applied by Up
+func Rollback(ctx context.Context, db *mongo.Database) ([]string, error) {
+	return rollbackBatch(ctx, db, All(db))
+}
+
+// rollbackBatch is Rollback over migrations
+func rollbackBatch(ctx context.Context, db *mongo.Database, migrations []*Migration) ([]string, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	last := 0
+	for _, migration := range applied {
+		if migration.Batch > last {
+			last = migration.Batch
+		}
+	}
+	return rollback(ctx, db, migrations, func(migration AppliedMigration) bool {
+		return migration.Batch == last
+	})
+}
+
+// StatusOf lists every known migration with its ledger entry, followed by ledger entries without a migration
+func StatusOf(ctx context.Context, db *mongo.Database) ([]Status, error) {
+	return statusOf(ctx, db, All(db))
+}
+
+// statusOf is StatusOf over migrations
+func statusOf(ctx context.Context, db *mongo.Database, migrations []*Migration) ([]Status, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range migrations {
+		status := Status{ID: migration.ID}
+		if entry, ok := applied[migration.ID]; ok {
+			status.Applied = true
+			status.Batch = entry.Batch
+			appliedAt := entry.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.ID)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entry := range applied {
+		appliedAt := entry.AppliedAt
+		statuses = append(statuses, Status{ID: entry.ID, Applied: true, Batch: entry.Batch, AppliedAt: &appliedAt, Missing: true})
+	}
+	return statuses, nil
+}
+
+// rollback runs Rollback of the applied migrations selected, newest first, under the lock
+func rollback(ctx context.Context, db *mongo.Database, migrations []*Migration, selected func(AppliedMigration) bool) ([]string, error) {
+	var done []string
+	err := withLock(ctx, db, func() error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			entry, ok := applied[migrations[i].ID]
+			if !ok || !selected(entry) {
+				continue
+			}
+			if err := migrations[i].Rollback(); err != nil {
+				return fmt.Errorf("rollback migration %s failed: %w", migrations[i].ID, err)
+			}
+			if _, err := db.Collection(ledgerCollection).DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+				return fmt.Errorf("remove migration %s from the ledger: %w", entry.ID, err)
+			}
+			done = append(done, entry.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// appliedMigrations reads the ledger keyed by migration ID
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[string]AppliedMigration, error) {
+	cursor, err := db.Collection(ledgerCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AppliedMigration
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]AppliedMigration, len(entries))
+	for _, entry := range entries {
+		applied[entry.ID] = entry
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migration lock, waiting up to lockWait for another instance to finish
+func withLock(ctx context.Context, db *mongo.Database, fn func() error) error {
+	owner, err := lockOwner()
+	if err != nil {
+		return err
+	}
+
+	locks := db.Collection(lockCollection)
+	deadline := time.Now().Add(lockWait)
+	for {
+		// the filter only matches an expired lock, a live one makes the upsert fail on the duplicate _id
+		now := time.Now()
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "locked_at": now, "expires_at": now.Add(lockTTL)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+
+		logrus.Println("Waiting for another instance to finish migrating...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	defer func() {
+		if _, err := locks.DeleteOne(context.Background(), bson.M{"_id": lockID, "owner": owner}); err != nil {
+			logrus.Errorf("Failed to release migration lock: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// lockOwner names this process in the lock so only it releases the lock
+func lockOwner() (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix)), nil
+}
+
+func findMigration(migrations []*Migration, id string) *Migration {
+	for _, migration := range migrations {
+		if migration.ID == id {
+			return migration
+		}
+	}
+	return nil
+}
@@ -1,11 +1,11 @@
 package migrations
 
 import (
-	"fmt"
+	"context"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"os"
-	"user-service/database/seeder"
+	"sort"
 )
 
 // Migration is a struct to define migration
@@ -15,53 +15,37 @@ type Migration struct {
 	Rollback func() error
 }
 
-// Migrate is a function to migrate all tables
-func Migrate(db *mongo.Database) error {
+// All returns every migration ordered by ID, make:migration registers new ones at the end of the list
+func All(db *mongo.Database) []*Migration {
 	migrations := []*Migration{
 		createUsersCollectionMigration(db, "email"),
 		createUseractivitylogCollectionMigration(db, "userID"),
+		createLinkedidentitiesIndexMigration(db),
+		createPasskeysIndexMigration(db),
+		createUsertokensCollectionMigration(db),
+		createVerifiedphoneIndexMigration(db),
+		createRolesCollectionMigration(db),
+		createDataexportsCollectionMigration(db),
+		updateUseractivitylogAuditMigration(db),
+		updateLinkedidentitiesKeyMigration(db),
+		// make:migration
 	}
-	autoMigrate := os.Getenv("AUTO_MIGRATE")
-	autoDrop := os.Getenv("AUTO_DROP")
-
-	if autoDrop == "true" && autoMigrate == "true" {
-		logrus.Println("Running AutoDrop (Rollback all migrations) and AutoMigrate...")
-
-		for i := len(migrations) - 1; i >= 0; i-- {
-			if err := migrations[i].Rollback(); err != nil {
-				return fmt.Errorf("rollback migration %s failed: %v", migrations[i].ID, err)
-			}
-		}
-
-		for _, migration := range migrations {
-			if err := migration.Migrate(); err != nil {
-				return fmt.Errorf("migration failed after drop: %v", err)
-			}
-		}
-
-		// Seeders
-		seeder.SeedAll(db)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].ID < migrations[j].ID })
+	return migrations
+}
 
-		logrus.Println("AutoMigrate and Seeders completed.")
-	} else if autoDrop == "true" {
-		logrus.Println("Running AutoDrop (Rollback all migrations)...")
+// Migrate applies the pending migrations at startup when AUTO_MIGRATE=true, use the migrate command otherwise
+func Migrate(db *mongo.Database) error {
+	if os.Getenv("AUTO_MIGRATE") != "true" {
+		logrus.Println("Skipping AutoMigrate.")
+		return nil
+	}
 
-		for i := len(migrations) - 1; i >= 0; i-- {
-			if err := migrations[i].Rollback(); err != nil {
-				return fmt.Errorf("rollback migration %s failed: %v", migrations[i].ID, err)
-			}
-		}
-	} else if autoMigrate == "true" {
-		logrus.Println("Running AutoMigrate...")
-		for _, migration := range migrations {
-			if err := migration.Migrate(); err != nil {
-				return fmt.Errorf("migration failed: %v", err)
-			}
-		}
-		seeder.SeedAll(db)
-		logrus.Println("AutoMigrate completed.")
-	} else {
-		logrus.Println("Skipping AutoMigrate and AutoDrop.")
+	logrus.Println("Running AutoMigrate...")
+	applied, err := Up(context.Background(), db)
+	if err != nil {
+		return err
 	}
+	logrus.Printf("AutoMigrate completed, %d migrations applied.", len(applied))
 	return nil
 }
@@ -0,0 +1,206 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"create_users_collection", "createUsersCollectionMigration"},
+		{"create_dataExports_collection", "createDataexportsCollectionMigration"},
+		{"Update_ROLES", "updateRolesMigration"},
+		{"seed", "seedMigration"},
+		{"add_index_2", "addIndex2Migration"},
+	}
+	for _, tt := range tests {
+		if got := migrationFunc(tt.name); got != tt.want {
+			t.Errorf("migrationFunc(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	registry := "package migrations\n\nfunc All() {\n\t_ = []int{\n\t\t// make:migration\n\t}\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "migrate.go"), []byte(registry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 19, 21, 4, 5, 0, time.FixedZone("WIB", 7*60*60))
+
+	path, err := Generate(dir, "create_orders_collection", now)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	// the timestamp is UTC whatever the zone of the machine
+	if want := filepath.Join(dir, "20261019140405_create_orders_collection.go"); path != want {
+		t.Fatalf("Generate = %s, want %s", path, want)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), path, source, 0); err != nil {
+		t.Fatalf("the generated migration does not parse: %v", err)
+	}
+	for _, want := range []string{"func createOrdersCollectionMigration(", `ID: "20261019140405_create_orders_collection"`} {
+		if !strings.Contains(string(source), want) {
+			t.Errorf("the generated migration misses %s:\n%s", want, source)
+		}
+	}
+
+	if _, err := Generate(dir, "add_orders_index", now.Add(time.Second)); err != nil {
+		t.Fatalf("Generate of a second migration: %v", err)
+	}
+	updated, err := os.ReadFile(filepath.Join(dir, "migrate.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// new migrations are registered in the order they were generated, above the marker
+	wantRegistry := "\t\tcreateOrdersCollectionMigration(db),\n\t\taddOrdersIndexMigration(db),\n" + registryMarker
+	if !strings.Contains(string(updated), wantRegistry) {
+		t.Fatalf("registry = %s, want the migrations registered above the marker", updated)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{"", dir},
+		{"1_starts_with_a_digit", dir},
+		{"has-dash", dir},
+		{"trailing_", dir},
+		{"double__underscore", dir},
+		{"create_orders_collection", dir},
+		{"no_registry", t.TempDir()},
+	}
+	for _, tt := range tests {
+		if path, err := Generate(tt.dir, tt.name, now); err == nil {
+			t.Errorf("Generate(%q) = %s, want an error", tt.name, path)
+		}
+	}
+}
+
+func TestGenerateWithoutMarker(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "migrate.go"), []byte("package migrations\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(dir, "create_orders_collection", time.Now()); err == nil {
+		t.Fatal("Generate without a make:migration line succeeded, want an error")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*_create_orders_collection.go")); len(matches) > 0 {
+		t.Fatalf("Generate left %v behind", matches)
+	}
+}
+
+// openLedgerDB connects to MONGO_TEST_URI and returns a database of its own that is dropped afterwards, e.g.
+//
+//	docker run -p 27017:27017 mongo:7
+//	MONGO_TEST_URI=mongodb://localhost:27017 go test ./database/migrations/
+func openLedgerDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect MongoDB: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("migrations_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db
+}
+
+// fakeMigrations returns migrations with the IDs given that append to calls when they run
+func fakeMigrations(calls *[]string, ids ...string) []*Migration {
+	var migrations []*Migration
+	for _, id := range ids {
+		id := id
+		migrations = append(migrations, &Migration{
+			ID:       id,
+			Migrate:  func() error { *calls = append(*calls, "up "+id); return nil },
+			Rollback: func() error { *calls = append(*calls, "down "+id); return nil },
+		})
+	}
+	return migrations
+}
+
+func TestLedger(t *testing.T) {
+	db := openLedgerDB(t)
+	ctx := context.Background()
+	var calls []string
+	first := fakeMigrations(&calls, "20260101000000_a", "20260102000000_b")
+	all := append(first, fakeMigrations(&calls, "20260103000000_c")...)
+
+	check := func(step string, got []string, err error, want ...string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if !reflect.DeepEqual(got, want) && !(len(got) == 0 && len(want) == 0) {
+			t.Fatalf("%s = %v, want %v", step, got, want)
+		}
+	}
+
+	done, err := up(ctx, db, first)
+	check("first up", done, err, "20260101000000_a", "20260102000000_b")
+	done, err = up(ctx, db, all)
+	check("second up", done, err, "20260103000000_c")
+	done, err = up(ctx, db, all)
+	check("up without pending migrations", done, err)
+
+	// a ledger entry whose migration left the code is listed last
+	if _, err := db.Collection(ledgerCollection).InsertOne(ctx, AppliedMigration{ID: "20250101000000_gone", Batch: 1, AppliedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := statusOf(ctx, db, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, status := range statuses {
+		summary = append(summary, fmt.Sprintf("%s batch %d applied %v missing %v", status.ID, status.Batch, status.Applied, status.Missing))
+	}
+	check("status", summary, nil,
+		"20260101000000_a batch 1 applied true missing false",
+		"20260102000000_b batch 1 applied true missing false",
+		"20260103000000_c batch 2 applied true missing false",
+		"20250101000000_gone batch 1 applied true missing true",
+	)
+
+	// rollback undoes the last batch only, newest first
+	done, err = rollbackBatch(ctx, db, all)
+	check("rollback", done, err, "20260103000000_c")
+	done, err = down(ctx, db, all, "20260101000000_a")
+	check("down to a", done, err, "20260102000000_b")
+	if _, err := down(ctx, db, all, "20990101000000_unknown"); err == nil {
+		t.Fatal("down to an unknown migration succeeded, want an error")
+	}
+	done, err = down(ctx, db, all, "0")
+	check("down to 0", done, err, "20260101000000_a")
+
+	check("calls", calls, nil,
+		"up 20260101000000_a", "up 20260102000000_b", "up 20260103000000_c",
+		"down 20260103000000_c", "down 20260102000000_b", "down 20260101000000_a",
+	)
+}
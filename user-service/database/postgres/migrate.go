@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"user-service/database/migrations"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// lockKey is the advisory lock that keeps two instances from migrating at once
+const lockKey = 72_616_301
+
+// sqlMigration is a pair of <id>.up.sql and <id>.down.sql files
+type sqlMigration struct {
+	ID   string
+	Up   string
+	Down string
+}
+
+// Migrate applies the pending SQL migrations at startup when AUTO_MIGRATE=true
+func Migrate(db *sql.DB) error {
+	if os.Getenv("AUTO_MIGRATE") != "true" {
+		return nil
+	}
+
+	applied, err := Up(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	logrus.Printf("Postgres AutoMigrate completed, %d migrations applied.", len(applied))
+	return nil
+}
+
+// Up applies the pending migrations in ID order as one batch, each in its own transaction
+func Up(ctx context.Context, db *sql.DB) ([]string, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		batch := 1
+		for _, entry := range applied {
+			if entry.Batch >= batch {
+				batch = entry.Batch + 1
+			}
+		}
+
+		for _, migration := range all {
+			if _, ok := applied[migration.ID]; ok {
+				continue
+			}
+			err := inTx(ctx, conn, migration.Up, `INSERT INTO schema_migrations (id, batch, applied_at) VALUES ($1, $2, $3)`,
+				migration.ID, batch, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %s failed: %w", migration.ID, err)
+			}
+			logrus.Printf("Migration: %s completed", migration.ID)
+			done = append(done, migration.ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back, newest first, every applied migration after target, "0" rolls back everything
+func Down(ctx context.Context, db *sql.DB, target string) ([]string, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if target != "0" {
+		found := false
+		for _, migration := range all {
+			found = found || migration.ID == target
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown migration %s", target)
+		}
+	}
+
+	return rollback(ctx, db, all, func(entry migrations.AppliedMigration, _ int) bool {
+		return target == "0" || entry.ID > target
+	})
+}
+
+// Rollback rolls back the migrations of the last batch applied by Up
+func Rollback(ctx context.Context, db *sql.DB) ([]string, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return rollback(ctx, db, all, func(entry migrations.AppliedMigration, lastBatch int) bool {
+		return entry.Batch == lastBatch
+	})
+}
+
+// StatusOf lists every SQL migration with its ledger entry, followed by ledger entries without files
+func StatusOf(ctx context.Context, db *sql.DB) ([]migrations.Status, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []migrations.Status
+	for _, migration := range all {
+		status := migrations.Status{ID: migration.ID}
+		if entry, ok := applied[migration.ID]; ok {
+			status.Applied, status.Batch = true, entry.Batch
+			appliedAt := entry.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.ID)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, entry := range applied {
+		appliedAt := entry.AppliedAt
+		statuses = append(statuses, migrations.Status{ID: entry.ID, Applied: true, Batch: entry.Batch, AppliedAt: &appliedAt, Missing: true})
+	}
+	return statuses, nil
+}
+
+func rollback(ctx context.Context, db *sql.DB, all []sqlMigration, selected func(entry migrations.AppliedMigration, lastBatch int) bool) ([]string, error) {
+	var done []string
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		lastBatch := 0
+		for _, entry := range applied {
+			if entry.Batch > lastBatch {
+				lastBatch = entry.Batch
+			}
+		}
+
+		for i := len(all) - 1; i >= 0; i-- {
+			entry, ok := applied[all[i].ID]
+			if !ok || !selected(entry, lastBatch) {
+				continue
+			}
+			if err := inTx(ctx, conn, all[i].Down, `DELETE FROM schema_migrations WHERE id = $1`, all[i].ID); err != nil {
+				return fmt.Errorf("rollback migration %s failed: %w", all[i].ID, err)
+			}
+			logrus.Printf("Rollback: %s completed", all[i].ID)
+			done = append(done, all[i].ID)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// inTx runs the script of a migration and its ledger change atomically, Postgres DDL is transactional
+func inTx(ctx context.Context, conn *sql.Conn, script, ledger string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, ledger, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations reads the ledger keyed by migration ID, creating it on first use
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[string]migrations.AppliedMigration, error) {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		id         TEXT PRIMARY KEY,
+		batch      INTEGER     NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT id, batch, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]migrations.AppliedMigration{}
+	for rows.Next() {
+		var entry migrations.AppliedMigration
+		if err := rows.Scan(&entry.ID, &entry.Batch, &entry.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[entry.ID] = entry
+	}
+	return applied, rows.Err()
+}
+
+// withLock runs fn on one connection holding the advisory lock, the lock ends with the session if the process dies
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return migrations.ErrLocked
+		}
+
+		logrus.Println("Waiting for another instance to finish migrating...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			logrus.Errorf("Failed to release migration lock: %v", err)
+		}
+	}()
+	return fn(conn)
+}
+
+// loadMigrations pairs the embedded up and down files by ID, in ID order
+func loadMigrations() ([]sqlMigration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*sqlMigration{}
+	for _, file := range files {
+		name := strings.TrimPrefix(file, "migrations/")
+		var id, direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			id, direction = strings.TrimSuffix(name, ".up.sql"), "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			id, direction = strings.TrimSuffix(name, ".down.sql"), "down"
+		default:
+			return nil, fmt.Errorf("%s: migration files end in .up.sql or .down.sql", name)
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if byID[id] == nil {
+			byID[id] = &sqlMigration{ID: id}
+		}
+		if direction == "up" {
+			byID[id].Up = string(content)
+		} else {
+			byID[id].Down = string(content)
+		}
+	}
+
+	all := make([]sqlMigration, 0, len(byID))
+	for _, migration := range byID {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an .up.sql and a .down.sql file", migration.ID)
+		}
+		all = append(all, *migration)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
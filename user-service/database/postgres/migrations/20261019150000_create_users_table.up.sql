@@ -0,0 +1,67 @@
+-- Users, IDs are the hex ObjectIDs the rest of the service passes around
+CREATE TABLE users (
+    id                      CHAR(24) PRIMARY KEY,
+    username                TEXT        NOT NULL DEFAULT '',
+    email                   TEXT        NOT NULL,
+    email_verified          BOOLEAN     NOT NULL DEFAULT FALSE,
+    email_verified_at       TIMESTAMPTZ,
+    password                TEXT        NOT NULL DEFAULT '',
+    address                 TEXT        NOT NULL DEFAULT '',
+    phone                   TEXT        NOT NULL DEFAULT '',
+    phone_verified          BOOLEAN     NOT NULL DEFAULT FALSE,
+    phone_verified_at       TIMESTAMPTZ,
+    age                     INTEGER     NOT NULL DEFAULT 0,
+    avatar                  TEXT        NOT NULL DEFAULT '',
+    avatar_variants         JSONB,
+    role                    TEXT        NOT NULL DEFAULT 'USER',
+    created_at              TIMESTAMPTZ,
+    updated_at              TIMESTAMPTZ,
+    mfa_totp_enabled        BOOLEAN     NOT NULL DEFAULT FALSE,
+    mfa_totp_secret         TEXT        NOT NULL DEFAULT '',
+    mfa_totp_last_step      BIGINT      NOT NULL DEFAULT 0,
+    mfa_recovery_codes      TEXT[]      NOT NULL DEFAULT '{}',
+    mfa_enrolled_at         TIMESTAMPTZ,
+    status                  TEXT        NOT NULL DEFAULT '',
+    suspension              JSONB,
+    password_reset_required BOOLEAN     NOT NULL DEFAULT FALSE,
+    deletion_scheduled_at   TIMESTAMPTZ,
+    deleted_at              TIMESTAMPTZ,
+    version                 BIGINT      NOT NULL DEFAULT 0,
+    CONSTRAINT users_email_key UNIQUE (email)
+);
+
+-- a phone number can be verified by one account only
+CREATE UNIQUE INDEX users_verified_phone_key ON users (phone) WHERE phone_verified;
+CREATE INDEX users_created_at_idx ON users (created_at DESC NULLS LAST, id DESC);
+CREATE INDEX users_deletion_due_idx ON users (deletion_scheduled_at) WHERE status = 'pending_deletion';
+
+-- Accounts at OIDC providers, one per provider and user
+CREATE TABLE user_identities (
+    user_id   CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider  TEXT        NOT NULL,
+    subject   TEXT        NOT NULL,
+    email     TEXT        NOT NULL DEFAULT '',
+    linked_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT user_identities_pkey PRIMARY KEY (user_id, provider),
+    CONSTRAINT user_identities_provider_subject_key UNIQUE (provider, subject)
+);
+
+-- WebAuthn credentials, a credential ID is registered once
+CREATE TABLE user_passkeys (
+    id               TEXT        NOT NULL,
+    user_id          CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             TEXT        NOT NULL DEFAULT '',
+    public_key       BYTEA       NOT NULL,
+    attestation_type TEXT        NOT NULL DEFAULT '',
+    transports       TEXT[]      NOT NULL DEFAULT '{}',
+    aaguid           BYTEA,
+    sign_count       BIGINT      NOT NULL DEFAULT 0,
+    backup_eligible  BOOLEAN     NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN     NOT NULL DEFAULT FALSE,
+    clone_warning    BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMPTZ NOT NULL,
+    last_used_at     TIMESTAMPTZ,
+    CONSTRAINT user_passkeys_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX user_passkeys_user_idx ON user_passkeys (user_id);
@@ -0,0 +1,10 @@
+-- Activity of users, kept without a foreign key so anonymized and deleted users keep their history
+CREATE TABLE user_activity_log (
+    id                 CHAR(24) PRIMARY KEY,
+    user_id            CHAR(24)    NOT NULL,
+    activity_type      TEXT        NOT NULL,
+    actor_id           CHAR(24),
+    activity_timestamp TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX user_activity_log_user_idx ON user_activity_log (user_id, activity_timestamp);
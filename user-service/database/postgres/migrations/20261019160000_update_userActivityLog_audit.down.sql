@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS user_activity_log_timestamp_idx;
+DROP INDEX IF EXISTS user_activity_log_action_idx;
+DROP INDEX IF EXISTS user_activity_log_actor_idx;
+DROP INDEX IF EXISTS user_activity_log_user_idx;
+CREATE INDEX user_activity_log_user_idx ON user_activity_log (user_id, activity_timestamp);
+
+ALTER TABLE user_activity_log
+    DROP COLUMN metadata,
+    DROP COLUMN correlation_id,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip;
+ALTER TABLE user_activity_log RENAME COLUMN action TO activity_type;
@@ -0,0 +1,19 @@
+-- One audit model: the action replaces the free text activity type and every entry records the request behind it
+ALTER TABLE user_activity_log RENAME COLUMN activity_type TO action;
+ALTER TABLE user_activity_log
+    ADD COLUMN ip             TEXT NOT NULL DEFAULT '',
+    ADD COLUMN user_agent     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN correlation_id TEXT NOT NULL DEFAULT '',
+    ADD COLUMN metadata       JSONB;
+
+-- entries used to be written with Unix seconds read as milliseconds, which dates them in January 1970
+UPDATE user_activity_log
+SET activity_timestamp = to_timestamp(extract(epoch FROM activity_timestamp) * 1000)
+WHERE activity_timestamp < '1971-01-01';
+
+-- entries are read newest first per user, per actor and per action, old entries are purged by time
+DROP INDEX IF EXISTS user_activity_log_user_idx;
+CREATE INDEX user_activity_log_user_idx ON user_activity_log (user_id, activity_timestamp DESC, id DESC);
+CREATE INDEX user_activity_log_actor_idx ON user_activity_log (actor_id, activity_timestamp DESC) WHERE actor_id IS NOT NULL;
+CREATE INDEX user_activity_log_action_idx ON user_activity_log (action, activity_timestamp DESC);
+CREATE INDEX user_activity_log_timestamp_idx ON user_activity_log (activity_timestamp);
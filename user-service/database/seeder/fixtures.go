@@ -0,0 +1,125 @@
+package seeder
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:embed fixtures/minimal.yaml
+var minimalFixtures []byte
+
+// Fixtures is the content of a fixture file
+type Fixtures struct {
+	Users []UserFixture `json:"users" yaml:"users"`
+}
+
+// UserFixture is a user to seed, Email is its natural key
+type UserFixture struct {
+	Email         string `json:"email" yaml:"email"`
+	Username      string `json:"username" yaml:"username"`
+	Password      string `json:"password,omitempty" yaml:"password,omitempty"`
+	Role          string `json:"role,omitempty" yaml:"role,omitempty"`
+	Status        string `json:"status,omitempty" yaml:"status,omitempty"`
+	EmailVerified bool   `json:"email_verified" yaml:"email_verified"`
+	Phone         string `json:"phone,omitempty" yaml:"phone,omitempty"`
+	PhoneVerified bool   `json:"phone_verified" yaml:"phone_verified"`
+	Address       string `json:"address,omitempty" yaml:"address,omitempty"`
+	Age           int    `json:"age,omitempty" yaml:"age,omitempty"`
+	Avatar        string `json:"avatar,omitempty" yaml:"avatar,omitempty"`
+}
+
+// LoadFixtures reads a .yaml, .yml or .json fixture file, or every one of them in a directory in name order
+func LoadFixtures(path string) (*Fixtures, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadFixtureFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && fixtureFormat(entry.Name()) != "" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	all := &Fixtures{}
+	for _, name := range names {
+		fixtures, err := loadFixtureFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		all.Users = append(all.Users, fixtures.Users...)
+	}
+	return all, nil
+}
+
+func loadFixtureFile(path string) (*Fixtures, error) {
+	format := fixtureFormat(path)
+	if format == "" {
+		return nil, fmt.Errorf("%s: fixtures must be .yaml, .yml or .json", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixtures, err := parseFixtures(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// parseFixtures decodes fixtures and checks every user has an email
+func parseFixtures(data []byte, format string) (*Fixtures, error) {
+	var fixtures Fixtures
+	var err error
+	if format == "json" {
+		err = json.Unmarshal(data, &fixtures)
+	} else {
+		err = yaml.Unmarshal(data, &fixtures)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range fixtures.Users {
+		if strings.TrimSpace(user.Email) == "" {
+			return nil, fmt.Errorf("user %d has no email", i+1)
+		}
+	}
+	return &fixtures, nil
+}
+
+func fixtureFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return ""
+}
+
+// minimalUsers are the accounts every seed set starts with
+func minimalUsers() ([]UserFixture, error) {
+	fixtures, err := parseFixtures(minimalFixtures, "yaml")
+	if err != nil {
+		return nil, fmt.Errorf("fixtures/minimal.yaml: %w", err)
+	}
+	return fixtures.Users, nil
+}
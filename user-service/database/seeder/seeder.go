@@ -0,0 +1,108 @@
+package seeder
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	// DefaultSeed makes two runs without --seed produce the same data
+	DefaultSeed uint64 = 42
+	// DefaultPassword is the password of seeded accounts whose fixture sets none
+	DefaultPassword = "test12345"
+)
+
+// Options of one seeding run
+type Options struct {
+	// Set is the name of a seed set, see Sets
+	Set string
+	// Seed drives the fake data generator, the same seed gives the same users
+	Seed uint64
+	// Count overrides how many fake users the set generates
+	Count int
+	// Password is given to accounts whose fixture sets none
+	Password string
+	// Fixtures are YAML or JSON files, or directories of them, upserted after the set
+	Fixtures []string
+}
+
+// SeedSet is a named collection of data to seed
+type SeedSet struct {
+	Description string
+	// FakeUsers is how many generated users the set adds to the minimal accounts
+	FakeUsers int
+}
+
+// Sets are the seed sets by name, every set starts with the accounts of fixtures/minimal.yaml
+var Sets = map[string]SeedSet{
+	"minimal":   {Description: "the predictable QA accounts only", FakeUsers: 0},
+	"demo":      {Description: "QA accounts and 50 generated users", FakeUsers: 50},
+	"load-test": {Description: "QA accounts and 10000 generated users", FakeUsers: 10000},
+}
+
+// SetNames returns the names of Sets in order
+func SetNames() []string {
+	names := make([]string, 0, len(Sets))
+	for name := range Sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run seeds the set of opts and then its fixtures, running it again updates the same documents instead of adding more
+func Run(ctx context.Context, db *mongo.Database, opts Options) error {
+	set, ok := Sets[opts.Set]
+	if !ok {
+		return fmt.Errorf("unknown seed set %q, use one of %v", opts.Set, SetNames())
+	}
+	if opts.Password == "" {
+		opts.Password = DefaultPassword
+	}
+	count := set.FakeUsers
+	if opts.Count > 0 {
+		count = opts.Count
+	}
+
+	users, err := minimalUsers()
+	if err != nil {
+		return err
+	}
+	users = append(users, fakeUsers(opts.Seed, count)...)
+
+	for _, path := range opts.Fixtures {
+		fixtures, err := LoadFixtures(path)
+		if err != nil {
+			return err
+		}
+		users = append(users, fixtures.Users...)
+	}
+
+	seeded, err := upsertUsers(ctx, db, users, opts.Password)
+	if err != nil {
+		return err
+	}
+	logrus.Printf("Seed set %s: %d users upserted (seed %d)", opts.Set, seeded, opts.Seed)
+	return nil
+}
+
+// SeedAll seeds at startup when AUTO_SEED=true, SEED_SET and SEED picks the set and the seed
+func SeedAll(db *mongo.Database) {
+	opts := Options{Set: os.Getenv("SEED_SET"), Seed: DefaultSeed, Password: os.Getenv("SEED_PASSWORD")}
+	if opts.Set == "" {
+		opts.Set = "minimal"
+	}
+	if seed, err := strconv.ParseUint(os.Getenv("SEED"), 10, 64); err == nil {
+		opts.Seed = seed
+	}
+
+	if err := Run(context.Background(), db, opts); err != nil {
+		logrus.Fatalf("Seed failed: %v", err)
+	}
+	logrus.Println("Seed all success")
+}
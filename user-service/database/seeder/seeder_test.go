@@ -0,0 +1,125 @@
+package seeder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"user-service/core/models"
+)
+
+func TestFakeUsers(t *testing.T) {
+	first := fakeUsers(42, 50)
+	if again := fakeUsers(42, 50); !reflect.DeepEqual(first, again) {
+		t.Fatal("fakeUsers with the same seed generated different users")
+	}
+	// a bigger set starts with the users of a smaller one, so growing a set keeps the existing accounts
+	if prefix := fakeUsers(42, 10); !reflect.DeepEqual(prefix, first[:10]) {
+		t.Fatal("fakeUsers(42, 10) is not the start of fakeUsers(42, 50)")
+	}
+	if other := fakeUsers(7, 50); reflect.DeepEqual(first, other) {
+		t.Fatal("fakeUsers with different seeds generated the same users")
+	}
+
+	if len(first) != 50 {
+		t.Fatalf("fakeUsers(42, 50) generated %d users", len(first))
+	}
+	if first[0].Email != "seed.user00001@example.com" || first[49].Email != "seed.user00050@example.com" {
+		t.Fatalf("emails = %s..%s, want them numbered from seed.user00001", first[0].Email, first[49].Email)
+	}
+	for _, user := range first {
+		if user.Role != models.RoleUser && user.Role != models.RoleAdmin {
+			t.Fatalf("%s has role %s, want USER or ADMIN", user.Email, user.Role)
+		}
+		if user.Username == "" || user.Age < 18 || user.Age > 60 {
+			t.Fatalf("%s = %+v, want a name and an age between 18 and 60", user.Email, user)
+		}
+	}
+}
+
+func TestLastPerEmail(t *testing.T) {
+	users := []UserFixture{
+		{Email: "admin@example.com", Username: "minimal admin"},
+		{Email: "user@example.com", Username: "minimal user"},
+		{Email: " Admin@Example.com ", Username: "fixture admin"},
+		{Email: "new@example.com", Username: "fixture user"},
+		{Email: "USER@example.com", Username: "last user"},
+	}
+	var got []string
+	for _, user := range lastPerEmail(users) {
+		got = append(got, user.Username)
+	}
+	// an override takes the place of the first user with the email, so the order of first appearance holds
+	want := []string{"fixture admin", "last user", "fixture user"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("lastPerEmail = %v, want %v", got, want)
+	}
+	if lastPerEmail(nil) != nil {
+		t.Fatal("lastPerEmail(nil) is not empty")
+	}
+}
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		data      string
+		wantUsers int
+		wantErr   bool
+	}{
+		{"yaml", "yaml", "users:\n  - email: a@example.com\n    role: ADMIN\n  - email: b@example.com\n", 2, false},
+		{"json", "json", `{"users": [{"email": "a@example.com", "email_verified": true}]}`, 1, false},
+		{"empty", "yaml", "", 0, false},
+		{"missing email", "yaml", "users:\n  - email: a@example.com\n  - username: nobody\n", 0, true},
+		{"blank email", "json", `{"users": [{"email": "  "}]}`, 0, true},
+		{"invalid yaml", "yaml", "users: [", 0, true},
+		{"invalid json", "json", `{"users": `, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixtures, err := parseFixtures([]byte(tt.data), tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFixtures = %+v, want an error", fixtures)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fixtures.Users) != tt.wantUsers {
+				t.Fatalf("parseFixtures = %d users, want %d", len(fixtures.Users), tt.wantUsers)
+			}
+		})
+	}
+
+	if _, err := minimalUsers(); err != nil {
+		t.Fatalf("the built-in minimal fixtures: %v", err)
+	}
+}
+
+func TestLoadFixturesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"20_second.json": `{"users": [{"email": "b@example.com"}]}`,
+		"10_first.yaml":  "users:\n  - email: a@example.com\n",
+		"notes.txt":      "not a fixture",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files are read in name order, so later files override earlier ones
+	if len(fixtures.Users) != 2 || fixtures.Users[0].Email != "a@example.com" || fixtures.Users[1].Email != "b@example.com" {
+		t.Fatalf("LoadFixtures = %+v, want a@ then b@", fixtures.Users)
+	}
+	if _, err := LoadFixtures(filepath.Join(dir, "notes.txt")); err == nil {
+		t.Fatal("LoadFixtures of a .txt file succeeded, want an error")
+	}
+}
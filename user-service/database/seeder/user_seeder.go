@@ -2,47 +2,172 @@ package seeder
 
 import (
 	"context"
+	"fmt"
 	"github.com/brianvoe/gofakeit/v7"
-	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 	"time"
 	"user-service/core/models"
 	"user-service/utils"
 )
 
-func SeedUsers(db *mongo.Database) {
-	userCollection := db.Collection("users")
-
-	var users []interface{}
-
-	for i := 1; i <= 15; i++ {
-		gofakeit.Seed(0)
-		password, _ := utils.HashPassword("test12345")
-		role := gofakeit.RandomString([]string{models.RoleAdmin, models.RoleUser})
-		imageUrl := "https://picsum.photos/200/300"
-		user := models.User{
-			Username: gofakeit.Name(),
-			Email:    gofakeit.Email(),
-			Address:  gofakeit.Address().Address,
-			Age:      gofakeit.Number(18, 60),
-			Phone:    gofakeit.Phone(),
-			Password: password,
-			GoogleID: gofakeit.UUID(),
-			Avatar:   imageUrl,
-			Role:     role,
+// upsertBatchSize is how many users one bulk write upserts
+const upsertBatchSize = 1000
+
+// fakeUsers generates count users, the same seed always gives the same users with the same emails
+func fakeUsers(seed uint64, count int) []UserFixture {
+	faker := gofakeit.New(seed)
+
+	users := make([]UserFixture, 0, count)
+	for i := 1; i <= count; i++ {
+		role := models.RoleUser
+		if faker.Number(1, 10) == 1 {
+			role = models.RoleAdmin
+		}
+		users = append(users, UserFixture{
+			// the index keeps the natural key unique whatever names the generator picks
+			Email:         fmt.Sprintf("seed.user%05d@example.com", i),
+			Username:      faker.Name(),
+			Role:          role,
+			EmailVerified: faker.Number(1, 5) > 1,
+			Phone:         faker.Phone(),
+			Address:       faker.Address().Address,
+			Age:           faker.Number(18, 60),
+			Avatar:        "https://picsum.photos/seed/" + faker.UUID() + "/256",
+		})
+	}
+	return users
+}
+
+// upsertUsers writes users by email, seeded fields are reset on every run and the account keeps its ID
+func upsertUsers(ctx context.Context, db *mongo.Database, users []UserFixture, defaultPassword string) (int, error) {
+	collection := db.Collection("users")
+	users = lastPerEmail(users)
+	// password hashing is slow on purpose, every password is hashed once per run
+	hashes := map[string]string{}
+	now := time.Now()
+
+	upserted := 0
+	for start := 0; start < len(users); start += upsertBatchSize {
+		end := start + upsertBatchSize
+		if end > len(users) {
+			end = len(users)
+		}
+
+		var writes []mongo.WriteModel
+		for _, user := range users[start:end] {
+			password := user.Password
+			if password == "" {
+				password = defaultPassword
+			}
+			if _, ok := hashes[password]; !ok {
+				hash, err := utils.HashPassword(password)
+				if err != nil {
+					return upserted, err
+				}
+				hashes[password] = hash
+			}
+
+			email := normalizeEmail(user.Email)
+			set, unset := userFields(user, hashes[password], now)
+			// a changed fixture bumps the version so profile updates read before it are refused
+			update := bson.M{
+				"$set":         set,
+				"$setOnInsert": bson.M{"created_at": now},
+				"$inc":         bson.M{"version": int64(1)},
+			}
+			if len(unset) > 0 {
+				update["$unset"] = unset
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"email": email}).
+				SetUpdate(update).
+				SetUpsert(true))
+		}
+
+		batchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		_, err := collection.BulkWrite(batchCtx, writes, options.BulkWrite().SetOrdered(false))
+		cancel()
+		if err != nil {
+			return upserted, fmt.Errorf("seed users: %w", err)
+		}
+		upserted += len(writes)
+	}
+	return upserted, nil
+}
+
+// userFields maps a fixture to the fields it sets, optional fields it leaves empty are removed
+func userFields(user UserFixture, passwordHash string, now time.Time) (bson.M, bson.M) {
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+	username := user.Username
+	if username == "" {
+		username = strings.Split(user.Email, "@")[0]
+	}
+
+	set := bson.M{
+		"username":       username,
+		"password":       passwordHash,
+		"role":           role,
+		"email_verified": user.EmailVerified,
+		"phone_verified": user.PhoneVerified && user.Phone != "",
+		"updated_at":     now,
+	}
+	unset := bson.M{}
+
+	optional := map[string]interface{}{
+		"phone":   user.Phone,
+		"address": user.Address,
+		"avatar":  user.Avatar,
+		"age":     user.Age,
+		"status":  user.Status,
+	}
+	for field, value := range optional {
+		if value == "" || value == 0 {
+			unset[field] = ""
+		} else {
+			set[field] = value
 		}
-		users = append(users, user)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if user.EmailVerified {
+		set["email_verified_at"] = now
+	} else {
+		unset["email_verified_at"] = ""
+	}
+	if set["phone_verified"] == true {
+		set["phone_verified_at"] = now
+	} else {
+		unset["phone_verified_at"] = ""
+	}
+	if user.Status == models.UserStatusSuspended {
+		set["suspension"] = models.Suspension{Reason: "Seeded suspended account", ActorID: "seeder", SuspendedAt: now}
+	} else {
+		unset["suspension"] = ""
+	}
+	return set, unset
+}
 
-	_, err := userCollection.InsertMany(ctx, users, options.InsertMany().SetOrdered(false))
-	if err != nil {
-		logrus.Fatalf("Seed users failed: %v", err)
-		return
+// lastPerEmail keeps the last user of every email, so a fixture file can override a minimal or generated account
+func lastPerEmail(users []UserFixture) []UserFixture {
+	index := map[string]int{}
+	var unique []UserFixture
+	for _, user := range users {
+		email := normalizeEmail(user.Email)
+		if i, ok := index[email]; ok {
+			unique[i] = user
+			continue
+		}
+		index[email] = len(unique)
+		unique = append(unique, user)
 	}
+	return unique
+}
 
-	logrus.Println("Seed users success")
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password reset links
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewMailerFromEnv picks the mailer configured with MAIL_DRIVER (log or smtp), log is the default
+func NewMailerFromEnv() Mailer {
+	switch strings.ToLower(os.Getenv("MAIL_DRIVER")) {
+	case "smtp":
+		return NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	default:
+		return NewLogMailer()
+	}
+}
+
+type logMailer struct{}
+
+// NewLogMailer writes emails to the log instead of sending them, for local development
+func NewLogMailer() Mailer {
+	return &logMailer{}
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	logrus.Infof("[mailer] To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends emails through an SMTP server, auth is skipped when no username is given
+func NewSMTPMailer(host, port, username, password, from string) Mailer {
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpMailer{
+		addr: host + ":" + port,
+		from: from,
+		auth: auth,
+	}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	body := "From: " + m.from + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(body))
+}
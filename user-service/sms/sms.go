@@ -0,0 +1,91 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery channels supported by the providers
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// Message is a short text sent to a phone number in E.164 format
+type Message struct {
+	To      string
+	Body    string
+	Channel string
+}
+
+// SMSSender delivers one-time codes and other short texts to phones
+type SMSSender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewSMSSenderFromEnv picks the sender configured with SMS_DRIVER (console, file, twilio or vonage), console is the default
+func NewSMSSenderFromEnv() SMSSender {
+	switch strings.ToLower(os.Getenv("SMS_DRIVER")) {
+	case "file":
+		return NewFileSender(os.Getenv("SMS_FILE_PATH"))
+	case "twilio":
+		return NewTwilioSender(
+			os.Getenv("TWILIO_ACCOUNT_SID"),
+			os.Getenv("TWILIO_AUTH_TOKEN"),
+			os.Getenv("TWILIO_FROM"),
+			os.Getenv("TWILIO_WHATSAPP_FROM"),
+		)
+	case "vonage":
+		return NewVonageSender(
+			os.Getenv("VONAGE_API_KEY"),
+			os.Getenv("VONAGE_API_SECRET"),
+			os.Getenv("VONAGE_FROM"),
+		)
+	default:
+		return NewConsoleSender()
+	}
+}
+
+type consoleSender struct{}
+
+// NewConsoleSender writes messages to the log, for local development
+func NewConsoleSender() SMSSender {
+	return &consoleSender{}
+}
+
+func (s *consoleSender) Send(ctx context.Context, msg Message) error {
+	logrus.Infof("[sms:%s] To: %s | %s", msg.Channel, msg.To, msg.Body)
+	return nil
+}
+
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSender appends messages to a file so tests and local tools can read the codes
+func NewFileSender(path string) SMSSender {
+	if path == "" {
+		path = "tmp/sms.log"
+	}
+	return &fileSender{path: path}
+}
+
+func (s *fileSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), msg.Channel, msg.To, msg.Body)
+	return err
+}
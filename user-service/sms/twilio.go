@@ -0,0 +1,66 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type twilioSender struct {
+	accountSID   string
+	authToken    string
+	from         string
+	whatsAppFrom string
+	client       *http.Client
+}
+
+// NewTwilioSender sends through the Twilio Messaging API, WhatsApp needs a separate approved sender
+func NewTwilioSender(accountSID, authToken, from, whatsAppFrom string) SMSSender {
+	return &twilioSender{
+		accountSID:   accountSID,
+		authToken:    authToken,
+		from:         from,
+		whatsAppFrom: whatsAppFrom,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *twilioSender) Send(ctx context.Context, msg Message) error {
+	to, from := msg.To, s.from
+	if msg.Channel == ChannelWhatsApp {
+		if s.whatsAppFrom == "" {
+			return errors.New("twilio: no WhatsApp sender configured")
+		}
+		to, from = "whatsapp:"+msg.To, "whatsapp:"+s.whatsAppFrom
+	}
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", from)
+	form.Set("Body", msg.Body)
+
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", s.accountSID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("twilio: status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
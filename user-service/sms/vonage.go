@@ -0,0 +1,67 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type vonageSender struct {
+	apiKey    string
+	apiSecret string
+	from      string
+	client    *http.Client
+}
+
+// NewVonageSender sends through the Vonage Messages API, which handles both SMS and WhatsApp
+func NewVonageSender(apiKey, apiSecret, from string) SMSSender {
+	return &vonageSender{
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		from:      from,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *vonageSender) Send(ctx context.Context, msg Message) error {
+	channel := msg.Channel
+	if channel == "" {
+		channel = ChannelSMS
+	}
+
+	// Vonage expects numbers without the leading +
+	payload, err := json.Marshal(map[string]string{
+		"message_type": "text",
+		"channel":      channel,
+		"to":           strings.TrimPrefix(msg.To, "+"),
+		"from":         strings.TrimPrefix(s.from, "+"),
+		"text":         msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.nexmo.com/v1/messages", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.apiKey, s.apiSecret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vonage: status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
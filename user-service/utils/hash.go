@@ -1,20 +1,152 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+// PasswordHasher hashes passwords into PHC strings and checks passwords against stored hashes
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash, and whether hash uses an outdated algorithm or
+	// parameters and should be replaced with a new Hash of the password
+	Verify(password, hash string) (match bool, rehash bool)
+}
+
+// Argon2idParams are the cost parameters of Argon2id, Memory is in KiB
+type Argon2idParams struct {
+	Memory     uint32
+	Time       uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation of 64 MiB of memory
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:     64 * 1024,
+	Time:       3,
+	Threads:    2,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// Argon2idParamsFromEnv reads PASSWORD_ARGON2_MEMORY (KiB), PASSWORD_ARGON2_TIME and PASSWORD_ARGON2_THREADS,
+// the defaults fill in what is unset
+func Argon2idParamsFromEnv() Argon2idParams {
+	params := DefaultArgon2idParams
+	if memory, err := strconv.ParseUint(os.Getenv("PASSWORD_ARGON2_MEMORY"), 10, 32); err == nil && memory >= 8*1024 {
+		params.Memory = uint32(memory)
+	}
+	if time, err := strconv.ParseUint(os.Getenv("PASSWORD_ARGON2_TIME"), 10, 32); err == nil && time >= 1 {
+		params.Time = uint32(time)
+	}
+	if threads, err := strconv.ParseUint(os.Getenv("PASSWORD_ARGON2_THREADS"), 10, 8); err == nil && threads >= 1 {
+		params.Threads = uint8(threads)
+	}
+	return params
+}
+
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+// Hash returns $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(hash), nil
+	key := argon2.IDKey([]byte(password), salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.params.Memory, h.params.Time, h.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks Argon2id hashes with the parameters they were made with, and bcrypt hashes of accounts created
+// before Argon2id, which always need a rehash
+func (h argon2idHasher) Verify(password, hash string) (bool, bool) {
+	if isBcryptHash(hash) {
+		match := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+		return match, match
+	}
+
+	version, params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, false
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false
+	}
+	return true, version != argon2.Version || params != h.params
+}
+
+// parseArgon2id splits a PHC string made by argon2idHasher.Hash
+func parseArgon2id(hash string) (int, Argon2idParams, []byte, []byte, error) {
+	var version int
+	var params Argon2idParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return 0, params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return 0, params, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return 0, params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return 0, params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return 0, params, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return version, params, salt, key, nil
+}
+
+// isBcryptHash reports whether hash is a bcrypt hash ($2a$, $2b$ or $2y$)
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// NewArgon2idHasher hashes with Argon2id and params, and still verifies bcrypt hashes
+func NewArgon2idHasher(params Argon2idParams) PasswordHasher {
+	return argon2idHasher{params: params}
+}
+
+var (
+	defaultHasherOnce sync.Once
+	defaultHasher     PasswordHasher
+)
+
+// DefaultPasswordHasher is the Argon2id hasher with the parameters of the environment
+func DefaultPasswordHasher() PasswordHasher {
+	defaultHasherOnce.Do(func() {
+		defaultHasher = NewArgon2idHasher(Argon2idParamsFromEnv())
+	})
+	return defaultHasher
+}
+
+// HashPassword hashes a password with the default hasher
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher().Hash(password)
 }
 
 // CheckPasswordHash checks if a password matches its hash
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	match, _ := DefaultPasswordHasher().Verify(password, hash)
+	return match
 }
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+// testParams keep the tests fast, the defaults spend 64 MiB per hash
+var testParams = Argon2idParams{Memory: 1024, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2idHasher(testParams)
+	hash, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Hash = %s, want an argon2id PHC string with the parameters", hash)
+	}
+
+	version, params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2id(%s): %v", hash, err)
+	}
+	if version != 19 || params != testParams || len(salt) != 16 || len(key) != 32 {
+		t.Fatalf("parseArgon2id(%s) = %d, %+v, %d byte salt, %d byte key", hash, version, params, len(salt), len(key))
+	}
+
+	other, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Fatal("two hashes of the same password are equal, want a fresh salt each time")
+	}
+
+	tests := []struct {
+		password   string
+		wantMatch  bool
+		wantRehash bool
+	}{
+		{"correct horse battery staple", true, false},
+		{"correct horse battery stapl", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		match, rehash := hasher.Verify(tt.password, hash)
+		if match != tt.wantMatch || rehash != tt.wantRehash {
+			t.Errorf("Verify(%q) = %v, %v, want %v, %v", tt.password, match, rehash, tt.wantMatch, tt.wantRehash)
+		}
+	}
+}
+
+func TestParseArgon2idInvalid(t *testing.T) {
+	valid, err := NewArgon2idHasher(testParams).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	with := func(index int, value string) string {
+		changed := append([]string(nil), parts...)
+		changed[index] = value
+		return strings.Join(changed, "$")
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"argon2i", with(1, "argon2i")},
+		{"missing key", strings.Join(parts[:5], "$")},
+		{"version", with(2, "v=x")},
+		{"parameters", with(3, "m=1024,t=1")},
+		{"salt", with(4, "not base64!")},
+		{"key", with(5, "not base64!")},
+		{"empty key", with(5, "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, _, err := parseArgon2id(tt.hash); err == nil {
+				t.Fatalf("parseArgon2id(%q) succeeded, want an error", tt.hash)
+			}
+			if match, rehash := NewArgon2idHasher(testParams).Verify("password", tt.hash); match || rehash {
+				t.Fatalf("Verify of %q = %v, %v, want false, false", tt.hash, match, rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := NewArgon2idHasher(testParams)
+
+	// a bcrypt hash that matches is always replaced, one that does not must not be
+	if match, rehash := hasher.Verify("password", string(hash)); !match || !rehash {
+		t.Fatalf("Verify of the bcrypt hash = %v, %v, want true, true", match, rehash)
+	}
+	if match, rehash := hasher.Verify("wrong", string(hash)); match || rehash {
+		t.Fatalf("Verify of a wrong password against bcrypt = %v, %v, want false, false", match, rehash)
+	}
+
+	prefixes := map[string]bool{"$2a$": true, "$2b$": true, "$2y$": true, "$2x$": false, "$argon2id$": false, "": false}
+	for prefix, want := range prefixes {
+		if got := isBcryptHash(prefix + "10$abc"); got != want {
+			t.Errorf("isBcryptHash(%q) = %v, want %v", prefix+"10$abc", got, want)
+		}
+	}
+}
+
+func TestVerifyRehashOnParamChange(t *testing.T) {
+	hash, err := NewArgon2idHasher(testParams).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := func(change func(params *Argon2idParams)) Argon2idParams {
+		params := testParams
+		change(&params)
+		return params
+	}
+
+	tests := []struct {
+		name       string
+		params     Argon2idParams
+		hash       string
+		wantRehash bool
+	}{
+		{"same parameters", testParams, hash, false},
+		{"memory", changed(func(p *Argon2idParams) { p.Memory = 2048 }), hash, true},
+		{"time", changed(func(p *Argon2idParams) { p.Time = 2 }), hash, true},
+		{"threads", changed(func(p *Argon2idParams) { p.Threads = 2 }), hash, true},
+		{"salt length", changed(func(p *Argon2idParams) { p.SaltLength = 32 }), hash, true},
+		{"key length", changed(func(p *Argon2idParams) { p.KeyLength = 64 }), hash, true},
+		// the version only labels the hash, the key is computed the same way
+		{"older version", testParams, strings.Replace(hash, "$v=19$", "$v=16$", 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash := NewArgon2idHasher(tt.params).Verify("password", tt.hash)
+			if !match || rehash != tt.wantRehash {
+				t.Fatalf("Verify = %v, %v, want true, %v", match, rehash, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idParamsFromEnv(t *testing.T) {
+	tests := []struct {
+		name                  string
+		memory, time, threads string
+		want                  Argon2idParams
+	}{
+		{"unset", "", "", "", DefaultArgon2idParams},
+		{"set", "19456", "2", "1", Argon2idParams{Memory: 19456, Time: 2, Threads: 1, SaltLength: 16, KeyLength: 32}},
+		{"below the minimums", "1024", "0", "0", DefaultArgon2idParams},
+		{"not numbers", "lots", "-1", "many", DefaultArgon2idParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_ARGON2_MEMORY", tt.memory)
+			t.Setenv("PASSWORD_ARGON2_TIME", tt.time)
+			t.Setenv("PASSWORD_ARGON2_THREADS", tt.threads)
+			if got := Argon2idParamsFromEnv(); got != tt.want {
+				t.Fatalf("Argon2idParamsFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks values sealed with MFA_ENCRYPTION_KEY
+const encryptedPrefix = "enc:v1:"
+
+// EncryptSecret seals a secret with AES-GCM using MFA_ENCRYPTION_KEY,
+// the value is stored as-is when no key is configured (local development)
+func EncryptSecret(plain string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil || gcm == nil {
+		return plain, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a value produced by EncryptSecret
+func DecryptSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	if gcm == nil {
+		return "", errors.New("MFA_ENCRYPTION_KEY is required to decrypt secrets")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	key := os.Getenv("MFA_ENCRYPTION_KEY")
+	if key == "" {
+		return nil, nil
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+)
+
+// GenerateToken returns a random URL-safe token of n bytes
+func GenerateToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken signs an emailed token with TOKEN_HASH_SECRET (JWT_SECRET when unset),
+// so a leaked database alone can not be used to check guessed tokens
+func HashToken(token string) string {
+	secret := os.Getenv("TOKEN_HASH_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
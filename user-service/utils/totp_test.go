@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestGenerateTOTPCode checks the RFC 6238 appendix B vectors, truncated to the 6 digits apps show
+func TestGenerateTOTPCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := GenerateTOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("GenerateTOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("GenerateTOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	// the padding and lowercase of secrets typed by hand are accepted
+	if got, err := GenerateTOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", time.Unix(59, 0)); err != nil || got != "287082" {
+		t.Errorf("GenerateTOTPCode of a lowercase padded secret = %s, %v, want 287082", got, err)
+	}
+	if _, err := GenerateTOTPCode("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("GenerateTOTPCode of an invalid secret succeeded, want an error")
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		offset   int64
+		wantOK   bool
+		wantStep int64
+	}{
+		{"two steps early", -2, false, 0},
+		{"previous step", -1, true, current - 1},
+		{"current step", 0, true, current},
+		{"next step", 1, true, current + 1},
+		{"two steps late", 2, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := GenerateTOTPCode(rfcSecret, now.Add(time.Duration(tt.offset*totpPeriod)*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := ValidateTOTP(rfcSecret, code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("ValidateTOTP = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	// the window follows the step boundaries, not the distance in seconds: a code from the last second of
+	// the step before the previous one is 31 seconds old at the start of the current step, and refused
+	start := time.Unix(current*totpPeriod, 0)
+	code, err := GenerateTOTPCode(rfcSecret, start.Add(-(totpPeriod+1)*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := ValidateTOTP(rfcSecret, code, start); ok {
+		t.Fatalf("ValidateTOTP of a code two steps old = %d, true, want it refused", step)
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		want   bool
+	}{
+		{"valid", rfcSecret, "050471", true},
+		{"surrounding spaces", rfcSecret, " 050471 ", true},
+		{"wrong code", rfcSecret, "050472", false},
+		{"too short", rfcSecret, "50471", false},
+		{"8 digits", rfcSecret, "14050471", false},
+		{"empty", rfcSecret, "", false},
+		{"invalid secret", "not base32!", "050471", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok != tt.want {
+				t.Fatalf("ValidateTOTP(%q) = %v, want %v", tt.code, ok, tt.want)
+			}
+		})
+	}
+}